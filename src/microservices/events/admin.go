@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// topicAdmin is the subset of *kafka.Client used by the admin endpoints.
+type topicAdmin interface {
+	Metadata(ctx context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error)
+	DeleteTopics(ctx context.Context, req *kafka.DeleteTopicsRequest) (*kafka.DeleteTopicsResponse, error)
+	CreateTopics(ctx context.Context, req *kafka.CreateTopicsRequest) (*kafka.CreateTopicsResponse, error)
+}
+
+var (
+	adminClient topicAdmin
+	adminToken  string
+	// allowDestructiveAdmin enables endpoints that delete data. It is forced
+	// off when APP_ENV names a production environment.
+	allowDestructiveAdmin bool
+)
+
+// requireAdmin checks the bearer token against ADMIN_TOKEN. Admin endpoints
+// are disabled entirely when no token is configured.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.Error(w, "Admin endpoints are disabled", http.StatusForbidden)
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(adminToken)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func isProduction(env string) bool {
+	switch strings.ToLower(env) {
+	case "prod", "production":
+		return true
+	}
+	return false
+}
+
+// handleTopicReset deletes and recreates one of the service's own topics with
+// the same partition count and replication factor. The caller must repeat the
+// topic name in X-Confirm-Topic.
+func handleTopicReset(w http.ResponseWriter, r *http.Request) {
+	if !allowDestructiveAdmin {
+		http.Error(w, "Destructive admin operations are disabled", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	topic := r.URL.Query().Get("topic")
+	if !isServiceTopic(topic) {
+		http.Error(w, "Unknown topic", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("X-Confirm-Topic") != topic {
+		http.Error(w, "X-Confirm-Topic must repeat the topic name", http.StatusPreconditionRequired)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	if err := resetTopic(ctx, adminClient, topicName(topic)); err != nil {
+		log.Printf("Failed to reset topic %s: %v", topicName(topic), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[ADMIN] Topic %s was reset", topicName(topic))
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "reset", "topic": topicName(topic)})
+}
+
+func isServiceTopic(base string) bool {
+	for _, t := range eventTypes {
+		if t == base {
+			return true
+		}
+	}
+	return false
+}
+
+func resetTopic(ctx context.Context, admin topicAdmin, topic string) error {
+	meta, err := admin.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return fmt.Errorf("describe topic: %w", err)
+	}
+	if len(meta.Topics) != 1 || meta.Topics[0].Error != nil || len(meta.Topics[0].Partitions) == 0 {
+		return fmt.Errorf("topic %s not found", topic)
+	}
+	cfg := kafka.TopicConfig{
+		Topic:             topic,
+		NumPartitions:     len(meta.Topics[0].Partitions),
+		ReplicationFactor: len(meta.Topics[0].Partitions[0].Replicas),
+	}
+
+	del, err := admin.DeleteTopics(ctx, &kafka.DeleteTopicsRequest{Topics: []string{topic}})
+	if err != nil {
+		return fmt.Errorf("delete topic: %w", err)
+	}
+	if err := del.Errors[topic]; err != nil {
+		return fmt.Errorf("delete topic: %w", err)
+	}
+
+	// Deletion completes asynchronously on the brokers, so creating the topic
+	// again is retried while the old one is still being removed.
+	for {
+		created, err := admin.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: []kafka.TopicConfig{cfg}})
+		if err == nil {
+			err = created.Errors[topic]
+		}
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, kafka.TopicAlreadyExists) {
+			return fmt.Errorf("create topic: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("create topic: %w", ctx.Err())
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
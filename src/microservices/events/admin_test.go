@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// mockAdmin records the admin calls and reports topics with three partitions
+// of two replicas each.
+type mockAdmin struct {
+	calls []string
+	// existsFor is how many CreateTopics calls answer TopicAlreadyExists.
+	existsFor int
+	created   []kafka.TopicConfig
+}
+
+func (m *mockAdmin) Metadata(ctx context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error) {
+	m.calls = append(m.calls, "metadata "+strings.Join(req.Topics, ","))
+	partition := kafka.Partition{Replicas: []kafka.Broker{{ID: 1}, {ID: 2}}}
+	return &kafka.MetadataResponse{Topics: []kafka.Topic{{Name: req.Topics[0], Partitions: []kafka.Partition{partition, partition, partition}}}}, nil
+}
+
+func (m *mockAdmin) DeleteTopics(ctx context.Context, req *kafka.DeleteTopicsRequest) (*kafka.DeleteTopicsResponse, error) {
+	m.calls = append(m.calls, "delete "+strings.Join(req.Topics, ","))
+	return &kafka.DeleteTopicsResponse{Errors: map[string]error{}}, nil
+}
+
+func (m *mockAdmin) CreateTopics(ctx context.Context, req *kafka.CreateTopicsRequest) (*kafka.CreateTopicsResponse, error) {
+	m.calls = append(m.calls, "create "+req.Topics[0].Topic)
+	if m.existsFor > 0 {
+		m.existsFor--
+		return &kafka.CreateTopicsResponse{Errors: map[string]error{req.Topics[0].Topic: kafka.TopicAlreadyExists}}, nil
+	}
+	m.created = append(m.created, req.Topics[0])
+	return &kafka.CreateTopicsResponse{Errors: map[string]error{}}, nil
+}
+
+func TestTopicReset(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow       bool
+		token       string
+		method      string
+		topic       string
+		confirm     string
+		existsFor   int
+		wantStatus  int
+		wantCreates int
+	}{
+		{"flag off", false, "secret", http.MethodPost, movieTopic, movieTopic, 0, http.StatusForbidden, 0},
+		{"bad token", true, "wrong", http.MethodPost, movieTopic, movieTopic, 0, http.StatusUnauthorized, 0},
+		{"wrong method", true, "secret", http.MethodGet, movieTopic, movieTopic, 0, http.StatusMethodNotAllowed, 0},
+		{"foreign topic", true, "secret", http.MethodPost, "__consumer_offsets", "__consumer_offsets", 0, http.StatusBadRequest, 0},
+		{"missing confirmation", true, "secret", http.MethodPost, movieTopic, "", 0, http.StatusPreconditionRequired, 0},
+		{"reset", true, "secret", http.MethodPost, movieTopic, movieTopic, 0, http.StatusOK, 1},
+		{"reset while deletion settles", true, "secret", http.MethodPost, userTopic, userTopic, 1, http.StatusOK, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withTopicPrefix(t, "dev.")
+			admin := &mockAdmin{existsFor: tt.existsFor}
+			prevClient, prevToken, prevAllow := adminClient, adminToken, allowDestructiveAdmin
+			adminClient, adminToken, allowDestructiveAdmin = admin, "secret", tt.allow
+			defer func() { adminClient, adminToken, allowDestructiveAdmin = prevClient, prevToken, prevAllow }()
+
+			r := jsonRequest(tt.method, "/api/events/admin/reset?topic="+tt.topic, "")
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			if tt.confirm != "" {
+				r.Header.Set("X-Confirm-Topic", tt.confirm)
+			}
+			rec := serve(requireAdmin(handleTopicReset), r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCreates == 0 {
+				if len(admin.calls) > 0 {
+					t.Fatalf("admin client called: %v", admin.calls)
+				}
+				return
+			}
+			topic := "dev." + tt.topic
+			want := []string{"metadata " + topic, "delete " + topic}
+			for i := 0; i < tt.wantCreates; i++ {
+				want = append(want, "create "+topic)
+			}
+			if strings.Join(admin.calls, "; ") != strings.Join(want, "; ") {
+				t.Fatalf("calls = %v, want %v", admin.calls, want)
+			}
+			if c := admin.created[0]; c.NumPartitions != 3 || c.ReplicationFactor != 2 {
+				t.Fatalf("recreated with %d partitions x %d replicas, want 3 x 2", c.NumPartitions, c.ReplicationFactor)
+			}
+		})
+	}
+}
+
+func TestIsProduction(t *testing.T) {
+	for env, want := range map[string]bool{"prod": true, "Production": true, "staging": false, "": false} {
+		if got := isProduction(env); got != want {
+			t.Errorf("isProduction(%q) = %v, want %v", env, got, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// assignedRetryInterval is how long an assigned partition waits before trying
+// to open again, for example while the brokers are still starting.
+const assignedRetryInterval = 5 * time.Second
+
+// parseAssignedPartitions reads KAFKA_ASSIGNED_PARTITIONS, a comma-separated
+// list of partition IDs. An empty value keeps group-managed assignment.
+func parseAssignedPartitions(value string) ([]int, error) {
+	var partitions []int
+	seen := make(map[int]bool)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		p, err := strconv.Atoi(field)
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("%q is not a partition ID", field)
+		}
+		if !seen[p] {
+			seen[p] = true
+			partitions = append(partitions, p)
+		}
+	}
+	return partitions, nil
+}
+
+// offsetCommitter is the subset of *kafka.Client used to store offsets for
+// readers that are not part of a group generation.
+type offsetCommitter interface {
+	OffsetFetch(ctx context.Context, req *kafka.OffsetFetchRequest) (*kafka.OffsetFetchResponse, error)
+	OffsetCommit(ctx context.Context, req *kafka.OffsetCommitRequest) (*kafka.OffsetCommitResponse, error)
+}
+
+// assignedReader reads one fixed partition without joining the group and
+// stores its offsets under the group ID itself, the way Kafka's standalone
+// consumers do. It implements messageReader so runConsumer treats it like a
+// group reader: ReadMessage commits on fetch and FetchMessage leaves the
+// commit to CommitMessages.
+type assignedReader struct {
+	*kafka.Reader
+	client    offsetCommitter
+	group     string
+	topic     string
+	partition int
+}
+
+// openAssignedReader positions a partition reader after the group's committed
+// offset, or at cfg.StartOffset when nothing has been committed yet.
+func openAssignedReader(ctx context.Context, client offsetCommitter, cfg consumerConfig, topic string, partition int) (*assignedReader, error) {
+	resp, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: cfg.GroupID, Topics: map[string][]int{topic: {partition}}})
+	if err != nil {
+		return nil, fmt.Errorf("fetch committed offset: %w", err)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("fetch committed offset: %w", resp.Error)
+	}
+	offset := cfg.StartOffset
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("fetch committed offset: %w", p.Error)
+		}
+		if p.Partition == partition && p.CommittedOffset >= 0 {
+			offset = p.CommittedOffset
+		}
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   cfg.Brokers,
+		Topic:     topic,
+		Partition: partition,
+		MinBytes:  10e3,
+		MaxBytes:  10e6,
+	})
+	if err := r.SetOffset(offset); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &assignedReader{Reader: r, client: client, group: cfg.GroupID, topic: topic, partition: partition}, nil
+}
+
+// FetchMessage counts the message as consumed. A kafka-go Reader does not
+// expose the record batch, so its codec is reported as unknown.
+func (r *assignedReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	m, err := r.Reader.FetchMessage(ctx)
+	if err == nil {
+		messagesConsumed.WithLabelValues(m.Topic, "unknown").Inc()
+	}
+	return m, err
+}
+
+func (r *assignedReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	m, err := r.FetchMessage(ctx)
+	if err != nil {
+		return m, err
+	}
+	// Like a group reader's background commits, a failed commit does not
+	// fail the read; the next successful one covers this offset too.
+	if err := r.CommitMessages(ctx, m); err != nil {
+		log.Printf("Failed to commit offset %d for topic %s partition %d: %v", m.Offset, r.topic, r.partition, err)
+	}
+	return m, nil
+}
+
+func (r *assignedReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	next := msgs[len(msgs)-1].Offset + 1
+	resp, err := r.client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      r.group,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{r.topic: {{Partition: r.partition, Offset: next}}},
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range resp.Topics[r.topic] {
+		if p.Error != nil {
+			return p.Error
+		}
+	}
+	return nil
+}
+
+// consumeAssigned runs one consume loop per assigned partition of topic.
+func consumeAssigned(ctx context.Context, cfg consumerConfig, topic string) {
+	client := &kafka.Client{Addr: kafka.TCP(cfg.Brokers...)}
+	var wg sync.WaitGroup
+	for _, partition := range cfg.AssignedPartitions {
+		wg.Add(1)
+		go func(partition int) {
+			defer wg.Done()
+			var r *assignedReader
+			for {
+				var err error
+				if r, err = openAssignedReader(ctx, client, cfg, topic, partition); err == nil {
+					break
+				}
+				log.Printf("Failed to open partition %d of topic %s, retrying in %s: %v", partition, topic, assignedRetryInterval, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(assignedRetryInterval):
+				}
+			}
+			defer r.Close()
+			log.Printf("Consumer started for topic %s partition %d", topic, partition)
+			runConsumer(ctx, r, cfg, topic, handleMessage)
+		}(partition)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestParseAssignedPartitions(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []int
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"0", []int{0}, false},
+		{"0, 2,5", []int{0, 2, 5}, false},
+		{"3,3,1,", []int{3, 1}, false},
+		{"1,x", nil, true},
+		{"-1", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseAssignedPartitions(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("partitions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeOffsets keeps committed offsets per partition of one topic and records
+// the partitions each request asked about.
+type fakeOffsets struct {
+	committed map[int]int64
+	fetched   [][]int
+	commits   []kafka.OffsetCommitRequest
+	err       error
+}
+
+func (f *fakeOffsets) OffsetFetch(ctx context.Context, req *kafka.OffsetFetchRequest) (*kafka.OffsetFetchResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp := &kafka.OffsetFetchResponse{Topics: make(map[string][]kafka.OffsetFetchPartition)}
+	for topic, partitions := range req.Topics {
+		f.fetched = append(f.fetched, partitions)
+		for _, p := range partitions {
+			offset, ok := f.committed[p]
+			if !ok {
+				offset = -1
+			}
+			resp.Topics[topic] = append(resp.Topics[topic], kafka.OffsetFetchPartition{Partition: p, CommittedOffset: offset})
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeOffsets) OffsetCommit(ctx context.Context, req *kafka.OffsetCommitRequest) (*kafka.OffsetCommitResponse, error) {
+	f.commits = append(f.commits, *req)
+	resp := &kafka.OffsetCommitResponse{Topics: make(map[string][]kafka.OffsetCommitPartition)}
+	for topic, commits := range req.Topics {
+		for _, c := range commits {
+			f.committed[c.Partition] = c.Offset
+			resp.Topics[topic] = append(resp.Topics[topic], kafka.OffsetCommitPartition{Partition: c.Partition})
+		}
+	}
+	return resp, nil
+}
+
+func TestOpenAssignedReader(t *testing.T) {
+	cfg := consumerConfig{Brokers: []string{"127.0.0.1:1"}, GroupID: "events-service", StartOffset: kafka.FirstOffset}
+	tests := []struct {
+		name       string
+		committed  map[int]int64
+		partition  int
+		wantOffset int64
+	}{
+		{"resumes after the committed offset", map[int]int64{2: 41}, 2, 41},
+		{"starts at StartOffset without a commit", map[int]int64{}, 2, kafka.FirstOffset},
+		{"other partitions' offsets ignored", map[int]int64{0: 10, 1: 20}, 3, kafka.FirstOffset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offsets := &fakeOffsets{committed: tt.committed}
+			r, err := openAssignedReader(context.Background(), offsets, cfg, movieTopic, tt.partition)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			rc := r.Config()
+			if rc.Topic != movieTopic || rc.Partition != tt.partition || rc.GroupID != "" {
+				t.Errorf("reader reads %s partition %d group %q, want %s partition %d without a group", rc.Topic, rc.Partition, rc.GroupID, movieTopic, tt.partition)
+			}
+			if got := r.Offset(); got != tt.wantOffset {
+				t.Errorf("offset = %d, want %d", got, tt.wantOffset)
+			}
+			if want := [][]int{{tt.partition}}; !reflect.DeepEqual(offsets.fetched, want) {
+				t.Errorf("fetched offsets for %v, want %v", offsets.fetched, want)
+			}
+
+			// Commits go under the group ID, outside any generation, and
+			// only for the assigned partition.
+			if err := r.CommitMessages(context.Background(), kafka.Message{Offset: 50}, kafka.Message{Offset: 51}); err != nil {
+				t.Fatal(err)
+			}
+			req := offsets.commits[0]
+			if req.GroupID != cfg.GroupID || req.GenerationID != -1 {
+				t.Errorf("committed as group %q generation %d", req.GroupID, req.GenerationID)
+			}
+			if want := map[string][]kafka.OffsetCommit{movieTopic: {{Partition: tt.partition, Offset: 52}}}; !reflect.DeepEqual(req.Topics, want) {
+				t.Errorf("committed %v, want %v", req.Topics, want)
+			}
+		})
+	}
+}
+
+func TestOpenAssignedReaderErrors(t *testing.T) {
+	cfg := consumerConfig{Brokers: []string{"127.0.0.1:1"}, GroupID: "events-service"}
+	if _, err := openAssignedReader(context.Background(), &fakeOffsets{err: errors.New("coordinator unavailable")}, cfg, movieTopic, 0); err == nil {
+		t.Error("opened a reader without the committed offset")
+	}
+}
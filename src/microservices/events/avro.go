@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/registry"
+)
+
+// Avro schemas for the event types, matching their JSON field names.
+var avroSchemas = map[string]string{
+	movieTopic: `{
+		"type": "record", "name": "MovieEvent", "namespace": "cinemaabyss.events",
+		"fields": [
+			{"name": "movie_id", "type": "long"},
+			{"name": "title", "type": "string"},
+			{"name": "action", "type": "string"},
+			{"name": "user_id", "type": "long"}
+		]
+	}`,
+	userTopic: `{
+		"type": "record", "name": "UserEvent", "namespace": "cinemaabyss.events",
+		"fields": [
+			{"name": "user_id", "type": "long"},
+			{"name": "username", "type": "string"},
+			{"name": "action", "type": "string"},
+			{"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-millis"}}
+		]
+	}`,
+	paymentTopic: `{
+		"type": "record", "name": "PaymentEvent", "namespace": "cinemaabyss.events",
+		"fields": [
+			{"name": "payment_id", "type": "long"},
+			{"name": "user_id", "type": "long"},
+			{"name": "amount", "type": "double"},
+			{"name": "status", "type": "string"},
+			{"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-millis"}}
+		]
+	}`,
+}
+
+// confluentMagicByte prefixes every Schema Registry framed payload, followed
+// by the 4-byte big-endian schema ID.
+const confluentMagicByte = 0x00
+
+var avroAPI = avro.Config{TagKey: "json"}.Freeze()
+
+// schemaRegistry is the subset of *registry.Client the codec needs.
+type schemaRegistry interface {
+	CreateSchema(ctx context.Context, subject, schema string, refs ...registry.SchemaReference) (int, avro.Schema, error)
+	GetSchema(ctx context.Context, id int) (avro.Schema, error)
+}
+
+type registeredSchema struct {
+	id     int
+	schema avro.Schema
+}
+
+// avroCodec encodes events for the topics listed in AVRO_TOPICS using the
+// Confluent wire format and decodes framed payloads on the consume side.
+type avroCodec struct {
+	registry schemaRegistry
+	topics   map[string]bool
+
+	mu         sync.Mutex
+	registered map[string]registeredSchema
+	byID       map[int]avro.Schema
+}
+
+// codec is nil unless SCHEMA_REGISTRY_URL is configured.
+var codec *avroCodec
+
+func newAvroCodec(reg schemaRegistry, topics []string) *avroCodec {
+	c := &avroCodec{
+		registry:   reg,
+		topics:     make(map[string]bool),
+		registered: make(map[string]registeredSchema),
+		byID:       make(map[int]avro.Schema),
+	}
+	for _, t := range topics {
+		c.topics[t] = true
+	}
+	return c
+}
+
+// parseAvroTopics reads AVRO_TOPICS; an empty value selects every topic.
+func parseAvroTopics(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return []string{movieTopic, userTopic, paymentTopic}, nil
+	}
+	var topics []string
+	for _, t := range strings.Split(value, ",") {
+		t = strings.TrimSpace(t)
+		if _, ok := avroSchemas[t]; !ok {
+			return nil, fmt.Errorf("no Avro schema for topic %q", t)
+		}
+		topics = append(topics, t)
+	}
+	return topics, nil
+}
+
+func (c *avroCodec) enabled(topic string) bool {
+	return c != nil && c.topics[topic]
+}
+
+// schemaFor registers the topic's schema under the <topic>-value subject on
+// first use and caches the returned ID.
+func (c *avroCodec) schemaFor(ctx context.Context, topic string) (registeredSchema, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.registered[topic]; ok {
+		return s, nil
+	}
+	id, schema, err := c.registry.CreateSchema(ctx, topicName(topic)+"-value", avroSchemas[topic])
+	if err != nil {
+		return registeredSchema{}, fmt.Errorf("register schema for %s: %w", topic, err)
+	}
+	s := registeredSchema{id: id, schema: schema}
+	c.registered[topic] = s
+	c.byID[id] = schema
+	return s, nil
+}
+
+func (c *avroCodec) encode(ctx context.Context, topic string, event Event) ([]byte, error) {
+	s, err := c.schemaFor(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	body, err := avroAPI.Marshal(s.schema, event)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 5, 5+len(body))
+	out[0] = confluentMagicByte
+	binary.BigEndian.PutUint32(out[1:5], uint32(s.id))
+	return append(out, body...), nil
+}
+
+func isAvroFramed(value []byte) bool {
+	return len(value) >= 5 && value[0] == confluentMagicByte
+}
+
+// decodeJSON turns a framed Avro payload back into JSON for the consumer.
+func (c *avroCodec) decodeJSON(ctx context.Context, value []byte) ([]byte, error) {
+	if !isAvroFramed(value) {
+		return nil, errors.New("not a Schema Registry framed payload")
+	}
+	id := int(binary.BigEndian.Uint32(value[1:5]))
+
+	c.mu.Lock()
+	schema, ok := c.byID[id]
+	c.mu.Unlock()
+	if !ok {
+		var err error
+		if schema, err = c.registry.GetSchema(ctx, id); err != nil {
+			return nil, fmt.Errorf("fetch schema %d: %w", id, err)
+		}
+		c.mu.Lock()
+		c.byID[id] = schema
+		c.mu.Unlock()
+	}
+
+	var decoded map[string]interface{}
+	if err := avroAPI.Unmarshal(schema, value[5:], &decoded); err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/registry"
+)
+
+// mockRegistry hands out IDs from 100 in registration order.
+type mockRegistry struct {
+	subjects map[string]int
+	schemas  map[int]avro.Schema
+	creates  int
+}
+
+func newMockRegistry() *mockRegistry {
+	return &mockRegistry{subjects: make(map[string]int), schemas: make(map[int]avro.Schema)}
+}
+
+func (m *mockRegistry) CreateSchema(ctx context.Context, subject, schema string, refs ...registry.SchemaReference) (int, avro.Schema, error) {
+	m.creates++
+	s, err := avro.Parse(schema)
+	if err != nil {
+		return 0, nil, err
+	}
+	id, ok := m.subjects[subject]
+	if !ok {
+		id = 100 + len(m.subjects)
+		m.subjects[subject] = id
+		m.schemas[id] = s
+	}
+	return id, s, nil
+}
+
+func (m *mockRegistry) GetSchema(ctx context.Context, id int) (avro.Schema, error) {
+	s, ok := m.schemas[id]
+	if !ok {
+		return nil, fmt.Errorf("schema %d not found", id)
+	}
+	return s, nil
+}
+
+func TestAvroWireFormat(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		topic string
+		event Event
+		want  map[string]interface{}
+	}{
+		{movieTopic, &MovieEvent{MovieID: 7, Title: "Heat", Action: "viewed", UserID: 3}, map[string]interface{}{"movie_id": 7.0, "title": "Heat", "action": "viewed", "user_id": 3.0}},
+		{userTopic, &UserEvent{UserID: 3, Username: "ann", Action: "login", Timestamp: ts}, map[string]interface{}{"user_id": 3.0, "username": "ann", "action": "login"}},
+		{paymentTopic, &PaymentEvent{PaymentID: 9, UserID: 3, Amount: 12.5, Status: "paid", Timestamp: ts}, map[string]interface{}{"payment_id": 9.0, "amount": 12.5, "status": "paid"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			withTopicPrefix(t, "staging.")
+			reg := newMockRegistry()
+			c := newAvroCodec(reg, []string{tt.topic})
+
+			value, err := c.encode(context.Background(), tt.topic, tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value[0] != confluentMagicByte {
+				t.Fatalf("magic byte = %#x", value[0])
+			}
+			id, ok := reg.subjects["staging."+tt.topic+"-value"]
+			if !ok {
+				t.Fatalf("schema registered under %v, want staging.%s-value", reg.subjects, tt.topic)
+			}
+			if got := binary.BigEndian.Uint32(value[1:5]); int(got) != id {
+				t.Fatalf("schema id = %d, want %d", got, id)
+			}
+			if _, err := c.encode(context.Background(), tt.topic, tt.event); err != nil || reg.creates != 1 {
+				t.Fatalf("second encode registered again (%d registrations, %v)", reg.creates, err)
+			}
+
+			// A consumer with a cold cache fetches the schema by ID.
+			decoded, err := newAvroCodec(reg, nil).decodeJSON(context.Background(), value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(decoded, &got); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestAvroTopics(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{movieTopic, userTopic, paymentTopic}, false},
+		{"movie-events", []string{movieTopic}, false},
+		{" payment-events , user-events", []string{paymentTopic, userTopic}, false},
+		{"ticket-events", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			topics, err := parseAvroTopics(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error: %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(topics) != fmt.Sprint(tt.want) {
+				t.Fatalf("topics = %v, want %v", topics, tt.want)
+			}
+			c := newAvroCodec(newMockRegistry(), topics)
+			for _, topic := range []string{movieTopic, userTopic, paymentTopic} {
+				want := false
+				for _, w := range tt.want {
+					want = want || w == topic
+				}
+				if c.enabled(topic) != want {
+					t.Errorf("enabled(%s) = %v, want %v", topic, c.enabled(topic), want)
+				}
+			}
+		})
+	}
+}
+
+func TestAvroDecodeRejectsUnframed(t *testing.T) {
+	c := newAvroCodec(newMockRegistry(), nil)
+	for _, value := range [][]byte{[]byte(`{"movie_id":1}`), {0x00, 0x00}, {0x00, 0x00, 0x00, 0x00, 0x63, 0x02}} {
+		if _, err := c.decodeJSON(context.Background(), value); err == nil {
+			t.Errorf("decodeJSON(%q) succeeded", value)
+		}
+	}
+}
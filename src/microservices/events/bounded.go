@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+)
+
+// messageBudget stops the topic consumers once they have processed max
+// messages between them, for runs such as a CronJob that work through a
+// backlog and exit. Consumers holding a message when the budget runs out
+// still finish and commit it, so a run may process one message more than
+// max per topic.
+type messageBudget struct {
+	max    int64
+	n      atomic.Int64
+	cancel context.CancelFunc
+}
+
+// newMessageBudget returns nil when max is 0. cancel must stop the context
+// the budgeted consumers read with.
+func newMessageBudget(max int, cancel context.CancelFunc) *messageBudget {
+	if max <= 0 {
+		return nil
+	}
+	return &messageBudget{max: int64(max), cancel: cancel}
+}
+
+// spend counts one processed message and reports whether the budget is
+// used up. It is safe to call on nil.
+func (b *messageBudget) spend() bool {
+	if b == nil {
+		return false
+	}
+	n := b.n.Add(1)
+	if n == b.max {
+		log.Printf("[CONSUMER] Processed %d messages, stopping consumers", b.max)
+		b.cancel()
+	}
+	return n >= b.max
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// idleReader hands out msgs and then waits for more, like a reader at the
+// end of its partitions, until ctx ends.
+type idleReader struct{ fakeReader }
+
+func (r *idleReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if len(r.msgs) > 0 {
+		m := r.msgs[0]
+		r.msgs = r.msgs[1:]
+		r.mu.Unlock()
+		return m, nil
+	}
+	r.mu.Unlock()
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *idleReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	return r.ReadMessage(ctx)
+}
+
+func backlog(topic string, n int) []kafka.Message {
+	msgs := make([]kafka.Message, n)
+	for i := range msgs {
+		msgs[i] = kafka.Message{Topic: topic, Offset: int64(i), Value: []byte(`{}`)}
+	}
+	return msgs
+}
+
+func TestBoundedConsume(t *testing.T) {
+	tests := []struct {
+		name        string
+		max         int
+		idle        time.Duration
+		topics      []string
+		backlog     int
+		wantHandled []int // acceptable totals across topics
+	}{
+		{"stops after max messages", 3, 0, []string{movieTopic}, 5, []int{3}},
+		{"budget shared across topics", 3, 0, []string{movieTopic, userTopic}, 5, []int{3, 4}},
+		{"stops after the idle timeout", 0, 50 * time.Millisecond, []string{movieTopic}, 2, []int{2}},
+		{"idle timeout on every topic", 0, 50 * time.Millisecond, []string{movieTopic, userTopic}, 2, []int{4}},
+		{"backlog shorter than max then idle", 10, 50 * time.Millisecond, []string{movieTopic}, 4, []int{4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cfg := consumerConfig{MaxAttempts: 1, ManualCommit: true, IdleTimeout: tt.idle, Budget: newMessageBudget(tt.max, cancel)}
+
+			var handled atomic.Int64
+			readers := make([]*idleReader, len(tt.topics))
+			var wg sync.WaitGroup
+			for i, topic := range tt.topics {
+				readers[i] = &idleReader{fakeReader{msgs: backlog(topic, tt.backlog)}}
+				wg.Add(1)
+				go func(r *idleReader, topic string) {
+					defer wg.Done()
+					runConsumer(ctx, r, cfg, topic, func(context.Context, kafka.Message) error {
+						handled.Add(1)
+						return nil
+					})
+				}(readers[i], topic)
+			}
+
+			done := make(chan struct{})
+			go func() { wg.Wait(); close(done) }()
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("consumers still running")
+			}
+
+			got := int(handled.Load())
+			ok := false
+			for _, want := range tt.wantHandled {
+				ok = ok || got == want
+			}
+			if !ok {
+				t.Errorf("handled %d messages, want one of %v", got, tt.wantHandled)
+			}
+			// Everything handled was committed before the loops exited.
+			committed := 0
+			for _, r := range readers {
+				r.mu.Lock()
+				committed += len(r.committed)
+				r.mu.Unlock()
+			}
+			if committed != got {
+				t.Errorf("committed %d messages, handled %d", committed, got)
+			}
+		})
+	}
+}
+
+func TestNewMessageBudget(t *testing.T) {
+	if newMessageBudget(0, func() {}) != nil {
+		t.Error("budget built without a limit")
+	}
+	var nilBudget *messageBudget
+	if nilBudget.spend() {
+		t.Error("nil budget used up")
+	}
+	cancels := 0
+	b := newMessageBudget(2, func() { cancels++ })
+	for i, want := range []bool{false, true, true} {
+		if got := b.spend(); got != want {
+			t.Errorf("spend %d = %v, want %v", i+1, got, want)
+		}
+	}
+	if cancels != 1 {
+		t.Errorf("cancelled %d times, want once", cancels)
+	}
+}
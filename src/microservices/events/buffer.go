@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// maxFlushBatch bounds how many queued events one flush writes at a time.
+const maxFlushBatch = 100
+
+// spoolRetryInterval is how often events spooled to disk are retried.
+const spoolRetryInterval = 5 * time.Second
+
+// bufferedEvent is an event accepted in async mode but not yet written to
+// Kafka. Base selects the per-topic writer.
+type bufferedEvent struct {
+	Base  string `json:"base"`
+	Topic string `json:"topic"`
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value"`
+
+	Headers []kafka.Header `json:"headers,omitempty"`
+}
+
+func (e bufferedEvent) message() kafka.Message {
+	return kafka.Message{Topic: e.Topic, Key: e.Key, Value: e.Value, Headers: e.Headers}
+}
+
+// produceBuffer implements PRODUCE_MODE=async: handlers enqueue events and a
+// background flusher writes them. Events that cannot be written, or that do
+// not fit in the queue, are appended to a spool file in dir and retried
+// until they succeed, including across restarts. Without a dir they are
+// dropped and logged.
+type produceBuffer struct {
+	queue chan bufferedEvent
+	dir   string
+	write func(ctx context.Context, base string, msgs ...kafka.Message) error
+
+	spoolMu sync.Mutex
+	done    chan struct{}
+}
+
+// buffer is nil in the default synchronous produce mode.
+var buffer *produceBuffer
+
+func newProduceBuffer(size int, dir string) *produceBuffer {
+	return &produceBuffer{
+		queue: make(chan bufferedEvent, size),
+		dir:   dir,
+		write: func(ctx context.Context, base string, msgs ...kafka.Message) error {
+			return writerFor(base).WriteMessages(ctx, msgs...)
+		},
+		done: make(chan struct{}),
+	}
+}
+
+func (b *produceBuffer) spoolPath() string {
+	return filepath.Join(b.dir, "pending.jsonl")
+}
+
+// enqueue accepts an event for asynchronous delivery. It reports false only
+// when the queue is full and there is no spool to overflow into.
+func (b *produceBuffer) enqueue(base string, msg kafka.Message) bool {
+	e := bufferedEvent{Base: base, Topic: msg.Topic, Key: msg.Key, Value: msg.Value, Headers: msg.Headers}
+	select {
+	case b.queue <- e:
+		return true
+	default:
+	}
+	if b.dir == "" {
+		return false
+	}
+	return b.spool(e) == nil
+}
+
+// start runs the flusher and the spool retry loop until ctx is cancelled.
+// drain must be called afterwards to deal with what is left.
+func (b *produceBuffer) start(ctx context.Context) {
+	go func() {
+		defer close(b.done)
+		ticker := time.NewTicker(spoolRetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-b.queue:
+				b.flush(ctx, append([]bufferedEvent{e}, b.take(maxFlushBatch-1)...))
+			case <-ticker.C:
+				if _, _, err := b.retrySpool(ctx); err != nil {
+					log.Printf("Failed to retry spooled events: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// take removes up to n events that are already queued without waiting.
+func (b *produceBuffer) take(n int) []bufferedEvent {
+	var events []bufferedEvent
+	for len(events) < n {
+		select {
+		case e := <-b.queue:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+	return events
+}
+
+// flush writes events grouped by base topic, spooling any group that fails.
+// It returns how many were written.
+func (b *produceBuffer) flush(ctx context.Context, events []bufferedEvent) int {
+	groups := make(map[string][]bufferedEvent)
+	var order []string
+	for _, e := range events {
+		if _, ok := groups[e.Base]; !ok {
+			order = append(order, e.Base)
+		}
+		groups[e.Base] = append(groups[e.Base], e)
+	}
+
+	written := 0
+	for _, base := range order {
+		group := groups[base]
+		msgs := make([]kafka.Message, len(group))
+		for i, e := range group {
+			msgs[i] = e.message()
+		}
+		if err := b.write(ctx, base, msgs...); err != nil {
+			log.Printf("Failed to write %d buffered events to %s: %v", len(group), topicName(base), err)
+			b.spoolAll(group)
+			continue
+		}
+		written += len(group)
+	}
+	return written
+}
+
+func (b *produceBuffer) spoolAll(events []bufferedEvent) {
+	for _, e := range events {
+		if err := b.spool(e); err != nil {
+			log.Printf("Dropped event for %s: %v", e.Topic, err)
+		}
+	}
+}
+
+func (b *produceBuffer) spool(e bufferedEvent) error {
+	if b.dir == "" {
+		return errors.New("no PRODUCE_BUFFER_DIR configured")
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b.spoolMu.Lock()
+	defer b.spoolMu.Unlock()
+	f, err := os.OpenFile(b.spoolPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// retrySpool writes spooled events in order, stopping at the first failure
+// or when ctx ends, and rewrites the spool with whatever is left.
+func (b *produceBuffer) retrySpool(ctx context.Context) (flushed, remaining int, err error) {
+	if b.dir == "" {
+		return 0, 0, nil
+	}
+	b.spoolMu.Lock()
+	defer b.spoolMu.Unlock()
+
+	data, err := os.ReadFile(b.spoolPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var events []bufferedEvent
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, maxMessageBytes*2)
+	for scanner.Scan() {
+		var e bufferedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("Skipping corrupt spool entry: %v", err)
+			continue
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, fmt.Errorf("read spool: %w", err)
+	}
+
+	for flushed < len(events) && ctx.Err() == nil {
+		e := events[flushed]
+		if err := b.write(ctx, e.Base, e.message()); err != nil {
+			break
+		}
+		flushed++
+	}
+	rest := events[flushed:]
+	if flushed == 0 {
+		return 0, len(rest), nil
+	}
+
+	var out bytes.Buffer
+	for _, e := range rest {
+		line, _ := json.Marshal(e)
+		out.Write(append(line, '\n'))
+	}
+	tmp := b.spoolPath() + ".tmp"
+	if err := os.WriteFile(tmp, out.Bytes(), 0o600); err != nil {
+		return flushed, len(rest), err
+	}
+	return flushed, len(rest), os.Rename(tmp, b.spoolPath())
+}
+
+// drain runs after the flusher has stopped and the HTTP server no longer
+// accepts events. It writes what is still queued, then retries the spool,
+// giving up when ctx expires. Anything not written stays in the spool for the
+// next start.
+func (b *produceBuffer) drain(ctx context.Context) {
+	<-b.done
+
+	queued := b.take(len(b.queue))
+	total, flushed := len(queued), 0
+	for len(queued) > 0 && ctx.Err() == nil {
+		n := min(len(queued), maxFlushBatch)
+		flushed += b.flush(ctx, queued[:n])
+		queued = queued[n:]
+	}
+	if len(queued) > 0 {
+		b.spoolAll(queued)
+	}
+
+	spoolFlushed, remaining, err := b.retrySpool(ctx)
+	if err != nil {
+		log.Printf("Failed to retry spooled events during drain: %v", err)
+	}
+	flushed += spoolFlushed
+	if b.dir == "" {
+		log.Printf("Produce buffer drained: %d flushed, %d abandoned", flushed, total-flushed)
+		return
+	}
+	log.Printf("Produce buffer drained: %d flushed, %d abandoned and kept in %s", flushed, remaining, b.spoolPath())
+}
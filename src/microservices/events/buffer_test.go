@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// capacityWriter accepts up to capacity messages, then blocks each further
+// write until ctx ends, like a Kafka cluster that stopped answering.
+type capacityWriter struct {
+	mu       sync.Mutex
+	capacity int
+	written  []string
+}
+
+func (c *capacityWriter) write(ctx context.Context, base string, msgs ...kafka.Message) error {
+	c.mu.Lock()
+	if len(c.written)+len(msgs) <= c.capacity {
+		for _, m := range msgs {
+			c.written = append(c.written, string(m.Value))
+		}
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// spooled returns the values left in the spool of b.
+func spooled(t *testing.T, b *produceBuffer) []string {
+	t.Helper()
+	w := &capacityWriter{capacity: 1 << 20}
+	rest := &produceBuffer{dir: b.dir, write: w.write}
+	if _, remaining, err := rest.retrySpool(context.Background()); err != nil || remaining != 0 {
+		t.Fatalf("reading spool: %d remaining, %v", remaining, err)
+	}
+	return w.written
+}
+
+func TestProduceBufferDrain(t *testing.T) {
+	tests := []struct {
+		name        string
+		capacity    int
+		wantWritten []string
+		// wantSpooled is what the next start finds on disk.
+		wantSpooled []string
+	}{
+		{"everything flushed", 10, []string{"movie", "user", "payment", "spooled-1", "spooled-2"}, nil},
+		{"deadline keeps the rest on disk", 2, []string{"movie", "user"}, []string{"spooled-1", "spooled-2", "payment"}},
+		{"nothing written", 0, nil, []string{"spooled-1", "spooled-2", "movie", "user", "payment"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newProduceBuffer(10, t.TempDir())
+			w := &capacityWriter{capacity: tt.capacity}
+			b.write = w.write
+			// Left over from an earlier run.
+			for _, v := range []string{"spooled-1", "spooled-2"} {
+				if err := b.spool(bufferedEvent{Base: userTopic, Topic: userTopic, Value: []byte(v)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, base := range []string{movieTopic, userTopic, paymentTopic} {
+				if !b.enqueue(base, kafka.Message{Topic: base, Value: []byte(base[:len(base)-len("-events")])}) {
+					t.Fatal("enqueue failed")
+				}
+			}
+			close(b.done)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			b.drain(ctx)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("drain took %s despite the deadline", elapsed)
+			}
+
+			if fmt.Sprint(w.written) != fmt.Sprint(tt.wantWritten) {
+				t.Errorf("written %v, want %v", w.written, tt.wantWritten)
+			}
+			if got := spooled(t, b); fmt.Sprint(got) != fmt.Sprint(tt.wantSpooled) {
+				t.Errorf("spooled %v, want %v", got, tt.wantSpooled)
+			}
+		})
+	}
+}
+
+func TestProduceBufferEnqueue(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     bool
+		events  int
+		want    []bool
+		spooled int
+	}{
+		{"fits in the queue", false, 2, []bool{true, true}, 0},
+		{"full queue without spool", false, 3, []bool{true, true, false}, 0},
+		{"full queue overflows to spool", true, 3, []bool{true, true, true}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := ""
+			if tt.dir {
+				dir = t.TempDir()
+			}
+			b := newProduceBuffer(2, dir)
+			for i := 0; i < tt.events; i++ {
+				if got := b.enqueue(movieTopic, kafka.Message{Topic: movieTopic, Value: []byte("m")}); got != tt.want[i] {
+					t.Fatalf("enqueue %d = %v, want %v", i, got, tt.want[i])
+				}
+			}
+			if tt.dir {
+				if got := len(spooled(t, b)); got != tt.spooled {
+					t.Errorf("spooled %d events, want %d", got, tt.spooled)
+				}
+			}
+		})
+	}
+}
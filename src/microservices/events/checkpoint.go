@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type partitionPosition struct {
+	offset int64
+	lag    int64
+}
+
+// consumerCheckpoint accumulates what a consume loop has done so it can be
+// logged at a fixed interval, independent of how many messages flow.
+type consumerCheckpoint struct {
+	topic string
+
+	mu        sync.Mutex
+	positions map[int]partitionPosition
+	processed int64
+	since     time.Time
+}
+
+func newConsumerCheckpoint(topic string) *consumerCheckpoint {
+	return &consumerCheckpoint{topic: topic, positions: make(map[int]partitionPosition), since: time.Now()}
+}
+
+// record notes a handled message. Lag is derived from the high-water mark the
+// broker returned with the fetch, so it is as current as the last message.
+func (c *consumerCheckpoint) record(m kafka.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.positions[m.Partition] = partitionPosition{offset: m.Offset, lag: max(0, m.HighWaterMark-m.Offset-1)}
+	c.processed++
+}
+
+// line formats the checkpoint and starts the next interval.
+func (c *consumerCheckpoint) line(now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	partitions := make([]int, 0, len(c.positions))
+	for p := range c.positions {
+		partitions = append(partitions, p)
+	}
+	sort.Ints(partitions)
+	offsets := make([]string, len(partitions))
+	var lag int64
+	for i, p := range partitions {
+		pos := c.positions[p]
+		offsets[i] = fmt.Sprintf("%d:%d", p, pos.offset)
+		lag += pos.lag
+	}
+
+	elapsed := now.Sub(c.since).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(c.processed) / elapsed
+	}
+	line := fmt.Sprintf("[CHECKPOINT] topic=%s offsets=%s lag=%d processed=%d rate=%.2f/s",
+		c.topic, strings.Join(offsets, ","), lag, c.processed, rate)
+	c.processed, c.since = 0, now
+	return line
+}
+
+func (c *consumerCheckpoint) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			log.Print(c.line(now))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestCheckpointLine(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		msgs    []kafka.Message
+		elapsed time.Duration
+		want    string
+	}{
+		{"quiet period", nil, 10 * time.Second, "[CHECKPOINT] topic=movie-events offsets= lag=0 processed=0 rate=0.00/s"},
+		{
+			"latest offset per partition",
+			[]kafka.Message{
+				{Partition: 1, Offset: 4, HighWaterMark: 10},
+				{Partition: 0, Offset: 7, HighWaterMark: 8},
+				{Partition: 1, Offset: 5, HighWaterMark: 10},
+				{Partition: 0, Offset: 8, HighWaterMark: 12},
+			},
+			2 * time.Second,
+			"[CHECKPOINT] topic=movie-events offsets=0:8,1:5 lag=7 processed=4 rate=2.00/s",
+		},
+		{"caught up", []kafka.Message{{Partition: 2, Offset: 9, HighWaterMark: 10}}, 4 * time.Second, "[CHECKPOINT] topic=movie-events offsets=2:9 lag=0 processed=1 rate=0.25/s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConsumerCheckpoint("movie-events")
+			c.since = start
+			for _, m := range tt.msgs {
+				c.record(m)
+			}
+			if got := c.line(start.Add(tt.elapsed)); got != tt.want {
+				t.Fatalf("line = %q\n want %q", got, tt.want)
+			}
+			// The next interval starts empty but keeps the positions.
+			next := c.line(start.Add(tt.elapsed + time.Second))
+			if !strings.Contains(next, "processed=0 rate=0.00/s") {
+				t.Fatalf("next line = %q, want the counters reset", next)
+			}
+		})
+	}
+}
+
+// syncBuffer collects log output written from the checkpoint goroutine.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRunConsumerLogsCheckpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		want     bool
+	}{
+		{"after the interval", 20 * time.Millisecond, true},
+		{"disabled", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &syncBuffer{}
+			log.SetOutput(out)
+			defer log.SetOutput(io.Discard)
+
+			r := &fakeReader{msgs: []kafka.Message{{Topic: movieTopic, Partition: 0, Offset: 3, HighWaterMark: 6}}}
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			cfg := consumerConfig{CheckpointInterval: tt.interval, MaxAttempts: 1}
+			handle := func(context.Context, kafka.Message) error { return nil }
+			// The reader runs dry at once; keep the checkpoint goroutine
+			// going until ctx ends.
+			runConsumer(ctx, r, cfg, movieTopic, handle)
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+
+			var lines []string
+			for _, l := range strings.Split(out.String(), "\n") {
+				if strings.Contains(l, "[CHECKPOINT]") {
+					lines = append(lines, l)
+				}
+			}
+			if !tt.want {
+				if len(lines) != 0 {
+					t.Fatalf("checkpoint lines = %q, want none", lines)
+				}
+				return
+			}
+			if len(lines) < 2 {
+				t.Fatalf("checkpoint lines = %q, want at least two", lines)
+			}
+			// 200ms at a 20ms interval logs about ten lines, not one per
+			// message or per poll.
+			if len(lines) > 12 {
+				t.Errorf("logged %d checkpoint lines, want about %d", len(lines), 200*time.Millisecond/tt.interval)
+			}
+			for _, field := range []string{"topic=" + movieTopic, "offsets=0:3", "lag=2", "processed=1", "rate="} {
+				if !strings.Contains(lines[0], field) {
+					t.Errorf("first line %q lacks %s", lines[0], field)
+				}
+			}
+			if !strings.Contains(lines[1], "processed=0") || !strings.Contains(lines[1], "offsets=0:3") {
+				t.Errorf("quiet line %q should keep the offset and count nothing", lines[1])
+			}
+		})
+	}
+}
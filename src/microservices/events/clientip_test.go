@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted string
+		remote  string
+		xff     []string
+		want    string
+	}{
+		{"no trusted proxies ignores XFF", "", "203.0.113.7:5000", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"untrusted peer spoofing XFF", "10.0.0.0/8", "203.0.113.7:5000", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"trusted peer", "10.0.0.0/8", "10.0.0.5:5000", []string{"198.51.100.9"}, "198.51.100.9"},
+		{"trusted peer without XFF", "10.0.0.0/8", "10.0.0.5:5000", nil, "10.0.0.5"},
+		{"client prepends a spoofed hop", "10.0.0.0/8", "10.0.0.5:5000", []string{"1.2.3.4, 198.51.100.9"}, "198.51.100.9"},
+		{"trusted hops skipped", "10.0.0.0/8", "10.0.0.5:5000", []string{"198.51.100.9, 10.1.1.1", "10.2.2.2"}, "198.51.100.9"},
+		{"bare address entry", "10.0.0.5", "10.0.0.5:5000", []string{"198.51.100.9"}, "198.51.100.9"},
+		{"garbage hop stops the walk", "10.0.0.0/8", "10.0.0.5:5000", []string{"198.51.100.9, not-an-ip"}, "10.0.0.5"},
+		{"ipv4-mapped peer", "10.0.0.0/8", "[::ffff:10.0.0.5]:5000", []string{"198.51.100.9"}, "198.51.100.9"},
+		{"ipv6", "fd00::/8", "[fd00::1]:5000", []string{"2001:db8::7"}, "2001:db8::7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefixes, err := parseTrustedProxies(tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prev := trustedProxies
+			trustedProxies = prefixes
+			defer func() { trustedProxies = prev }()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"10.0.0.0/8, 192.168.1.1", 2, false},
+		{"10.0.0.1/8", 1, false},
+		{"fd00::/8", 1, false},
+		{"10.0.0.0/33", 0, true},
+		{"proxy.internal", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTrustedProxies(tt.value)
+		if (err != nil) != tt.wantErr || len(got) != tt.want {
+			t.Errorf("parseTrustedProxies(%q) = %v, %v; want %d prefixes, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestKafkaClusters(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		wantProducer []string
+		wantConsumer []string
+	}{
+		{"defaults", nil, []string{"localhost:9092"}, []string{"localhost:9092"}},
+		{"shared cluster", map[string]string{"KAFKA_BROKERS": "kafka-1:9092, kafka-2:9092"}, []string{"kafka-1:9092", "kafka-2:9092"}, []string{"kafka-1:9092", "kafka-2:9092"}},
+		{
+			"consumer reads a mirror",
+			map[string]string{"KAFKA_BROKERS": "primary:9092", "KAFKA_CONSUMER_BROKERS": "mirror-1:9092,mirror-2:9092"},
+			[]string{"primary:9092"}, []string{"mirror-1:9092", "mirror-2:9092"},
+		},
+		{
+			"both overridden",
+			map[string]string{"KAFKA_BROKERS": "unused:9092", "KAFKA_PRODUCER_BROKERS": "primary:9092", "KAFKA_CONSUMER_BROKERS": "mirror:9092"},
+			[]string{"primary:9092"}, []string{"mirror:9092"},
+		},
+		{"empty override falls back", map[string]string{"KAFKA_BROKERS": "primary:9092", "KAFKA_PRODUCER_BROKERS": ""}, []string{"primary:9092"}, []string{"primary:9092"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"KAFKA_BROKERS", "KAFKA_PRODUCER_BROKERS", "KAFKA_CONSUMER_BROKERS"} {
+				value, ok := tt.env[key]
+				t.Setenv(key, value)
+				if !ok {
+					os.Unsetenv(key)
+				}
+			}
+			producer, consumer := kafkaClusters()
+			if !reflect.DeepEqual(producer, tt.wantProducer) || !reflect.DeepEqual(consumer, tt.wantConsumer) {
+				t.Fatalf("clusters = %v / %v, want %v / %v", producer, consumer, tt.wantProducer, tt.wantConsumer)
+			}
+
+			// The writers and readers are built from their own list.
+			prevWriter, prevWriters := writer, topicWriters
+			topicWriters = make(map[string]eventWriter)
+			defer func() { writer, topicWriters = prevWriter, prevWriters }()
+			cfg := &producerConfig{Topics: map[string]producerSettings{paymentTopic: {}}}
+			if err := newWriters(producer, cfg); err != nil {
+				t.Fatal(err)
+			}
+			defer closeWriters()
+			want := strings.Join(tt.wantProducer, ",")
+			if got := writer.Addr.String(); got != want {
+				t.Errorf("writer brokers = %s, want %s", got, want)
+			}
+			if got := topicWriters[paymentTopic].(*kafka.Writer).Addr.String(); got != want {
+				t.Errorf("payment writer brokers = %s, want %s", got, want)
+			}
+			if rc := newReaderConfig(consumerConfig{Brokers: consumer}, movieTopic); !reflect.DeepEqual(rc.Brokers, tt.wantConsumer) {
+				t.Errorf("reader brokers = %v, want %v", rc.Brokers, tt.wantConsumer)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// batchCommitter implements KAFKA_COMMIT_INTERVAL_MS: instead of committing
+// every handled message, the consume loop hands them to the committer, which
+// commits the latest one per partition on a ticker and once more when the
+// loop ends. Only handled messages are added, so at-least-once delivery is
+// kept; a crash redelivers whatever was handled since the last commit.
+type batchCommitter struct {
+	r        messageReader
+	topic    string
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[int]kafka.Message
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+	done     chan struct{}
+}
+
+func newBatchCommitter(r messageReader, topic string, interval time.Duration) *batchCommitter {
+	c := &batchCommitter{
+		r:        r,
+		topic:    topic,
+		interval: interval,
+		pending:  make(map[int]kafka.Message),
+		stopped:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *batchCommitter) add(m kafka.Message) {
+	c.mu.Lock()
+	c.pending[m.Partition] = m
+	c.mu.Unlock()
+}
+
+func (c *batchCommitter) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopped:
+			return
+		case <-ticker.C:
+			c.commit()
+		}
+	}
+}
+
+// commit commits what is pending. Failed offsets are put back unless a newer
+// message for the partition arrived meanwhile, so the next tick retries them.
+func (c *batchCommitter) commit() {
+	c.mu.Lock()
+	if len(c.pending) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	msgs := make([]kafka.Message, 0, len(c.pending))
+	for _, m := range c.pending {
+		msgs = append(msgs, m)
+	}
+	c.pending = make(map[int]kafka.Message)
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+	err := c.r.CommitMessages(ctx, msgs...)
+	cancel()
+	if err == nil {
+		return
+	}
+	log.Printf("Failed to commit %d partition offsets for topic %s, retrying next interval: %v", len(msgs), c.topic, err)
+	c.mu.Lock()
+	for _, m := range msgs {
+		if _, ok := c.pending[m.Partition]; !ok {
+			c.pending[m.Partition] = m
+		}
+	}
+	c.mu.Unlock()
+}
+
+// stop ends the ticker and makes the final commit.
+func (c *batchCommitter) stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopped)
+		<-c.done
+		c.commit()
+	})
+}
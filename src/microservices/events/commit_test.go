@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// commitRecorder is a fakeReader that records each CommitMessages call as one
+// batch and fails the first failures of them.
+type commitRecorder struct {
+	fakeReader
+	failures int
+
+	commitMu sync.Mutex
+	batches  [][]kafka.Message
+}
+
+func (c *commitRecorder) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+	if c.failures > 0 {
+		c.failures--
+		return errors.New("coordinator unavailable")
+	}
+	batch := append([]kafka.Message(nil), msgs...)
+	sort.Slice(batch, func(i, j int) bool { return batch[i].Partition < batch[j].Partition })
+	c.batches = append(c.batches, batch)
+	return nil
+}
+
+func (c *commitRecorder) committedBatches() [][]kafka.Message {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+	return append([][]kafka.Message(nil), c.batches...)
+}
+
+// offsets gives the partition:offset pairs of a batch.
+func offsets(batch []kafka.Message) [][2]int64 {
+	pairs := make([][2]int64, len(batch))
+	for i, m := range batch {
+		pairs[i] = [2]int64{int64(m.Partition), m.Offset}
+	}
+	return pairs
+}
+
+func TestBatchCommitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		added    []kafka.Message
+		// retried is added after the first commit attempt.
+		retried []kafka.Message
+		want    [][2]int64
+	}{
+		{
+			"latest offset per partition",
+			0,
+			[]kafka.Message{{Partition: 0, Offset: 1}, {Partition: 1, Offset: 7}, {Partition: 0, Offset: 2}, {Partition: 0, Offset: 3}},
+			nil,
+			[][2]int64{{0, 3}, {1, 7}},
+		},
+		{
+			"failed commit retried",
+			1,
+			[]kafka.Message{{Partition: 0, Offset: 4}, {Partition: 2, Offset: 9}},
+			nil,
+			[][2]int64{{0, 4}, {2, 9}},
+		},
+		{
+			"newer message replaces a failed offset",
+			1,
+			[]kafka.Message{{Partition: 0, Offset: 4}, {Partition: 2, Offset: 9}},
+			[]kafka.Message{{Partition: 0, Offset: 5}},
+			[][2]int64{{0, 5}, {2, 9}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &commitRecorder{failures: tt.failures}
+			c := &batchCommitter{r: r, topic: movieTopic, pending: make(map[int]kafka.Message)}
+			for _, m := range tt.added {
+				c.add(m)
+			}
+			c.commit()
+			for _, m := range tt.retried {
+				c.add(m)
+			}
+			if tt.failures > 0 {
+				c.commit()
+			}
+			batches := r.committedBatches()
+			if len(batches) != 1 {
+				t.Fatalf("committed %d batches, want 1", len(batches))
+			}
+			if got := offsets(batches[0]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("committed %v, want %v", got, tt.want)
+			}
+			c.commit()
+			if n := len(r.committedBatches()); n != 1 {
+				t.Errorf("nothing pending but committed %d batches", n)
+			}
+		})
+	}
+}
+
+func TestBatchCommitterInterval(t *testing.T) {
+	r := &commitRecorder{}
+	c := newBatchCommitter(r, movieTopic, 20*time.Millisecond)
+	c.add(kafka.Message{Partition: 0, Offset: 1})
+	c.add(kafka.Message{Partition: 0, Offset: 2})
+	for deadline := time.Now().Add(2 * time.Second); len(r.committedBatches()) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("nothing committed after the interval")
+		}
+	}
+
+	// Handled after the tick, committed by stop rather than the next tick.
+	c.add(kafka.Message{Partition: 0, Offset: 3})
+	c.stop()
+	c.stop()
+	batches := r.committedBatches()
+	if len(batches) != 2 {
+		t.Fatalf("committed %d batches, want the tick's and the final one", len(batches))
+	}
+	if got := offsets(batches[0]); !reflect.DeepEqual(got, [][2]int64{{0, 2}}) {
+		t.Errorf("tick committed %v, want offset 2", got)
+	}
+	if got := offsets(batches[1]); !reflect.DeepEqual(got, [][2]int64{{0, 3}}) {
+		t.Errorf("stop committed %v, want offset 3", got)
+	}
+}
+
+func TestRunConsumerCommitsOnShutdown(t *testing.T) {
+	r := &commitRecorder{fakeReader: fakeReader{msgs: []kafka.Message{
+		{Topic: movieTopic, Partition: 0, Offset: 10},
+		{Topic: movieTopic, Partition: 1, Offset: 20},
+		{Topic: movieTopic, Partition: 0, Offset: 11},
+	}}}
+	cfg := consumerConfig{ManualCommit: true, CommitInterval: time.Hour, MaxAttempts: 1}
+	runConsumer(context.Background(), r, cfg, movieTopic, func(context.Context, kafka.Message) error { return nil })
+
+	batches := r.committedBatches()
+	if len(batches) != 1 {
+		t.Fatalf("committed %d batches, want one on shutdown", len(batches))
+	}
+	if got := offsets(batches[0]); !reflect.DeepEqual(got, [][2]int64{{0, 11}, {1, 20}}) {
+		t.Errorf("committed %v, want the last offset of each partition", got)
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/segmentio/kafka-go/compress"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// batchCodec names the compression codec of the record batch r arrived in,
+// as set by its producer's compression.type. Kafka's codec IDs are the ones
+// kafka-go's compress package numbers its codecs with.
+func batchCodec(r *kgo.Record) string {
+	return compress.Compression(r.Attrs.CompressionType()).String()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	producesInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "events_produce_in_flight",
+		Help: "Produce requests currently being handled, by topic.",
+	}, []string{"topic"})
+	producesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_produce_rejected_total",
+		Help: "Produce requests turned away because their topic was at its concurrency limit, by topic.",
+	}, []string{"topic"})
+)
+
+// topicLimiter gives every topic its own fixed number of produce slots, so a
+// burst on one topic cannot take the capacity another topic needs. A request
+// that finds no free slot is rejected at once rather than queued.
+type topicLimiter struct {
+	slots map[string]chan struct{}
+	// retryAfter is the Retry-After, in seconds, sent with rejections.
+	retryAfter int
+}
+
+// produceLimits is nil when no topic has a limit.
+var produceLimits *topicLimiter
+
+// parseTopicLimits reads PRODUCE_CONCURRENCY, the limit for every service
+// topic (0 for none), and PRODUCE_CONCURRENCY_TOPICS, per-topic overrides
+// such as "payment-events=20,movie-events=50".
+func parseTopicLimits(fallback, value string) (map[string]int, error) {
+	def, err := strconv.Atoi(fallback)
+	if err != nil || def < 0 {
+		return nil, fmt.Errorf("%q must be a non-negative integer", fallback)
+	}
+	limits := make(map[string]int)
+	for _, base := range eventTypes {
+		limits[base] = def
+	}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		topic, n, ok := strings.Cut(pair, "=")
+		topic = strings.TrimSpace(topic)
+		if !ok || !isServiceTopic(topic) {
+			return nil, fmt.Errorf("%q is not a known topic=limit pair", pair)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("topic %s: %q must be a non-negative integer", topic, n)
+		}
+		limits[topic] = limit
+	}
+	return limits, nil
+}
+
+// newTopicLimiter returns nil when every limit is 0.
+func newTopicLimiter(limits map[string]int, retryAfter int) *topicLimiter {
+	l := &topicLimiter{slots: make(map[string]chan struct{}), retryAfter: retryAfter}
+	for topic, n := range limits {
+		if n > 0 {
+			l.slots[topic] = make(chan struct{}, n)
+		}
+	}
+	if len(l.slots) == 0 {
+		return nil
+	}
+	return l
+}
+
+// acquire takes a slot for topic, reporting false when none is free. The
+// returned release must be called once the request is done. Topics without
+// a limit, and a nil limiter, always succeed.
+func (l *topicLimiter) acquire(topic string) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	slots, limited := l.slots[topic]
+	if !limited {
+		return func() {}, true
+	}
+	select {
+	case slots <- struct{}{}:
+	default:
+		producesRejected.WithLabelValues(topic).Inc()
+		return nil, false
+	}
+	producesInFlight.WithLabelValues(topic).Inc()
+	return func() {
+		producesInFlight.WithLabelValues(topic).Dec()
+		<-slots
+	}, true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestParseTopicLimits(t *testing.T) {
+	tests := []struct {
+		fallback string
+		value    string
+		want     map[string]int
+		wantErr  bool
+	}{
+		{"0", "", map[string]int{movieTopic: 0, paymentTopic: 0, userTopic: 0}, false},
+		{"10", "payment-events=2", map[string]int{movieTopic: 10, paymentTopic: 2, userTopic: 10}, false},
+		{"0", " payment-events = 20 , movie-events=50 ,", map[string]int{movieTopic: 50, paymentTopic: 20, userTopic: 0}, false},
+		{"-1", "", nil, true},
+		{"0", "orders=5", nil, true},
+		{"0", "payment-events", nil, true},
+		{"0", "payment-events=many", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTopicLimits(tt.fallback, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseTopicLimits(%q, %q) err = %v, want error %v", tt.fallback, tt.value, err, tt.wantErr)
+		}
+		for topic, want := range tt.want {
+			if got[topic] != want {
+				t.Errorf("parseTopicLimits(%q, %q)[%s] = %d, want %d", tt.fallback, tt.value, topic, got[topic], want)
+			}
+		}
+	}
+	if newTopicLimiter(map[string]int{movieTopic: 0, paymentTopic: 0}, 1) != nil {
+		t.Error("limiter built without any limit")
+	}
+}
+
+// gatedWriter holds every write until open is closed, announcing each one
+// on started.
+type gatedWriter struct {
+	started chan struct{}
+	open    chan struct{}
+}
+
+func (g *gatedWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	g.started <- struct{}{}
+	<-g.open
+	return nil
+}
+
+func (*gatedWriter) Close() error { return nil }
+
+func TestProduceConcurrencyPerTopic(t *testing.T) {
+	payments := &gatedWriter{started: make(chan struct{}, 10), open: make(chan struct{})}
+	movies := &recordingWriter{}
+	prevWriters, prevLimits, prevDLQ := topicWriters, produceLimits, produceDLQ
+	topicWriters = map[string]eventWriter{paymentTopic: payments, movieTopic: movies}
+	produceLimits = newTopicLimiter(map[string]int{paymentTopic: 2, movieTopic: 2}, 3)
+	produceDLQ = nil
+	defer func() { topicWriters, produceLimits, produceDLQ = prevWriters, prevLimits, prevDLQ }()
+
+	const (
+		payment = `{"payment_id": 1, "user_id": 9, "amount": 12.5, "status": "completed", "timestamp": "2024-03-01T12:00:00Z"}`
+		movie   = `{"movie_id": 1, "title": "Heat", "action": "viewed"}`
+	)
+	produce := func(topic, body string) *httptest.ResponseRecorder {
+		return serve(handleEvent(topic), jsonRequest(http.MethodPost, "/api/events/"+topic, body))
+	}
+
+	// Fill the payment pool with produces that stay in flight.
+	var wg sync.WaitGroup
+	held := make([]int, 2)
+	for i := range held {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			held[i] = produce(paymentTopic, payment).Code
+		}(i)
+		select {
+		case <-payments.started:
+		case <-time.After(time.Second):
+			t.Fatal("payment produce never reached the writer")
+		}
+	}
+
+	tests := []struct {
+		name           string
+		topic          string
+		body           string
+		want           int
+		wantRetryAfter string
+	}{
+		{"saturated topic rejected", paymentTopic, payment, http.StatusServiceUnavailable, "3"},
+		{"other topic unaffected", movieTopic, movie, http.StatusCreated, ""},
+		{"other topic still unaffected", movieTopic, movie, http.StatusCreated, ""},
+		{"unlimited topic unaffected", userTopic, `{"user_id": 9, "action": "login", "timestamp": "2024-03-01T12:00:00Z"}`, http.StatusCreated, ""},
+	}
+	topicWriters[userTopic] = &recordingWriter{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := produce(tt.topic, tt.body)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+			if tt.want == http.StatusServiceUnavailable {
+				var resp struct {
+					Category  string `json:"category"`
+					Code      string `json:"code"`
+					Retryable bool   `json:"retryable"`
+				}
+				decodeJSON(t, rec, &resp)
+				if resp.Code != "topic_busy" || resp.Category != "transient" || !resp.Retryable {
+					t.Errorf("error = %+v, want a transient topic_busy", resp)
+				}
+			}
+		})
+	}
+
+	close(payments.open)
+	wg.Wait()
+	for i, code := range held {
+		if code != http.StatusCreated {
+			t.Errorf("held produce %d = %d, want %d", i, code, http.StatusCreated)
+		}
+	}
+	if rec := produce(paymentTopic, payment); rec.Code != http.StatusCreated {
+		t.Errorf("payment produce after the burst = %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// consumerConfig holds the settings shared by every topic consumer.
+type consumerConfig struct {
+	Brokers []string
+	GroupID string
+	// StartOffset is where a consumer group with no committed offset begins
+	// reading (kafka.FirstOffset or kafka.LastOffset). Once the group has
+	// committed offsets for a partition, those always take precedence.
+	StartOffset int64
+	// ManualCommit commits each message only after it has been handled,
+	// instead of kafka-go committing on fetch.
+	ManualCommit bool
+	// MaxAttempts is how many times a failing message is handled before it
+	// is quarantined and skipped.
+	MaxAttempts int
+	// CheckpointInterval is how often each consume loop logs its offsets,
+	// lag and throughput; 0 disables the checkpoint lines.
+	CheckpointInterval time.Duration
+	// SessionTimeout and HeartbeatInterval are passed to the group
+	// coordinator; zero keeps kafka-go's defaults of 30s and 3s.
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	// CommitInterval batches manual commits: handled offsets are committed
+	// this often rather than after every message. 0 commits each message.
+	CommitInterval time.Duration
+	// AssignedPartitions, when set, makes the logging consumers read these
+	// partitions directly instead of joining the group.
+	AssignedPartitions []int
+	// HeaderFilter skips messages whose headers do not match. Skipped
+	// messages are still committed, so they are not read again.
+	HeaderFilter headerFilter
+	// ErrorPolicies decides, per topic, what happens to a message whose
+	// handler keeps failing.
+	ErrorPolicies errorPolicies
+	// IdleTimeout stops the loop once no message has arrived for this long;
+	// 0 reads until shutdown.
+	IdleTimeout time.Duration
+	// Budget, when set, stops the consumers after a total number of
+	// processed messages.
+	Budget *messageBudget
+}
+
+// messageReader is the subset of *kafka.Reader used by the consume loop.
+type messageReader interface {
+	ReadMessage(ctx context.Context) (kafka.Message, error)
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// commitTimeout bounds the final commit made while draining.
+const commitTimeout = 5 * time.Second
+
+// retryBackoff is the delay before the second attempt at a failing message;
+// it doubles for each further attempt.
+const retryBackoff = 200 * time.Millisecond
+
+// parseStartOffset maps KAFKA_START_OFFSET to a kafka-go start offset.
+func parseStartOffset(value string) (int64, error) {
+	switch value {
+	case "earliest":
+		return kafka.FirstOffset, nil
+	case "latest":
+		return kafka.LastOffset, nil
+	}
+	return 0, fmt.Errorf("%q must be earliest or latest", value)
+}
+
+func newReaderConfig(cfg consumerConfig, topic string) kafka.ReaderConfig {
+	return kafka.ReaderConfig{
+		Brokers:           cfg.Brokers,
+		Topic:             topic,
+		GroupID:           cfg.GroupID,
+		StartOffset:       cfg.StartOffset,
+		MinBytes:          10e3,
+		MaxBytes:          10e6,
+		SessionTimeout:    cfg.SessionTimeout,
+		HeartbeatInterval: cfg.HeartbeatInterval,
+		Logger:            newAssignmentTracker(cfg.GroupID, topic),
+	}
+}
+
+func consume(ctx context.Context, cfg consumerConfig, topic string, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	activeConsumers.Add(1)
+	defer activeConsumers.Add(-1)
+
+	if len(cfg.AssignedPartitions) > 0 {
+		consumeAssigned(ctx, cfg, topic)
+		return
+	}
+
+	r, err := newGroupReader(cfg, topic)
+	if err != nil {
+		log.Printf("Failed to start consumer for topic %s: %v", topic, err)
+		return
+	}
+	defer r.Close()
+
+	log.Printf("Consumer started for topic %s", topic)
+	runConsumer(ctx, r, cfg, topic, handleMessage)
+}
+
+// runConsumer reads until ctx is cancelled, the reader fails or, with
+// IdleTimeout set, the topic goes quiet. The readers do
+// not surface partition revocation to callers, so shutdown and rebalances are
+// both handled the same way: a message that has been fetched is always
+// processed and, with manual commit, committed before the loop exits.
+func runConsumer(ctx context.Context, r messageReader, cfg consumerConfig, topic string, handle func(context.Context, kafka.Message) error) {
+	var checkpoint *consumerCheckpoint
+	if cfg.CheckpointInterval > 0 {
+		checkpoint = newConsumerCheckpoint(topic)
+		go checkpoint.run(ctx, cfg.CheckpointInterval)
+	}
+	var committer *batchCommitter
+	if cfg.ManualCommit && cfg.CommitInterval > 0 {
+		committer = newBatchCommitter(r, topic, cfg.CommitInterval)
+		defer committer.stop()
+	}
+	policy := cfg.ErrorPolicies.forTopic(topic)
+	maxAttempts := cfg.MaxAttempts
+	if policy == policyDrop {
+		maxAttempts = 1
+	}
+	for {
+		var (
+			m   kafka.Message
+			err error
+		)
+		fetchCtx, cancelFetch := ctx, context.CancelFunc(func() {})
+		if cfg.IdleTimeout > 0 {
+			fetchCtx, cancelFetch = context.WithTimeout(ctx, cfg.IdleTimeout)
+		}
+		if cfg.ManualCommit {
+			m, err = r.FetchMessage(fetchCtx)
+		} else {
+			m, err = r.ReadMessage(fetchCtx)
+		}
+		cancelFetch()
+		if err != nil {
+			if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) && cfg.IdleTimeout > 0 {
+				log.Printf("Consumer for topic %s stopped after %s without messages", topic, cfg.IdleTimeout)
+			} else if ctx.Err() != nil {
+				log.Printf("Consumer for topic %s stopped", topic)
+			} else {
+				log.Printf("Error reading message from topic %s: %v", topic, err)
+			}
+			return
+		}
+
+		// The message in hand is finished even if shutdown started meanwhile.
+		workCtx := context.WithoutCancel(ctx)
+		if !cfg.HeaderFilter.matches(m) {
+			messagesFiltered.WithLabelValues(m.Topic).Inc()
+		} else {
+			attempts, err := handleWithRetry(workCtx, m, maxAttempts, handle)
+			var decodeErr deserializationError
+			for policy == policyRetry && err != nil && !errors.Is(err, errConsumerStopped) && !unretryable(err) {
+				log.Printf("Still failing to handle message from topic %s at offset %d after %d attempts, retrying in %s: %v", m.Topic, m.Offset, attempts, retryRoundPause, err)
+				select {
+				case <-ctx.Done():
+					log.Printf("Consumer for topic %s stopped while retrying offset %d", topic, m.Offset)
+					return
+				case <-time.After(retryRoundPause):
+				}
+				var n int
+				n, err = handleWithRetry(workCtx, m, maxAttempts, handle)
+				attempts += n
+			}
+			if errors.Is(err, errConsumerStopped) {
+				log.Printf("Consumer for topic %s stopped before offset %d was handed over", topic, m.Offset)
+				return
+			}
+			var expiredErr expiredError
+			switch {
+			case err == nil:
+			case policy == policyDrop:
+				if errors.As(err, &decodeErr) {
+					recordDeserializationError(m, err)
+				} else {
+					log.Printf("Dropped message from topic %s at offset %d: %v", m.Topic, m.Offset, err)
+				}
+				messagesDropped.WithLabelValues(m.Topic).Inc()
+			case policy == policyHalt && !errors.As(err, &expiredErr):
+				if errors.As(err, &decodeErr) {
+					recordDeserializationError(m, err)
+				}
+				haltConsumer(m, err)
+				return
+			default:
+				// An expired message is dead-lettered even under the halt
+				// policy: it is not a failure that needs an operator.
+				if errors.As(err, &decodeErr) {
+					recordDeserializationError(m, err)
+				} else {
+					log.Printf("Failed to handle message from topic %s at offset %d: %v", m.Topic, m.Offset, err)
+				}
+				if qerr := quarantineMessage(workCtx, m, attempts, err); qerr != nil {
+					haltConsumer(m, qerr)
+					return
+				}
+			}
+		}
+		if checkpoint != nil {
+			checkpoint.record(m)
+		}
+		if committer != nil {
+			committer.add(m)
+		} else if cfg.ManualCommit {
+			commitCtx, cancel := context.WithTimeout(workCtx, commitTimeout)
+			err := r.CommitMessages(commitCtx, m)
+			cancel()
+			if err != nil {
+				log.Printf("Failed to commit offset %d for topic %s: %v", m.Offset, m.Topic, err)
+			}
+		}
+		if cfg.Budget.spend() {
+			log.Printf("Consumer for topic %s stopped", topic)
+			return
+		}
+	}
+}
+
+// handleWithRetry calls handle up to maxAttempts times, returning the number
+// of attempts made and the last error.
+func handleWithRetry(ctx context.Context, m kafka.Message, maxAttempts int, handle func(context.Context, kafka.Message) error) (int, error) {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := handle(ctx, m)
+		if err == nil || attempt >= maxAttempts || errors.Is(err, errConsumerStopped) || unretryable(err) {
+			return attempt, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// invalidEventError is returned for a consumed event that decodes but fails
+// validation, typically one written by a producer other than this service.
+type invalidEventError struct{ violations []Violation }
+
+func (e invalidEventError) Error() string {
+	v := e.violations[0]
+	return fmt.Sprintf("invalid event: %s: %s", v.Field, v.Message)
+}
+
+// unretryable reports whether handling a message again cannot succeed, so
+// retrying it is pointless.
+func unretryable(err error) bool {
+	var (
+		decodeErr  deserializationError
+		invalidErr invalidEventError
+	)
+	var expiredErr expiredError
+	return errors.As(err, &decodeErr) || errors.As(err, &invalidErr) || errors.As(err, &expiredErr)
+}
+
+func handleMessage(ctx context.Context, m kafka.Message) error {
+	if m.Value == nil {
+		log.Printf("[CONSUMER] Tombstone from topic %s at offset %d for key %s", m.Topic, m.Offset, string(m.Key))
+		return nil
+	}
+	if err := checkExpiry(m, time.Now()); err != nil {
+		log.Printf("[CONSUMER] Skipping expired message from topic %s at offset %d", m.Topic, m.Offset)
+		return err
+	}
+	consumedRate.add(m.Topic, 1, time.Now())
+	value, err := messageValue(ctx, m)
+	if err != nil {
+		return err
+	}
+	event, _, err := decodeConsumed(m, value)
+	if err != nil {
+		return deserializationError{fmt.Errorf("decode event: %w", err)}
+	}
+	if event != nil {
+		if violations := event.Validate(); len(violations) > 0 {
+			return invalidEventError{violations}
+		}
+	}
+	log.Printf("[CONSUMER] Received message from topic %s at offset %d%s: %s = %s\n", m.Topic, m.Offset, describeSource(m), string(m.Key), string(value))
+	if sink != nil {
+		return sink.deliver(ctx, m, value)
+	}
+	return nil
+}
+
+// plainValue undoes Avro framing so the value is the event's JSON.
+func plainValue(ctx context.Context, value []byte) ([]byte, error) {
+	if codec != nil && isAvroFramed(value) {
+		decoded, err := codec.decodeJSON(ctx, value)
+		if err != nil {
+			return nil, fmt.Errorf("decode Avro payload: %w", err)
+		}
+		value = decoded
+	}
+	return value, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestStartOffset(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"earliest", kafka.FirstOffset, false},
+		{"latest", kafka.LastOffset, false},
+		{"newest", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			offset, err := parseStartOffset(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStartOffset(%q) error = %v, want error: %v", tt.value, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			cfg := newReaderConfig(consumerConfig{Brokers: []string{"kafka:9092"}, GroupID: "events", StartOffset: offset}, "movie-events")
+			if cfg.StartOffset != tt.want {
+				t.Fatalf("ReaderConfig.StartOffset = %d, want %d", cfg.StartOffset, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsumerDrainsOnCancel(t *testing.T) {
+	tests := []struct {
+		name          string
+		manualCommit  bool
+		wantCommitted []int64
+	}{
+		{"manual commit", true, []int64{1}},
+		{"auto commit", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeReader{msgs: []kafka.Message{{Topic: "movie-events", Offset: 1}, {Topic: "movie-events", Offset: 2}}}
+			ctx, cancel := context.WithCancel(context.Background())
+			var handled []int64
+			handle := func(hctx context.Context, m kafka.Message) error {
+				// Shutdown or a rebalance arrives while the message is in hand.
+				cancel()
+				if hctx.Err() != nil {
+					return errors.New("handler saw the cancellation")
+				}
+				handled = append(handled, m.Offset)
+				return nil
+			}
+			done := make(chan struct{})
+			go func() {
+				runConsumer(ctx, r, consumerConfig{ManualCommit: tt.manualCommit, MaxAttempts: 1}, "movie-events", handle)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("consumer did not stop after cancellation")
+			}
+			if len(handled) != 1 || handled[0] != 1 {
+				t.Fatalf("handled %v, want only the in-flight offset 1", handled)
+			}
+			if got := r.commits(); fmt.Sprint(got) != fmt.Sprint(tt.wantCommitted) {
+				t.Fatalf("committed %v, want %v", got, tt.wantCommitted)
+			}
+		})
+	}
+}
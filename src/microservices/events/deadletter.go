@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+var eventsDeadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_dead_lettered_total",
+	Help: "Produce requests written to the dead-letter file after exhausting retries, by topic and error code.",
+}, []string{"topic", "code"})
+
+// deadLetter is one line of PRODUCE_DLQ_FILE. The embedded event uses the
+// same shape as the async spool, so entries can be moved into
+// PRODUCE_BUFFER_DIR/pending.jsonl to be produced again.
+type deadLetter struct {
+	bufferedEvent
+	Code     string    `json:"code"`
+	Category string    `json:"category"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// deadLetterFile keeps events that synchronous produce gave up on, so the
+// caller gets a 202 instead of losing the event to a transient Kafka outage.
+type deadLetterFile struct {
+	path string
+	mu   sync.Mutex
+}
+
+// produceDLQ is nil unless PRODUCE_DLQ_FILE is set.
+var produceDLQ *deadLetterFile
+
+// accept appends msg if perr is worth deferring, reporting whether it did.
+// Validation and fatal errors are returned to the caller as before.
+func (d *deadLetterFile) accept(base string, msg kafka.Message, perr *ProduceError) bool {
+	if d == nil || !perr.Category.retryable() {
+		return false
+	}
+	entry := deadLetter{
+		bufferedEvent: bufferedEvent{Base: base, Topic: msg.Topic, Key: msg.Key, Value: msg.Value, Headers: msg.Headers},
+		Code:          perr.Code,
+		Category:      perr.Category.String(),
+		Error:         perr.Error(),
+		FailedAt:      time.Now(),
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to encode dead letter for %s: %v", msg.Topic, err)
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.OpenFile(d.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Failed to open PRODUCE_DLQ_FILE %s: %v", d.path, err)
+		return false
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Printf("Failed to write dead letter for %s to %s: %v", msg.Topic, d.path, err)
+		return false
+	}
+	eventsDeadLettered.WithLabelValues(msg.Topic, perr.Code).Inc()
+	log.Printf("[DLQ] Event for %s dead-lettered to %s after %s", msg.Topic, d.path, perr.Code)
+	return true
+}
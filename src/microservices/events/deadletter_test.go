@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestProduceRetryAndDeadLetter(t *testing.T) {
+	unavailable := kafka.LeaderNotAvailable
+	tests := []struct {
+		name       string
+		errs       []error
+		dlq        bool
+		wantStatus int
+		wantWrites int
+		// wantDLQ and wantCategory describe the dead-lettered event, if any.
+		wantDLQ      string
+		wantCategory string
+	}{
+		{"transient failure succeeds on retry", []error{unavailable}, true, http.StatusCreated, 2, "", ""},
+		{"succeeds on the last attempt", []error{unavailable, unavailable}, true, http.StatusCreated, 3, "", ""},
+		{"exhausted retries dead-lettered", []error{unavailable, unavailable, unavailable}, true, http.StatusAccepted, 3, "kafka_unavailable", "transient"},
+		{"exhausted timeouts dead-lettered", []error{errTimeout, errTimeout, errTimeout}, true, http.StatusAccepted, 3, "kafka_timeout", "timeout"},
+		{"exhausted without a DLQ", []error{unavailable, unavailable, unavailable}, false, http.StatusServiceUnavailable, 3, "", ""},
+		{"fatal error not retried", []error{errors.New("broker rejected the record")}, true, http.StatusInternalServerError, 1, "", ""},
+		{"unknown topic not deferred", []error{kafka.UnknownTopicOrPartition}, true, http.StatusServiceUnavailable, 1, "", ""},
+		{"too large not retried", []error{kafka.MessageSizeTooLarge}, true, http.StatusRequestEntityTooLarge, 1, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubWriter{errs: tt.errs}
+			path := filepath.Join(t.TempDir(), "dlq.jsonl")
+			prevWriters, prevDLQ, prevAttempts, prevBackoff := topicWriters, produceDLQ, produceAttempts, produceRetryBackoff
+			topicWriters, produceDLQ, produceAttempts, produceRetryBackoff = map[string]eventWriter{movieTopic: stub}, nil, 3, time.Millisecond
+			defer func() {
+				topicWriters, produceDLQ, produceAttempts, produceRetryBackoff = prevWriters, prevDLQ, prevAttempts, prevBackoff
+			}()
+			if tt.dlq {
+				produceDLQ = &deadLetterFile{path: path}
+			}
+			counter := eventsDeadLettered.WithLabelValues(movieTopic, tt.wantDLQ)
+			before := testutil.ToFloat64(counter)
+
+			rec := serve(handleEvent(movieTopic), jsonRequest(http.MethodPost, "/api/events/movie", `{"movie_id": 1, "title": "Heat", "action": "viewed"}`))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if stub.writes != tt.wantWrites {
+				t.Errorf("writes = %d, want %d", stub.writes, tt.wantWrites)
+			}
+
+			entries := readDeadLetters(t, path)
+			if tt.wantDLQ == "" {
+				if len(entries) != 0 {
+					t.Fatalf("dead-lettered %d events, want none", len(entries))
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("dead-lettered %d events, want 1", len(entries))
+			}
+			e := entries[0]
+			if e.Category != tt.wantCategory || !strings.Contains(e.Error, tt.errs[0].Error()) {
+				t.Errorf("dead letter category %q error %q, want %s and the produce error", e.Category, e.Error, tt.wantCategory)
+			}
+			if e.Code != tt.wantDLQ || e.Base != movieTopic || e.Topic != topicName(movieTopic) || !strings.Contains(string(e.Value), `"Heat"`) {
+				t.Errorf("dead letter = %+v", e)
+			}
+			var resp struct{ Status, Code string }
+			decodeJSON(t, rec, &resp)
+			if resp.Status != "deferred" || resp.Code != tt.wantDLQ {
+				t.Errorf("response = %+v, want deferred with %s", resp, tt.wantDLQ)
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("events_dead_lettered_total grew by %v, want 1", got)
+			}
+		})
+	}
+}
+
+// errTimeout is a write that ran out of time.
+var errTimeout = &timeoutError{}
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
+
+func readDeadLetters(t *testing.T, path string) []deadLetter {
+	t.Helper()
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []deadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("dead letter %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+var deserializationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "kafka_deserialization_errors_total",
+	Help: "Consumed messages whose value could not be decoded, by topic.",
+}, []string{"topic"})
+
+// deserializationError marks a message that can never be handled as sent.
+// Retrying it is pointless, so the consumer quarantines it straight away.
+type deserializationError struct{ err error }
+
+func (e deserializationError) Error() string { return e.err.Error() }
+
+func (e deserializationError) Unwrap() error { return e.err }
+
+// logSampler limits repetitive log lines per key: the first burst lines are
+// written, then at most one per interval, carrying the count suppressed in
+// between.
+type logSampler struct {
+	burst    int
+	interval time.Duration
+
+	mu    sync.Mutex
+	state map[string]*sampleState
+}
+
+type sampleState struct {
+	seen       int
+	suppressed int
+	last       time.Time
+}
+
+func newLogSampler(burst int, interval time.Duration) *logSampler {
+	return &logSampler{burst: burst, interval: interval, state: make(map[string]*sampleState)}
+}
+
+// allow reports whether the occurrence at now should be logged, and how many
+// were suppressed since the last logged one.
+func (s *logSampler) allow(key string, now time.Time) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.state[key]
+	if !ok {
+		st = &sampleState{}
+		s.state[key] = st
+	}
+	st.seen++
+	if st.seen <= s.burst || now.Sub(st.last) >= s.interval {
+		suppressed := st.suppressed
+		st.suppressed, st.last = 0, now
+		return true, suppressed
+	}
+	st.suppressed++
+	return false, 0
+}
+
+// deserializationLogs is set from DESERIALIZATION_LOG_BURST and
+// DESERIALIZATION_LOG_INTERVAL.
+var deserializationLogs = newLogSampler(10, time.Minute)
+
+// recordDeserializationError counts a decode failure and logs it if the
+// sampler lets it through.
+func recordDeserializationError(m kafka.Message, err error) {
+	deserializationErrors.WithLabelValues(m.Topic).Inc()
+	ok, suppressed := deserializationLogs.allow(m.Topic, time.Now())
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		log.Printf("[CONSUMER] Undecodable message from topic %s at offset %d: %v (%d similar errors suppressed)", m.Topic, m.Offset, err, suppressed)
+		return
+	}
+	log.Printf("[CONSUMER] Undecodable message from topic %s at offset %d: %v", m.Topic, m.Offset, err)
+}
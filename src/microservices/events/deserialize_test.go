@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestLogSampler(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	type occurrence struct {
+		at             time.Duration
+		wantLogged     bool
+		wantSuppressed int
+	}
+	tests := []struct {
+		name        string
+		burst       int
+		occurrences []occurrence
+	}{
+		{"burst logged", 3, []occurrence{{0, true, 0}, {0, true, 0}, {0, true, 0}, {0, false, 0}, {time.Second, false, 0}}},
+		{"one per interval after the burst", 1, []occurrence{{0, true, 0}, {time.Second, false, 0}, {2 * time.Second, false, 0}, {time.Minute, true, 2}, {time.Minute + time.Second, false, 0}, {2 * time.Minute, true, 1}}},
+		{"no burst", 0, []occurrence{{0, true, 0}, {time.Second, false, 0}, {time.Minute, true, 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newLogSampler(tt.burst, time.Minute)
+			for i, o := range tt.occurrences {
+				logged, suppressed := s.allow(movieTopic, start.Add(o.at))
+				if logged != o.wantLogged || suppressed != o.wantSuppressed {
+					t.Errorf("occurrence %d at %s = %v, %d suppressed, want %v, %d", i, o.at, logged, suppressed, o.wantLogged, o.wantSuppressed)
+				}
+			}
+			// Keys are sampled independently.
+			if logged, _ := s.allow(userTopic, start); !logged {
+				t.Error("first error of another topic suppressed")
+			}
+		})
+	}
+}
+
+func TestRepeatedBadMessages(t *testing.T) {
+	const bad = 25
+	prevLogs, prevQuarantine := deserializationLogs, quarantine
+	deserializationLogs = newLogSampler(3, time.Hour)
+	quarantine, _ = newQuarantineStore(bad, "")
+	defer func() { deserializationLogs, quarantine = prevLogs, prevQuarantine }()
+
+	var msgs []kafka.Message
+	for i := 0; i < bad; i++ {
+		msgs = append(msgs, kafka.Message{Topic: movieTopic, Offset: int64(i), Value: []byte(`{"movie_id": `)})
+	}
+	r := &fakeReader{msgs: msgs}
+	counter := deserializationErrors.WithLabelValues(movieTopic)
+	before := testutil.ToFloat64(counter)
+	out := &syncBuffer{}
+	log.SetOutput(out)
+	defer log.SetOutput(io.Discard)
+
+	handled := 0
+	runConsumer(context.Background(), r, consumerConfig{MaxAttempts: 3, ManualCommit: true}, movieTopic, func(ctx context.Context, m kafka.Message) error {
+		handled++
+		return handleMessage(ctx, m)
+	})
+
+	if got := testutil.ToFloat64(counter) - before; got != bad {
+		t.Errorf("kafka_deserialization_errors_total grew by %v, want %d", got, bad)
+	}
+	if logged := strings.Count(out.String(), "Undecodable message from topic"); logged != 3 {
+		t.Errorf("logged %d undecodable messages, want the burst of 3:\n%s", logged, out.String())
+	}
+	if handled != bad {
+		t.Errorf("handled %d times, want each bad message once without retries", handled)
+	}
+	if n := len(quarantine.list()); n != bad {
+		t.Errorf("quarantined %d messages, want %d", n, bad)
+	}
+	if len(r.committed) != bad {
+		t.Errorf("committed %d messages, want %d", len(r.committed), bad)
+	}
+}
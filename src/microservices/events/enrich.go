@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Enricher adds derived fields to a decoded event before it is produced.
+type Enricher interface {
+	Enrich(r *http.Request, event map[string]interface{})
+}
+
+// EnricherFunc adapts a function to the Enricher interface.
+type EnricherFunc func(r *http.Request, event map[string]interface{})
+
+func (f EnricherFunc) Enrich(r *http.Request, event map[string]interface{}) { f(r, event) }
+
+// receivedAt stamps the server-side receive time.
+var receivedAt = EnricherFunc(func(r *http.Request, event map[string]interface{}) {
+	event["received_at"] = time.Now().UTC().Format(time.RFC3339Nano)
+})
+
+// sourceTag copies the X-Source header, if present, into the event.
+var sourceTag = EnricherFunc(func(r *http.Request, event map[string]interface{}) {
+	if source := r.Header.Get("X-Source"); source != "" {
+		event["source"] = source
+	}
+})
+
+// clientIPTag records the address of the caller, see ClientIP.
+var clientIPTag = EnricherFunc(func(r *http.Request, event map[string]interface{}) {
+	event["client_ip"] = ClientIP(r)
+})
+
+var availableEnrichers = map[string]Enricher{
+	"received_at": receivedAt,
+	"source":      sourceTag,
+	"client_ip":   clientIPTag,
+}
+
+// enrichers is the active set, configured with EVENT_ENRICHERS.
+var enrichers []Enricher
+
+// parseEnrichers resolves a comma-separated list of enricher names.
+func parseEnrichers(value string) ([]Enricher, error) {
+	var list []Enricher
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		e, ok := availableEnrichers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown enricher %q", name)
+		}
+		list = append(list, e)
+	}
+	return list, nil
+}
+
+// marshalEvent encodes the event, applying the configured enrichers to its
+// JSON object form first.
+func marshalEvent(r *http.Request, event Event) ([]byte, error) {
+	if len(enrichers) == 0 {
+		return json.Marshal(event)
+	}
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	for _, e := range enrichers {
+		e.Enrich(r, fields)
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestEnrichers(t *testing.T) {
+	tests := []struct {
+		name       string
+		enrichers  string
+		source     string
+		wantFields []string
+		wantErr    bool
+	}{
+		{"none", "", "", nil, false},
+		{"received_at", "received_at", "", []string{"received_at"}, false},
+		{"source with header", "source", "billing", []string{"source"}, false},
+		{"source without header", "source", "", nil, false},
+		{"all", "received_at, source, client_ip", "billing", []string{"client_ip", "received_at", "source"}, false},
+		{"unknown", "geo", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := parseEnrichers(tt.enrichers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEnrichers error = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			prev := enrichers
+			enrichers = list
+			defer func() { enrichers = prev }()
+
+			r := jsonRequest(http.MethodPost, "/api/events/movie", "")
+			if tt.source != "" {
+				r.Header.Set("X-Source", tt.source)
+			}
+			msg, err := newEventMessage(r, movieTopic, &MovieEvent{MovieID: 1, Title: "Heat", Action: "viewed"}, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]interface{}
+			if err := json.Unmarshal(msg.Value, &fields); err != nil {
+				t.Fatal(err)
+			}
+			var extra []string
+			for k := range fields {
+				switch k {
+				case "movie_id", "title", "action", "user_id":
+				default:
+					extra = append(extra, k)
+				}
+			}
+			sort.Strings(extra)
+			if strings.Join(extra, ",") != strings.Join(tt.wantFields, ",") {
+				t.Fatalf("produced message has extra fields %v, want %v", extra, tt.wantFields)
+			}
+			if tt.source != "" && fields["source"] != nil && fields["source"] != tt.source {
+				t.Fatalf("source = %v, want %s", fields["source"], tt.source)
+			}
+			if fields["movie_id"] != float64(1) {
+				t.Fatalf("movie_id = %v, want 1", fields["movie_id"])
+			}
+		})
+	}
+}
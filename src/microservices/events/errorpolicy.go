@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+// errorPolicy selects what a consume loop does with a message its handler
+// keeps failing on.
+type errorPolicy string
+
+const (
+	// policyDLQ retries up to CONSUMER_MAX_ATTEMPTS, then quarantines the
+	// message and moves on. It is the default.
+	policyDLQ errorPolicy = "dlq"
+	// policyRetry never skips a message: it keeps retrying, pausing between
+	// rounds, until the handler succeeds or the consumer shuts down.
+	// Messages that cannot be decoded or fail validation are still
+	// quarantined, since no retry can fix them.
+	policyRetry errorPolicy = "retry"
+	// policyDrop does not retry: the message is committed, counted and
+	// skipped.
+	policyDrop errorPolicy = "drop"
+	// policyHalt retries like dlq, then stops consuming the topic without
+	// committing the message and fails readiness until restarted. With
+	// KAFKA_MANUAL_COMMIT off kafka-go has already committed the offset, so
+	// the message is not redelivered after the restart.
+	policyHalt errorPolicy = "halt"
+)
+
+// retryRoundPause separates rounds of attempts under the retry policy.
+var retryRoundPause = 5 * time.Second
+
+func parseErrorPolicy(value string) (errorPolicy, error) {
+	switch p := errorPolicy(value); p {
+	case policyDLQ, policyRetry, policyDrop, policyHalt:
+		return p, nil
+	}
+	return "", fmt.Errorf("%q must be retry, dlq, drop or halt", value)
+}
+
+// errorPolicies is the policy for each base topic, with a fallback for the
+// rest. The zero value applies dlq everywhere.
+type errorPolicies struct {
+	fallback errorPolicy
+	topics   map[string]errorPolicy
+}
+
+// parseErrorPolicies reads CONSUMER_ERROR_POLICY and CONSUMER_ERROR_POLICIES,
+// the latter a comma-separated list such as
+// "payment-events=retry,movie-events=drop".
+func parseErrorPolicies(fallback, value string) (errorPolicies, error) {
+	def, err := parseErrorPolicy(fallback)
+	if err != nil {
+		return errorPolicies{}, err
+	}
+	p := errorPolicies{fallback: def, topics: make(map[string]errorPolicy)}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		topic, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			return errorPolicies{}, fmt.Errorf("%q is not a topic=policy pair", pair)
+		}
+		topic = strings.TrimSpace(topic)
+		if !isServiceTopic(topic) {
+			return errorPolicies{}, fmt.Errorf("unknown topic %q", topic)
+		}
+		if p.topics[topic], err = parseErrorPolicy(strings.TrimSpace(name)); err != nil {
+			return errorPolicies{}, fmt.Errorf("topic %s: %w", topic, err)
+		}
+	}
+	return p, nil
+}
+
+// forTopic returns the policy for a full topic name.
+func (p errorPolicies) forTopic(topic string) errorPolicy {
+	if policy, ok := p.topics[strings.TrimPrefix(topic, topicPrefix)]; ok {
+		return policy
+	}
+	if p.fallback == "" {
+		return policyDLQ
+	}
+	return p.fallback
+}
+
+var (
+	messagesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_messages_dropped_total",
+		Help: "Consumed messages skipped after a handling failure under the drop error policy, by topic.",
+	}, []string{"topic"})
+	consumerHalted = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_consumer_halted",
+		Help: "1 for topics whose consumer stopped under the halt error policy.",
+	}, []string{"topic"})
+)
+
+// halted holds the topics whose consumer stopped under the halt policy.
+var halted = struct {
+	mu     sync.Mutex
+	topics map[string]string
+}{topics: make(map[string]string)}
+
+func haltConsumer(m kafka.Message, err error) {
+	halted.mu.Lock()
+	halted.topics[m.Topic] = fmt.Sprintf("offset %d: %v", m.Offset, err)
+	halted.mu.Unlock()
+	consumerHalted.WithLabelValues(m.Topic).Set(1)
+	log.Printf("[CONSUMER] HALTED consumer for topic %s at offset %d after handling failed: %v", m.Topic, m.Offset, err)
+}
+
+// haltedTopics maps each halted topic to the failure that stopped it.
+func haltedTopics() map[string]string {
+	halted.mu.Lock()
+	defer halted.mu.Unlock()
+	out := make(map[string]string, len(halted.topics))
+	for topic, reason := range halted.topics {
+		out[topic] = reason
+	}
+	return out
+}
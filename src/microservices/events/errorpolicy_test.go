@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestParseErrorPolicies(t *testing.T) {
+	tests := []struct {
+		fallback string
+		value    string
+		want     map[string]errorPolicy
+		wantErr  bool
+	}{
+		{"dlq", "", map[string]errorPolicy{movieTopic: policyDLQ, paymentTopic: policyDLQ}, false},
+		{"drop", "payment-events=retry", map[string]errorPolicy{movieTopic: policyDrop, paymentTopic: policyRetry}, false},
+		{"dlq", " payment-events = halt , movie-events=drop ,", map[string]errorPolicy{movieTopic: policyDrop, paymentTopic: policyHalt, userTopic: policyDLQ}, false},
+		{"skip", "", nil, true},
+		{"dlq", "payment-events", nil, true},
+		{"dlq", "orders=drop", nil, true},
+		{"dlq", "payment-events=ignore", nil, true},
+	}
+	for _, tt := range tests {
+		p, err := parseErrorPolicies(tt.fallback, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseErrorPolicies(%q, %q) err = %v, want error %v", tt.fallback, tt.value, err, tt.wantErr)
+		}
+		for topic, want := range tt.want {
+			if got := p.forTopic(topic); got != want {
+				t.Errorf("parseErrorPolicies(%q, %q) policy for %s = %s, want %s", tt.fallback, tt.value, topic, got, want)
+			}
+		}
+	}
+	if got := (errorPolicies{}).forTopic(movieTopic); got != policyDLQ {
+		t.Errorf("zero policies give %s, want dlq", got)
+	}
+}
+
+func TestRunConsumerErrorPolicies(t *testing.T) {
+	failing := errors.New("sink down")
+	tests := []struct {
+		name            string
+		policy          errorPolicy
+		failures        int // attempts on offset 1 that fail, -1 for all of them
+		err             error
+		wantAttempts    int
+		wantCommitted   int
+		wantNext        bool // offset 2 is handled after offset 1
+		wantQuarantined int
+		wantDropped     float64
+		wantHalted      bool
+	}{
+		{"dlq quarantines after the attempts", policyDLQ, -1, failing, 2, 2, true, 1, 0, false},
+		{"drop skips without retrying", policyDrop, -1, failing, 1, 2, true, 0, 1, false},
+		{"retry keeps going until it succeeds", policyRetry, 3, failing, 4, 2, true, 0, 0, false},
+		{"retry quarantines undecodable messages", policyRetry, -1, deserializationError{errors.New("bad json")}, 1, 2, true, 1, 0, false},
+		{"halt stops without committing", policyHalt, -1, failing, 2, 0, false, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prevQuarantine, prevPause := quarantine, retryRoundPause
+			quarantine, _ = newQuarantineStore(10, "")
+			retryRoundPause = time.Millisecond
+			defer func() { quarantine, retryRoundPause = prevQuarantine, prevPause }()
+			defer func() {
+				halted.mu.Lock()
+				delete(halted.topics, movieTopic)
+				halted.mu.Unlock()
+				consumerHalted.WithLabelValues(movieTopic).Set(0)
+			}()
+			dropped := messagesDropped.WithLabelValues(movieTopic)
+			before := testutil.ToFloat64(dropped)
+
+			r := &fakeReader{msgs: []kafka.Message{
+				{Topic: movieTopic, Offset: 1, Value: []byte(`{}`)},
+				{Topic: movieTopic, Offset: 2, Value: []byte(`{}`)},
+			}}
+			cfg := consumerConfig{MaxAttempts: 2, ManualCommit: true, ErrorPolicies: errorPolicies{fallback: tt.policy}}
+			attempts, next := 0, false
+			runConsumer(context.Background(), r, cfg, movieTopic, func(ctx context.Context, m kafka.Message) error {
+				if m.Offset == 2 {
+					next = true
+					return nil
+				}
+				attempts++
+				if tt.failures < 0 || attempts <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if len(r.committed) != tt.wantCommitted {
+				t.Errorf("committed %d messages, want %d", len(r.committed), tt.wantCommitted)
+			}
+			if next != tt.wantNext {
+				t.Errorf("next message handled = %v, want %v", next, tt.wantNext)
+			}
+			if n := len(quarantine.list()); n != tt.wantQuarantined {
+				t.Errorf("quarantined %d messages, want %d", n, tt.wantQuarantined)
+			}
+			if got := testutil.ToFloat64(dropped) - before; got != tt.wantDropped {
+				t.Errorf("dropped counter rose by %v, want %v", got, tt.wantDropped)
+			}
+			_, isHalted := haltedTopics()[movieTopic]
+			if isHalted != tt.wantHalted {
+				t.Errorf("halted = %v, want %v", isHalted, tt.wantHalted)
+			}
+			if got := testutil.ToFloat64(consumerHalted.WithLabelValues(movieTopic)); (got == 1) != tt.wantHalted {
+				t.Errorf("kafka_consumer_halted = %v, want halted %v", got, tt.wantHalted)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type MovieEvent struct {
+	MovieID int    `json:"movie_id"`
+	Title   string `json:"title"`
+	Action  string `json:"action"`
+	UserID  int    `json:"user_id"`
+}
+
+type UserEvent struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Action    string    `json:"action"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type PaymentEvent struct {
+	PaymentID int       `json:"payment_id"`
+	UserID    int       `json:"user_id"`
+	Amount    float64   `json:"amount"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Violation describes a single field that failed validation.
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Event is implemented by every payload the service accepts.
+type Event interface {
+	Validate() []Violation
+}
+
+func required(field string) Violation {
+	return Violation{Field: field, Rule: "required", Message: field + " is required"}
+}
+
+func positive(field string) Violation {
+	return Violation{Field: field, Rule: "positive", Message: field + " must be greater than 0"}
+}
+
+func (e *MovieEvent) Validate() []Violation {
+	var v []Violation
+	if e.MovieID <= 0 {
+		v = append(v, positive("movie_id"))
+	}
+	if e.Title == "" {
+		v = append(v, required("title"))
+	}
+	if e.Action == "" {
+		v = append(v, required("action"))
+	}
+	return v
+}
+
+func (e *UserEvent) Validate() []Violation {
+	var v []Violation
+	if e.UserID <= 0 {
+		v = append(v, positive("user_id"))
+	}
+	if e.Action == "" {
+		v = append(v, required("action"))
+	}
+	if e.Timestamp.IsZero() {
+		v = append(v, required("timestamp"))
+	}
+	return v
+}
+
+func (e *PaymentEvent) Validate() []Violation {
+	var v []Violation
+	if e.PaymentID <= 0 {
+		v = append(v, positive("payment_id"))
+	}
+	if e.UserID <= 0 {
+		v = append(v, positive("user_id"))
+	}
+	if e.Amount < 0 {
+		v = append(v, Violation{Field: "amount", Rule: "non_negative", Message: "amount must not be negative"})
+	}
+	if e.Status == "" {
+		v = append(v, required("status"))
+	}
+	if e.Timestamp.IsZero() {
+		v = append(v, required("timestamp"))
+	}
+	return v
+}
+
+// eventTypes maps the short type names used in URLs to base topics.
+var eventTypes = map[string]string{
+	"movie":   movieTopic,
+	"user":    userTopic,
+	"payment": paymentTopic,
+}
+
+func newEvent(topic string) (Event, error) {
+	switch topic {
+	case movieTopic:
+		return &MovieEvent{}, nil
+	case userTopic:
+		return &UserEvent{}, nil
+	case paymentTopic:
+		return &PaymentEvent{}, nil
+	}
+	return nil, fmt.Errorf("unknown event type for topic %s", topic)
+}
+
+// Decoding modes, set from STRICT_DECODING and COMPAT_FIELD_NAMES.
+var (
+	// strictDecoding rejects fields the event type does not define.
+	strictDecoding bool
+	// compatFieldNames rewrites camelCase and PascalCase spellings of known
+	// fields to their snake_case names before decoding.
+	compatFieldNames bool
+	// requireJSONContentType, from REQUIRE_JSON_CONTENT_TYPE, answers 415 to
+	// event bodies not declared as JSON. Off by default because older
+	// clients post with text/plain or no Content-Type at all.
+	requireJSONContentType bool
+)
+
+// isJSONContentType accepts application/json and structured +json types
+// such as application/vnd.cinemaabyss+json, with any parameters.
+func isJSONContentType(value string) bool {
+	mt, _, err := mime.ParseMediaType(value)
+	return err == nil && (mt == "application/json" || (strings.HasPrefix(mt, "application/") && strings.HasSuffix(mt, "+json")))
+}
+
+func acceptsContentType(r *http.Request) bool {
+	return !requireJSONContentType || isJSONContentType(r.Header.Get("Content-Type"))
+}
+
+// decodeEvent reads the payload for the given base topic.
+func decodeEvent(topic string, body io.Reader) (Event, error) {
+	return decodeEventMode(topic, body, strictDecoding)
+}
+
+func decodeEventMode(topic string, body io.Reader, strict bool) (Event, error) {
+	event, err := newEvent(topic)
+	if err != nil {
+		return nil, err
+	}
+	if compatFieldNames {
+		if body, err = normalizeFieldNames(event, body); err != nil {
+			return nil, err
+		}
+	}
+	dec := json.NewDecoder(body)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
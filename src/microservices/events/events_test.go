@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIsJSONContentType(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"Application/JSON", true},
+		{"application/vnd.cinemaabyss+json", true},
+		{"text/plain", false},
+		{"text/json+xml", false},
+		{"application/x-www-form-urlencoded", false},
+		{"", false},
+		{"application/json; =broken", false},
+	}
+	for _, tt := range tests {
+		if got := isJSONContentType(tt.value); got != tt.want {
+			t.Errorf("isJSONContentType(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestContentTypeEnforcement(t *testing.T) {
+	tests := []struct {
+		name         string
+		enforced     bool
+		contentType  string
+		wantProduce  int
+		wantValidate int
+	}{
+		{"JSON accepted", true, "application/json", http.StatusCreated, http.StatusOK},
+		{"JSON with charset accepted", true, "application/json; charset=utf-8", http.StatusCreated, http.StatusOK},
+		{"text/plain rejected", true, "text/plain", http.StatusUnsupportedMediaType, http.StatusUnsupportedMediaType},
+		{"missing Content-Type rejected", true, "", http.StatusUnsupportedMediaType, http.StatusUnsupportedMediaType},
+		{"text/plain allowed when not enforced", false, "text/plain", http.StatusCreated, http.StatusOK},
+		{"missing Content-Type allowed when not enforced", false, "", http.StatusCreated, http.StatusOK},
+	}
+	const body = `{"movie_id": 1, "title": "Heat", "action": "viewed"}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &recordingWriter{}
+			prevWriters, prevRequire := topicWriters, requireJSONContentType
+			topicWriters, requireJSONContentType = map[string]eventWriter{movieTopic: w}, tt.enforced
+			defer func() { topicWriters, requireJSONContentType = prevWriters, prevRequire }()
+
+			r := jsonRequest(http.MethodPost, "/api/events/movie", body)
+			r.Header.Set("Content-Type", tt.contentType)
+			rec := serve(handleEvent(movieTopic), r)
+			if rec.Code != tt.wantProduce {
+				t.Fatalf("produce status = %d, want %d: %s", rec.Code, tt.wantProduce, rec.Body.String())
+			}
+			if tt.wantProduce == http.StatusUnsupportedMediaType {
+				var resp struct{ Code string }
+				decodeJSON(t, rec, &resp)
+				if resp.Code != "unsupported_media_type" || len(w.written) != 0 {
+					t.Errorf("code = %q with %d messages produced, want unsupported_media_type and none", resp.Code, len(w.written))
+				}
+			}
+
+			r = jsonRequest(http.MethodPost, "/api/events/validate?type=movie", body)
+			r.Header.Set("Content-Type", tt.contentType)
+			if rec := serve(http.HandlerFunc(handleValidate), r); rec.Code != tt.wantValidate {
+				t.Errorf("validate status = %d, want %d", rec.Code, tt.wantValidate)
+			}
+		})
+	}
+}
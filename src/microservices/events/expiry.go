@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+// expiresAtHeader carries the Unix time in milliseconds after which a
+// consumer should no longer act on the message.
+const expiresAtHeader = "expires-at"
+
+var messagesExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "kafka_messages_expired_total",
+	Help: "Consumed messages not handled because their expires-at had passed, by topic.",
+}, []string{"topic"})
+
+// requestTTL reads the optional ttl_seconds query parameter of a produce
+// request. Zero means the event does not expire.
+func requestTTL(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("ttl_seconds")
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("ttl_seconds must be a positive integer")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func expiryHeader(at time.Time) kafka.Header {
+	return kafka.Header{Key: expiresAtHeader, Value: []byte(strconv.FormatInt(at.UnixMilli(), 10))}
+}
+
+// expired reports whether m carries an expires-at that is before now. A
+// malformed header is ignored rather than dropping the message.
+func expired(m kafka.Message, now time.Time) bool {
+	for _, h := range m.Headers {
+		if h.Key != expiresAtHeader {
+			continue
+		}
+		ms, err := strconv.ParseInt(string(h.Value), 10, 64)
+		if err != nil {
+			return false
+		}
+		return now.After(time.UnixMilli(ms))
+	}
+	return false
+}
+
+// expiredError is returned for a consumed message whose expires-at had
+// passed. Handling it again cannot succeed, so it is dead-lettered with the
+// expired reason instead.
+type expiredError struct{}
+
+func (expiredError) Error() string { return "message expired before it was handled" }
+
+// checkExpiry counts and returns an expiredError when m has expired.
+func checkExpiry(m kafka.Message, now time.Time) error {
+	if !expired(m, now) {
+		return nil
+	}
+	messagesExpired.WithLabelValues(m.Topic).Inc()
+	return expiredError{}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestRequestTTL(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"?ttl_seconds=30", 30 * time.Second, false},
+		{"?ttl_seconds=0", 0, true},
+		{"?ttl_seconds=-5", 0, true},
+		{"?ttl_seconds=soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := requestTTL(httptest.NewRequest(http.MethodPost, "/api/events/movie"+tt.query, nil))
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("requestTTL(%q) = %v, %v; want %v, error %v", tt.query, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func expiresAt(at time.Time) []kafka.Header {
+	return []kafka.Header{expiryHeader(at)}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		headers []kafka.Header
+		want    bool
+	}{
+		{"no header", nil, false},
+		{"passed", expiresAt(now.Add(-time.Second)), true},
+		{"still fresh", expiresAt(now.Add(time.Second)), false},
+		{"malformed", []kafka.Header{{Key: expiresAtHeader, Value: []byte("tomorrow")}}, false},
+	}
+	for _, tt := range tests {
+		if got := expired(kafka.Message{Headers: tt.headers}, now); got != tt.want {
+			t.Errorf("%s: expired = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandleEventStampsExpiry(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantTTL    time.Duration
+	}{
+		{"no ttl", "", http.StatusCreated, 0},
+		{"ttl", "?ttl_seconds=60", http.StatusCreated, time.Minute},
+		{"invalid ttl", "?ttl_seconds=0", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &recordingWriter{}
+			prev := topicWriters
+			topicWriters = map[string]eventWriter{movieTopic: w}
+			defer func() { topicWriters = prev }()
+
+			before := time.Now()
+			rec := serve(handleEvent(movieTopic), jsonRequest(http.MethodPost, "/api/events/movie"+tt.query, `{"movie_id": 1, "title": "Heat", "action": "viewed"}`))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+			stamp := header(w.written[0], expiresAtHeader)
+			if tt.wantTTL == 0 {
+				if stamp != "" {
+					t.Fatalf("expires-at = %q, want none", stamp)
+				}
+				return
+			}
+			ms, err := strconv.ParseInt(stamp, 10, 64)
+			if err != nil {
+				t.Fatalf("expires-at = %q: %v", stamp, err)
+			}
+			if at := time.UnixMilli(ms); at.Before(before.Add(tt.wantTTL).Truncate(time.Millisecond)) || at.After(time.Now().Add(tt.wantTTL)) {
+				t.Errorf("expires-at = %s, want about %s from now", at, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestConsumerDeadLettersExpired(t *testing.T) {
+	value := []byte(`{"movie_id": 1, "title": "Heat", "action": "viewed"}`)
+	tests := []struct {
+		name        string
+		headers     []kafka.Header
+		wantExpired bool
+	}{
+		{"expired", expiresAt(time.Now().Add(-time.Minute)), true},
+		{"fresh", expiresAt(time.Now().Add(time.Minute)), false},
+		{"no expiry", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := quarantine
+			quarantine, _ = newQuarantineStore(10, "")
+			defer func() { quarantine = prev }()
+			counter := messagesExpired.WithLabelValues(movieTopic)
+			before := testutil.ToFloat64(counter)
+			m := kafka.Message{Topic: movieTopic, Offset: 3, Value: value, Headers: tt.headers}
+
+			c := NewConsumer(consumerConfig{ManualCommit: true, MaxAttempts: 1}, 1)
+			r := &fakeReader{msgs: []kafka.Message{m}}
+			runConsumer(context.Background(), r, c.cfg, movieTopic, c.handle)
+			var expiredErr expiredError
+			if err := handleMessage(context.Background(), m); errors.As(err, &expiredErr) != tt.wantExpired {
+				t.Fatalf("handleMessage = %v, want expired %v", err, tt.wantExpired)
+			}
+
+			delivered := len(drain(c.Movies())) == 1
+			if delivered == tt.wantExpired {
+				t.Errorf("delivered = %v, want %v", delivered, !tt.wantExpired)
+			}
+			// Expired or not, the message is done with and committed; an
+			// expired one is dead-lettered with its reason.
+			if len(r.committed) != 1 {
+				t.Errorf("committed %d messages, want 1", len(r.committed))
+			}
+			entries := quarantine.list()
+			if tt.wantExpired != (len(entries) == 1) {
+				t.Fatalf("quarantined %d messages, want expired %v", len(entries), tt.wantExpired)
+			}
+			if tt.wantExpired && entries[0].Reason != reasonExpired {
+				t.Errorf("reason = %s, want %s", entries[0].Reason, reasonExpired)
+			}
+			wantCount := 0.0
+			if tt.wantExpired {
+				wantCount = 2
+			}
+			if got := testutil.ToFloat64(counter) - before; got != wantCount {
+				t.Errorf("expired counter rose by %v, want %v", got, wantCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// fetchedHeader is a message header with its value as text, which is how
+// every header this service writes is encoded.
+type fetchedHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type fetchedMessage struct {
+	Topic     string          `json:"topic"`
+	Partition int             `json:"partition"`
+	Offset    int64           `json:"offset"`
+	Time      time.Time       `json:"time"`
+	Key       string          `json:"key,omitempty"`
+	Headers   []fetchedHeader `json:"headers,omitempty"`
+	// Value is the decoded event when the payload is JSON, Avro or
+	// protobuf. Anything else is returned base64 encoded in RawValue.
+	Value    json.RawMessage `json:"value,omitempty"`
+	RawValue []byte          `json:"raw_value,omitempty"`
+}
+
+// messageFetcher serves GET /api/events/message, reading the single message
+// at a topic, partition and offset for debugging.
+type messageFetcher struct {
+	open    func(topic string, partition int, offset int64) (messageReader, error)
+	timeout time.Duration
+}
+
+func (f *messageFetcher) handleGet(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	base := q.Get("topic")
+	if !isServiceTopic(base) {
+		http.Error(w, "Unknown topic", http.StatusBadRequest)
+		return
+	}
+	partition, err := strconv.Atoi(q.Get("partition"))
+	if err != nil || partition < 0 {
+		http.Error(w, "partition must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	offset, err := strconv.ParseInt(q.Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	topic := topicName(base)
+	reader, err := f.open(topic, partition, offset)
+	if err != nil {
+		log.Printf("Failed to open %s[%d] at offset %d: %v", topic, partition, offset, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), f.timeout)
+	defer cancel()
+	m, err := reader.ReadMessage(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "No message at that offset within "+f.timeout.String(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read %s[%d] at offset %d: %v", topic, partition, offset, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	// A deleted or compacted offset makes the reader return the next message
+	// instead; that is not the message that was asked for.
+	if m.Offset != offset {
+		http.Error(w, "No message at that offset", http.StatusNotFound)
+		return
+	}
+
+	out := fetchedMessage{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset, Time: m.Time, Key: string(m.Key)}
+	for _, h := range m.Headers {
+		out.Headers = append(out.Headers, fetchedHeader{Key: h.Key, Value: string(h.Value)})
+	}
+	if value, err := messageValue(ctx, m); err == nil && json.Valid(value) {
+		out.Value = value
+	} else {
+		out.RawValue = m.Value
+	}
+	writeJSON(w, r, http.StatusOK, out)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// blockingReader has no message to hand out and waits for ctx, like a
+// reader positioned past the end of the partition.
+type blockingReader struct{ fakeReader }
+
+func (b *blockingReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func TestMessageFetcher(t *testing.T) {
+	recorded := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	partition := []kafka.Message{
+		{Topic: paymentTopic, Partition: 0, Offset: 41, Time: recorded, Value: []byte(`{"payment_id":1}`)},
+		{Topic: paymentTopic, Partition: 0, Offset: 42, Time: recorded, Key: []byte("user-9"), Value: []byte(`{"payment_id":2}`),
+			Headers: []kafka.Header{{Key: "source-host", Value: []byte("events-1")}}},
+		{Topic: paymentTopic, Partition: 0, Offset: 44, Time: recorded, Value: []byte("not json")},
+	}
+	// open positions a reader at offset like SetOffset does: the first
+	// message at or after it comes next.
+	open := func(topic string, p int, offset int64) (messageReader, error) {
+		if topic != paymentTopic {
+			return nil, errors.New("unexpected topic " + topic)
+		}
+		r := &fakeReader{}
+		for _, m := range partition {
+			if m.Partition == p && m.Offset >= offset {
+				r.msgs = append(r.msgs, m)
+			}
+		}
+		if len(r.msgs) == 0 {
+			return &blockingReader{}, nil
+		}
+		return r, nil
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		query string
+		want  int
+		check func(t *testing.T, m fetchedMessage)
+	}{
+		{"message at the offset", "secret", "?topic=payment-events&partition=0&offset=42", http.StatusOK, func(t *testing.T, m fetchedMessage) {
+			if m.Topic != paymentTopic || m.Partition != 0 || m.Offset != 42 || m.Key != "user-9" || !m.Time.Equal(recorded) {
+				t.Errorf("fetched %+v", m)
+			}
+			if string(m.Value) != `{"payment_id":2}` || m.RawValue != nil {
+				t.Errorf("value = %s raw %q, want the JSON payload", m.Value, m.RawValue)
+			}
+			if len(m.Headers) != 1 || m.Headers[0] != (fetchedHeader{Key: "source-host", Value: "events-1"}) {
+				t.Errorf("headers = %+v", m.Headers)
+			}
+		}},
+		{"non-JSON value returned raw", "secret", "?topic=payment-events&partition=0&offset=44", http.StatusOK, func(t *testing.T, m fetchedMessage) {
+			if m.Value != nil || string(m.RawValue) != "not json" {
+				t.Errorf("value = %s raw %q, want the raw payload", m.Value, m.RawValue)
+			}
+		}},
+		{"compacted offset", "secret", "?topic=payment-events&partition=0&offset=43", http.StatusNotFound, nil},
+		{"past the end", "secret", "?topic=payment-events&partition=0&offset=45", http.StatusNotFound, nil},
+		{"unknown topic", "secret", "?topic=orders&partition=0&offset=42", http.StatusBadRequest, nil},
+		{"negative partition", "secret", "?topic=payment-events&partition=-1&offset=42", http.StatusBadRequest, nil},
+		{"missing offset", "secret", "?topic=payment-events&partition=0", http.StatusBadRequest, nil},
+		{"wrong token", "guess", "?topic=payment-events&partition=0&offset=42", http.StatusUnauthorized, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prevToken := adminToken
+			adminToken = "secret"
+			defer func() { adminToken = prevToken }()
+
+			f := &messageFetcher{open: open, timeout: 20 * time.Millisecond}
+			r := httptest.NewRequest(http.MethodGet, "/api/events/message"+tt.query, nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := serve(requireAdmin(f.handleGet), r)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.check != nil {
+				var m fetchedMessage
+				decodeJSON(t, rec, &m)
+				tt.check(t, m)
+			}
+		})
+	}
+}
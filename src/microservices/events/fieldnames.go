@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// normalizeFieldNames rewrites the top-level keys of a JSON object so that
+// legacy spellings such as MovieID or movieId reach the movie_id field. Keys
+// that do not fold onto a field of event are left alone, and a key already
+// spelled canonically wins over a variant of it.
+func normalizeFieldNames(event Event, body io.Reader) (io.Reader, error) {
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	known := jsonFieldNames(event)
+	out := make(map[string]json.RawMessage, len(raw))
+	for key, value := range raw {
+		if known[key] {
+			out[key] = value
+		}
+	}
+	for key, value := range raw {
+		if known[key] {
+			continue
+		}
+		name := snakeCase(key)
+		if !known[name] {
+			out[key] = value
+			continue
+		}
+		if _, ok := out[name]; !ok {
+			out[name] = value
+		}
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// jsonFieldNames returns the json tag names of the struct event points to.
+func jsonFieldNames(event Event) map[string]bool {
+	t := reflect.TypeOf(event).Elem()
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// snakeCase converts movieId, MovieID and MOVIE_ID to movie_id. An
+// underscore is inserted where a lowercase letter or digit is followed by an
+// uppercase one, so acronyms such as ID stay a single word.
+func snakeCase(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+				b.WriteByte('_')
+			}
+		}
+		if r == '-' {
+			r = '_'
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
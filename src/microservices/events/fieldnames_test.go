@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// withDecoding sets STRICT_DECODING and COMPAT_FIELD_NAMES for the rest of
+// the test.
+func withDecoding(t *testing.T, strict, compat bool) {
+	prevStrict, prevCompat := strictDecoding, compatFieldNames
+	strictDecoding, compatFieldNames = strict, compat
+	t.Cleanup(func() { strictDecoding, compatFieldNames = prevStrict, prevCompat })
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"movie_id", "movie_id"},
+		{"movieId", "movie_id"},
+		{"MovieID", "movie_id"},
+		{"MOVIE_ID", "movie_id"},
+		{"movie-id", "movie_id"},
+		{"userId2", "user_id2"},
+		{"Title", "title"},
+	}
+	for _, tt := range tests {
+		if got := snakeCase(tt.in); got != tt.want {
+			t.Errorf("snakeCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeEventFieldNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		compat  bool
+		body    string
+		want    MovieEvent
+		wantErr bool
+	}{
+		{"snake case", false, false, `{"movie_id":1,"title":"Heat","action":"viewed"}`, MovieEvent{MovieID: 1, Title: "Heat", Action: "viewed"}, false},
+		{"camelCase without compat", false, false, `{"movieId":1,"title":"Heat"}`, MovieEvent{Title: "Heat"}, false},
+		{"camelCase", false, true, `{"movieId":1,"userId":2,"title":"Heat"}`, MovieEvent{MovieID: 1, UserID: 2, Title: "Heat"}, false},
+		{"PascalCase", false, true, `{"MovieID":1,"UserID":2,"Title":"Heat","Action":"viewed"}`, MovieEvent{MovieID: 1, UserID: 2, Title: "Heat", Action: "viewed"}, false},
+		{"canonical wins over variant", false, true, `{"MovieID":9,"movie_id":1}`, MovieEvent{MovieID: 1}, false},
+		{"strict rejects variants", true, false, `{"movieId":1}`, MovieEvent{}, true},
+		{"strict with compat accepts variants", true, true, `{"movieId":1,"Title":"Heat"}`, MovieEvent{MovieID: 1, Title: "Heat"}, false},
+		{"strict with compat rejects unknown fields", true, true, `{"movieId":1,"director":"Mann"}`, MovieEvent{}, true},
+		{"compat rejects malformed", false, true, `{"movieId":`, MovieEvent{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withDecoding(t, tt.strict, tt.compat)
+			event, err := decodeEvent(movieTopic, strings.NewReader(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := *event.(*MovieEvent); got != tt.want {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// filterRule matches events whose Field compares to Value (eq, ne) or to one
+// of Values (in, not_in). Field is the JSON field name, Topic a base topic;
+// a rule without a topic applies to every topic. Values are compared in their
+// string form, so "42" and 42 match the same user_id.
+type filterRule struct {
+	Name   string        `json:"name"`
+	Topic  string        `json:"topic,omitempty"`
+	Field  string        `json:"field"`
+	Op     string        `json:"op"`
+	Value  interface{}   `json:"value,omitempty"`
+	Values []interface{} `json:"values,omitempty"`
+	// Action is "drop" to accept the event without producing it, or "flag"
+	// to produce it with a filter-flagged header naming the rule.
+	Action string `json:"action"`
+}
+
+// filterRules are loaded from FILTER_RULES_FILE and checked in order; the
+// first matching rule wins.
+var filterRules []filterRule
+
+func loadFilterRules(path string) ([]filterRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []filterRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if rule.Topic != "" && !isServiceTopic(rule.Topic) {
+			return nil, fmt.Errorf("rule %s: unknown topic %q", rule.Name, rule.Topic)
+		}
+		if rule.Field == "" {
+			return nil, fmt.Errorf("rule %s: field is required", rule.Name)
+		}
+		switch rule.Op {
+		case "eq", "ne", "in", "not_in":
+		default:
+			return nil, fmt.Errorf("rule %s: unknown op %q", rule.Name, rule.Op)
+		}
+		if rule.Action != "drop" && rule.Action != "flag" {
+			return nil, fmt.Errorf("rule %s: action must be drop or flag", rule.Name)
+		}
+	}
+	return rules, nil
+}
+
+func (rule filterRule) matches(fields map[string]interface{}) bool {
+	v, ok := fields[rule.Field]
+	if !ok || v == nil {
+		// A missing field only satisfies the negative operators.
+		return rule.Op == "ne" || rule.Op == "not_in"
+	}
+	got := fmt.Sprint(v)
+	switch rule.Op {
+	case "eq":
+		return got == fmt.Sprint(rule.Value)
+	case "ne":
+		return got != fmt.Sprint(rule.Value)
+	}
+	in := false
+	for _, want := range rule.Values {
+		if got == fmt.Sprint(want) {
+			in = true
+			break
+		}
+	}
+	return in == (rule.Op == "in")
+}
+
+// applyFilters returns the first rule matching the event, if any.
+func applyFilters(topic string, event Event) (*filterRule, error) {
+	if len(filterRules) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	for i, rule := range filterRules {
+		if (rule.Topic == "" || rule.Topic == topic) && rule.matches(fields) {
+			eventsFiltered.WithLabelValues(topic, rule.Name, rule.Action).Inc()
+			return &filterRules[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func flagHeader(rule *filterRule) kafka.Header {
+	return kafka.Header{Key: "filter-flagged", Value: []byte(rule.Name)}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordingWriter is a fakeWriter that can stand in for a topic writer.
+type recordingWriter struct{ fakeWriter }
+
+func (*recordingWriter) Close() error { return nil }
+
+func TestLoadFilterRules(t *testing.T) {
+	rules, err := loadFilterRules("filters.example.json")
+	if err != nil {
+		t.Fatalf("filters.example.json: %v", err)
+	}
+	if len(rules) != 2 || rules[0].Name != "test-accounts" || rules[1].Action != "flag" {
+		t.Fatalf("rules = %+v", rules)
+	}
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"no name", `[{"field": "action", "op": "eq", "value": "debug", "action": "drop"}]`, "name is required"},
+		{"unknown topic", `[{"name": "r", "topic": "nope", "field": "action", "op": "eq", "action": "drop"}]`, `unknown topic "nope"`},
+		{"no field", `[{"name": "r", "op": "eq", "action": "drop"}]`, "field is required"},
+		{"unknown op", `[{"name": "r", "field": "action", "op": "gt", "action": "drop"}]`, `unknown op "gt"`},
+		{"unknown action", `[{"name": "r", "field": "action", "op": "eq", "action": "reject"}]`, "action must be drop or flag"},
+		{"invalid JSON", `{`, "parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "filters.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadFilterRules(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterRuleMatches(t *testing.T) {
+	fields := map[string]interface{}{"user_id": json.Number("42"), "action": "debug"}
+	tests := []struct {
+		name string
+		rule filterRule
+		want bool
+	}{
+		{"eq", filterRule{Field: "action", Op: "eq", Value: "debug"}, true},
+		{"eq mismatch", filterRule{Field: "action", Op: "eq", Value: "login"}, false},
+		{"ne", filterRule{Field: "action", Op: "ne", Value: "login"}, true},
+		{"in with a number", filterRule{Field: "user_id", Op: "in", Values: []interface{}{7.0, 42.0}}, true},
+		{"in with a string", filterRule{Field: "user_id", Op: "in", Values: []interface{}{"42"}}, true},
+		{"not in", filterRule{Field: "user_id", Op: "not_in", Values: []interface{}{"42"}}, false},
+		{"missing field eq", filterRule{Field: "title", Op: "eq", Value: ""}, false},
+		{"missing field not_in", filterRule{Field: "title", Op: "not_in", Values: []interface{}{"x"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.matches(fields); got != tt.want {
+				t.Fatalf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleEventFilters(t *testing.T) {
+	rules, err := loadFilterRules("filters.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantDropped bool
+		wantFlag    string
+	}{
+		{"blocklisted numeric id", `{"user_id": 999, "action": "login", "timestamp": "2024-01-01T00:00:00Z"}`, http.StatusOK, true, ""},
+		{"blocklisted string-listed id", `{"user_id": 1000, "action": "login", "timestamp": "2024-01-01T00:00:00Z"}`, http.StatusOK, true, ""},
+		{"other user passes through", `{"user_id": 7, "action": "login", "timestamp": "2024-01-01T00:00:00Z"}`, http.StatusCreated, false, ""},
+		{"debug action is flagged", `{"user_id": 7, "action": "debug", "timestamp": "2024-01-01T00:00:00Z"}`, http.StatusCreated, false, "debug"},
+		{"drop wins over a later flag", `{"user_id": 999, "action": "debug", "timestamp": "2024-01-01T00:00:00Z"}`, http.StatusOK, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &recordingWriter{}
+			prevRules, prevWriters := filterRules, topicWriters
+			filterRules, topicWriters = rules, map[string]eventWriter{userTopic: w}
+			defer func() { filterRules, topicWriters = prevRules, prevWriters }()
+
+			rec := serve(handleEvent(userTopic), jsonRequest(http.MethodPost, "/api/events/user", tt.body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			var resp struct {
+				Dropped bool `json:"dropped"`
+			}
+			decodeJSON(t, rec, &resp)
+			if resp.Dropped != tt.wantDropped {
+				t.Errorf("dropped = %v, want %v", resp.Dropped, tt.wantDropped)
+			}
+			if tt.wantDropped {
+				if len(w.written) != 0 {
+					t.Fatalf("dropped event was produced: %+v", w.written)
+				}
+				return
+			}
+			if len(w.written) != 1 {
+				t.Fatalf("produced %d messages, want 1", len(w.written))
+			}
+			if got := header(w.written[0], "filter-flagged"); got != tt.wantFlag {
+				t.Errorf("filter-flagged = %q, want %q", got, tt.wantFlag)
+			}
+		})
+	}
+}
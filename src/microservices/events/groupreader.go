@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// groupOptions are the franz-go options shared by everything that joins
+// cfg.GroupID on topic.
+func groupOptions(cfg consumerConfig, topic string) []kgo.Opt {
+	start := kgo.NewOffset().AtStart()
+	if cfg.StartOffset == kafka.LastOffset {
+		start = kgo.NewOffset().AtEnd()
+	}
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.ConsumerGroup(cfg.GroupID),
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(start),
+	}
+	if cfg.SessionTimeout > 0 {
+		opts = append(opts, kgo.SessionTimeout(cfg.SessionTimeout))
+	}
+	if cfg.HeartbeatInterval > 0 {
+		opts = append(opts, kgo.HeartbeatInterval(cfg.HeartbeatInterval))
+	}
+	return opts
+}
+
+// groupReader is the messageReader of the group consumers. It is a franz-go
+// client rather than a kafka-go Reader because only franz-go exposes the
+// attributes of the record batch a message arrived in, and with them the
+// compression codec the producer chose. Both clients decompress all four
+// codecs.
+//
+// Without ManualCommit, offsets are committed in the background once the
+// message has been handed out, as kafka-go's ReadMessage does.
+type groupReader struct {
+	client *kgo.Client
+}
+
+func newGroupReader(cfg consumerConfig, topic string, opts ...kgo.Opt) (*groupReader, error) {
+	tracker := newAssignmentTracker(cfg.GroupID, topic)
+	base := append(groupOptions(cfg, topic),
+		kgo.FetchMinBytes(10e3),
+		kgo.FetchMaxBytes(10e6),
+		// kafka-go's default balancers, so instances on either client can
+		// share a generation during a rollout.
+		kgo.Balancers(kgo.RangeBalancer(), kgo.RoundRobinBalancer()),
+		kgo.OnPartitionsAssigned(func(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+			partitions := make([]int, 0, len(assigned[topic]))
+			for _, p := range assigned[topic] {
+				partitions = append(partitions, int(p))
+			}
+			slices.Sort(partitions)
+			tracker.observe(partitions)
+		}),
+	)
+	opts = append(base, opts...)
+	if cfg.ManualCommit {
+		opts = append(opts, kgo.DisableAutoCommit())
+	}
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &groupReader{client: client}, nil
+}
+
+// FetchMessage returns the next message and counts it in
+// kafka_messages_consumed_total under the codec of its batch. Partition
+// errors are logged and fetching goes on, as kafka-go's Reader retries them.
+func (r *groupReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		fetches := r.client.PollRecords(ctx, 1)
+		if fetches.IsClientClosed() {
+			return kafka.Message{}, kgo.ErrClientClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return kafka.Message{}, err
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			log.Printf("Error fetching topic %s partition %d: %v", topic, partition, err)
+		})
+		var (
+			m     kafka.Message
+			found bool
+		)
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			for _, rec := range p.Records {
+				m, found = recordMessage(rec), true
+				m.HighWaterMark = p.HighWatermark
+				messagesConsumed.WithLabelValues(rec.Topic, batchCodec(rec)).Inc()
+			}
+		})
+		if found {
+			return m, nil
+		}
+	}
+}
+
+// ReadMessage is FetchMessage; the client commits in the background.
+func (r *groupReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	return r.FetchMessage(ctx)
+}
+
+func (r *groupReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	records := make([]*kgo.Record, len(msgs))
+	for i, m := range msgs {
+		records[i] = &kgo.Record{Topic: m.Topic, Partition: int32(m.Partition), Offset: m.Offset, LeaderEpoch: -1}
+	}
+	return r.client.CommitRecords(ctx, records...)
+}
+
+// Close leaves the group.
+func (r *groupReader) Close() error {
+	r.client.Close()
+	return nil
+}
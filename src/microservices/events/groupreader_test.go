@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// groupBroker is a single-node Kafka cluster, just capable enough to let a
+// franz-go group consumer join, fetch one record batch from partition 0 of
+// topic and commit it. The batch is compressed with codec on the wire, the
+// way an external producer would have written it.
+type groupBroker struct {
+	t     *testing.T
+	ln    net.Listener
+	topic string
+	batch []byte
+	count int64
+
+	mu        sync.Mutex
+	committed int64
+}
+
+func newGroupBroker(t *testing.T, topic string, codec compress.Compression, values ...string) *groupBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &groupBroker{t: t, ln: ln, topic: topic, batch: recordBatch(t, codec, values), count: int64(len(values)), committed: -1}
+	go b.serve()
+	t.Cleanup(func() { ln.Close() })
+	return b
+}
+
+func (b *groupBroker) addr() string { return b.ln.Addr().String() }
+
+func (b *groupBroker) committedOffset() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.committed
+}
+
+// recordBatch encodes values as a v2 record batch starting at offset 0, its
+// records compressed with codec.
+func recordBatch(t *testing.T, codec compress.Compression, values []string) []byte {
+	t.Helper()
+	var records []byte
+	for i, v := range values {
+		r := kmsg.Record{OffsetDelta: int32(i), Value: []byte(v)}
+		r.Length = int32(len(r.AppendTo(nil)) - 1)
+		records = r.AppendTo(records)
+	}
+	if codec != compress.None {
+		var buf bytes.Buffer
+		w := codec.Codec().NewWriter(&buf)
+		if _, err := w.Write(records); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		records = buf.Bytes()
+	}
+	now := time.Now().UnixMilli()
+	batch := kmsg.RecordBatch{
+		Magic:           2,
+		Attributes:      int16(codec),
+		LastOffsetDelta: int32(len(values) - 1),
+		FirstTimestamp:  now,
+		MaxTimestamp:    now,
+		ProducerID:      -1,
+		ProducerEpoch:   -1,
+		FirstSequence:   -1,
+		NumRecords:      int32(len(values)),
+		Records:         records,
+	}
+	raw := batch.AppendTo(nil)
+	// Length covers everything after itself, the CRC everything after it.
+	binary.BigEndian.PutUint32(raw[8:12], uint32(len(raw)-12))
+	binary.BigEndian.PutUint32(raw[17:21], crc32.Checksum(raw[21:], crc32.MakeTable(crc32.Castagnoli)))
+	return raw
+}
+
+// groupBrokerVersions caps what the broker advertises at versions that need
+// neither topic IDs nor batched group requests.
+var groupBrokerVersions = map[int16][2]int16{
+	kmsg.ApiVersions.Int16():     {0, 3},
+	kmsg.Metadata.Int16():        {1, 8},
+	kmsg.FindCoordinator.Int16(): {0, 3},
+	kmsg.JoinGroup.Int16():       {0, 5},
+	kmsg.SyncGroup.Int16():       {0, 3},
+	kmsg.Heartbeat.Int16():       {0, 3},
+	kmsg.LeaveGroup.Int16():      {0, 2},
+	kmsg.OffsetFetch.Int16():     {1, 5},
+	kmsg.OffsetCommit.Int16():    {2, 7},
+	kmsg.ListOffsets.Int16():     {1, 5},
+	kmsg.Fetch.Int16():           {4, 11},
+}
+
+func (b *groupBroker) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		go b.serveConn(conn)
+	}
+}
+
+func (b *groupBroker) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size int32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		key, version := int16(binary.BigEndian.Uint16(buf)), int16(binary.BigEndian.Uint16(buf[2:]))
+		correlation := buf[4:8]
+		clientIDLen := int16(binary.BigEndian.Uint16(buf[8:]))
+		body := buf[10+max(clientIDLen, 0):]
+
+		req := kmsg.RequestForKey(key)
+		if req == nil {
+			b.t.Errorf("unexpected request key %d", key)
+			return
+		}
+		req.SetVersion(version)
+		if req.IsFlexible() {
+			body = skipTags(body)
+		}
+		if err := req.ReadFrom(body); err != nil {
+			b.t.Errorf("decode %T v%d: %v", req, version, err)
+			return
+		}
+		resp := b.handle(req)
+		resp.SetVersion(version)
+
+		out := append([]byte{0, 0, 0, 0}, correlation...)
+		if resp.IsFlexible() && key != kmsg.ApiVersions.Int16() {
+			out = append(out, 0)
+		}
+		out = resp.AppendTo(out)
+		binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+// skipTags drops the tagged fields of a flexible request header.
+func skipTags(b []byte) []byte {
+	n, read := binary.Uvarint(b)
+	b = b[read:]
+	for ; n > 0; n-- {
+		_, read = binary.Uvarint(b)
+		b = b[read:]
+		size, read := binary.Uvarint(b)
+		b = b[read+int(size):]
+	}
+	return b
+}
+
+const groupBrokerMember = "member-1"
+
+func (b *groupBroker) handle(req kmsg.Request) kmsg.Response {
+	host, portStr, _ := net.SplitHostPort(b.addr())
+	port, _ := strconv.Atoi(portStr)
+	switch req := req.(type) {
+	case *kmsg.ApiVersionsRequest:
+		resp := kmsg.NewPtrApiVersionsResponse()
+		for key, v := range groupBrokerVersions {
+			resp.ApiKeys = append(resp.ApiKeys, kmsg.ApiVersionsResponseApiKey{ApiKey: key, MinVersion: v[0], MaxVersion: v[1]})
+		}
+		return resp
+	case *kmsg.MetadataRequest:
+		resp := kmsg.NewPtrMetadataResponse()
+		resp.Brokers = []kmsg.MetadataResponseBroker{{NodeID: 0, Host: host, Port: int32(port)}}
+		topic := b.topic
+		resp.Topics = []kmsg.MetadataResponseTopic{{Topic: &topic, Partitions: []kmsg.MetadataResponseTopicPartition{
+			{Partition: 0, Leader: 0, LeaderEpoch: -1, Replicas: []int32{0}, ISR: []int32{0}},
+		}}}
+		return resp
+	case *kmsg.FindCoordinatorRequest:
+		resp := kmsg.NewPtrFindCoordinatorResponse()
+		resp.NodeID, resp.Host, resp.Port = 0, host, int32(port)
+		return resp
+	case *kmsg.JoinGroupRequest:
+		resp := kmsg.NewPtrJoinGroupResponse()
+		protocol, protocolType := req.Protocols[0].Name, req.ProtocolType
+		resp.Generation, resp.Protocol, resp.ProtocolType = 1, &protocol, &protocolType
+		resp.LeaderID, resp.MemberID = groupBrokerMember, groupBrokerMember
+		resp.Members = []kmsg.JoinGroupResponseMember{{MemberID: groupBrokerMember, ProtocolMetadata: req.Protocols[0].Metadata}}
+		return resp
+	case *kmsg.SyncGroupRequest:
+		resp := kmsg.NewPtrSyncGroupResponse()
+		for _, a := range req.GroupAssignment {
+			if a.MemberID == groupBrokerMember {
+				resp.MemberAssignment = a.MemberAssignment
+			}
+		}
+		return resp
+	case *kmsg.HeartbeatRequest:
+		return kmsg.NewPtrHeartbeatResponse()
+	case *kmsg.LeaveGroupRequest:
+		return kmsg.NewPtrLeaveGroupResponse()
+	case *kmsg.OffsetFetchRequest:
+		resp := kmsg.NewPtrOffsetFetchResponse()
+		for _, rt := range req.Topics {
+			topic := kmsg.OffsetFetchResponseTopic{Topic: rt.Topic}
+			for _, p := range rt.Partitions {
+				topic.Partitions = append(topic.Partitions, kmsg.OffsetFetchResponseTopicPartition{Partition: p, Offset: b.committedOffset(), LeaderEpoch: -1})
+			}
+			resp.Topics = append(resp.Topics, topic)
+		}
+		return resp
+	case *kmsg.OffsetCommitRequest:
+		resp := kmsg.NewPtrOffsetCommitResponse()
+		for _, rt := range req.Topics {
+			topic := kmsg.OffsetCommitResponseTopic{Topic: rt.Topic}
+			for _, p := range rt.Partitions {
+				b.mu.Lock()
+				b.committed = p.Offset
+				b.mu.Unlock()
+				topic.Partitions = append(topic.Partitions, kmsg.OffsetCommitResponseTopicPartition{Partition: p.Partition})
+			}
+			resp.Topics = append(resp.Topics, topic)
+		}
+		return resp
+	case *kmsg.ListOffsetsRequest:
+		resp := kmsg.NewPtrListOffsetsResponse()
+		for _, rt := range req.Topics {
+			topic := kmsg.ListOffsetsResponseTopic{Topic: rt.Topic}
+			for _, p := range rt.Partitions {
+				offset := int64(0)
+				if p.Timestamp == -1 {
+					offset = b.count
+				}
+				topic.Partitions = append(topic.Partitions, kmsg.ListOffsetsResponseTopicPartition{Partition: p.Partition, Offset: offset, Timestamp: -1, LeaderEpoch: -1})
+			}
+			resp.Topics = append(resp.Topics, topic)
+		}
+		return resp
+	case *kmsg.FetchRequest:
+		resp := kmsg.NewPtrFetchResponse()
+		delivered := false
+		for _, rt := range req.Topics {
+			topic := kmsg.FetchResponseTopic{Topic: rt.Topic}
+			for _, p := range rt.Partitions {
+				part := kmsg.FetchResponseTopicPartition{Partition: p.Partition, HighWatermark: b.count, LastStableOffset: b.count, PreferredReadReplica: -1}
+				if p.FetchOffset == 0 {
+					part.RecordBatches, delivered = b.batch, true
+				}
+				topic.Partitions = append(topic.Partitions, part)
+			}
+			resp.Topics = append(resp.Topics, topic)
+		}
+		if !delivered {
+			// Nothing new: hold the fetch like a broker waiting for data.
+			time.Sleep(50 * time.Millisecond)
+		}
+		return resp
+	}
+	b.t.Errorf("unhandled request %T", req)
+	return req.ResponseKind()
+}
+
+func TestGroupReaderLabelsBatchCodec(t *testing.T) {
+	const topic = "external-payloads"
+	values := []string{`{"id":"1","type":"external"}`, `{"id":"2","type":"external"}`}
+	tests := []struct {
+		codec compress.Compression
+		label string
+	}{
+		{compress.None, "uncompressed"},
+		{compress.Gzip, "gzip"},
+		{compress.Snappy, "snappy"},
+		{compress.Lz4, "lz4"},
+		{compress.Zstd, "zstd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			broker := newGroupBroker(t, topic, tt.codec, values...)
+			counter := messagesConsumed.WithLabelValues(topic, tt.label)
+			before := testutil.ToFloat64(counter)
+			rebalances := consumerRebalances.WithLabelValues("events-service", topic)
+			rebalancesBefore := testutil.ToFloat64(rebalances)
+
+			cfg := consumerConfig{Brokers: []string{broker.addr()}, GroupID: "events-service", StartOffset: kafka.FirstOffset, ManualCommit: true}
+			r, err := newGroupReader(cfg, topic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			var last kafka.Message
+			for i, want := range values {
+				m, err := r.FetchMessage(ctx)
+				if err != nil {
+					t.Fatalf("fetch %d: %v", i, err)
+				}
+				if string(m.Value) != want || m.Offset != int64(i) || m.Topic != topic || m.HighWaterMark != int64(len(values)) {
+					t.Fatalf("message %d = %s/%d@%d %q (high water mark %d)", i, m.Topic, m.Partition, m.Offset, m.Value, m.HighWaterMark)
+				}
+				last = m
+			}
+			if got := testutil.ToFloat64(counter) - before; got != float64(len(values)) {
+				t.Errorf("kafka_messages_consumed_total{codec=%q} grew by %v, want %d", tt.label, got, len(values))
+			}
+			if got := testutil.ToFloat64(rebalances) - rebalancesBefore; got != 1 {
+				t.Errorf("kafka_consumer_rebalances_total grew by %v, want 1 for the join", got)
+			}
+			if err := r.CommitMessages(ctx, last); err != nil {
+				t.Fatal(err)
+			}
+			if got := broker.committedOffset(); got != int64(len(values)) {
+				t.Errorf("committed offset %d, want %d", got, len(values))
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestGRPCHealthTransitions(t *testing.T) {
+	var ready atomic.Bool
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g, err := startGRPCHealth(ctx, addr, 10*time.Millisecond, ready.Load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	steps := []struct {
+		name  string
+		ready bool
+		want  healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{"not ready at start", false, healthpb.HealthCheckResponse_NOT_SERVING},
+		{"becomes ready", true, healthpb.HealthCheckResponse_SERVING},
+		{"loses readiness", false, healthpb.HealthCheckResponse_NOT_SERVING},
+		{"ready again", true, healthpb.HealthCheckResponse_SERVING},
+	}
+	for _, s := range steps {
+		ready.Store(s.ready)
+		waitForStatus(t, client, s.name, s.want)
+	}
+
+	// A watcher sees NOT_SERVING as soon as shutdown starts, while the
+	// service is still ready.
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	watch, err := client.Watch(watchCtx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("watch = %v, %v; want SERVING", resp, err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		g.stop()
+		close(stopped)
+	}()
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("watch after stop = %v, %v; want NOT_SERVING", resp, err)
+	}
+	stopWatch()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stop did not return")
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+func waitForStatus(t *testing.T, client healthpb.HealthClient, step string, want healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+	var got healthpb.HealthCheckResponse_ServingStatus
+	var err error
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		var resp *healthpb.HealthCheckResponse
+		if resp, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err == nil {
+			if got = resp.Status; got == want {
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%s: status = %v (err %v), want %v", step, got, err, want)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+var messagesFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "kafka_messages_filtered_total",
+	Help: "Consumed messages skipped without handling because their headers did not match CONSUMER_HEADER_FILTER, by topic.",
+}, []string{"topic"})
+
+// headerFilter maps a header key to the values it may have. A message
+// matches when, for every key, it carries that header with one of the
+// listed values. The zero filter matches everything.
+type headerFilter map[string][]string
+
+// parseHeaderFilter reads CONSUMER_HEADER_FILTER, a comma-separated list of
+// key=value pairs. Repeating a key allows several values for it, so
+// "tenant=acme,tenant=globex,region=eu" keeps messages of either tenant in
+// the eu region.
+func parseHeaderFilter(value string) (headerFilter, error) {
+	var f headerFilter
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		if f == nil {
+			f = make(headerFilter)
+		}
+		f[key] = append(f[key], strings.TrimSpace(val))
+	}
+	return f, nil
+}
+
+func (f headerFilter) matches(m kafka.Message) bool {
+	for key, values := range f {
+		found := false
+		for _, h := range m.Headers {
+			if h.Key != key {
+				continue
+			}
+			for _, v := range values {
+				if string(h.Value) == v {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (f headerFilter) String() string {
+	var pairs []string
+	for key, values := range f {
+		for _, v := range values {
+			pairs = append(pairs, key+"="+v)
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestParseHeaderFilter(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"tenant=acme", "tenant=acme", false},
+		{" tenant = acme , tenant=globex, region=eu ,", "region=eu,tenant=acme,tenant=globex", false},
+		{"tenant=", "tenant=", false},
+		{"tenant", "", true},
+		{"=acme", "", true},
+	}
+	for _, tt := range tests {
+		f, err := parseHeaderFilter(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseHeaderFilter(%q) err = %v, want error %v", tt.value, err, tt.wantErr)
+		}
+		if err == nil && f.String() != tt.want {
+			t.Errorf("parseHeaderFilter(%q) = %q, want %q", tt.value, f.String(), tt.want)
+		}
+	}
+}
+
+func TestHeaderFilterMatches(t *testing.T) {
+	msg := func(pairs ...string) kafka.Message {
+		var m kafka.Message
+		for i := 0; i < len(pairs); i += 2 {
+			m.Headers = append(m.Headers, kafka.Header{Key: pairs[i], Value: []byte(pairs[i+1])})
+		}
+		return m
+	}
+	tests := []struct {
+		name   string
+		filter string
+		msg    kafka.Message
+		want   bool
+	}{
+		{"no filter", "", msg(), true},
+		{"matching tenant", "tenant=acme", msg("tenant", "acme"), true},
+		{"other tenant", "tenant=acme", msg("tenant", "globex"), false},
+		{"missing header", "tenant=acme", msg("region", "eu"), false},
+		{"either listed value", "tenant=acme,tenant=globex", msg("tenant", "globex"), true},
+		{"every key must match", "tenant=acme,region=eu", msg("tenant", "acme", "region", "us"), false},
+		{"all keys matching", "tenant=acme,region=eu", msg("region", "eu", "tenant", "acme"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseHeaderFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.matches(tt.msg); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunConsumerHeaderFilter(t *testing.T) {
+	tenant := func(offset int64, value string) kafka.Message {
+		m := kafka.Message{Topic: movieTopic, Offset: offset, Value: []byte(`{}`)}
+		if value != "" {
+			m.Headers = []kafka.Header{{Key: "tenant", Value: []byte(value)}}
+		}
+		return m
+	}
+	msgs := []kafka.Message{tenant(1, "acme"), tenant(2, "globex"), tenant(3, ""), tenant(4, "acme")}
+	tests := []struct {
+		name        string
+		filter      string
+		wantHandled []int64
+	}{
+		{"no filter handles everything", "", []int64{1, 2, 3, 4}},
+		{"only matching tenant handled", "tenant=acme", []int64{1, 4}},
+		{"nothing matches", "tenant=initech", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseHeaderFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counter := messagesFiltered.WithLabelValues(movieTopic)
+			before := testutil.ToFloat64(counter)
+
+			r := &fakeReader{msgs: append([]kafka.Message(nil), msgs...)}
+			var handled []int64
+			runConsumer(context.Background(), r, consumerConfig{MaxAttempts: 1, ManualCommit: true, HeaderFilter: f}, movieTopic, func(ctx context.Context, m kafka.Message) error {
+				handled = append(handled, m.Offset)
+				return nil
+			})
+
+			if len(handled) != len(tt.wantHandled) {
+				t.Fatalf("handled %v, want %v", handled, tt.wantHandled)
+			}
+			for i := range handled {
+				if handled[i] != tt.wantHandled[i] {
+					t.Fatalf("handled %v, want %v", handled, tt.wantHandled)
+				}
+			}
+			// Skipped messages are committed too, so they are not read again.
+			if len(r.committed) != len(msgs) {
+				t.Errorf("committed %d messages, want %d", len(r.committed), len(msgs))
+			}
+			if got := testutil.ToFloat64(counter) - before; got != float64(len(msgs)-len(tt.wantHandled)) {
+				t.Errorf("filtered counter rose by %v, want %d", got, len(msgs)-len(tt.wantHandled))
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// resetHealthState clears halted topics and stalled pipelines for the test.
+func resetHealthState(t *testing.T) {
+	reset := func() {
+		halted.mu.Lock()
+		halted.topics = map[string]string{}
+		halted.mu.Unlock()
+		stalled.mu.Lock()
+		stalled.pipelines = map[string]string{}
+		stalled.mu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestHandleHealth(t *testing.T) {
+	oldWriter, oldClient := writer, brokerClient
+	defer func() { writer, brokerClient = oldWriter, oldClient }()
+	reachable := &kafka.Client{Addr: kafka.TCP("localhost:9092"), Transport: brokerTransport{}}
+	// Nothing listens on port 1, so the broker probe fails fast.
+	unreachable := &kafka.Client{Addr: kafka.TCP("127.0.0.1:1")}
+	verboseFields := []string{"status", "version", "uptime", "uptime_seconds", "broker_reachable", "active_consumers", "writer_initialized"}
+
+	tests := []struct {
+		name       string
+		target     string
+		writer     *kafka.Writer
+		client     *kafka.Client
+		consumers  int32
+		halted     map[string]string
+		stalled    map[string]string
+		wantCode   int
+		wantFields []string
+		want       healthDetails
+	}{
+		{
+			name:       "minimal when ready",
+			target:     "/api/events/health",
+			writer:     &kafka.Writer{},
+			client:     reachable,
+			wantCode:   http.StatusOK,
+			wantFields: []string{"status"},
+			want:       healthDetails{Status: true},
+		},
+		{
+			name:       "minimal without a writer",
+			target:     "/api/events/health",
+			client:     reachable,
+			wantCode:   http.StatusServiceUnavailable,
+			wantFields: []string{"status"},
+		},
+		{
+			name:       "verbose=false with an unreachable broker",
+			target:     "/api/events/health?verbose=false",
+			writer:     &kafka.Writer{},
+			client:     unreachable,
+			wantCode:   http.StatusServiceUnavailable,
+			wantFields: []string{"status"},
+		},
+		{
+			name:       "minimal with a halted topic",
+			target:     "/api/events/health",
+			writer:     &kafka.Writer{},
+			client:     reachable,
+			halted:     map[string]string{"payment-events": "offset 7: boom"},
+			wantCode:   http.StatusServiceUnavailable,
+			wantFields: []string{"status"},
+		},
+		{
+			name:       "verbose when ready",
+			target:     "/api/events/health?verbose=true",
+			writer:     &kafka.Writer{},
+			client:     reachable,
+			consumers:  2,
+			wantCode:   http.StatusOK,
+			wantFields: verboseFields,
+			want:       healthDetails{Status: true, Version: "dev", BrokerReachable: true, ActiveConsumers: 2, WriterInitialized: true},
+		},
+		{
+			name:       "verbose without a broker client",
+			target:     "/api/events/health?verbose=true",
+			writer:     &kafka.Writer{},
+			consumers:  2,
+			wantCode:   http.StatusServiceUnavailable,
+			wantFields: append(verboseFields, "broker_error"),
+			want:       healthDetails{Version: "dev", BrokerError: "no broker client", ActiveConsumers: 2, WriterInitialized: true},
+		},
+		{
+			name:       "verbose with an unreachable broker and a halted topic",
+			target:     "/api/events/health?verbose=true",
+			writer:     &kafka.Writer{},
+			client:     unreachable,
+			halted:     map[string]string{"payment-events": "offset 7: boom"},
+			wantCode:   http.StatusServiceUnavailable,
+			wantFields: append(verboseFields, "broker_error", "halted_topics"),
+			want:       healthDetails{Version: "dev", WriterInitialized: true, HaltedTopics: map[string]string{"payment-events": "offset 7: boom"}},
+		},
+		{
+			name:       "verbose with a stalled pipeline",
+			target:     "/api/events/health?verbose=true",
+			writer:     &kafka.Writer{},
+			client:     reachable,
+			stalled:    map[string]string{"movie-stats": "broker down"},
+			wantCode:   http.StatusServiceUnavailable,
+			wantFields: append(verboseFields, "stalled_pipelines"),
+			want:       healthDetails{Version: "dev", BrokerReachable: true, WriterInitialized: true, StalledPipelines: map[string]string{"movie-stats": "broker down"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer, brokerClient = tt.writer, tt.client
+			activeConsumers.Store(tt.consumers)
+			defer activeConsumers.Store(0)
+			resetHealthState(t)
+			halted.mu.Lock()
+			for topic, reason := range tt.halted {
+				halted.topics[topic] = reason
+			}
+			halted.mu.Unlock()
+			stalled.mu.Lock()
+			for name, reason := range tt.stalled {
+				stalled.pipelines[name] = reason
+			}
+			stalled.mu.Unlock()
+
+			rec := serve(http.HandlerFunc(handleHealth), httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			var fields map[string]interface{}
+			decodeJSON(t, rec, &fields)
+			if len(fields) != len(tt.wantFields) {
+				t.Errorf("fields = %v, want %v", fields, tt.wantFields)
+			}
+			for _, f := range tt.wantFields {
+				if _, ok := fields[f]; !ok {
+					t.Errorf("missing field %q in %s", f, rec.Body.String())
+				}
+			}
+
+			var got healthDetails
+			decodeJSON(t, rec, &got)
+			if tt.client == unreachable && len(tt.wantFields) > 1 {
+				if got.BrokerError == "" {
+					t.Errorf("broker_error is empty for an unreachable broker")
+				}
+				got.BrokerError = ""
+			}
+			if got.Uptime == "" && len(tt.wantFields) > 1 {
+				t.Errorf("uptime is empty")
+			}
+			got.Uptime, got.UptimeSeconds = "", 0
+			if got.Status != tt.want.Status || got.Version != tt.want.Version || got.BrokerReachable != tt.want.BrokerReachable ||
+				got.BrokerError != tt.want.BrokerError || got.ActiveConsumers != tt.want.ActiveConsumers ||
+				got.WriterInitialized != tt.want.WriterInitialized || len(got.HaltedTopics) != len(tt.want.HaltedTopics) ||
+				len(got.StalledPipelines) != len(tt.want.StalledPipelines) {
+				t.Errorf("health = %+v, want %+v", got, tt.want)
+			}
+			for topic, reason := range tt.want.HaltedTopics {
+				if got.HaltedTopics[topic] != reason {
+					t.Errorf("halted_topics[%s] = %q, want %q", topic, got.HaltedTopics[topic], reason)
+				}
+			}
+			for name, reason := range tt.want.StalledPipelines {
+				if got.StalledPipelines[name] != reason {
+					t.Errorf("stalled_pipelines[%s] = %q, want %q", name, got.StalledPipelines[name], reason)
+				}
+			}
+		})
+	}
+}
+
+// A consumer halted by the halt policy flips the health check to 503.
+func TestHandleHealthAfterHalt(t *testing.T) {
+	oldWriter, oldClient := writer, brokerClient
+	defer func() { writer, brokerClient = oldWriter, oldClient }()
+	writer, brokerClient = &kafka.Writer{}, &kafka.Client{Addr: kafka.TCP("localhost:9092"), Transport: brokerTransport{}}
+	resetHealthState(t)
+
+	check := func(wantCode int, wantStatus bool) {
+		t.Helper()
+		rec := serve(http.HandlerFunc(handleHealth), httptest.NewRequest(http.MethodGet, "/api/events/health", nil))
+		var body struct {
+			Status bool `json:"status"`
+		}
+		decodeJSON(t, rec, &body)
+		if rec.Code != wantCode || body.Status != wantStatus {
+			t.Fatalf("health = %d %v, want %d %v", rec.Code, body.Status, wantCode, wantStatus)
+		}
+	}
+	check(http.StatusOK, true)
+	haltConsumer(kafka.Message{Topic: "payment-events", Offset: 7}, errors.New("boom"))
+	check(http.StatusServiceUnavailable, false)
+}
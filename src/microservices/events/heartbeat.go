@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+// heartbeatTopic is the base topic synthetic heartbeats go through.
+const heartbeatTopic = "heartbeat"
+
+var (
+	heartbeatsProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_heartbeats_produced_total",
+		Help: "Synthetic heartbeat events produced, by result.",
+	}, []string{"result"})
+	heartbeatAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "events_heartbeat_age_seconds",
+		Help: "Seconds since this instance last consumed one of its own heartbeats.",
+	})
+	heartbeatHealthy = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "events_heartbeat_healthy",
+		Help: "1 while heartbeats make it through Kafka within HEARTBEAT_STALE_AFTER, 0 once they stop.",
+	})
+)
+
+// heartbeatEvent is the payload of a heartbeat message.
+type heartbeatEvent struct {
+	Instance string `json:"instance"`
+	Seq      int64  `json:"seq"`
+	SentAt   int64  `json:"sent_at"`
+}
+
+// heartbeatMonitor produces a heartbeat every interval and consumes them
+// back, flagging the pipeline as broken when none of this instance's own
+// heartbeats has been consumed for staleAfter. Each instance reads the topic
+// in its own group from the latest offset, so replicas never compete for the
+// partitions carrying their heartbeats.
+type heartbeatMonitor struct {
+	instance   string
+	interval   time.Duration
+	staleAfter time.Duration
+	produce    func(ctx context.Context, msg kafka.Message) error
+
+	seq      atomic.Int64
+	lastSeen atomic.Int64 // unix nanoseconds
+	started  time.Time
+
+	mu      sync.Mutex
+	healthy bool
+}
+
+func newHeartbeatMonitor(instance string, interval, staleAfter time.Duration) *heartbeatMonitor {
+	return &heartbeatMonitor{
+		instance:   instance,
+		interval:   interval,
+		staleAfter: staleAfter,
+		produce: func(ctx context.Context, msg kafka.Message) error {
+			if _, _, perr := produceMessage(ctx, heartbeatTopic, msg); perr != nil {
+				return perr
+			}
+			return nil
+		},
+		started: time.Now(),
+		healthy: true,
+	}
+}
+
+func (h *heartbeatMonitor) beat(ctx context.Context, now time.Time) {
+	value, _ := json.Marshal(heartbeatEvent{Instance: h.instance, Seq: h.seq.Add(1), SentAt: now.UnixMilli()})
+	msg := kafka.Message{Topic: topicName(heartbeatTopic), Key: []byte(h.instance), Value: value}
+	if err := h.produce(ctx, msg); err != nil {
+		heartbeatsProduced.WithLabelValues("error").Inc()
+		log.Printf("[HEARTBEAT] Failed to produce heartbeat: %v", err)
+		return
+	}
+	heartbeatsProduced.WithLabelValues("ok").Inc()
+}
+
+// handle records a consumed heartbeat. Heartbeats of other instances are
+// ignored: they say nothing about this instance's producer.
+func (h *heartbeatMonitor) handle(_ context.Context, m kafka.Message) error {
+	if string(m.Key) == h.instance {
+		h.lastSeen.Store(time.Now().UnixNano())
+	}
+	return nil
+}
+
+// check updates the metrics and logs when the pipeline goes stale or
+// recovers. It reports whether heartbeats are arriving. Until the first
+// heartbeat is seen, the age counts from startup.
+func (h *heartbeatMonitor) check(now time.Time) bool {
+	last := h.started
+	if seen := h.lastSeen.Load(); seen != 0 {
+		last = time.Unix(0, seen)
+	}
+	age := now.Sub(last)
+	heartbeatAge.Set(age.Seconds())
+	healthy := age <= h.staleAfter
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if healthy != h.healthy {
+		if healthy {
+			log.Printf("[HEARTBEAT] Heartbeats are being consumed again")
+		} else {
+			log.Printf("[HEARTBEAT] ALERT: no heartbeat consumed for %s, the produce/consume pipeline may be broken", age.Truncate(time.Second))
+		}
+		h.healthy = healthy
+	}
+	if healthy {
+		heartbeatHealthy.Set(1)
+	} else {
+		heartbeatHealthy.Set(0)
+	}
+	return healthy
+}
+
+// run produces and checks heartbeats until ctx is cancelled, consuming them
+// with r.
+func (h *heartbeatMonitor) run(ctx context.Context, r messageReader, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer r.Close()
+
+	go runConsumer(ctx, r, consumerConfig{MaxAttempts: 1}, topicName(heartbeatTopic), h.handle)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	h.beat(ctx, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.check(now)
+			h.beat(ctx, now)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestHeartbeatCheck(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		started time.Duration
+		seen    time.Duration // how long ago a message was consumed, 0 for never
+		key     string
+		want    bool
+	}{
+		{"starting up", 20 * time.Second, 0, "", true},
+		{"nothing consumed since startup", 40 * time.Second, 0, "", false},
+		{"own heartbeat recently consumed", time.Hour, 5 * time.Second, "events-1", true},
+		{"own heartbeat too old", time.Hour, 45 * time.Second, "events-1", false},
+		{"only another instance's heartbeat", 40 * time.Second, time.Second, "events-2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHeartbeatMonitor("events-1", 10*time.Second, 30*time.Second)
+			h.started = now.Add(-tt.started)
+			if tt.seen != 0 {
+				h.handle(context.Background(), kafka.Message{Key: []byte(tt.key)})
+				if h.lastSeen.Load() != 0 {
+					h.lastSeen.Store(now.Add(-tt.seen).UnixNano())
+				}
+			}
+			if got := h.check(now); got != tt.want {
+				t.Errorf("check = %v, want %v", got, tt.want)
+			}
+			want := 0.0
+			if tt.want {
+				want = 1
+			}
+			if got := testutil.ToFloat64(heartbeatHealthy); got != want {
+				t.Errorf("events_heartbeat_healthy = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// loopbackReader hands back whatever the heartbeat producer wrote to it,
+// until cut stops delivery, as a stalled consumer or broker would.
+type loopbackReader struct {
+	fakeReader
+	ch  chan kafka.Message
+	cut atomic.Bool
+}
+
+func (l *loopbackReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-l.ch:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (l *loopbackReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	return l.ReadMessage(ctx)
+}
+
+func TestHeartbeatMonitorRun(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	r := &loopbackReader{ch: make(chan kafka.Message, 100)}
+	var mu sync.Mutex
+	var sent []heartbeatEvent
+
+	h := newHeartbeatMonitor("events-1", interval, 3*interval)
+	h.produce = func(ctx context.Context, msg kafka.Message) error {
+		var e heartbeatEvent
+		if err := json.Unmarshal(msg.Value, &e); err != nil {
+			t.Errorf("heartbeat payload %s: %v", msg.Value, err)
+		}
+		mu.Lock()
+		sent = append(sent, e)
+		mu.Unlock()
+		if !r.cut.Load() {
+			r.ch <- msg
+		}
+		return nil
+	}
+	sentCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go h.run(ctx, r, &wg)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	tests := []struct {
+		name        string
+		cut         bool
+		wantHealthy float64
+	}{
+		{"heartbeats consumed", false, 1},
+		{"consumption stopped", true, 0},
+		{"consumption resumed", false, 1},
+	}
+	for _, tt := range tests {
+		r.cut.Store(tt.cut)
+		before := sentCount()
+		time.Sleep(10 * interval)
+		// A tick every interval, allowing for a slow scheduler.
+		if n := sentCount() - before; n < 5 || n > 11 {
+			t.Errorf("%s: produced %d heartbeats in %s, want about 10", tt.name, n, 10*interval)
+		}
+		if got := testutil.ToFloat64(heartbeatHealthy); got != tt.wantHealthy {
+			t.Errorf("%s: events_heartbeat_healthy = %v, want %v", tt.name, got, tt.wantHealthy)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, e := range sent {
+		if e.Instance != "events-1" || e.Seq != int64(i+1) || e.SentAt == 0 {
+			t.Fatalf("heartbeat %d = %+v", i, e)
+		}
+	}
+}
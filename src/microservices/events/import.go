@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// importBatchSize is how many imported lines are written to Kafka at once.
+const importBatchSize = 100
+
+// maxImportErrors caps the per-line errors listed in an import summary.
+const maxImportErrors = 100
+
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importResult is the outcome of one non-blank line: produced, buffered,
+// dropped or failed.
+type importResult struct {
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type importSummary struct {
+	Produced int           `json:"produced"`
+	Dropped  int           `json:"dropped"`
+	Failed   int           `json:"failed"`
+	Errors   []importError `json:"errors,omitempty"`
+	// StoppedAt is the line that ended an ?on_error=stop import.
+	StoppedAt int            `json:"stopped_at,omitempty"`
+	Results   []importResult `json:"results,omitempty"`
+
+	stopOnError bool
+	// writeFailed counts lines that were valid but Kafka did not take.
+	writeFailed int
+}
+
+// record adds a result for line and returns its index in Results.
+func (s *importSummary) record(line int, status string) int {
+	s.Results = append(s.Results, importResult{Line: line, Status: status})
+	return len(s.Results) - 1
+}
+
+// fail records a failed line. With ?on_error=stop the first failure also
+// ends the import.
+func (s *importSummary) fail(line int, err error) {
+	s.failResult(s.record(line, "failed"), err)
+}
+
+func (s *importSummary) failResult(i int, err error) {
+	res := &s.Results[i]
+	res.Status, res.Error = "failed", err.Error()
+	s.Failed++
+	if len(s.Errors) < maxImportErrors {
+		s.Errors = append(s.Errors, importError{Line: res.Line, Error: res.Error})
+	}
+	if s.stopOnError && s.StoppedAt == 0 {
+		s.StoppedAt = res.Line
+	}
+}
+
+// status is the response code for a finished import: 422 for a stopped
+// import or one where every line was invalid, 500 when nothing was written
+// and Kafka refused at least one line, 207 when only some lines made it.
+func (s *importSummary) status() int {
+	switch {
+	case s.stopped():
+		return http.StatusUnprocessableEntity
+	case s.Failed > 0 && s.Produced == 0 && s.writeFailed > 0:
+		return http.StatusInternalServerError
+	case s.Failed > 0 && s.Produced == 0:
+		return http.StatusUnprocessableEntity
+	case s.Failed > 0:
+		return http.StatusMultiStatus
+	}
+	outcome := produceCommitted
+	switch {
+	case buffer != nil:
+		outcome = produceBuffered
+	case s.Produced == 0:
+		outcome = produceDropped
+	}
+	return produceStatus(outcome)
+}
+
+func (s *importSummary) stopped() bool {
+	return s.StoppedAt != 0
+}
+
+// batchWriter is the part of *kafka.Writer writeBatch needs.
+type batchWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// writeBatch writes msgs and returns the error for each, nil where it was
+// written. A synchronous kafka.Writer reports batch failures as
+// kafka.WriteErrors with one entry per message; any other error says nothing
+// about which messages were written, so the batch is written again one
+// message at a time to find out. Messages that had in fact been written
+// before the error are then written twice.
+func writeBatch(ctx context.Context, w batchWriter, msgs []kafka.Message) []error {
+	errs := make([]error, len(msgs))
+	err := w.WriteMessages(ctx, msgs...)
+	if err == nil {
+		return errs
+	}
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) && len(writeErrs) == len(msgs) {
+		copy(errs, writeErrs)
+		return errs
+	}
+	if len(msgs) == 1 {
+		errs[0] = err
+		return errs
+	}
+	log.Printf("Batch of %d messages to %s failed, writing them one by one: %v", len(msgs), msgs[0].Topic, err)
+	for i := range msgs {
+		errs[i] = w.WriteMessages(ctx, msgs[i])
+	}
+	return errs
+}
+
+// handleImport produces an application/x-ndjson body one event per line,
+// reading and writing in batches. By default bad lines are counted and
+// reported in the summary without stopping the import; with ?on_error=stop
+// the first bad line ends it with a 422, after the lines before it have been
+// written. Results lists the outcome of every line.
+func handleImport(topic string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/x-ndjson" {
+			http.Error(w, "Content-Type must be application/x-ndjson", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		ttl, err := requestTTL(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var (
+			summary importSummary
+			batch   []kafka.Message
+			// pending holds the Results index of each batched line.
+			pending []int
+		)
+		switch r.URL.Query().Get("on_error") {
+		case "", "skip":
+		case "stop":
+			summary.stopOnError = true
+		default:
+			http.Error(w, "on_error must be skip or stop", http.StatusBadRequest)
+			return
+		}
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			for i, err := range writeBatch(r.Context(), writerFor(topic), batch) {
+				if err != nil {
+					summary.writeFailed++
+					summary.failResult(pending[i], err)
+				} else {
+					summary.Produced++
+					summary.Results[pending[i]].Status = "produced"
+				}
+			}
+			batch, pending = batch[:0], pending[:0]
+		}
+
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxMessageBytes)
+		line := 0
+		for !summary.stopped() && scanner.Scan() {
+			line++
+			raw := bytes.TrimSpace(scanner.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+			event, err := decodeEvent(topic, bytes.NewReader(raw))
+			if err != nil {
+				summary.fail(line, err)
+				continue
+			}
+			if violations := event.Validate(); len(violations) > 0 {
+				recordViolations(topic, violations)
+				summary.fail(line, fmt.Errorf("%s: %s", violations[0].Field, violations[0].Message))
+				continue
+			}
+			rule, err := applyFilters(topic, event)
+			if err != nil {
+				summary.fail(line, err)
+				continue
+			}
+			if rule != nil && rule.Action == "drop" {
+				summary.Dropped++
+				summary.record(line, "dropped")
+				continue
+			}
+			msg, err := newEventMessage(r, topic, event, rule, ttl)
+			if err != nil {
+				summary.fail(line, err)
+				continue
+			}
+			if size := messageSize(msg); size > maxMessageBytes {
+				summary.fail(line, fmt.Errorf("event too large: %d bytes", size))
+				continue
+			}
+
+			if buffer != nil {
+				if !buffer.enqueue(topic, msg) {
+					summary.writeFailed++
+					summary.fail(line, fmt.Errorf("produce buffer is full"))
+				} else {
+					summary.Produced++
+					summary.record(line, "buffered")
+				}
+				continue
+			}
+			batch = append(batch, msg)
+			pending = append(pending, summary.record(line, "pending"))
+			if len(batch) >= importBatchSize {
+				flush()
+			}
+		}
+		// Lines before a stopping failure were accepted and are still written.
+		flush()
+		if err := scanner.Err(); err != nil && !summary.stopped() {
+			summary.fail(line+1, fmt.Errorf("read body: %w", err))
+		}
+
+		log.Printf("Imported %d events to %s from %s (%d dropped, %d failed)", summary.Produced, topicName(topic), ClientIP(r), summary.Dropped, summary.Failed)
+		writeJSON(w, r, summary.status(), summary)
+	}
+}
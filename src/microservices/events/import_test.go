@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// countingWriter records the size of every write; writes fail with err for
+// messages whose value contains failOn.
+type countingWriter struct {
+	mu      sync.Mutex
+	batches []int
+	written []kafka.Message
+	failOn  string
+}
+
+func (c *countingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batches = append(c.batches, len(msgs))
+	var errs kafka.WriteErrors
+	failed := false
+	for _, m := range msgs {
+		if c.failOn != "" && strings.Contains(string(m.Value), c.failOn) {
+			errs = append(errs, kafka.LeaderNotAvailable)
+			failed = true
+			continue
+		}
+		errs = append(errs, nil)
+		c.written = append(c.written, m)
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+func (c *countingWriter) Close() error { return nil }
+
+func importRequest(target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, body)
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	return r
+}
+
+func movieLine(id int) string {
+	return fmt.Sprintf(`{"movie_id": %d, "title": "Movie %d", "action": "imported"}`, id, id)
+}
+
+func TestHandleImportStreamsManyLines(t *testing.T) {
+	const lines = 1050
+	w := &countingWriter{}
+	prev := topicWriters
+	topicWriters = map[string]eventWriter{movieTopic: w}
+	defer func() { topicWriters = prev }()
+
+	// The body is written while the handler reads it, as an upload would be.
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 1; i <= lines; i++ {
+			fmt.Fprintln(pw, movieLine(i))
+			if i%100 == 0 {
+				fmt.Fprintln(pw)
+			}
+		}
+		pw.Close()
+	}()
+	rec := serve(handleImport(movieTopic), importRequest("/api/events/movie/import", pr))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var summary importSummary
+	decodeJSON(t, rec, &summary)
+	if summary.Produced != lines || summary.Failed != 0 || len(summary.Results) != lines {
+		t.Fatalf("summary produced %d, failed %d, %d results; want %d produced", summary.Produced, summary.Failed, len(summary.Results), lines)
+	}
+	if len(w.written) != lines {
+		t.Fatalf("wrote %d messages, want %d", len(w.written), lines)
+	}
+	for i, m := range w.written {
+		if want := fmt.Sprintf(`"movie_id":%d,`, i+1); !strings.Contains(string(m.Value), want) {
+			t.Fatalf("message %d = %s, want movie %d in order", i, m.Value, i+1)
+		}
+	}
+	for _, n := range w.batches {
+		if n > importBatchSize {
+			t.Fatalf("wrote a batch of %d, want at most %d", n, importBatchSize)
+		}
+	}
+	if len(w.batches) != (lines+importBatchSize-1)/importBatchSize {
+		t.Errorf("wrote %d batches, want %d", len(w.batches), (lines+importBatchSize-1)/importBatchSize)
+	}
+}
+
+func TestHandleImportSummary(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		contentType  string
+		lines        []string
+		failOn       string
+		wantStatus   int
+		wantProduced int
+		wantFailed   int
+		wantStopped  int
+		wantStatuses []string
+	}{
+		{"bad lines skipped", "", "", []string{movieLine(1), `{"movie_id": `, `{"title": "no id"}`, movieLine(2)}, "", http.StatusMultiStatus, 2, 2, 0, []string{"produced", "failed", "failed", "produced"}},
+		{"stop on the first bad line", "?on_error=stop", "", []string{movieLine(1), `not json`, movieLine(2)}, "", http.StatusUnprocessableEntity, 1, 1, 2, []string{"produced", "failed"}},
+		{"every line invalid", "", "", []string{`{}`, `[]`}, "", http.StatusUnprocessableEntity, 0, 2, 0, []string{"failed", "failed"}},
+		{"kafka refused everything", "", "", []string{movieLine(1)}, "Movie 1", http.StatusInternalServerError, 0, 1, 0, []string{"failed"}},
+		{"kafka refused one line", "", "", []string{movieLine(1), movieLine(2)}, "Movie 2", http.StatusMultiStatus, 1, 1, 0, []string{"produced", "failed"}},
+		{"empty body", "", "", nil, "", http.StatusOK, 0, 0, 0, nil},
+		{"wrong content type", "", "application/json", []string{movieLine(1)}, "", http.StatusUnsupportedMediaType, 0, 0, 0, nil},
+		{"bad on_error", "?on_error=retry", "", []string{movieLine(1)}, "", http.StatusBadRequest, 0, 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &countingWriter{failOn: tt.failOn}
+			prev := topicWriters
+			topicWriters = map[string]eventWriter{movieTopic: w}
+			defer func() { topicWriters = prev }()
+
+			r := importRequest("/api/events/movie/import"+tt.query, strings.NewReader(strings.Join(tt.lines, "\n")))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := serve(handleImport(movieTopic), r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+				if len(w.written) != 0 {
+					t.Fatalf("rejected import produced %d messages", len(w.written))
+				}
+				return
+			}
+			var summary importSummary
+			decodeJSON(t, rec, &summary)
+			if summary.Produced != tt.wantProduced || summary.Failed != tt.wantFailed || summary.StoppedAt != tt.wantStopped {
+				t.Errorf("summary = %+v", summary)
+			}
+			if len(summary.Errors) != tt.wantFailed || len(w.written) != tt.wantProduced {
+				t.Errorf("%d errors listed and %d messages written", len(summary.Errors), len(w.written))
+			}
+			var statuses []string
+			for _, res := range summary.Results {
+				statuses = append(statuses, res.Status)
+			}
+			if strings.Join(statuses, ",") != strings.Join(tt.wantStatuses, ",") {
+				t.Errorf("results = %v, want %v", statuses, tt.wantStatuses)
+			}
+		})
+	}
+}
+
+// flakyBatchWriter fails every multi-message write with a generic error and
+// single writes for the listed values.
+type flakyBatchWriter struct {
+	fail   map[string]bool
+	writes int
+}
+
+func (f *flakyBatchWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.writes++
+	if len(msgs) > 1 {
+		return errors.New("broker went away")
+	}
+	if f.fail[string(msgs[0].Value)] {
+		return kafka.LeaderNotAvailable
+	}
+	return nil
+}
+
+func TestWriteBatch(t *testing.T) {
+	msgs := []kafka.Message{{Value: []byte("a")}, {Value: []byte("b")}, {Value: []byte("c")}}
+	tests := []struct {
+		name       string
+		msgs       []kafka.Message
+		fail       map[string]bool
+		wantFailed []bool
+		wantWrites int
+	}{
+		{"one by one after a batch failure", msgs, map[string]bool{"b": true}, []bool{false, true, false}, 4},
+		{"single message", msgs[:1], map[string]bool{"a": true}, []bool{true}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &flakyBatchWriter{fail: tt.fail}
+			errs := writeBatch(context.Background(), w, tt.msgs)
+			for i, err := range errs {
+				if (err != nil) != tt.wantFailed[i] {
+					t.Errorf("message %d err = %v, want failed %v", i, err, tt.wantFailed[i])
+				}
+			}
+			if w.writes != tt.wantWrites {
+				t.Errorf("writes = %d, want %d", w.writes, tt.wantWrites)
+			}
+		})
+	}
+
+	// Per-message write errors are taken as they are, without rewriting.
+	w := &countingWriter{failOn: "b"}
+	errs := writeBatch(context.Background(), w, msgs)
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil || len(w.batches) != 1 {
+		t.Errorf("errs = %v after %d writes", errs, len(w.batches))
+	}
+}
+
+func TestHandleImportOnError(t *testing.T) {
+	body := strings.Join([]string{
+		movieLine(1),
+		`{"title": "no id", "action": "viewed"}`,
+		"",
+		movieLine(2),
+		`{"movie_id": `,
+		movieLine(3),
+	}, "\n")
+	tests := []struct {
+		query        string
+		wantStatus   int
+		wantProduced []int
+		wantErrLines []int
+		wantStopped  int
+	}{
+		{"", http.StatusMultiStatus, []int{1, 2, 3}, []int{2, 5}, 0},
+		{"?on_error=skip", http.StatusMultiStatus, []int{1, 2, 3}, []int{2, 5}, 0},
+		{"?on_error=stop", http.StatusUnprocessableEntity, []int{1}, []int{2}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := &countingWriter{}
+			prev := topicWriters
+			topicWriters = map[string]eventWriter{movieTopic: w}
+			defer func() { topicWriters = prev }()
+
+			rec := serve(handleImport(movieTopic), importRequest("/api/events/movie/import"+tt.query, strings.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			var summary importSummary
+			decodeJSON(t, rec, &summary)
+			if summary.Produced != len(tt.wantProduced) || summary.StoppedAt != tt.wantStopped {
+				t.Errorf("summary = %+v", summary)
+			}
+			if len(w.written) != len(tt.wantProduced) {
+				t.Fatalf("wrote %d messages, want %d", len(w.written), len(tt.wantProduced))
+			}
+			for i, id := range tt.wantProduced {
+				if want := fmt.Sprintf(`"movie_id":%d,`, id); !strings.Contains(string(w.written[i].Value), want) {
+					t.Errorf("message %d = %s, want movie %d", i, w.written[i].Value, id)
+				}
+			}
+			if len(summary.Errors) != len(tt.wantErrLines) {
+				t.Fatalf("errors = %+v, want lines %v", summary.Errors, tt.wantErrLines)
+			}
+			for i, line := range tt.wantErrLines {
+				if summary.Errors[i].Line != line || summary.Errors[i].Error == "" {
+					t.Errorf("error %d = %+v, want line %d", i, summary.Errors[i], line)
+				}
+			}
+			// The validation failure names the field Validate rejected.
+			if !strings.Contains(summary.Errors[0].Error, "movie_id") {
+				t.Errorf("line 2 error = %q, want it to name movie_id", summary.Errors[0].Error)
+			}
+		})
+	}
+}
+
+// batchFailingWriter fails every multi-message write with an error that
+// does not say which messages were written, as a broken connection would,
+// and single writes of values containing one of failOn.
+type batchFailingWriter struct {
+	failOn  []string
+	written []kafka.Message
+}
+
+func (b *batchFailingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if len(msgs) > 1 {
+		return errors.New("connection reset by peer")
+	}
+	for _, s := range b.failOn {
+		if strings.Contains(string(msgs[0].Value), s) {
+			return kafka.NotLeaderForPartition
+		}
+	}
+	b.written = append(b.written, msgs[0])
+	return nil
+}
+
+func (*batchFailingWriter) Close() error { return nil }
+
+func TestHandleImportPartialWrites(t *testing.T) {
+	body := strings.Join([]string{movieLine(1), movieLine(2), "", movieLine(3)}, "\n")
+	tests := []struct {
+		name        string
+		failOn      []string
+		extra       string
+		wantStatus  int
+		wantResults []importResult
+		wantWritten int
+	}{
+		{"every message written", nil, "", http.StatusCreated, []importResult{
+			{Line: 1, Status: "produced"}, {Line: 2, Status: "produced"}, {Line: 4, Status: "produced"},
+		}, 3},
+		{"one message refused", []string{`"movie_id":2,`}, "", http.StatusMultiStatus, []importResult{
+			{Line: 1, Status: "produced"}, {Line: 2, Status: "failed"}, {Line: 4, Status: "produced"},
+		}, 2},
+		{"every message refused", []string{`"movie_id":`}, "", http.StatusInternalServerError, []importResult{
+			{Line: 1, Status: "failed"}, {Line: 2, Status: "failed"}, {Line: 4, Status: "failed"},
+		}, 0},
+		{"refused and invalid lines", []string{`"movie_id":`}, "\n{\"title\": \"no id\"}", http.StatusInternalServerError, []importResult{
+			{Line: 1, Status: "failed"}, {Line: 2, Status: "failed"}, {Line: 4, Status: "failed"}, {Line: 5, Status: "failed"},
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &batchFailingWriter{failOn: tt.failOn}
+			prev := topicWriters
+			topicWriters = map[string]eventWriter{movieTopic: w}
+			defer func() { topicWriters = prev }()
+
+			rec := serve(handleImport(movieTopic), importRequest("/api/events/movie/import", strings.NewReader(body+tt.extra)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			var summary importSummary
+			decodeJSON(t, rec, &summary)
+			if len(summary.Results) != len(tt.wantResults) {
+				t.Fatalf("results = %+v, want %+v", summary.Results, tt.wantResults)
+			}
+			failed := 0
+			for i, want := range tt.wantResults {
+				got := summary.Results[i]
+				if got.Line != want.Line || got.Status != want.Status || (got.Status == "failed") != (got.Error != "") {
+					t.Errorf("result %d = %+v, want %+v", i, got, want)
+				}
+				if got.Status == "failed" {
+					failed++
+				}
+			}
+			if summary.Produced != tt.wantWritten || summary.Failed != failed || len(w.written) != tt.wantWritten {
+				t.Errorf("summary produced %d failed %d with %d written, want %d produced", summary.Produced, summary.Failed, len(w.written), tt.wantWritten)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// logRange is the span of offsets a partition currently holds; last is the
+// high-water mark, the offset the next message will get.
+type logRange struct {
+	first, last int64
+}
+
+// offsetSource reports committed group offsets and partition high-water
+// marks. Committed offsets are -1 for partitions the group never committed.
+type offsetSource interface {
+	committedOffsets(ctx context.Context, group string, topics []string) (map[string]map[int]int64, error)
+	logRanges(ctx context.Context, topics []string) (map[string]map[int]logRange, error)
+}
+
+type kafkaOffsetSource struct {
+	client *kafka.Client
+}
+
+func (s kafkaOffsetSource) partitions(ctx context.Context, topics []string) (map[string][]int, error) {
+	meta, err := s.client.Metadata(ctx, &kafka.MetadataRequest{Topics: topics})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]int, len(meta.Topics))
+	for _, t := range meta.Topics {
+		if t.Error != nil {
+			return nil, fmt.Errorf("topic %s: %w", t.Name, t.Error)
+		}
+		for _, p := range t.Partitions {
+			out[t.Name] = append(out[t.Name], p.ID)
+		}
+	}
+	return out, nil
+}
+
+func (s kafkaOffsetSource) committedOffsets(ctx context.Context, group string, topics []string) (map[string]map[int]int64, error) {
+	parts, err := s.partitions(ctx, topics)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: group, Topics: parts})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	out := make(map[string]map[int]int64, len(resp.Topics))
+	for topic, partitions := range resp.Topics {
+		out[topic] = make(map[int]int64, len(partitions))
+		for _, p := range partitions {
+			if p.Error != nil {
+				return nil, fmt.Errorf("topic %s partition %d: %w", topic, p.Partition, p.Error)
+			}
+			out[topic][p.Partition] = p.CommittedOffset
+		}
+	}
+	return out, nil
+}
+
+func (s kafkaOffsetSource) logRanges(ctx context.Context, topics []string) (map[string]map[int]logRange, error) {
+	parts, err := s.partitions(ctx, topics)
+	if err != nil {
+		return nil, err
+	}
+	req := &kafka.ListOffsetsRequest{Topics: make(map[string][]kafka.OffsetRequest, len(parts))}
+	for topic, ids := range parts {
+		for _, id := range ids {
+			req.Topics[topic] = append(req.Topics[topic], kafka.FirstOffsetOf(id), kafka.LastOffsetOf(id))
+		}
+	}
+	resp, err := s.client.ListOffsets(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]map[int]logRange, len(resp.Topics))
+	for topic, partitions := range resp.Topics {
+		out[topic] = make(map[int]logRange, len(partitions))
+		for _, p := range partitions {
+			if p.Error != nil {
+				return nil, fmt.Errorf("topic %s partition %d: %w", topic, p.Partition, p.Error)
+			}
+			out[topic][p.Partition] = logRange{first: p.FirstOffset, last: p.LastOffset}
+		}
+	}
+	return out, nil
+}
+
+type lagReport struct {
+	Total  int64            `json:"total"`
+	Topics map[string]int64 `json:"topics"`
+}
+
+// computeLag sums high-water mark minus committed offset over every
+// partition. A partition the group has not committed yet counts all of its
+// retained messages, since the consumer starts from the earliest offset.
+func computeLag(ctx context.Context, src offsetSource, group string, topics []string) (lagReport, error) {
+	committed, err := src.committedOffsets(ctx, group, topics)
+	if err != nil {
+		return lagReport{}, fmt.Errorf("fetch committed offsets: %w", err)
+	}
+	ranges, err := src.logRanges(ctx, topics)
+	if err != nil {
+		return lagReport{}, fmt.Errorf("list offsets: %w", err)
+	}
+
+	report := lagReport{Topics: make(map[string]int64, len(topics))}
+	for _, topic := range topics {
+		var lag int64
+		for partition, r := range ranges[topic] {
+			offset, ok := committed[topic][partition]
+			if !ok || offset < r.first {
+				offset = r.first
+			}
+			if r.last > offset {
+				lag += r.last - offset
+			}
+		}
+		report.Topics[topic] = lag
+		report.Total += lag
+	}
+	return report, nil
+}
+
+// lagMonitor serves GET /api/events/lag, reusing a computed report for ttl
+// so frequent scrapes do not each hit the brokers.
+type lagMonitor struct {
+	src    offsetSource
+	group  string
+	topics []string
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	report   lagReport
+	computed time.Time
+}
+
+func (m *lagMonitor) current(ctx context.Context) (lagReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.computed.IsZero() && time.Since(m.computed) < m.ttl {
+		return m.report, nil
+	}
+	report, err := computeLag(ctx, m.src, m.group, m.topics)
+	if err != nil {
+		return lagReport{}, err
+	}
+	m.report, m.computed = report, time.Now()
+	return report, nil
+}
+
+// handleLag returns the total lag as a bare number for autoscalers, or the
+// per-topic breakdown with ?format=json or Accept: application/json.
+func (m *lagMonitor) handleLag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	report, err := m.current(ctx)
+	if err != nil {
+		log.Printf("Failed to compute consumer lag: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, r, http.StatusOK, report)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(strconv.FormatInt(report.Total, 10) + "\n"))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockOffsets is a fixed offsetSource that counts how often it is queried.
+type mockOffsets struct {
+	committed map[string]map[int]int64
+	ranges    map[string]map[int]logRange
+	err       error
+	calls     atomic.Int32
+}
+
+func (m *mockOffsets) committedOffsets(ctx context.Context, group string, topics []string) (map[string]map[int]int64, error) {
+	m.calls.Add(1)
+	return m.committed, m.err
+}
+
+func (m *mockOffsets) logRanges(ctx context.Context, topics []string) (map[string]map[int]logRange, error) {
+	return m.ranges, m.err
+}
+
+func TestComputeLag(t *testing.T) {
+	tests := []struct {
+		name      string
+		committed map[string]map[int]int64
+		ranges    map[string]map[int]logRange
+		want      map[string]int64
+		wantTotal int64
+	}{
+		{
+			"caught up",
+			map[string]map[int]int64{"movie-events": {0: 10}},
+			map[string]map[int]logRange{"movie-events": {0: {0, 10}}},
+			map[string]int64{"movie-events": 0, "user-events": 0},
+			0,
+		},
+		{
+			"summed over partitions and topics",
+			map[string]map[int]int64{"movie-events": {0: 5, 1: 20}, "user-events": {0: 3}},
+			map[string]map[int]logRange{"movie-events": {0: {0, 10}, 1: {0, 25}}, "user-events": {0: {0, 7}}},
+			map[string]int64{"movie-events": 10, "user-events": 4},
+			14,
+		},
+		{
+			"never committed counts retained messages",
+			map[string]map[int]int64{"movie-events": {0: -1}},
+			map[string]map[int]logRange{"movie-events": {0: {100, 130}, 1: {40, 50}}},
+			map[string]int64{"movie-events": 40, "user-events": 0},
+			40,
+		},
+		{
+			"committed before retention counts from the first offset",
+			map[string]map[int]int64{"movie-events": {0: 20}},
+			map[string]map[int]logRange{"movie-events": {0: {50, 60}}},
+			map[string]int64{"movie-events": 10, "user-events": 0},
+			10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &mockOffsets{committed: tt.committed, ranges: tt.ranges}
+			report, err := computeLag(context.Background(), src, "events-group", []string{"movie-events", "user-events"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", report.Total, tt.wantTotal)
+			}
+			for topic, want := range tt.want {
+				if got := report.Topics[topic]; got != want {
+					t.Errorf("%s lag = %d, want %d", topic, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleLag(t *testing.T) {
+	src := &mockOffsets{
+		committed: map[string]map[int]int64{"movie-events": {0: 5}, "user-events": {0: 1}},
+		ranges:    map[string]map[int]logRange{"movie-events": {0: {0, 10}}, "user-events": {0: {0, 3}}},
+	}
+	tests := []struct {
+		name     string
+		target   string
+		accept   string
+		wantBody string
+	}{
+		{"plain number", "/api/events/lag", "", "7\n"},
+		{"json query", "/api/events/lag?format=json", "", `{"total":7,"topics":{"movie-events":5,"user-events":2}}`},
+		{"json accept", "/api/events/lag", "application/json", `{"total":7,"topics":{"movie-events":5,"user-events":2}}`},
+	}
+	m := &lagMonitor{src: src, group: "events-group", topics: []string{"movie-events", "user-events"}, ttl: time.Minute}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			rec := serve(http.HandlerFunc(m.handleLag), r)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Body.String(); got != tt.wantBody && got != tt.wantBody+"\n" {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+	if got := src.calls.Load(); got != 1 {
+		t.Errorf("offset source queried %d times, want 1 within the cache ttl", got)
+	}
+}
+
+func TestHandleLagCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		err       error
+		wantCalls int32
+		wantCode  int
+	}{
+		{"cached", time.Minute, nil, 1, http.StatusOK},
+		{"no cache", 0, nil, 3, http.StatusOK},
+		{"errors are not cached", time.Minute, errors.New("broker unreachable"), 3, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &mockOffsets{err: tt.err}
+			m := &lagMonitor{src: src, group: "events-group", topics: []string{"movie-events"}, ttl: tt.ttl}
+			for i := 0; i < 3; i++ {
+				if rec := serve(http.HandlerFunc(m.handleLag), httptest.NewRequest(http.MethodGet, "/api/events/lag", nil)); rec.Code != tt.wantCode {
+					t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+				}
+			}
+			if got := src.calls.Load(); got != tt.wantCalls {
+				t.Errorf("offset source queried %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
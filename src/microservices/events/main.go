@@ -2,83 +2,521 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/hamba/avro/v2/registry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/segmentio/kafka-go"
 )
 
-type MovieEvent struct {
-	MovieID int    `json:"movie_id"`
-	Title   string `json:"title"`
-	Action  string `json:"action"`
-	UserID  int    `json:"user_id"`
-}
-
-type UserEvent struct {
-	UserID    int       `json:"user_id"`
-	Username  string    `json:"username"`
-	Action    string    `json:"action"`
-	Timestamp time.Time `json:"timestamp"`
-}
-
-type PaymentEvent struct {
-	PaymentID int       `json:"payment_id"`
-	UserID    int       `json:"user_id"`
-	Amount    float64   `json:"amount"`
-	Status    string    `json:"status"`
-	Timestamp time.Time `json:"timestamp"`
-}
-
 var writer *kafka.Writer
 
+// maxHeaderBytes caps request headers well below net/http's 1 MB default;
+// event producers send only a handful of small headers.
+const maxHeaderBytes = 64 << 10
+
+// Base topic names. The effective name on the cluster is topicName(base),
+// which applies KAFKA_TOPIC_PREFIX so several environments can share one cluster.
 const (
 	movieTopic   = "movie-events"
 	userTopic    = "user-events"
 	paymentTopic = "payment-events"
 )
 
+var topicPrefix string
+
+func topicName(base string) string {
+	return topicPrefix + base
+}
+
 func getEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		value = fallback
 	}
-	return fallback
+	recordSetting(key, value)
+	return value
 }
 
-func main() {
+// brokerList splits the comma-separated brokers in key, using fallback when
+// key is unset or empty.
+func brokerList(key, fallback string) []string {
+	value := getEnv(key, "")
+	if value == "" {
+		value = fallback
+	}
+	var brokers []string
+	for _, b := range strings.Split(value, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	return brokers
+}
+
+// kafkaClusters returns the producer and consumer broker lists. Producing
+// and consuming can target different clusters, for example consuming from a
+// mirror of the primary; both default to KAFKA_BROKERS.
+func kafkaClusters() (producer, consumer []string) {
 	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
-	brokers := strings.Split(kafkaBrokers, ",")
+	return brokerList("KAFKA_PRODUCER_BROKERS", kafkaBrokers), brokerList("KAFKA_CONSUMER_BROKERS", kafkaBrokers)
+}
+
+func main() {
+	if problems := validateConfig(); len(problems) > 0 {
+		log.Fatalf("Invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+
+	producerBrokers, consumerBrokers := kafkaClusters()
+	topicPrefix = getEnv("KAFKA_TOPIC_PREFIX", "")
+	maxBytes, err := strconv.Atoi(getEnv("KAFKA_MAX_MESSAGE_BYTES", strconv.Itoa(maxMessageBytes)))
+	if err != nil || maxBytes <= 0 {
+		log.Fatalf("Invalid KAFKA_MAX_MESSAGE_BYTES: must be a positive integer")
+	}
+	maxMessageBytes = maxBytes
+	if enrichers, err = parseEnrichers(getEnv("EVENT_ENRICHERS", "")); err != nil {
+		log.Fatalf("Invalid EVENT_ENRICHERS: %v", err)
+	}
+	if trustedProxies, err = parseTrustedProxies(getEnv("TRUSTED_PROXIES", "")); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	if filterRules, err = loadFilterRules(getEnv("FILTER_RULES_FILE", "")); err != nil {
+		log.Fatalf("Failed to load FILTER_RULES_FILE: %v", err)
+	}
+	strictDecoding = getEnv("STRICT_DECODING", "false") == "true"
+	compatFieldNames = getEnv("COMPAT_FIELD_NAMES", "false") == "true"
+	requireJSONContentType = getEnv("REQUIRE_JSON_CONTENT_TYPE", "false") == "true"
+
+	producerCfg, err := loadProducerConfig(getEnv("PRODUCER_CONFIG_FILE", ""))
+	if err != nil {
+		log.Fatalf("Failed to load PRODUCER_CONFIG_FILE: %v", err)
+	}
+	if err := newWriters(producerBrokers, producerCfg); err != nil {
+		log.Fatalf("Invalid producer configuration: %v", err)
+	}
+	defer closeWriters()
+	autoCreateTopics = getEnv("KAFKA_AUTO_CREATE_TOPICS", "false") == "true"
+	if autoCreatePartitions, err = strconv.Atoi(getEnv("KAFKA_AUTO_CREATE_PARTITIONS", "1")); err != nil || autoCreatePartitions <= 0 {
+		log.Fatalf("Invalid KAFKA_AUTO_CREATE_PARTITIONS: must be a positive integer")
+	}
+	if autoCreateReplication, err = strconv.Atoi(getEnv("KAFKA_AUTO_CREATE_REPLICATION", "1")); err != nil || autoCreateReplication <= 0 {
+		log.Fatalf("Invalid KAFKA_AUTO_CREATE_REPLICATION: must be a positive integer")
+	}
+	for topic, override := range producerCfg.Topics {
+		log.Printf("Producer settings for %s: %+v", topic, producerCfg.Defaults.merge(override))
+	}
+
+	if registryURL := getEnv("SCHEMA_REGISTRY_URL", ""); registryURL != "" {
+		reg, err := registry.NewClient(registryURL)
+		if err != nil {
+			log.Fatalf("Invalid SCHEMA_REGISTRY_URL: %v", err)
+		}
+		avroTopics, err := parseAvroTopics(getEnv("AVRO_TOPICS", ""))
+		if err != nil {
+			log.Fatalf("Invalid AVRO_TOPICS: %v", err)
+		}
+		codec = newAvroCodec(reg, avroTopics)
+		log.Printf("Producing Avro via schema registry %s for topics %v", registryURL, avroTopics)
+	}
+
+	switch valueFormat = getEnv("KAFKA_VALUE_FORMAT", "json"); valueFormat {
+	case "json":
+	case formatProtobuf:
+		log.Printf("Producing protobuf for topics not listed in AVRO_TOPICS")
+	default:
+		log.Fatalf("Invalid KAFKA_VALUE_FORMAT: must be json or protobuf")
+	}
+
+	if getEnv("SOURCE_HEADERS", "false") == "true" {
+		host, err := os.Hostname()
+		if err != nil {
+			log.Printf("Failed to read hostname for source-host header: %v", err)
+		}
+		sourceHeaders = newSourceHeaders(host, getEnv("POD_NAME", ""))
+	}
+
+	// Topic administration and the health probe concern the producer
+	// cluster; lag, group membership and the state view read the consumer
+	// cluster.
+	kafkaClient := &kafka.Client{Addr: kafka.TCP(producerBrokers...)}
+	adminClient = kafkaClient
+	brokerClient = kafkaClient
+	consumerClient := &kafka.Client{Addr: kafka.TCP(consumerBrokers...)}
+	adminToken = getEnv("ADMIN_TOKEN", "")
+	allowDestructiveAdmin = getEnv("ALLOW_DESTRUCTIVE_ADMIN", "false") == "true"
+	if allowDestructiveAdmin && isProduction(getEnv("APP_ENV", "")) {
+		log.Printf("ALLOW_DESTRUCTIVE_ADMIN ignored because APP_ENV is production")
+		allowDestructiveAdmin = false
+	}
+
+	startOffset, err := parseStartOffset(getEnv("KAFKA_START_OFFSET", "earliest"))
+	if err != nil {
+		log.Fatalf("Invalid KAFKA_START_OFFSET: %v", err)
+	}
+	consumerCfg := consumerConfig{
+		Brokers:      consumerBrokers,
+		GroupID:      "cinemaabyss-events-consumer-group",
+		StartOffset:  startOffset,
+		ManualCommit: getEnv("KAFKA_MANUAL_COMMIT", "false") == "true",
+	}
+	if consumerCfg.MaxAttempts, err = strconv.Atoi(getEnv("CONSUMER_MAX_ATTEMPTS", "3")); err != nil || consumerCfg.MaxAttempts <= 0 {
+		log.Fatalf("Invalid CONSUMER_MAX_ATTEMPTS: must be a positive integer")
+	}
+	if consumerCfg.CheckpointInterval, err = time.ParseDuration(getEnv("CONSUMER_CHECKPOINT_INTERVAL", "60s")); err != nil || consumerCfg.CheckpointInterval < 0 {
+		log.Fatalf("Invalid CONSUMER_CHECKPOINT_INTERVAL: must be a duration such as 30s, or 0 to disable")
+	}
+	if consumerCfg.SessionTimeout, err = time.ParseDuration(getEnv("KAFKA_SESSION_TIMEOUT", "30s")); err != nil || consumerCfg.SessionTimeout <= 0 {
+		log.Fatalf("Invalid KAFKA_SESSION_TIMEOUT: must be a positive duration such as 30s")
+	}
+	if consumerCfg.HeartbeatInterval, err = time.ParseDuration(getEnv("KAFKA_HEARTBEAT_INTERVAL", "3s")); err != nil || consumerCfg.HeartbeatInterval <= 0 || consumerCfg.HeartbeatInterval >= consumerCfg.SessionTimeout {
+		log.Fatalf("Invalid KAFKA_HEARTBEAT_INTERVAL: must be a positive duration shorter than KAFKA_SESSION_TIMEOUT")
+	}
+	commitIntervalMS, err := strconv.Atoi(getEnv("KAFKA_COMMIT_INTERVAL_MS", "0"))
+	if err != nil || commitIntervalMS < 0 {
+		log.Fatalf("Invalid KAFKA_COMMIT_INTERVAL_MS: must be a non-negative integer")
+	}
+	consumerCfg.CommitInterval = time.Duration(commitIntervalMS) * time.Millisecond
+	if consumerCfg.CommitInterval > 0 {
+		if consumerCfg.ManualCommit {
+			log.Printf("Committing offsets every %s: after a crash, messages handled since the last commit are redelivered", consumerCfg.CommitInterval)
+		} else {
+			log.Printf("KAFKA_COMMIT_INTERVAL_MS ignored because KAFKA_MANUAL_COMMIT is false")
+		}
+	}
+	logBurst, err := strconv.Atoi(getEnv("DESERIALIZATION_LOG_BURST", "10"))
+	if err != nil || logBurst < 0 {
+		log.Fatalf("Invalid DESERIALIZATION_LOG_BURST: must be a non-negative integer")
+	}
+	logInterval, err := time.ParseDuration(getEnv("DESERIALIZATION_LOG_INTERVAL", "1m"))
+	if err != nil || logInterval <= 0 {
+		log.Fatalf("Invalid DESERIALIZATION_LOG_INTERVAL: must be a positive duration such as 1m")
+	}
+	deserializationLogs = newLogSampler(logBurst, logInterval)
+	if produceAttempts, err = strconv.Atoi(getEnv("PRODUCE_RETRY_ATTEMPTS", "3")); err != nil || produceAttempts <= 0 {
+		log.Fatalf("Invalid PRODUCE_RETRY_ATTEMPTS: must be a positive integer")
+	}
+	retryBackoffMS, err := strconv.Atoi(getEnv("PRODUCE_RETRY_BACKOFF_MS", "100"))
+	if err != nil || retryBackoffMS < 0 {
+		log.Fatalf("Invalid PRODUCE_RETRY_BACKOFF_MS: must be a non-negative integer")
+	}
+	produceRetryBackoff = time.Duration(retryBackoffMS) * time.Millisecond
+	limits, err := parseTopicLimits(getEnv("PRODUCE_CONCURRENCY", "0"), getEnv("PRODUCE_CONCURRENCY_TOPICS", ""))
+	if err != nil {
+		log.Fatalf("Invalid PRODUCE_CONCURRENCY or PRODUCE_CONCURRENCY_TOPICS: %v", err)
+	}
+	retryAfter, err := strconv.Atoi(getEnv("PRODUCE_RETRY_AFTER_SECONDS", "1"))
+	if err != nil || retryAfter < 0 {
+		log.Fatalf("Invalid PRODUCE_RETRY_AFTER_SECONDS: must be a non-negative integer")
+	}
+	if produceLimits = newTopicLimiter(limits, retryAfter); produceLimits != nil {
+		log.Printf("Produce concurrency limits per topic: %v", limits)
+	}
+	if path := getEnv("PRODUCE_DLQ_FILE", ""); path != "" {
+		produceDLQ = &deadLetterFile{path: path}
+		log.Printf("Events that exhaust produce retries are dead-lettered to %s", path)
+	}
+	if consumerCfg.AssignedPartitions, err = parseAssignedPartitions(getEnv("KAFKA_ASSIGNED_PARTITIONS", "")); err != nil {
+		log.Fatalf("Invalid KAFKA_ASSIGNED_PARTITIONS: %v", err)
+	}
+	if len(consumerCfg.AssignedPartitions) > 0 {
+		log.Printf("Consuming assigned partitions %v without group rebalancing", consumerCfg.AssignedPartitions)
+	}
+	if consumerCfg.HeaderFilter, err = parseHeaderFilter(getEnv("CONSUMER_HEADER_FILTER", "")); err != nil {
+		log.Fatalf("Invalid CONSUMER_HEADER_FILTER: %v", err)
+	}
+	if consumerCfg.HeaderFilter != nil {
+		log.Printf("Consumers skip messages whose headers do not match %s", consumerCfg.HeaderFilter)
+	}
+	if consumerCfg.ErrorPolicies, err = parseErrorPolicies(getEnv("CONSUMER_ERROR_POLICY", "dlq"), getEnv("CONSUMER_ERROR_POLICIES", "")); err != nil {
+		log.Fatalf("Invalid consumer error policy: %v", err)
+	}
+	consumerIdle, err := time.ParseDuration(getEnv("CONSUMER_IDLE_TIMEOUT", "0"))
+	if err != nil || consumerIdle < 0 {
+		log.Fatalf("Invalid CONSUMER_IDLE_TIMEOUT: must be a duration such as 30s, or 0 to disable")
+	}
+	maxMessages, err := strconv.Atoi(getEnv("CONSUMER_MAX_MESSAGES", "0"))
+	if err != nil || maxMessages < 0 {
+		log.Fatalf("Invalid CONSUMER_MAX_MESSAGES: must be a non-negative integer")
+	}
+	scheduleMax, err := strconv.Atoi(getEnv("SCHEDULE_MAX_PENDING", "1000"))
+	if err != nil || scheduleMax < 0 {
+		log.Fatalf("Invalid SCHEDULE_MAX_PENDING: must be a non-negative integer")
+	}
+	if scheduleMax > 0 {
+		if scheduler, err = newEventScheduler(scheduleMax, getEnv("SCHEDULE_FILE", "")); err != nil {
+			log.Fatalf("Failed to load SCHEDULE_FILE: %v", err)
+		}
+		if n := len(scheduler.pending); n > 0 {
+			log.Printf("Loaded %d pending scheduled events from %s", n, scheduler.path)
+		}
+	}
+	quarantineSize, err := strconv.Atoi(getEnv("QUARANTINE_SIZE", "100"))
+	if err != nil || quarantineSize < 0 {
+		log.Fatalf("Invalid QUARANTINE_SIZE: must be a non-negative integer")
+	}
+	if quarantineSize > 0 {
+		if quarantine, err = newQuarantineStore(quarantineSize, getEnv("QUARANTINE_FILE", "")); err != nil {
+			log.Fatalf("Failed to load QUARANTINE_FILE: %v", err)
+		}
+	}
+	if deadLetterSuffix = getEnv("CONSUMER_DLQ_TOPIC_SUFFIX", ".dlq"); deadLetterSuffix != "" {
+		deadLetterWriter = writer
+	} else if quarantine == nil {
+		log.Printf("CONSUMER_DLQ_TOPIC_SUFFIX is empty and QUARANTINE_SIZE is 0: a consumer halts on the first message it would dead-letter")
+	}
+	if sinkURL := getEnv("WEBHOOK_SINK_URL", ""); sinkURL != "" {
+		timeout, err := time.ParseDuration(getEnv("WEBHOOK_SINK_TIMEOUT", "5s"))
+		if err != nil || timeout <= 0 {
+			log.Fatalf("Invalid WEBHOOK_SINK_TIMEOUT: must be a positive duration such as 5s")
+		}
+		retries, err := strconv.Atoi(getEnv("WEBHOOK_SINK_RETRIES", "3"))
+		if err != nil || retries < 0 {
+			log.Fatalf("Invalid WEBHOOK_SINK_RETRIES: must be a non-negative integer")
+		}
+		threshold, err := strconv.Atoi(getEnv("WEBHOOK_BREAKER_FAILURES", "5"))
+		if err != nil || threshold <= 0 {
+			log.Fatalf("Invalid WEBHOOK_BREAKER_FAILURES: must be a positive integer")
+		}
+		cooldown, err := time.ParseDuration(getEnv("WEBHOOK_BREAKER_COOLDOWN", "30s"))
+		if err != nil || cooldown <= 0 {
+			log.Fatalf("Invalid WEBHOOK_BREAKER_COOLDOWN: must be a positive duration such as 30s")
+		}
+		sink = &webhookSink{
+			url:        sinkURL,
+			secret:     []byte(getEnv("WEBHOOK_SINK_SECRET", "")),
+			client:     &http.Client{Timeout: timeout},
+			maxRetries: retries,
+			breaker:    &circuitBreaker{threshold: threshold, cooldown: cooldown},
+		}
+		log.Printf("Consumed events are delivered to webhook %s (%d retries, breaker opens after %d failures for %s)", sinkURL, retries, threshold, cooldown)
+	}
+	pipelines, err := parseStreamPipelines(getEnv("STREAM_PIPELINES", ""))
+	if err != nil {
+		log.Fatalf("Invalid STREAM_PIPELINES: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	writer = &kafka.Writer{
-		Addr:     kafka.TCP(brokers...),
-		Balancer: &kafka.LeastBytes{},
+	drainTimeoutSeconds, err := strconv.Atoi(getEnv("DRAIN_TIMEOUT_SECONDS", "15"))
+	if err != nil || drainTimeoutSeconds < 0 {
+		log.Fatalf("Invalid DRAIN_TIMEOUT_SECONDS: must be a non-negative integer")
+	}
+	if getEnv("PRODUCE_MODE", "sync") == "async" {
+		bufferSize, err := strconv.Atoi(getEnv("PRODUCE_BUFFER_SIZE", "1000"))
+		if err != nil || bufferSize <= 0 {
+			log.Fatalf("Invalid PRODUCE_BUFFER_SIZE: must be a positive integer")
+		}
+		buffer = newProduceBuffer(bufferSize, getEnv("PRODUCE_BUFFER_DIR", ""))
+		if buffer.dir != "" {
+			if err := os.MkdirAll(buffer.dir, 0o700); err != nil {
+				log.Fatalf("Failed to create PRODUCE_BUFFER_DIR: %v", err)
+			}
+		}
+		buffer.start(ctx)
+		log.Printf("Async produce enabled (queue %d, spool dir %q)", bufferSize, buffer.dir)
+	}
+	if scheduler != nil {
+		go scheduler.run(ctx)
 	}
-	defer writer.Close()
 
 	var wg sync.WaitGroup
 	topics := []string{movieTopic, userTopic, paymentTopic}
-	for _, topic := range topics {
+	if maxMessages > 0 || consumerIdle > 0 {
+		// A bounded run: the whole service shuts down once every topic
+		// consumer has stopped, as if it had been sent SIGTERM.
+		consumeCtx, cancelConsumers := context.WithCancel(ctx)
+		bounded := consumerCfg
+		bounded.IdleTimeout = consumerIdle
+		bounded.Budget = newMessageBudget(maxMessages, cancelConsumers)
+		log.Printf("Consumers stop after %d messages (0 for no limit) or %s without messages (0 for no timeout)", maxMessages, consumerIdle)
+		var consumers sync.WaitGroup
+		for _, topic := range topics {
+			consumers.Add(1)
+			go consume(consumeCtx, bounded, topicName(topic), &consumers)
+		}
 		wg.Add(1)
-		go consume(context.Background(), topic, &wg)
+		go func() {
+			defer wg.Done()
+			consumers.Wait()
+			cancelConsumers()
+			log.Printf("[CONSUMER] All consumers finished")
+			stop()
+		}()
+	} else {
+		for _, topic := range topics {
+			wg.Add(1)
+			go consume(ctx, consumerCfg, topicName(topic), &wg)
+		}
+	}
+	for _, p := range pipelines {
+		wg.Add(1)
+		go runStream(ctx, consumerCfg, p, &wg)
+	}
+	if heartbeatInterval, err := time.ParseDuration(getEnv("HEARTBEAT_INTERVAL", "0")); err != nil || heartbeatInterval < 0 {
+		log.Fatalf("Invalid HEARTBEAT_INTERVAL: must be a duration such as 10s, or 0 to disable")
+	} else if heartbeatInterval > 0 {
+		staleAfter, err := time.ParseDuration(getEnv("HEARTBEAT_STALE_AFTER", (3 * heartbeatInterval).String()))
+		if err != nil || staleAfter <= heartbeatInterval {
+			log.Fatalf("Invalid HEARTBEAT_STALE_AFTER: must be a duration longer than HEARTBEAT_INTERVAL")
+		}
+		instance, _ := os.Hostname()
+		if pod := getEnv("POD_NAME", ""); pod != "" {
+			instance = pod
+		}
+		readerCfg := newReaderConfig(consumerCfg, topicName(heartbeatTopic))
+		readerCfg.GroupID = consumerCfg.GroupID + "-heartbeat-" + instance
+		readerCfg.StartOffset = kafka.LastOffset
+		wg.Add(1)
+		go newHeartbeatMonitor(instance, heartbeatInterval, staleAfter).run(ctx, kafka.NewReader(readerCfg), &wg)
+		log.Printf("Heartbeats every %s on %s, alerting after %s without one", heartbeatInterval, topicName(heartbeatTopic), staleAfter)
 	}
 
-	http.HandleFunc("/api/events/movie", handleEvent(movieTopic))
-	http.HandleFunc("/api/events/user", handleEvent(userTopic))
-	http.HandleFunc("/api/events/payment", handleEvent(paymentTopic))
+	spec, err := loadOpenAPI(ctx)
+	if err != nil {
+		log.Fatalf("Invalid embedded openapi.json: %v", err)
+	}
+	var openAPI *openAPIValidator
+	if getEnv("OPENAPI_VALIDATION", "false") == "true" {
+		openAPI = &openAPIValidator{doc: spec}
+		log.Printf("Validating produce requests against openapi.json")
+	}
+	http.HandleFunc("/api/events/movie", openAPI.wrap("/api/events/movie", handleEvent(movieTopic)))
+	http.HandleFunc("/api/events/user", openAPI.wrap("/api/events/user", handleEvent(userTopic)))
+	http.HandleFunc("/api/events/payment", openAPI.wrap("/api/events/payment", handleEvent(paymentTopic)))
+	http.HandleFunc("POST /api/events/movie/import", handleImport(movieTopic))
+	http.HandleFunc("/api/events/validate", openAPI.wrap("/api/events/validate", handleValidate))
 	http.HandleFunc("/api/events/health", handleHealth)
+	http.HandleFunc("GET /api/events/openapi.json", handleOpenAPI)
+	http.HandleFunc("/api/events/admin/reset", requireAdmin(handleTopicReset))
+	if rawTopics := parseRawTopics(getEnv("RAW_PRODUCE_TOPICS", "")); len(rawTopics) > 0 {
+		http.HandleFunc("POST /api/events/raw", requireAdmin(handleRawProduce(rawTopics)))
+		log.Printf("Raw produce enabled for topics %v", rawTopics)
+	}
+	throughputWindow, err := time.ParseDuration(getEnv("THROUGHPUT_WINDOW", "60s"))
+	if err != nil || throughputWindow < time.Second {
+		log.Fatalf("Invalid THROUGHPUT_WINDOW: must be a duration of at least 1s")
+	}
+	producedRate, consumedRate = newRateWindow(throughputWindow), newRateWindow(throughputWindow)
+	prometheus.MustRegister(newThroughputCollector(producedRate, consumedRate))
+	http.Handle("/metrics", promhttp.Handler())
+
+	lagCacheSeconds, err := strconv.Atoi(getEnv("LAG_CACHE_SECONDS", "5"))
+	if err != nil || lagCacheSeconds < 0 {
+		log.Fatalf("Invalid LAG_CACHE_SECONDS: must be a non-negative integer")
+	}
+	lag := &lagMonitor{
+		src:   kafkaOffsetSource{client: consumerClient},
+		group: consumerCfg.GroupID,
+		ttl:   time.Duration(lagCacheSeconds) * time.Second,
+	}
+	for _, topic := range topics {
+		lag.topics = append(lag.topics, topicName(topic))
+	}
+	http.HandleFunc("/api/events/lag", lag.handleLag)
+	http.HandleFunc("GET /api/events/consumer/members", requireAdmin(newGroupInspector(consumerClient, consumerCfg.GroupID).handleMembers))
+	if quarantine != nil {
+		http.HandleFunc("GET /api/events/quarantine", requireAdmin(quarantine.handleList))
+		http.HandleFunc("POST /api/events/quarantine/{id}/retry", requireAdmin(quarantine.handleRetry))
+	}
+
+	fetchTimeout, err := time.ParseDuration(getEnv("MESSAGE_FETCH_TIMEOUT", "5s"))
+	if err != nil || fetchTimeout <= 0 {
+		log.Fatalf("Invalid MESSAGE_FETCH_TIMEOUT: must be a positive duration such as 5s")
+	}
+	fetcher := &messageFetcher{open: newPartitionReader(consumerBrokers), timeout: fetchTimeout}
+	http.HandleFunc("GET /api/events/message", requireAdmin(fetcher.handleGet))
+
+	if getEnv("MOVIE_STATE_VIEW", "false") == "true" {
+		view := newMovieStateView()
+		wg.Add(1)
+		go view.run(ctx, kafkaOffsetSource{client: consumerClient}, consumerBrokers, topicName(movieTopic), &wg)
+		http.HandleFunc("GET /api/events/state/movie/{id}", view.handleGet)
+	}
+
+	if sinkURL := getEnv("REPLAY_SINK_URL", ""); sinkURL != "" {
+		rp := &replayer{
+			ctx:     ctx,
+			sinkURL: sinkURL,
+			client:  &http.Client{Timeout: 10 * time.Second},
+			open:    newPartitionReader(consumerBrokers),
+		}
+		for key, opt := range map[string]struct {
+			dst      *int
+			fallback int
+		}{
+			"REPLAY_CONCURRENCY":  {&rp.concurrency, 4},
+			"REPLAY_MAX_RETRIES":  {&rp.maxRetries, 3},
+			"REPLAY_MAX_FAILURES": {&rp.maxFailures, 10},
+		} {
+			n, err := strconv.Atoi(getEnv(key, strconv.Itoa(opt.fallback)))
+			if err != nil || n < 0 || (n == 0 && key != "REPLAY_MAX_RETRIES") {
+				log.Fatalf("Invalid %s: must be a positive integer", key)
+			}
+			*opt.dst = n
+		}
+		http.HandleFunc("/api/events/admin/replay", requireAdmin(rp.handleReplay))
+		log.Printf("Replay sink: %s (concurrency %d, %d retries, stop after %d failures)", sinkURL, rp.concurrency, rp.maxRetries, rp.maxFailures)
+	}
 
 	port := getEnv("PORT", "8082")
-	log.Printf("Events service starting on port %s", port)
-	log.Printf("Connecting to Kafka brokers at %s", kafkaBrokers)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	srv, err := newHTTPServer(":" + port)
+	if err != nil {
+		log.Fatalf("Invalid %v", err)
 	}
+	logStartupSummary(srv.Addr, enabledFeatures(consumerCfg, len(pipelines) > 0, maxMessages > 0 || consumerIdle > 0))
+	go func() {
+		err := listenAndServe(srv, func(addr net.Addr) {
+			log.Printf("[READY] Events service listening on %s", addr)
+		})
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
 
+	var grpcHealthServer *grpcHealth
+	if grpcHealthPort := getEnv("GRPC_HEALTH_PORT", ""); grpcHealthPort != "" {
+		intervalMS, err := strconv.Atoi(getEnv("GRPC_HEALTH_INTERVAL_MS", "5000"))
+		if err != nil || intervalMS <= 0 {
+			log.Fatalf("Invalid GRPC_HEALTH_INTERVAL_MS: must be a positive integer")
+		}
+		ready := func() bool { return isReady(ctx) }
+		if grpcHealthServer, err = startGRPCHealth(ctx, ":"+grpcHealthPort, time.Duration(intervalMS)*time.Millisecond, ready); err != nil {
+			log.Fatalf("Failed to start gRPC health server: %v", err)
+		}
+		log.Printf("gRPC health service listening on port %s", grpcHealthPort)
+	}
+
+	<-ctx.Done()
+	log.Printf("Shutting down events service")
+	if grpcHealthServer != nil {
+		grpcHealthServer.stop()
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+	if buffer != nil {
+		drainCtx, cancel := context.WithTimeout(context.Background(), time.Duration(drainTimeoutSeconds)*time.Second)
+		buffer.drain(drainCtx)
+		cancel()
+	}
+
+	// Consumers see the cancelled context, finish the message in hand and
+	// commit it before returning.
 	wg.Wait()
 }
 
@@ -88,77 +526,262 @@ func handleEvent(topic string) http.HandlerFunc {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		// An unusable envelope version is rejected before producing, so the
+		// client never sees an error for an event that was written.
+		if _, err := responseVersion(r); err != nil {
+			writeProduceError(w, r, &ProduceError{Category: CategoryValidation, Code: "unsupported_version", Message: err.Error()})
+			return
+		}
 
-		var eventData interface{}
-		switch topic {
-		case movieTopic:
-			eventData = &MovieEvent{}
-		case userTopic:
-			eventData = &UserEvent{}
-		case paymentTopic:
-			eventData = &PaymentEvent{}
-		default:
-			http.Error(w, "Unknown event type", http.StatusBadRequest)
+		release, ok := produceLimits.acquire(topic)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(produceLimits.retryAfter))
+			writeProduceError(w, r, &ProduceError{Category: CategoryTransient, Code: "topic_busy", Message: fmt.Sprintf("Too many concurrent produces to %s", topicName(topic)), Status: http.StatusServiceUnavailable})
 			return
 		}
+		defer release()
 
-		if err := json.NewDecoder(r.Body).Decode(eventData); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if !acceptsContentType(r) {
+			writeProduceError(w, r, &ProduceError{Category: CategoryValidation, Code: "unsupported_media_type", Message: "Content-Type must be application/json", Status: http.StatusUnsupportedMediaType})
+			return
+		}
+		ttl, err := requestTTL(r)
+		if err != nil {
+			writeProduceError(w, r, &ProduceError{Category: CategoryValidation, Code: "invalid_ttl", Message: err.Error()})
+			return
+		}
+		deliverAt, err := requestDeliverAt(r)
+		if err == nil && !deliverAt.IsZero() && scheduler == nil {
+			err = errors.New("scheduled events are disabled (SCHEDULE_MAX_PENDING=0)")
+		}
+		if err != nil {
+			writeProduceError(w, r, &ProduceError{Category: CategoryValidation, Code: "invalid_deliver_at", Message: err.Error()})
+			return
+		}
+		eventData, err := decodeEvent(topic, r.Body)
+		if err != nil {
+			writeProduceError(w, r, &ProduceError{Category: CategoryValidation, Code: "invalid_body", Message: err.Error()})
+			return
+		}
+		if violations := eventData.Validate(); len(violations) > 0 {
+			recordViolations(topic, violations)
+			writeViolations(w, r, violations)
+			return
+		}
+		rule, err := applyFilters(topic, eventData)
+		if err != nil {
+			writeProduceError(w, r, &ProduceError{Category: CategoryFatal, Code: "filter_failed", Message: "Failed to apply filter rules", Err: err})
+			return
+		}
+		if rule != nil && rule.Action == "drop" {
+			log.Printf("Dropped event for topic %s from %s: matched filter rule %s", topicName(topic), ClientIP(r), rule.Name)
+			writeProduced(w, r, produceDropped, map[string]interface{}{"status": "success", "dropped": true})
 			return
 		}
 
-		eventBytes, err := json.Marshal(eventData)
+		msg, err := newEventMessage(r, topic, eventData, rule, ttl)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Printf("Failed to encode event for topic %s: %v", topicName(topic), err)
+			writeProduceError(w, r, &ProduceError{Category: CategoryFatal, Code: "encode_failed", Message: err.Error()})
+			return
+		}
+		eventBytes := msg.Value
+		if size := messageSize(msg); size > maxMessageBytes {
+			writeProduceError(w, r, &ProduceError{
+				Category: CategoryValidation,
+				Code:     "event_too_large",
+				Message:  fmt.Sprintf("Event too large: %d bytes exceeds the %d byte limit", size, maxMessageBytes),
+				Status:   http.StatusRequestEntityTooLarge,
+			})
 			return
 		}
 
-		err = writer.WriteMessages(context.Background(), kafka.Message{
-			Topic: topic,
-			Value: eventBytes,
-		})
+		if deliverAt.After(time.Now()) {
+			id, err := scheduler.schedule(topic, msg, deliverAt)
+			if err != nil {
+				writeProduceError(w, r, &ProduceError{Category: CategoryTransient, Code: "schedule_full", Message: err.Error(), Status: http.StatusServiceUnavailable})
+				return
+			}
+			log.Printf("Scheduled event %s for topic %s at %s", id, topicName(topic), deliverAt.Format(time.RFC3339))
+			writeProduced(w, r, produceScheduled, map[string]interface{}{"status": "scheduled", "id": id, "deliver_at": deliverAt})
+			return
+		}
 
-		if err != nil {
-			log.Printf("Failed to write message to Kafka: %v", err)
-			http.Error(w, "Failed to write message to Kafka", http.StatusInternalServerError)
+		if buffer != nil {
+			if !buffer.enqueue(topic, msg) {
+				writeProduceError(w, r, &ProduceError{Category: CategoryTransient, Code: "buffer_full", Message: "Produce buffer is full"})
+				return
+			}
+			writeProduced(w, r, produceBuffered, map[string]interface{}{"status": "accepted"})
 			return
 		}
 
-		log.Printf("Successfully produced message to topic %s: %s", topic, string(eventBytes))
+		produced, located, perr := produceMessage(r.Context(), topic, msg)
+		if perr != nil && produceDLQ.accept(topic, msg, perr) {
+			writeProduced(w, r, produceDeferred, map[string]interface{}{"status": "deferred", "code": perr.Code})
+			return
+		}
+		if perr != nil {
+			log.Printf("Failed to write message to Kafka (%s): %v", perr.Code, perr)
+			writeProduceError(w, r, perr)
+			return
+		}
+
+		if codec.enabled(topic) {
+			log.Printf("Successfully produced Avro message to topic %s from %s (%d bytes)", topicName(topic), ClientIP(r), len(eventBytes))
+		} else if valueFormat == formatProtobuf {
+			log.Printf("Successfully produced protobuf message to topic %s from %s (%d bytes)", topicName(topic), ClientIP(r), len(eventBytes))
+		} else {
+			log.Printf("Successfully produced message to topic %s from %s: %s", topicName(topic), ClientIP(r), string(eventBytes))
+		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+		resp := map[string]interface{}{"status": "success"}
+		if located {
+			w.Header().Set("X-Kafka-Partition", strconv.Itoa(produced.Partition))
+			w.Header().Set("X-Kafka-Offset", strconv.FormatInt(produced.Offset, 10))
+			resp["partition"] = produced.Partition
+			resp["offset"] = produced.Offset
+		}
+		writeProduced(w, r, produceCommitted, resp)
 	}
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]bool{"status": true})
+// Retries of a synchronous produce, from PRODUCE_RETRY_ATTEMPTS and
+// PRODUCE_RETRY_BACKOFF_MS.
+var (
+	produceAttempts     = 3
+	produceRetryBackoff = 100 * time.Millisecond
+)
+
+// produceMessage writes msg synchronously, creating a missing topic first
+// when KAFKA_AUTO_CREATE_TOPICS allows it, and reports where it landed.
+// Transient and timeout failures are retried with exponential backoff until
+// produceAttempts is used up or the client goes away.
+func produceMessage(ctx context.Context, topic string, msg kafka.Message) (kafka.Message, bool, *ProduceError) {
+	delivered := deliveries.track(msg)
+	w := writerFor(topic)
+	var perr *ProduceError
+	backoff := produceRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := w.WriteMessages(ctx, msg)
+		if isUnknownTopic(err) && autoCreateTopics {
+			if cerr := createMissingTopic(ctx, adminClient, msg.Topic); cerr != nil {
+				log.Printf("Failed to auto-create topic %s: %v", msg.Topic, cerr)
+			} else {
+				log.Printf("Auto-created missing topic %s", msg.Topic)
+				err = w.WriteMessages(ctx, msg)
+			}
+		}
+		if err == nil {
+			perr = nil
+			break
+		}
+		perr = classifyWriteError(msg.Topic, err)
+		if !perr.Category.retryable() || attempt >= produceAttempts {
+			break
+		}
+		log.Printf("Produce to %s failed (%s), retrying in %s (attempt %d of %d)", msg.Topic, perr.Code, backoff, attempt, produceAttempts)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+			backoff *= 2
+			continue
+		}
+		break
+	}
+	produced, located := delivered()
+	if perr != nil {
+		return kafka.Message{}, false, perr
+	}
+	return produced, located && acknowledged(w), nil
 }
 
-func consume(ctx context.Context, topic string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
-	brokers := strings.Split(kafkaBrokers, ",")
-
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  brokers,
-		Topic:    topic,
-		GroupID:  "cinemaabyss-events-consumer-group",
-		MinBytes: 10e3,
-		MaxBytes: 10e6,
-	})
-	defer r.Close()
+// newEventMessage encodes a validated event into the message to produce,
+// with its key and headers. rule is the filter rule that flagged it, if any,
+// and a positive ttl stamps an expires-at header.
+func newEventMessage(r *http.Request, topic string, event Event, rule *filterRule, ttl time.Duration) (kafka.Message, error) {
+	var (
+		value []byte
+		err   error
+	)
+	protobuf := !codec.enabled(topic) && valueFormat == formatProtobuf
+	switch {
+	case codec.enabled(topic):
+		// Enrichers only apply to JSON; Avro and protobuf payloads follow
+		// their schema.
+		value, err = codec.encode(r.Context(), topic, event)
+	case protobuf:
+		value, err = marshalProto(event)
+	default:
+		value, err = marshalEvent(r, event)
+	}
+	if err != nil {
+		return kafka.Message{}, err
+	}
 
-	log.Printf("Consumer started for topic %s", topic)
+	msg := kafka.Message{
+		Topic: topicName(topic),
+		Value: value,
+	}
+	if protobuf {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: valueFormatHeader, Value: []byte(formatProtobuf)})
+	}
+	if movie, ok := event.(*MovieEvent); ok {
+		// Keyed by movie so movie-events can be compacted to the latest
+		// event per movie.
+		msg.Key = []byte(strconv.Itoa(movie.MovieID))
+	}
+	if rule != nil {
+		msg.Headers = append(msg.Headers, flagHeader(rule))
+	}
+	if id := r.Header.Get("X-Correlation-ID"); id != "" {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: "correlation-id", Value: []byte(id)})
+	}
+	if ttl > 0 {
+		msg.Headers = append(msg.Headers, expiryHeader(time.Now().Add(ttl)))
+	}
+	msg.Headers = append(msg.Headers, sourceHeaders...)
+	return msg, nil
+}
 
-	for {
-		m, err := r.ReadMessage(ctx)
-		if err != nil {
-			log.Printf("Error reading message from topic %s: %v", topic, err)
-			break
-		}
-		log.Printf("[CONSUMER] Received message from topic %s at offset %d: %s = %s\n", m.Topic, m.Offset, string(m.Key), string(m.Value))
+// handleValidate runs the produce path's decoding and validation for
+// ?type=movie|user|payment without writing anything to Kafka.
+func handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !acceptsContentType(r) {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	topic, ok := eventTypes[r.URL.Query().Get("type")]
+	if !ok {
+		http.Error(w, "Unknown event type", http.StatusBadRequest)
+		return
+	}
+
+	eventData, err := decodeEvent(topic, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-}
\ No newline at end of file
+	if violations := eventData.Validate(); len(violations) > 0 {
+		writeViolations(w, r, violations)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]bool{"valid": true})
+}
+
+func writeViolations(w http.ResponseWriter, r *http.Request, violations []Violation) {
+	writeJSON(w, r, http.StatusUnprocessableEntity, map[string]interface{}{
+		"valid":      false,
+		"status":     "error",
+		"code":       "validation_failed",
+		"category":   CategoryValidation.String(),
+		"retryable":  false,
+		"violations": violations,
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeReader hands out msgs in order and then fails with io.EOF, which ends
+// runConsumer, as does a cancelled ctx. Commits are recorded.
+type fakeReader struct {
+	mu        sync.Mutex
+	msgs      []kafka.Message
+	committed []kafka.Message
+}
+
+func (f *fakeReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return kafka.Message{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.msgs) == 0 {
+		return kafka.Message{}, io.EOF
+	}
+	m := f.msgs[0]
+	f.msgs = f.msgs[1:]
+	return m, nil
+}
+
+func (f *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	return f.ReadMessage(ctx)
+}
+
+func (f *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.committed = append(f.committed, msgs...)
+	return nil
+}
+
+func (f *fakeReader) Close() error { return nil }
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+}
+
+func jsonRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// No Kafka writer is set up in tests, so a handler that tried to produce
+// would panic rather than pass.
+func TestHandleValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		eventType  string
+		body       string
+		wantStatus int
+		wantFields []string
+	}{
+		{"valid movie", "movie", `{"movie_id": 1, "title": "Heat", "action": "viewed"}`, http.StatusOK, nil},
+		{"invalid movie", "movie", `{"movie_id": 0}`, http.StatusUnprocessableEntity, []string{"movie_id", "title", "action"}},
+		{"valid user", "user", `{"user_id": 7, "action": "login", "timestamp": "2024-01-01T00:00:00Z"}`, http.StatusOK, nil},
+		{"invalid payment", "payment", `{"payment_id": 1, "user_id": 1, "amount": -5, "status": "ok", "timestamp": "2024-01-01T00:00:00Z"}`, http.StatusUnprocessableEntity, []string{"amount"}},
+		{"malformed", "movie", `{"movie_id":`, http.StatusBadRequest, nil},
+		{"unknown type", "ticket", `{}`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(http.HandlerFunc(handleValidate), jsonRequest(http.MethodPost, "/api/events/validate?type="+tt.eventType, tt.body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code == http.StatusBadRequest {
+				return
+			}
+			var resp struct {
+				Valid      bool        `json:"valid"`
+				Violations []Violation `json:"violations"`
+			}
+			decodeJSON(t, rec, &resp)
+			if resp.Valid != (tt.wantFields == nil) {
+				t.Fatalf("valid = %v, want %v", resp.Valid, tt.wantFields == nil)
+			}
+			var fields []string
+			for _, v := range resp.Violations {
+				fields = append(fields, v.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Fatalf("violations on %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func (f *fakeReader) commits() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var offsets []int64
+	for _, m := range f.committed {
+		offsets = append(offsets, m.Offset)
+	}
+	return offsets
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// membersCacheTTL is how long a group description is reused.
+const membersCacheTTL = 5 * time.Second
+
+// groupDescriber is the subset of *kafka.Client used to inspect groups.
+type groupDescriber interface {
+	DescribeGroups(ctx context.Context, req *kafka.DescribeGroupsRequest) (*kafka.DescribeGroupsResponse, error)
+}
+
+type groupMember struct {
+	MemberID   string           `json:"member_id"`
+	ClientID   string           `json:"client_id"`
+	ClientHost string           `json:"client_host"`
+	Partitions map[string][]int `json:"partitions"`
+}
+
+type groupMembership struct {
+	Group   string        `json:"group"`
+	State   string        `json:"state"`
+	Members []groupMember `json:"members"`
+}
+
+type cachedMembership struct {
+	membership groupMembership
+	at         time.Time
+}
+
+// groupInspector serves GET /api/events/consumer/members, describing the
+// service's consumer group, or another one with ?group=.
+type groupInspector struct {
+	admin groupDescriber
+	group string
+
+	mu    sync.Mutex
+	cache map[string]cachedMembership
+}
+
+func newGroupInspector(admin groupDescriber, group string) *groupInspector {
+	return &groupInspector{admin: admin, group: group, cache: make(map[string]cachedMembership)}
+}
+
+func (g *groupInspector) describe(ctx context.Context, group string) (groupMembership, error) {
+	resp, err := g.admin.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{GroupIDs: []string{group}})
+	if err != nil {
+		return groupMembership{}, err
+	}
+	if len(resp.Groups) != 1 {
+		return groupMembership{}, fmt.Errorf("expected 1 group in response, got %d", len(resp.Groups))
+	}
+	desc := resp.Groups[0]
+	if desc.Error != nil {
+		return groupMembership{}, desc.Error
+	}
+
+	out := groupMembership{Group: desc.GroupID, State: desc.GroupState, Members: []groupMember{}}
+	for _, m := range desc.Members {
+		member := groupMember{MemberID: m.MemberID, ClientID: m.ClientID, ClientHost: m.ClientHost, Partitions: make(map[string][]int)}
+		for _, t := range m.MemberAssignments.Topics {
+			parts := append([]int(nil), t.Partitions...)
+			sort.Ints(parts)
+			member.Partitions[t.Topic] = parts
+		}
+		out.Members = append(out.Members, member)
+	}
+	sort.Slice(out.Members, func(i, j int) bool { return out.Members[i].MemberID < out.Members[j].MemberID })
+	return out, nil
+}
+
+func (g *groupInspector) current(ctx context.Context, group string) (groupMembership, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.cache[group]; ok && time.Since(c.at) < membersCacheTTL {
+		return c.membership, nil
+	}
+	membership, err := g.describe(ctx, group)
+	if err != nil {
+		return groupMembership{}, err
+	}
+	g.cache[group] = cachedMembership{membership: membership, at: time.Now()}
+	return membership, nil
+}
+
+func (g *groupInspector) handleMembers(w http.ResponseWriter, r *http.Request) {
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		group = g.group
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	membership, err := g.current(ctx, group)
+	if err != nil {
+		log.Printf("Failed to describe consumer group %s: %v", group, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, membership)
+}
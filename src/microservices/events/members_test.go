@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// mockGroups answers DescribeGroups with groups keyed by group ID.
+type mockGroups struct {
+	groups map[string]kafka.DescribeGroupsResponseGroup
+	err    error
+	calls  int
+}
+
+func (m *mockGroups) DescribeGroups(ctx context.Context, req *kafka.DescribeGroupsRequest) (*kafka.DescribeGroupsResponse, error) {
+	m.calls++
+	if m.err != nil {
+		return nil, m.err
+	}
+	resp := &kafka.DescribeGroupsResponse{}
+	for _, id := range req.GroupIDs {
+		if g, ok := m.groups[id]; ok {
+			resp.Groups = append(resp.Groups, g)
+		}
+	}
+	return resp, nil
+}
+
+func member(id, host string, topics map[string][]int) kafka.DescribeGroupsResponseMember {
+	m := kafka.DescribeGroupsResponseMember{MemberID: id, ClientID: "events-service", ClientHost: host}
+	for topic, parts := range topics {
+		m.MemberAssignments.Topics = append(m.MemberAssignments.Topics, kafka.GroupMemberTopic{Topic: topic, Partitions: parts})
+	}
+	return m
+}
+
+func TestHandleMembers(t *testing.T) {
+	groups := map[string]kafka.DescribeGroupsResponseGroup{
+		"events-service": {GroupID: "events-service", GroupState: "Stable", Members: []kafka.DescribeGroupsResponseMember{
+			member("pod-b", "/10.0.0.2", map[string][]int{movieTopic: {2, 0}}),
+			member("pod-a", "/10.0.0.1", map[string][]int{movieTopic: {1}, userTopic: {0}}),
+		}},
+		"rebalancing": {GroupID: "rebalancing", GroupState: "PreparingRebalance"},
+		"broken":      {GroupID: "broken", Error: kafka.GroupAuthorizationFailed},
+	}
+	tests := []struct {
+		name       string
+		token      string
+		query      string
+		err        error
+		wantStatus int
+		want       groupMembership
+	}{
+		{"service group", "secret", "", nil, http.StatusOK, groupMembership{Group: "events-service", State: "Stable", Members: []groupMember{
+			{MemberID: "pod-a", ClientID: "events-service", ClientHost: "/10.0.0.1", Partitions: map[string][]int{movieTopic: {1}, userTopic: {0}}},
+			{MemberID: "pod-b", ClientID: "events-service", ClientHost: "/10.0.0.2", Partitions: map[string][]int{movieTopic: {0, 2}}},
+		}}},
+		{"rebalancing without members", "secret", "?group=rebalancing", nil, http.StatusOK, groupMembership{Group: "rebalancing", State: "PreparingRebalance", Members: []groupMember{}}},
+		{"group error", "secret", "?group=broken", nil, http.StatusServiceUnavailable, groupMembership{}},
+		{"unknown group", "secret", "?group=missing", nil, http.StatusServiceUnavailable, groupMembership{}},
+		{"broker down", "secret", "", errors.New("dial tcp: connection refused"), http.StatusServiceUnavailable, groupMembership{}},
+		{"wrong token", "guess", "", nil, http.StatusUnauthorized, groupMembership{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prevToken := adminToken
+			adminToken = "secret"
+			defer func() { adminToken = prevToken }()
+
+			admin := &mockGroups{groups: groups, err: tt.err}
+			h := requireAdmin(newGroupInspector(admin, "events-service").handleMembers)
+			r := httptest.NewRequest(http.MethodGet, "/api/events/consumer/members"+tt.query, nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := serve(h, r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got groupMembership
+			decodeJSON(t, rec, &got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("membership = %+v\n want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupInspectorCache(t *testing.T) {
+	admin := &mockGroups{groups: map[string]kafka.DescribeGroupsResponseGroup{
+		"a": {GroupID: "a", GroupState: "Stable"},
+		"b": {GroupID: "b", GroupState: "Empty"},
+	}}
+	g := newGroupInspector(admin, "a")
+	for _, group := range []string{"a", "a", "b", "a", "b"} {
+		if _, err := g.current(context.Background(), group); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if admin.calls != 2 {
+		t.Fatalf("described %d times, want once per group", admin.calls)
+	}
+
+	// Failures are not cached.
+	admin.err = errors.New("broker down")
+	for i := 0; i < 2; i++ {
+		if _, err := g.current(context.Background(), "c"); err == nil {
+			t.Fatal("want an error")
+		}
+	}
+	if admin.calls != 4 {
+		t.Fatalf("described %d times, want failures retried", admin.calls)
+	}
+}
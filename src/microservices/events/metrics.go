@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var validationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "event_validation_failures_total",
+	Help: "Produce requests rejected by validation, by topic and failing field and rule.",
+}, []string{"topic", "field", "rule"})
+
+func recordViolations(topic string, violations []Violation) {
+	for _, v := range violations {
+		validationFailures.WithLabelValues(topic, v.Field, v.Rule).Inc()
+	}
+}
+
+var messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "kafka_messages_consumed_total",
+	Help: "Messages consumed, by topic and the compression codec of the record batch they arrived in; unknown for partition readers, which cannot see the batch.",
+}, []string{"topic", "codec"})
+
+var newerSchemaVersions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "kafka_messages_newer_schema_total",
+	Help: "Consumed messages whose schema_version is newer than the consumer supports, by topic.",
+}, []string{"topic"})
+
+var eventsFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_filtered_total",
+	Help: "Produce requests matched by a filter rule, by topic, rule and action.",
+}, []string{"topic", "rule", "action"})
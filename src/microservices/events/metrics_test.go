@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValidationFailureMetric(t *testing.T) {
+	type labels struct{ field, rule string }
+	tests := []struct {
+		name  string
+		path  string
+		topic string
+		body  string
+		want  []labels
+	}{
+		{"missing title and action", "/api/events/movie", movieTopic, `{"movie_id": 1}`, []labels{{"title", "required"}, {"action", "required"}}},
+		{"non-positive id", "/api/events/movie", movieTopic, `{"movie_id": -1, "title": "Heat", "action": "viewed"}`, []labels{{"movie_id", "positive"}}},
+		{"negative amount", "/api/events/payment", paymentTopic, `{"payment_id": 1, "user_id": 1, "amount": -1, "status": "ok", "timestamp": "2024-01-01T00:00:00Z"}`, []labels{{"amount", "non_negative"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := make([]float64, len(tt.want))
+			for i, l := range tt.want {
+				before[i] = testutil.ToFloat64(validationFailures.WithLabelValues(tt.topic, l.field, l.rule))
+			}
+			rec := serve(handleEvent(tt.topic), jsonRequest(http.MethodPost, tt.path, tt.body))
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want 422: %s", rec.Code, rec.Body.String())
+			}
+			for i, l := range tt.want {
+				if got := testutil.ToFloat64(validationFailures.WithLabelValues(tt.topic, l.field, l.rule)) - before[i]; got != 1 {
+					t.Errorf("event_validation_failures_total{field=%q,rule=%q} grew by %v, want 1", l.field, l.rule, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+func TestServedOpenAPISpec(t *testing.T) {
+	rec := serve(http.HandlerFunc(handleOpenAPI), jsonRequest(http.MethodGet, "/api/events/openapi.json", ""))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, Content-Type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !bytes.Equal(rec.Body.Bytes(), openAPISpec) {
+		t.Fatal("served document differs from the embedded openapi.json")
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf("served spec is invalid: %v", err)
+	}
+	for _, path := range []string{"/api/events/movie", "/api/events/user", "/api/events/payment", "/api/events/validate", "/api/events/health"} {
+		if doc.Paths.Value(path) == nil {
+			t.Errorf("spec does not describe %s", path)
+		}
+	}
+}
+
+func TestOpenAPIValidation(t *testing.T) {
+	doc, err := loadOpenAPI(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &openAPIValidator{doc: doc}
+	tests := []struct {
+		name        string
+		path        string
+		method      string
+		target      string
+		body        string
+		wantHandled bool
+	}{
+		{"valid movie event", "/api/events/movie", http.MethodPost, "/api/events/movie?ttl_seconds=60", `{"movie_id": 1, "title": "Heat", "action": "viewed"}`, true},
+		{"movie_id of the wrong type", "/api/events/movie", http.MethodPost, "/api/events/movie", `{"movie_id": "one", "title": "Heat", "action": "viewed"}`, false},
+		{"ttl below the minimum", "/api/events/movie", http.MethodPost, "/api/events/movie?ttl_seconds=0", `{"movie_id": 1}`, false},
+		{"timestamp not a date-time", "/api/events/payment", http.MethodPost, "/api/events/payment", `{"payment_id": 1, "timestamp": "yesterday"}`, false},
+		{"missing body", "/api/events/movie", http.MethodPost, "/api/events/movie", "", false},
+		{"validate with an unknown type", "/api/events/validate", http.MethodPost, "/api/events/validate?type=rating", `{}`, false},
+		{"validate with a known type", "/api/events/validate", http.MethodPost, "/api/events/validate?type=user", `{}`, true},
+		{"undescribed method left to the handler", "/api/events/movie", http.MethodGet, "/api/events/movie", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := false
+			h := v.wrap(tt.path, func(w http.ResponseWriter, r *http.Request) { handled = true })
+			rec := serve(h, jsonRequest(tt.method, tt.target, tt.body))
+			if handled != tt.wantHandled {
+				t.Fatalf("handled = %v, want %v (status %d: %s)", handled, tt.wantHandled, rec.Code, rec.Body.String())
+			}
+			if tt.wantHandled {
+				return
+			}
+			var resp struct{ Code, Category string }
+			decodeJSON(t, rec, &resp)
+			if rec.Code != http.StatusBadRequest || resp.Code != "openapi_violation" || resp.Category != "validation" {
+				t.Errorf("rejected with %d %+v, want 400 openapi_violation", rec.Code, resp)
+			}
+		})
+	}
+}
+
+func TestOpenAPIValidatorDisabled(t *testing.T) {
+	var v *openAPIValidator
+	handled := false
+	serve(v.wrap("/api/events/not-in-the-spec", func(w http.ResponseWriter, r *http.Request) { handled = true }), jsonRequest(http.MethodPost, "/api/events/movie", `{"movie_id": "one"}`))
+	if !handled {
+		t.Error("request rejected with validation disabled")
+	}
+}
@@ -0,0 +1,224 @@
+// Package eventsclient produces events through the events service HTTP API.
+//
+//	c, err := eventsclient.New("http://events-service:8082")
+//	res, err := c.ProduceMovie(ctx, eventsclient.MovieEvent{MovieID: 1, Action: "viewed"})
+package eventsclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CorrelationHeader carries the correlation ID of every produce request.
+const CorrelationHeader = "X-Correlation-ID"
+
+// Client posts events to one events service. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient replaces the default HTTP client, for example to set a
+// custom transport. It overrides WithTimeout if given after it.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithTimeout bounds each attempt. The default is 10 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.httpClient.Timeout = d }
+}
+
+// WithRetries sets how many times a failed attempt is retried and the delay
+// before the first retry, which doubles for every further one. The default
+// is 2 retries starting at 100ms; 0 disables retries.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = n, backoff }
+}
+
+// New returns a client for the events service at baseURL.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("eventsclient: invalid base URL %q", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		retries:    2,
+		backoff:    100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Result describes an accepted event.
+type Result struct {
+	// StatusCode is 201 when the event is in Kafka, 202 when the service
+	// buffered it and 200 when a filter rule dropped it.
+	StatusCode int
+	// Partition and Offset locate the event when the service reported them.
+	Partition *int
+	Offset    *int64
+	Dropped   bool
+	// CorrelationID is the ID sent with the request.
+	CorrelationID string
+}
+
+// Error is a response the service rejected. Violations is set for 422.
+type Error struct {
+	StatusCode int
+	Body       string
+	// Code and Category come from the service's error envelope, for example
+	// "kafka_unavailable" and "transient". They are empty for responses without
+	// one, such as errors from a proxy in front of the service.
+	Code       string
+	Category   string
+	Violations []Violation
+
+	retryable *bool
+}
+
+func (e *Error) Error() string {
+	if len(e.Violations) > 0 {
+		return fmt.Sprintf("eventsclient: %d: %d validation violations", e.StatusCode, len(e.Violations))
+	}
+	return fmt.Sprintf("eventsclient: %d: %s", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// Temporary reports whether retrying the request may succeed. The service's
+// own verdict is used when the response carried one.
+func (e *Error) Temporary() bool {
+	if e.retryable != nil {
+		return *e.retryable
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+type correlationKey struct{}
+
+// WithCorrelationID makes requests produced with ctx carry id. Without it a
+// random ID is generated per call.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+func correlationID(ctx context.Context) string {
+	if id, ok := ctx.Value(correlationKey{}).(string); ok && id != "" {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ProduceMovie posts a movie event.
+func (c *Client) ProduceMovie(ctx context.Context, e MovieEvent) (*Result, error) {
+	return c.produce(ctx, "movie", e)
+}
+
+// ProduceUser posts a user event.
+func (c *Client) ProduceUser(ctx context.Context, e UserEvent) (*Result, error) {
+	return c.produce(ctx, "user", e)
+}
+
+// ProducePayment posts a payment event.
+func (c *Client) ProducePayment(ctx context.Context, e PaymentEvent) (*Result, error) {
+	return c.produce(ctx, "payment", e)
+}
+
+// produce retries transport errors, 429 and 5xx. A retry after a timeout can
+// produce the event twice; consumers should tolerate duplicates.
+func (c *Client) produce(ctx context.Context, kind string, event interface{}) (*Result, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("eventsclient: encode %s event: %w", kind, err)
+	}
+	endpoint := c.baseURL.JoinPath("/api/events", kind).String()
+	id := correlationID(ctx)
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		res, err := c.post(ctx, endpoint, id, body)
+		if err == nil {
+			return res, nil
+		}
+		var apiErr *Error
+		if (errors.As(err, &apiErr) && !apiErr.Temporary()) || attempt >= c.retries || ctx.Err() != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) post(ctx context.Context, endpoint, id string, body []byte) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(CorrelationHeader, id)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &Error{StatusCode: resp.StatusCode, Body: string(data)}
+		var envelope struct {
+			Code       string      `json:"code"`
+			Category   string      `json:"category"`
+			Retryable  *bool       `json:"retryable"`
+			Violations []Violation `json:"violations"`
+		}
+		if json.Unmarshal(data, &envelope) == nil {
+			apiErr.Code, apiErr.Category = envelope.Code, envelope.Category
+			apiErr.Violations, apiErr.retryable = envelope.Violations, envelope.Retryable
+		}
+		return nil, apiErr
+	}
+
+	var out struct {
+		Partition *int   `json:"partition"`
+		Offset    *int64 `json:"offset"`
+		Dropped   bool   `json:"dropped"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("eventsclient: decode response: %w", err)
+	}
+	return &Result{
+		StatusCode:    resp.StatusCode,
+		Partition:     out.Partition,
+		Offset:        out.Offset,
+		Dropped:       out.Dropped,
+		CorrelationID: id,
+	}, nil
+}
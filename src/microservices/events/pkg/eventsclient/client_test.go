@@ -0,0 +1,214 @@
+package eventsclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProduce(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		produce  func(context.Context, *Client) (*Result, error)
+		wantPath string
+		wantBody map[string]interface{}
+	}{
+		{
+			"movie",
+			func(ctx context.Context, c *Client) (*Result, error) {
+				return c.ProduceMovie(ctx, MovieEvent{MovieID: 1, Title: "Heat", Action: "viewed", UserID: 7})
+			},
+			"/api/events/movie",
+			map[string]interface{}{"movie_id": 1.0, "title": "Heat", "action": "viewed", "user_id": 7.0},
+		},
+		{
+			"user",
+			func(ctx context.Context, c *Client) (*Result, error) {
+				return c.ProduceUser(ctx, UserEvent{UserID: 7, Username: "ann", Action: "login", Timestamp: ts})
+			},
+			"/api/events/user",
+			map[string]interface{}{"user_id": 7.0, "username": "ann", "action": "login", "timestamp": "2024-01-01T12:00:00Z"},
+		},
+		{
+			"payment",
+			func(ctx context.Context, c *Client) (*Result, error) {
+				return c.ProducePayment(ctx, PaymentEvent{PaymentID: 3, UserID: 7, Amount: 9.5, Status: "completed", Timestamp: ts})
+			},
+			"/api/events/payment",
+			map[string]interface{}{"payment_id": 3.0, "user_id": 7.0, "amount": 9.5, "status": "completed", "timestamp": "2024-01-01T12:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath, gotMethod, gotType, gotID string
+			var gotBody map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath, gotMethod = r.URL.Path, r.Method
+				gotType, gotID = r.Header.Get("Content-Type"), r.Header.Get(CorrelationHeader)
+				json.NewDecoder(r.Body).Decode(&gotBody)
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, `{"status": "success", "partition": 2, "offset": 41}`)
+			}))
+			defer srv.Close()
+			c, err := New(srv.URL + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := tt.produce(WithCorrelationID(context.Background(), "corr-1"), c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotMethod != http.MethodPost || gotPath != tt.wantPath || gotType != "application/json" || gotID != "corr-1" {
+				t.Errorf("request = %s %s (Content-Type %q, correlation %q)", gotMethod, gotPath, gotType, gotID)
+			}
+			if len(gotBody) != len(tt.wantBody) {
+				t.Errorf("body = %v, want %v", gotBody, tt.wantBody)
+			}
+			for k, v := range tt.wantBody {
+				if gotBody[k] != v {
+					t.Errorf("body[%s] = %v, want %v", k, gotBody[k], v)
+				}
+			}
+			if res.StatusCode != http.StatusCreated || res.Partition == nil || *res.Partition != 2 || res.Offset == nil || *res.Offset != 41 || res.CorrelationID != "corr-1" {
+				t.Errorf("result = %+v", res)
+			}
+		})
+	}
+}
+
+type reply struct {
+	status int
+	body   string
+}
+
+func TestProduceErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		replies       []reply
+		retries       int
+		wantCalls     int32
+		wantStatus    int
+		wantCode      string
+		wantTemporary bool
+		wantViolation string
+		wantDropped   bool
+	}{
+		{"validation is not retried", []reply{{422, `{"code": "validation_failed", "category": "validation", "retryable": false, "violations": [{"field": "movie_id", "rule": "required", "message": "movie_id is required"}]}`}}, 2, 1, 422, "validation_failed", false, "movie_id", false},
+		{"transient error is retried until exhausted", []reply{{503, `{"code": "kafka_unavailable", "category": "transient", "retryable": true}`}}, 2, 3, 503, "kafka_unavailable", true, "", false},
+		{"service verdict beats the status code", []reply{{500, `{"code": "encode_failed", "category": "fatal", "retryable": false}`}}, 2, 1, 500, "encode_failed", false, "", false},
+		{"plain 429 is retried", []reply{{429, "slow down"}, {201, `{"status": "success"}`}}, 2, 2, 0, "", false, "", false},
+		{"plain 502 without envelope", []reply{{502, "bad gateway"}}, 1, 2, 502, "", true, "", false},
+		{"plain 400 is not retried", []reply{{400, "bad request"}}, 2, 1, 400, "", false, "", false},
+		{"dropped", []reply{{200, `{"status": "success", "dropped": true}`}}, 2, 1, 0, "", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			ids := make(chan string, 10)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1)) - 1
+				ids <- r.Header.Get(CorrelationHeader)
+				rep := tt.replies[min(n, len(tt.replies)-1)]
+				w.WriteHeader(rep.status)
+				io.WriteString(w, rep.body)
+			}))
+			defer srv.Close()
+			c, err := New(srv.URL, WithRetries(tt.retries, time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := c.ProduceMovie(context.Background(), MovieEvent{MovieID: 1, Action: "viewed"})
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", got, tt.wantCalls)
+			}
+			close(ids)
+			first := <-ids
+			for id := range ids {
+				if id != first || id == "" {
+					t.Errorf("retries sent correlation IDs %q and %q, want one non-empty ID", first, id)
+				}
+			}
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Dropped != tt.wantDropped {
+					t.Errorf("dropped = %v, want %v", res.Dropped, tt.wantDropped)
+				}
+				return
+			}
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want *Error", err)
+			}
+			if apiErr.StatusCode != tt.wantStatus || apiErr.Code != tt.wantCode || apiErr.Temporary() != tt.wantTemporary {
+				t.Errorf("error = %+v (temporary %v)", apiErr, apiErr.Temporary())
+			}
+			if tt.wantViolation != "" && (len(apiErr.Violations) != 1 || apiErr.Violations[0].Field != tt.wantViolation) {
+				t.Errorf("violations = %+v", apiErr.Violations)
+			}
+		})
+	}
+}
+
+func TestProduceTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	tests := []struct {
+		name      string
+		opts      []Option
+		ctx       func() (context.Context, context.CancelFunc)
+		wantCalls int32
+	}{
+		{"per-attempt timeout is retried", []Option{WithTimeout(20 * time.Millisecond), WithRetries(1, time.Millisecond)}, func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, 2},
+		{"context deadline stops retries", []Option{WithRetries(3, time.Millisecond)}, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			c, err := New(srv.URL, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			if _, err := c.ProduceUser(ctx, UserEvent{UserID: 1, Action: "login"}); err == nil {
+				t.Fatal("want a timeout error")
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidURL(t *testing.T) {
+	for _, u := range []string{"", "events-service:8082", "/api/events", "http://"} {
+		if _, err := New(u); err == nil {
+			t.Errorf("New(%q) succeeded, want an error", u)
+		}
+	}
+}
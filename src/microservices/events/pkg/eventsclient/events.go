@@ -0,0 +1,35 @@
+package eventsclient
+
+import "time"
+
+// MovieEvent is the payload of POST /api/events/movie.
+type MovieEvent struct {
+	MovieID int    `json:"movie_id"`
+	Title   string `json:"title"`
+	Action  string `json:"action"`
+	UserID  int    `json:"user_id"`
+}
+
+// UserEvent is the payload of POST /api/events/user.
+type UserEvent struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Action    string    `json:"action"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PaymentEvent is the payload of POST /api/events/payment.
+type PaymentEvent struct {
+	PaymentID int       `json:"payment_id"`
+	UserID    int       `json:"user_id"`
+	Amount    float64   `json:"amount"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Violation is one field the service rejected.
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// ErrorCategory tells a producer what to do about a failed produce.
+type ErrorCategory int
+
+const (
+	// CategoryValidation: the request itself is wrong; do not retry it.
+	CategoryValidation ErrorCategory = iota + 1
+	// CategoryTransient: Kafka or the service is temporarily unable to
+	// accept the event; retry with backoff.
+	CategoryTransient
+	// CategoryFatal: the service failed in a way retrying will not fix.
+	CategoryFatal
+	// CategoryTimeout: the write did not finish in time and may or may not
+	// have reached Kafka; retrying can produce a duplicate.
+	CategoryTimeout
+)
+
+func (c ErrorCategory) String() string {
+	switch c {
+	case CategoryValidation:
+		return "validation"
+	case CategoryTransient:
+		return "transient"
+	case CategoryTimeout:
+		return "timeout"
+	}
+	return "fatal"
+}
+
+func (c ErrorCategory) retryable() bool {
+	return c == CategoryTransient || c == CategoryTimeout
+}
+
+// ProduceError is a failed produce with the category and machine-readable
+// code returned to the caller. Status overrides the category's default
+// status when set.
+type ProduceError struct {
+	Category ErrorCategory
+	Code     string
+	Message  string
+	Status   int
+	Err      error
+}
+
+func (e *ProduceError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *ProduceError) Unwrap() error { return e.Err }
+
+func (e *ProduceError) status() int {
+	if e.Status != 0 {
+		return e.Status
+	}
+	switch e.Category {
+	case CategoryValidation:
+		return http.StatusBadRequest
+	case CategoryTransient:
+		return http.StatusServiceUnavailable
+	case CategoryTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// classifyWriteError categorizes an error from writing to Kafka. A
+// kafka.WriteErrors does not unwrap, so its first failure stands for it.
+func classifyWriteError(topic string, err error) *ProduceError {
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		for _, e := range writeErrs {
+			if e != nil {
+				err = e
+				break
+			}
+		}
+	}
+	var (
+		netErr  net.Error
+		tempErr interface{ Temporary() bool }
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return &ProduceError{Category: CategoryTimeout, Code: "kafka_timeout", Message: "Timed out writing to Kafka", Err: err}
+	case isUnknownTopic(err):
+		// A missing topic needs an operator, so retrying or deferring the
+		// event to the dead-letter file would only hide it.
+		return &ProduceError{Category: CategoryFatal, Code: "unknown_topic", Message: "Topic " + topic + " does not exist on the Kafka cluster", Status: http.StatusServiceUnavailable, Err: err}
+	case errors.Is(err, kafka.MessageSizeTooLarge):
+		return &ProduceError{Category: CategoryValidation, Code: "event_too_large", Message: "Event exceeds the broker's message size limit", Status: http.StatusRequestEntityTooLarge, Err: err}
+	case (errors.As(err, &tempErr) && tempErr.Temporary()) || errors.As(err, &netErr):
+		return &ProduceError{Category: CategoryTransient, Code: "kafka_unavailable", Message: "Kafka is temporarily unavailable", Err: err}
+	}
+	return &ProduceError{Category: CategoryFatal, Code: "kafka_write_failed", Message: "Failed to write message to Kafka", Err: err}
+}
+
+// writeProduceError answers with the error envelope shared by every failed
+// produce: {"status":"error","code":...,"category":...,"retryable":...}.
+func writeProduceError(w http.ResponseWriter, r *http.Request, e *ProduceError) {
+	writeJSON(w, r, e.status(), map[string]interface{}{
+		"status":    "error",
+		"code":      e.Code,
+		"category":  e.Category.String(),
+		"retryable": e.Category.retryable(),
+		"error":     e.Message,
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestClassifyWriteError(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantCategory ErrorCategory
+		wantCode     string
+		wantStatus   int
+	}{
+		{"deadline", fmt.Errorf("write: %w", context.DeadlineExceeded), CategoryTimeout, "kafka_timeout", http.StatusGatewayTimeout},
+		{"network timeout", &net.OpError{Op: "write", Net: "tcp", Err: os.ErrDeadlineExceeded}, CategoryTimeout, "kafka_timeout", http.StatusGatewayTimeout},
+		{"unknown topic", kafka.UnknownTopicOrPartition, CategoryFatal, "unknown_topic", http.StatusServiceUnavailable},
+		{"leader election", kafka.WriteErrors{nil, kafka.LeaderNotAvailable}, CategoryTransient, "kafka_unavailable", http.StatusServiceUnavailable},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, CategoryTransient, "kafka_unavailable", http.StatusServiceUnavailable},
+		{"message too large", kafka.MessageSizeTooLarge, CategoryValidation, "event_too_large", http.StatusRequestEntityTooLarge},
+		{"anything else", errors.New("broker rejected the record"), CategoryFatal, "kafka_write_failed", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perr := classifyWriteError(movieTopic, tt.err)
+			if perr.Category != tt.wantCategory || perr.Code != tt.wantCode {
+				t.Errorf("classified as %s/%s, want %s/%s", perr.Category, perr.Code, tt.wantCategory, tt.wantCode)
+			}
+			if got := perr.status(); got != tt.wantStatus {
+				t.Errorf("status = %d, want %d", got, tt.wantStatus)
+			}
+			// A kafka.WriteErrors does not unwrap, so its first failure is
+			// what the ProduceError wraps.
+			cause := tt.err
+			if writeErrs, ok := tt.err.(kafka.WriteErrors); ok {
+				cause = writeErrs[1]
+			}
+			if !errors.Is(perr, cause) {
+				t.Errorf("%v does not unwrap to %v", perr, cause)
+			}
+		})
+	}
+}
+
+func TestCategoryStatus(t *testing.T) {
+	tests := []struct {
+		category      ErrorCategory
+		wantStatus    int
+		wantRetryable bool
+	}{
+		{CategoryValidation, http.StatusBadRequest, false},
+		{CategoryTransient, http.StatusServiceUnavailable, true},
+		{CategoryFatal, http.StatusInternalServerError, false},
+		{CategoryTimeout, http.StatusGatewayTimeout, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.category.String(), func(t *testing.T) {
+			perr := &ProduceError{Category: tt.category}
+			if got := perr.status(); got != tt.wantStatus {
+				t.Errorf("status = %d, want %d", got, tt.wantStatus)
+			}
+			if got := tt.category.retryable(); got != tt.wantRetryable {
+				t.Errorf("retryable = %v, want %v", got, tt.wantRetryable)
+			}
+		})
+	}
+	if got := (&ProduceError{Category: CategoryValidation, Status: http.StatusUnsupportedMediaType}).status(); got != http.StatusUnsupportedMediaType {
+		t.Errorf("explicit status = %d, want 415", got)
+	}
+}
+
+func TestHandleEventErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		writeErr      error
+		wantStatus    int
+		wantCode      string
+		wantCategory  string
+		wantRetryable bool
+	}{
+		{"invalid body", `{"movie_id": "one"}`, nil, http.StatusBadRequest, "invalid_body", "validation", false},
+		{"kafka timeout", "", context.DeadlineExceeded, http.StatusGatewayTimeout, "kafka_timeout", "timeout", true},
+		{"kafka unavailable", "", kafka.LeaderNotAvailable, http.StatusServiceUnavailable, "kafka_unavailable", "transient", true},
+		{"kafka write failed", "", errors.New("broker rejected the record"), http.StatusInternalServerError, "kafka_write_failed", "fatal", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &stubWriter{}
+			if tt.writeErr != nil {
+				w.errs = []error{tt.writeErr}
+			}
+			prevWriters, prevDLQ, prevAttempts := topicWriters, produceDLQ, produceAttempts
+			topicWriters, produceDLQ, produceAttempts = map[string]eventWriter{movieTopic: w}, nil, 1
+			defer func() { topicWriters, produceDLQ, produceAttempts = prevWriters, prevDLQ, prevAttempts }()
+
+			body := tt.body
+			if body == "" {
+				body = `{"movie_id": 1, "title": "Heat", "action": "viewed"}`
+			}
+			rec := serve(handleEvent(movieTopic), jsonRequest(http.MethodPost, "/api/events/movie", body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			var resp struct {
+				Status    string `json:"status"`
+				Code      string `json:"code"`
+				Category  string `json:"category"`
+				Retryable bool   `json:"retryable"`
+				Error     string `json:"error"`
+			}
+			decodeJSON(t, rec, &resp)
+			if resp.Status != "error" || resp.Code != tt.wantCode || resp.Category != tt.wantCategory || resp.Retryable != tt.wantRetryable || resp.Error == "" {
+				t.Errorf("envelope = %+v, want code %s category %s retryable %v", resp, tt.wantCode, tt.wantCategory, tt.wantRetryable)
+			}
+		})
+	}
+}
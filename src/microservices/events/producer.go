@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+)
+
+// recordOverhead is a conservative estimate of the bytes Kafka adds around a
+// single record (batch header plus per-record varints and attributes).
+const recordOverhead = 70
+
+// maxMessageBytes mirrors the broker's max.message.bytes so oversized events
+// are rejected before the write is attempted.
+var maxMessageBytes = 1048576
+
+// messageSize estimates the on-the-wire size of m including key and headers.
+func messageSize(m kafka.Message) int {
+	size := recordOverhead + len(m.Key) + len(m.Value)
+	for _, h := range m.Headers {
+		size += len(h.Key) + len(h.Value)
+	}
+	return size
+}
+
+// producerSettings are the kafka.Writer options that can differ per topic.
+// Empty fields inherit from the defaults.
+type producerSettings struct {
+	Acks        string `json:"acks,omitempty"`
+	Compression string `json:"compression,omitempty"`
+	Balancer    string `json:"balancer,omitempty"`
+}
+
+// producerConfig is the PRODUCER_CONFIG_FILE format. Topics are keyed by
+// base topic name, before KAFKA_TOPIC_PREFIX is applied.
+type producerConfig struct {
+	Defaults producerSettings            `json:"defaults"`
+	Topics   map[string]producerSettings `json:"topics"`
+}
+
+func loadProducerConfig(path string) (*producerConfig, error) {
+	cfg := &producerConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for topic := range cfg.Topics {
+		if !isServiceTopic(topic) {
+			return nil, fmt.Errorf("unknown topic %q", topic)
+		}
+	}
+	return cfg, nil
+}
+
+func (s producerSettings) merge(override producerSettings) producerSettings {
+	if override.Acks != "" {
+		s.Acks = override.Acks
+	}
+	if override.Compression != "" {
+		s.Compression = override.Compression
+	}
+	if override.Balancer != "" {
+		s.Balancer = override.Balancer
+	}
+	return s
+}
+
+var requiredAcks = map[string]kafka.RequiredAcks{
+	"":     kafka.RequireNone,
+	"none": kafka.RequireNone,
+	"one":  kafka.RequireOne,
+	"all":  kafka.RequireAll,
+}
+
+var compressionCodecs = map[string]compress.Compression{
+	"":       compress.None,
+	"none":   compress.None,
+	"gzip":   compress.Gzip,
+	"snappy": compress.Snappy,
+	"lz4":    compress.Lz4,
+	"zstd":   compress.Zstd,
+}
+
+var balancers = map[string]func() kafka.Balancer{
+	"":            func() kafka.Balancer { return &kafka.LeastBytes{} },
+	"least_bytes": func() kafka.Balancer { return &kafka.LeastBytes{} },
+	"round_robin": func() kafka.Balancer { return &kafka.RoundRobin{} },
+	"hash":        func() kafka.Balancer { return &kafka.Hash{} },
+	"murmur2":     func() kafka.Balancer { return kafka.Murmur2Balancer{} },
+}
+
+func newWriter(brokers []string, s producerSettings) (*kafka.Writer, error) {
+	acks, ok := requiredAcks[s.Acks]
+	if !ok {
+		return nil, fmt.Errorf("acks must be none, one or all, got %q", s.Acks)
+	}
+	compression, ok := compressionCodecs[s.Compression]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q", s.Compression)
+	}
+	balancer, ok := balancers[s.Balancer]
+	if !ok {
+		return nil, fmt.Errorf("unknown balancer %q", s.Balancer)
+	}
+	return &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     balancer(),
+		RequiredAcks: acks,
+		Compression:  compression,
+		BatchBytes:   int64(maxMessageBytes),
+		Completion:   writeCompleted,
+	}, nil
+}
+
+// eventWriter is the part of *kafka.Writer the produce paths use.
+type eventWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// acknowledged reports whether writes through w wait for the broker. With
+// acks=none kafka-go completes the batch without a produce response, so the
+// delivered messages carry a zero partition and offset that must not be
+// reported.
+func acknowledged(w eventWriter) bool {
+	kw, ok := w.(*kafka.Writer)
+	return !ok || kw.RequiredAcks != kafka.RequireNone
+}
+
+// topicWriters holds the writers built for topics with overrides; every
+// other topic uses the default writer.
+var topicWriters = map[string]eventWriter{}
+
+func writerFor(base string) eventWriter {
+	if w, ok := topicWriters[base]; ok {
+		return w
+	}
+	return writer
+}
+
+// newWriters builds the default writer and one writer per overridden topic.
+func newWriters(brokers []string, cfg *producerConfig) error {
+	var err error
+	if writer, err = newWriter(brokers, cfg.Defaults); err != nil {
+		return fmt.Errorf("defaults: %w", err)
+	}
+	for topic, override := range cfg.Topics {
+		w, err := newWriter(brokers, cfg.Defaults.merge(override))
+		if err != nil {
+			return fmt.Errorf("topic %s: %w", topic, err)
+		}
+		topicWriters[topic] = w
+	}
+	return nil
+}
+
+func closeWriters() {
+	writer.Close()
+	for _, w := range topicWriters {
+		w.Close()
+	}
+}
+
+// isUnknownTopic reports whether a write failed because the topic does not
+// exist. kafka.WriteErrors does not unwrap, so its entries are checked one by
+// one.
+func isUnknownTopic(err error) bool {
+	if errors.Is(err, kafka.UnknownTopicOrPartition) {
+		return true
+	}
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		for _, e := range writeErrs {
+			if errors.Is(e, kafka.UnknownTopicOrPartition) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Settings for KAFKA_AUTO_CREATE_TOPICS, which recreates a missing topic on
+// the first produce that fails because of it.
+var (
+	autoCreateTopics      bool
+	autoCreatePartitions  = 1
+	autoCreateReplication = 1
+)
+
+func createMissingTopic(ctx context.Context, admin topicAdmin, topic string) error {
+	resp, err := admin.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: []kafka.TopicConfig{{
+		Topic:             topic,
+		NumPartitions:     autoCreatePartitions,
+		ReplicationFactor: autoCreateReplication,
+	}}})
+	if err == nil {
+		err = resp.Errors[topic]
+	}
+	if errors.Is(err, kafka.TopicAlreadyExists) {
+		return nil
+	}
+	return err
+}
+
+// deliveryTracker reports where a message written through a kafka.Writer
+// landed. WriteMessages does not return offsets; the writer's Completion hook
+// sees them, but on copies of the messages batched with other callers'. The
+// copies still share the original Value slice, so its first byte identifies
+// the caller's message.
+type deliveryTracker struct {
+	mu      sync.Mutex
+	waiting map[*byte]chan kafka.Message
+}
+
+var deliveries = &deliveryTracker{waiting: make(map[*byte]chan kafka.Message)}
+
+// track must be called before the write; the returned function yields the
+// delivered message after WriteMessages has returned successfully.
+func (t *deliveryTracker) track(m kafka.Message) (delivered func() (kafka.Message, bool)) {
+	if len(m.Value) == 0 {
+		return func() (kafka.Message, bool) { return kafka.Message{}, false }
+	}
+	key := &m.Value[0]
+	ch := make(chan kafka.Message, 1)
+	t.mu.Lock()
+	t.waiting[key] = ch
+	t.mu.Unlock()
+
+	return func() (kafka.Message, bool) {
+		t.mu.Lock()
+		delete(t.waiting, key)
+		t.mu.Unlock()
+		select {
+		case d := <-ch:
+			return d, true
+		default:
+			return kafka.Message{}, false
+		}
+	}
+}
+
+// writeCompleted is the Completion hook of every writer.
+func writeCompleted(msgs []kafka.Message, err error) {
+	deliveries.complete(msgs, err)
+	if err == nil {
+		now := time.Now()
+		for _, m := range msgs {
+			producedRate.add(m.Topic, 1, now)
+		}
+	}
+}
+
+func (t *deliveryTracker) complete(msgs []kafka.Message, err error) {
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, m := range msgs {
+		if len(m.Value) == 0 {
+			continue
+		}
+		if ch, ok := t.waiting[&m.Value[0]]; ok {
+			select {
+			case ch <- m:
+			default:
+			}
+		}
+	}
+}
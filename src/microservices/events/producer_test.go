@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+	"github.com/segmentio/kafka-go/protocol"
+	metadataAPI "github.com/segmentio/kafka-go/protocol/metadata"
+	produceAPI "github.com/segmentio/kafka-go/protocol/produce"
+)
+
+func TestMessageSize(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  kafka.Message
+		want int
+	}{
+		{"value only", kafka.Message{Value: []byte("12345")}, recordOverhead + 5},
+		{"key", kafka.Message{Key: []byte("42"), Value: []byte("12345")}, recordOverhead + 7},
+		{"headers", kafka.Message{Value: []byte("12345"), Headers: []kafka.Header{{Key: "correlation-id", Value: []byte("abc")}}}, recordOverhead + 5 + 14 + 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageSize(tt.msg); got != tt.want {
+				t.Fatalf("messageSize = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleEventRejectsOversized(t *testing.T) {
+	prev := maxMessageBytes
+	maxMessageBytes = 256
+	defer func() { maxMessageBytes = prev }()
+
+	tests := []struct {
+		name          string
+		title         string
+		correlationID string
+	}{
+		{"large value", strings.Repeat("x", 300), ""},
+		{"headers push it over", strings.Repeat("x", 100), strings.Repeat("c", 120)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := jsonRequest(http.MethodPost, "/api/events/movie", `{"movie_id": 1, "title": "`+tt.title+`", "action": "viewed"}`)
+			if tt.correlationID != "" {
+				r.Header.Set("X-Correlation-ID", tt.correlationID)
+			}
+			rec := serve(handleEvent(movieTopic), r)
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("status = %d, want 413: %s", rec.Code, rec.Body.String())
+			}
+			var resp struct {
+				Code string `json:"code"`
+			}
+			decodeJSON(t, rec, &resp)
+			if resp.Code != "event_too_large" {
+				t.Fatalf("code = %q, want event_too_large", resp.Code)
+			}
+		})
+	}
+}
+
+func TestTopicProducerSettings(t *testing.T) {
+	prevWriter, prevTopicWriters := writer, topicWriters
+	topicWriters = map[string]eventWriter{}
+	defer func() { writer, topicWriters = prevWriter, prevTopicWriters }()
+
+	cfg, err := loadProducerConfig("producer.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newWriters([]string{"localhost:9092"}, cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer closeWriters()
+
+	tests := []struct {
+		topic       string
+		acks        kafka.RequiredAcks
+		compression compress.Compression
+		balancer    kafka.Balancer
+	}{
+		{paymentTopic, kafka.RequireAll, compress.None, &kafka.Hash{}},
+		{movieTopic, kafka.RequireOne, compress.Snappy, &kafka.LeastBytes{}},
+		// user-events has no override and gets the defaults.
+		{userTopic, kafka.RequireOne, compress.None, &kafka.LeastBytes{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			w := writerFor(tt.topic).(*kafka.Writer)
+			if w.RequiredAcks != tt.acks || w.Compression != tt.compression {
+				t.Errorf("acks %v, compression %v; want %v, %v", w.RequiredAcks, w.Compression, tt.acks, tt.compression)
+			}
+			if got, want := fmt.Sprintf("%T", w.Balancer), fmt.Sprintf("%T", tt.balancer); got != want {
+				t.Errorf("balancer %s, want %s", got, want)
+			}
+		})
+	}
+	if writerFor(userTopic) != writer {
+		t.Error("topic without overrides did not use the default writer")
+	}
+}
+
+func TestProducerConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"unknown topic", `{"topics": {"ticket-events": {"acks": "all"}}}`},
+		{"unknown acks", `{"topics": {"payment-events": {"acks": "most"}}}`},
+		{"unknown compression", `{"defaults": {"compression": "brotli"}}`},
+		{"unknown balancer", `{"topics": {"movie-events": {"balancer": "random"}}}`},
+		{"malformed", `{"topics": `},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prevWriter, prevTopicWriters := writer, topicWriters
+			topicWriters = map[string]eventWriter{}
+			defer func() { writer, topicWriters = prevWriter, prevTopicWriters }()
+
+			path := filepath.Join(t.TempDir(), "producer.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := loadProducerConfig(path)
+			if err == nil {
+				err = newWriters([]string{"localhost:9092"}, cfg)
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+// stubWriter fails successive writes with errs, then succeeds.
+type stubWriter struct {
+	errs   []error
+	writes int
+}
+
+func (s *stubWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	s.writes++
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func (s *stubWriter) Close() error { return nil }
+
+func TestHandleEventUnknownTopic(t *testing.T) {
+	tests := []struct {
+		name        string
+		errs        []error
+		autoCreate  bool
+		wantStatus  int
+		wantCode    string
+		wantCreates int
+		wantWrites  int
+	}{
+		{"unknown topic", []error{kafka.UnknownTopicOrPartition}, false, http.StatusServiceUnavailable, "unknown_topic", 0, 1},
+		{"unknown topic in write errors", []error{kafka.WriteErrors{kafka.UnknownTopicOrPartition}}, false, http.StatusServiceUnavailable, "unknown_topic", 0, 1},
+		{"auto-created", []error{kafka.UnknownTopicOrPartition}, true, http.StatusCreated, "", 1, 2},
+		{"still missing after auto-create", []error{kafka.UnknownTopicOrPartition, kafka.UnknownTopicOrPartition}, true, http.StatusServiceUnavailable, "unknown_topic", 1, 2},
+		{"other errors are not mapped", []error{errors.New("boom")}, false, http.StatusInternalServerError, "kafka_write_failed", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubWriter{errs: tt.errs}
+			admin := &mockAdmin{}
+			prevWriters, prevClient, prevAuto, prevAttempts := topicWriters, adminClient, autoCreateTopics, produceAttempts
+			topicWriters = map[string]eventWriter{movieTopic: stub}
+			adminClient, autoCreateTopics, produceAttempts = admin, tt.autoCreate, 1
+			defer func() {
+				topicWriters, adminClient, autoCreateTopics, produceAttempts = prevWriters, prevClient, prevAuto, prevAttempts
+			}()
+
+			rec := serve(handleEvent(movieTopic), jsonRequest(http.MethodPost, "/api/events/movie", `{"movie_id": 1, "title": "Heat", "action": "viewed"}`))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" {
+				var resp struct {
+					Code      string `json:"code"`
+					Retryable bool   `json:"retryable"`
+					Error     string `json:"error"`
+				}
+				decodeJSON(t, rec, &resp)
+				if resp.Code != tt.wantCode {
+					t.Errorf("code = %q, want %q", resp.Code, tt.wantCode)
+				}
+				if tt.wantCode == "unknown_topic" && (resp.Retryable || !strings.Contains(resp.Error, "movie-events")) {
+					t.Errorf("response %+v should not be retryable and should name the topic", resp)
+				}
+			}
+			if len(admin.created) != tt.wantCreates {
+				t.Errorf("created %d topics, want %d", len(admin.created), tt.wantCreates)
+			}
+			if stub.writes != tt.wantWrites {
+				t.Errorf("wrote %d times, want %d", stub.writes, tt.wantWrites)
+			}
+		})
+	}
+}
+
+// completingWriter assigns offsets like a broker and reports them through
+// the writers' Completion hook, on copies of the messages as kafka.Writer does.
+type completingWriter struct {
+	mu        sync.Mutex
+	partition int
+	next      int64
+}
+
+func (c *completingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	c.mu.Lock()
+	done := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		m.Partition, m.Offset = c.partition, c.next
+		c.next++
+		done[i] = m
+	}
+	c.mu.Unlock()
+	writeCompleted(done, nil)
+	return nil
+}
+
+func (c *completingWriter) Close() error { return nil }
+
+func TestHandleEventReportsOffset(t *testing.T) {
+	tests := []struct {
+		name          string
+		writer        eventWriter
+		wantPartition string
+		wantOffset    string
+	}{
+		{"located", &completingWriter{partition: 2, next: 41}, "2", "41"},
+		{"writer without completion", &stubWriter{}, "", ""},
+		{"acks none", brokerWriter(t, "none"), "", ""},
+		{"acks one", brokerWriter(t, "one"), "2", "41"},
+		{"acks all", brokerWriter(t, "all"), "2", "41"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := topicWriters
+			topicWriters = map[string]eventWriter{movieTopic: tt.writer}
+			defer func() { topicWriters = prev }()
+
+			rec := serve(handleEvent(movieTopic), jsonRequest(http.MethodPost, "/api/events/movie", `{"movie_id": 1, "title": "Heat", "action": "viewed"}`))
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("X-Kafka-Partition"); got != tt.wantPartition {
+				t.Errorf("X-Kafka-Partition = %q, want %q", got, tt.wantPartition)
+			}
+			if got := rec.Header().Get("X-Kafka-Offset"); got != tt.wantOffset {
+				t.Errorf("X-Kafka-Offset = %q, want %q", got, tt.wantOffset)
+			}
+			var body struct {
+				Partition *int   `json:"partition"`
+				Offset    *int64 `json:"offset"`
+			}
+			decodeJSON(t, rec, &body)
+			if (body.Offset != nil) != (tt.wantOffset != "") {
+				t.Fatalf("body offset = %v, want %q", body.Offset, tt.wantOffset)
+			}
+			if body.Offset != nil && (fmt.Sprint(*body.Partition) != tt.wantPartition || fmt.Sprint(*body.Offset) != tt.wantOffset) {
+				t.Errorf("body = %d/%d, want %s/%s", *body.Partition, *body.Offset, tt.wantPartition, tt.wantOffset)
+			}
+		})
+	}
+}
+
+// brokerTransport answers a kafka.Writer's metadata and produce requests
+// with three partitions and a base offset of 41.
+type brokerTransport struct{}
+
+func (brokerTransport) RoundTrip(ctx context.Context, addr net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *metadataAPI.Request:
+		res := &metadataAPI.Response{Brokers: []metadataAPI.ResponseBroker{{NodeID: 1, Host: "localhost", Port: 9092}}}
+		for _, topic := range req.TopicNames {
+			t := metadataAPI.ResponseTopic{Name: topic}
+			for p := int32(0); p < 3; p++ {
+				t.Partitions = append(t.Partitions, metadataAPI.ResponsePartition{PartitionIndex: p, LeaderID: 1})
+			}
+			res.Topics = append(res.Topics, t)
+		}
+		return res, nil
+	case *produceAPI.Request:
+		res := &produceAPI.Response{}
+		for _, topic := range req.Topics {
+			rt := produceAPI.ResponseTopic{Topic: topic.Topic}
+			for _, p := range topic.Partitions {
+				rt.Partitions = append(rt.Partitions, produceAPI.ResponsePartition{Partition: p.Partition, BaseOffset: 41})
+			}
+			res.Topics = append(res.Topics, rt)
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("unexpected request %T", req)
+}
+
+// brokerWriter is a real kafka.Writer with the given acks that writes to
+// partition 2 through brokerTransport.
+func brokerWriter(t *testing.T, acks string) *kafka.Writer {
+	w, err := newWriter([]string{"localhost:9092"}, producerSettings{Acks: acks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Transport = brokerTransport{}
+	w.BatchTimeout = time.Millisecond
+	w.Balancer = kafka.BalancerFunc(func(msg kafka.Message, partitions ...int) int { return 2 })
+	return w
+}
+
+func TestHandleEventOffsetsPerRequest(t *testing.T) {
+	prev := topicWriters
+	topicWriters = map[string]eventWriter{movieTopic: &completingWriter{}}
+	defer func() { topicWriters = prev }()
+
+	const n = 20
+	var wg sync.WaitGroup
+	offsets := make(chan string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := serve(handleEvent(movieTopic), jsonRequest(http.MethodPost, "/api/events/movie", `{"movie_id": 1, "title": "Heat", "action": "viewed"}`))
+			offsets <- rec.Header().Get("X-Kafka-Offset")
+		}()
+	}
+	wg.Wait()
+	close(offsets)
+	seen := make(map[string]bool)
+	for o := range offsets {
+		if o == "" || seen[o] {
+			t.Fatalf("offset %q missing or reported to two requests", o)
+		}
+		seen[o] = true
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// valueFormatHeader names the encoding of a message value when it is not
+// self-describing. Only "protobuf" is set today.
+const valueFormatHeader = "value-format"
+
+const formatProtobuf = "protobuf"
+
+// valueFormat is KAFKA_VALUE_FORMAT: "json" (the default) or "protobuf".
+// Topics listed in AVRO_TOPICS are encoded with Avro either way.
+var valueFormat = "json"
+
+// The encoders below follow proto/events.proto field for field. Field
+// numbers there must not change once partners produce with them.
+
+func marshalProto(event Event) ([]byte, error) {
+	var b []byte
+	switch e := event.(type) {
+	case *MovieEvent:
+		b = appendVarintField(b, 1, int64(e.MovieID))
+		b = appendStringField(b, 2, e.Title)
+		b = appendStringField(b, 3, e.Action)
+		b = appendVarintField(b, 4, int64(e.UserID))
+	case *UserEvent:
+		b = appendVarintField(b, 1, int64(e.UserID))
+		b = appendStringField(b, 2, e.Username)
+		b = appendStringField(b, 3, e.Action)
+		b = appendTimestampField(b, 4, e.Timestamp)
+	case *PaymentEvent:
+		b = appendVarintField(b, 1, int64(e.PaymentID))
+		b = appendVarintField(b, 2, int64(e.UserID))
+		if e.Amount != 0 {
+			b = protowire.AppendTag(b, 3, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(e.Amount))
+		}
+		b = appendStringField(b, 4, e.Status)
+		b = appendTimestampField(b, 5, e.Timestamp)
+	default:
+		return nil, fmt.Errorf("no protobuf encoding for %T", event)
+	}
+	return b, nil
+}
+
+// Proto3 leaves fields at their zero value off the wire.
+
+func appendVarintField(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendStringField(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// appendTimestampField writes t as a google.protobuf.Timestamp.
+func appendTimestampField(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var ts []byte
+	ts = appendVarintField(ts, 1, t.Unix())
+	ts = appendVarintField(ts, 2, int64(t.Nanosecond()))
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, ts)
+}
+
+// protoField is one decoded field; only the member matching its wire type is
+// set.
+type protoField struct {
+	varint uint64
+	fixed  uint64
+	bytes  []byte
+}
+
+// parseProto splits a message into its fields. Unknown fields are kept so
+// the caller can ignore them, as protobuf readers do.
+func parseProto(b []byte) (map[protowire.Number]protoField, error) {
+	fields := make(map[protowire.Number]protoField)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		var f protoField
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.fixed, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			f.fixed = uint64(v)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		fields[num] = f
+	}
+	return fields, nil
+}
+
+func protoTimestamp(b []byte) (time.Time, error) {
+	if b == nil {
+		return time.Time{}, nil
+	}
+	fields, err := parseProto(b)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(fields[1].varint), int64(fields[2].varint)).UTC(), nil
+}
+
+// unmarshalProto decodes a protobuf value consumed from the base topic.
+func unmarshalProto(base string, b []byte) (Event, error) {
+	fields, err := parseProto(b)
+	if err != nil {
+		return nil, err
+	}
+	switch base {
+	case movieTopic:
+		return &MovieEvent{
+			MovieID: int(fields[1].varint),
+			Title:   string(fields[2].bytes),
+			Action:  string(fields[3].bytes),
+			UserID:  int(fields[4].varint),
+		}, nil
+	case userTopic:
+		ts, err := protoTimestamp(fields[4].bytes)
+		if err != nil {
+			return nil, fmt.Errorf("timestamp: %w", err)
+		}
+		return &UserEvent{
+			UserID:    int(fields[1].varint),
+			Username:  string(fields[2].bytes),
+			Action:    string(fields[3].bytes),
+			Timestamp: ts,
+		}, nil
+	case paymentTopic:
+		ts, err := protoTimestamp(fields[5].bytes)
+		if err != nil {
+			return nil, fmt.Errorf("timestamp: %w", err)
+		}
+		return &PaymentEvent{
+			PaymentID: int(fields[1].varint),
+			UserID:    int(fields[2].varint),
+			Amount:    math.Float64frombits(fields[3].fixed),
+			Status:    string(fields[4].bytes),
+			Timestamp: ts,
+		}, nil
+	}
+	return nil, errors.New("no protobuf schema for topic " + base)
+}
+
+func isProtobuf(m kafka.Message) bool {
+	for _, h := range m.Headers {
+		if h.Key == valueFormatHeader {
+			return string(h.Value) == formatProtobuf
+		}
+	}
+	return false
+}
+
+// messageValue returns m's value as event JSON whatever it was produced
+// with: protobuf values are decoded by topic and re-encoded, everything else
+// goes through plainValue.
+func messageValue(ctx context.Context, m kafka.Message) ([]byte, error) {
+	if !isProtobuf(m) {
+		return plainValue(ctx, m.Value)
+	}
+	event, err := unmarshalProto(strings.TrimPrefix(m.Topic, topicPrefix), m.Value)
+	if err != nil {
+		return nil, fmt.Errorf("decode protobuf payload: %w", err)
+	}
+	return json.Marshal(event)
+}
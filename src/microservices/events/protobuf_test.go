@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestProtobufRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	tests := []struct {
+		name   string
+		topic  string
+		format string
+		body   string
+		want   Event
+	}{
+		{"movie", movieTopic, formatProtobuf, `{"movie_id": 7, "title": "Heat", "action": "viewed", "user_id": 3}`, &MovieEvent{MovieID: 7, Title: "Heat", Action: "viewed", UserID: 3}},
+		{"user", userTopic, formatProtobuf, `{"user_id": 3, "username": "ann", "action": "login", "timestamp": "2024-03-01T12:30:00.123456789Z"}`, &UserEvent{UserID: 3, Username: "ann", Action: "login", Timestamp: ts}},
+		{"payment", paymentTopic, formatProtobuf, `{"payment_id": 1, "user_id": 3, "amount": 9.99, "status": "completed", "timestamp": "2024-03-01T12:30:00.123456789Z"}`, &PaymentEvent{PaymentID: 1, UserID: 3, Amount: 9.99, Status: "completed", Timestamp: ts}},
+		{"json stays the default", movieTopic, "json", `{"movie_id": 7, "title": "Heat", "action": "viewed", "user_id": 3}`, &MovieEvent{MovieID: 7, Title: "Heat", Action: "viewed", UserID: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &recordingWriter{}
+			prevWriters, prevFormat := topicWriters, valueFormat
+			topicWriters, valueFormat = map[string]eventWriter{tt.topic: w}, tt.format
+			defer func() { topicWriters, valueFormat = prevWriters, prevFormat }()
+
+			rec := serve(handleEvent(tt.topic), jsonRequest(http.MethodPost, "/api/events", tt.body))
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			m := w.written[0]
+			if got := header(m, valueFormatHeader); (got == formatProtobuf) != (tt.format == formatProtobuf) {
+				t.Fatalf("value-format header = %q with KAFKA_VALUE_FORMAT=%s", got, tt.format)
+			}
+			if tt.format == formatProtobuf && m.Value[0] == '{' {
+				t.Fatalf("value %q is JSON, want protobuf", m.Value)
+			}
+
+			// Consume it back as the consumers do.
+			m.Topic = tt.topic
+			value, err := messageValue(context.Background(), m)
+			if err != nil {
+				t.Fatalf("messageValue: %v", err)
+			}
+			got, _, err := decodeConsumed(m, value)
+			if err != nil {
+				t.Fatalf("decodeConsumed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("consumed %+v, want %+v", got, tt.want)
+			}
+			if err := handleMessage(context.Background(), m); err != nil {
+				t.Errorf("handleMessage: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalProto(t *testing.T) {
+	// A newer producer's extra field 9 and a zero-valued field left off the
+	// wire both decode as proto3 readers would.
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, 7)
+	b = protowire.AppendTag(b, 9, protowire.BytesType)
+	b = protowire.AppendString(b, "director")
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendString(b, "viewed")
+
+	tests := []struct {
+		name    string
+		topic   string
+		value   []byte
+		want    Event
+		wantErr bool
+	}{
+		{"unknown fields ignored", movieTopic, b, &MovieEvent{MovieID: 7, Action: "viewed"}, false},
+		{"empty message", paymentTopic, nil, &PaymentEvent{}, false},
+		{"truncated", movieTopic, b[:len(b)-2], nil, true},
+		{"unknown topic", "movie-stats", b, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unmarshalProto(tt.topic, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Without the header the value is not taken for protobuf.
+	if isProtobuf(kafka.Message{Value: b}) {
+		t.Error("message without value-format header treated as protobuf")
+	}
+}
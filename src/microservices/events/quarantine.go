@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+// dlqReason says why a message was quarantined. It is stored with the entry
+// and as the dlq-reason header of the quarantined message.
+type dlqReason string
+
+const (
+	reasonDeserialization  dlqReason = "deserialization"
+	reasonValidation       dlqReason = "validation"
+	reasonHandlerError     dlqReason = "handler_error"
+	reasonExpired          dlqReason = "expired"
+	reasonRetriesExhausted dlqReason = "retries_exhausted"
+)
+
+// Headers added to dead-lettered messages.
+const (
+	dlqReasonHeader = "dlq-reason"
+	dlqErrorHeader  = "dlq-error"
+)
+
+// Consumed messages that exhaust their handling are produced through
+// deadLetterWriter to the topic named after their source with
+// deadLetterSuffix, as well as kept in the quarantine store. deadLetterWriter
+// is nil when CONSUMER_DLQ_TOPIC_SUFFIX is empty.
+var (
+	deadLetterSuffix = ".dlq"
+	deadLetterWriter eventWriter
+)
+
+var dlqMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "dlq_messages_total",
+	Help: "Consumed messages quarantined, by topic and reason.",
+}, []string{"topic", "reason"})
+
+// dlqReasonFor classifies a handling failure. A message whose TTL ran out
+// while it was being retried is reported as expired rather than by its last
+// error; otherwise a failure that was retried is retries_exhausted and one
+// handled once, as under CONSUMER_MAX_ATTEMPTS=1, is handler_error.
+func dlqReasonFor(m kafka.Message, attempts int, cause error, now time.Time) dlqReason {
+	var (
+		decodeErr  deserializationError
+		invalidErr invalidEventError
+	)
+	switch {
+	case errors.As(cause, &decodeErr):
+		return reasonDeserialization
+	case errors.As(cause, &invalidErr):
+		return reasonValidation
+	case expired(m, now):
+		return reasonExpired
+	case attempts > 1:
+		return reasonRetriesExhausted
+	}
+	return reasonHandlerError
+}
+
+// withDLQReason returns headers with the dlq-reason header set to reason,
+// replacing the dlq headers left by an earlier quarantine.
+func withDLQReason(headers []kafka.Header, reason dlqReason) []kafka.Header {
+	out := make([]kafka.Header, 0, len(headers)+1)
+	for _, h := range headers {
+		if h.Key != dlqReasonHeader && h.Key != dlqErrorHeader {
+			out = append(out, h)
+		}
+	}
+	return append(out, kafka.Header{Key: dlqReasonHeader, Value: []byte(reason)})
+}
+
+// quarantineMessage dead-letters m, which still failed after attempts: it is
+// produced to its dead-letter topic and kept in the quarantine store, each
+// when configured, with the reason it failed. It returns an error when
+// neither took the message, so the consumer does not commit past it.
+func quarantineMessage(ctx context.Context, m kafka.Message, attempts int, cause error) error {
+	reason := dlqReasonFor(m, attempts, cause, time.Now())
+	if deadLetterWriter == nil && quarantine == nil {
+		return fmt.Errorf("no dead-letter topic or quarantine to take the %s message: %w", reason, cause)
+	}
+	if deadLetterWriter != nil {
+		dlq := kafka.Message{
+			Topic:   m.Topic + deadLetterSuffix,
+			Key:     m.Key,
+			Value:   m.Value,
+			Headers: append(withDLQReason(m.Headers, reason), kafka.Header{Key: dlqErrorHeader, Value: []byte(cause.Error())}),
+		}
+		if err := deadLetterWriter.WriteMessages(ctx, dlq); err != nil {
+			return fmt.Errorf("produce to dead-letter topic %s: %w", dlq.Topic, err)
+		}
+	}
+	quarantine.add(m, attempts, reason, cause)
+	dlqMessages.WithLabelValues(m.Topic, string(reason)).Inc()
+	log.Printf("[QUARANTINE] Message %s quarantined after %d attempts (%s): %v", quarantineID(m), attempts, reason, cause)
+	return nil
+}
+
+// quarantinedMessage is a consumed message that still failed after the
+// consumer's retries. Value is kept as raw bytes and appears base64 encoded
+// in JSON, since a poison payload is not necessarily valid UTF-8. Headers are
+// kept because some, like value-format, are needed to decode it on retry.
+type quarantinedMessage struct {
+	ID            string         `json:"id"`
+	Topic         string         `json:"topic"`
+	Partition     int            `json:"partition"`
+	Offset        int64          `json:"offset"`
+	Key           []byte         `json:"key,omitempty"`
+	Value         []byte         `json:"value"`
+	Headers       []kafka.Header `json:"headers,omitempty"`
+	Error         string         `json:"error"`
+	Reason        dlqReason      `json:"reason,omitempty"`
+	Attempts      int            `json:"attempts"`
+	QuarantinedAt time.Time      `json:"quarantined_at"`
+}
+
+func (q *quarantinedMessage) message() kafka.Message {
+	return kafka.Message{Topic: q.Topic, Partition: q.Partition, Offset: q.Offset, Key: q.Key, Value: q.Value, Headers: q.Headers}
+}
+
+// quarantineStore keeps the most recent quarantined messages in memory,
+// dropping the oldest once full. With a path set, the store is rewritten to
+// disk after every change and reloaded on startup.
+type quarantineStore struct {
+	mu      sync.Mutex
+	max     int
+	path    string
+	entries []*quarantinedMessage
+}
+
+// quarantine is nil when QUARANTINE_SIZE is 0.
+var quarantine *quarantineStore
+
+func newQuarantineStore(max int, path string) (*quarantineStore, error) {
+	s := &quarantineStore{max: max, path: path}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(s.entries) > max {
+		s.entries = s.entries[len(s.entries)-max:]
+	}
+	return s, nil
+}
+
+func quarantineID(m kafka.Message) string {
+	return fmt.Sprintf("%s-%d-%d", m.Topic, m.Partition, m.Offset)
+}
+
+// add records m. A message that is quarantined again, for example after a
+// redelivery, replaces its previous entry.
+func (s *quarantineStore) add(m kafka.Message, attempts int, reason dlqReason, cause error) {
+	if s == nil {
+		return
+	}
+	entry := &quarantinedMessage{
+		ID:            quarantineID(m),
+		Topic:         m.Topic,
+		Partition:     m.Partition,
+		Offset:        m.Offset,
+		Key:           m.Key,
+		Value:         m.Value,
+		Headers:       withDLQReason(m.Headers, reason),
+		Error:         cause.Error(),
+		Reason:        reason,
+		Attempts:      attempts,
+		QuarantinedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(entry.ID)
+	s.entries = append(s.entries, entry)
+	if len(s.entries) > s.max {
+		s.entries = s.entries[len(s.entries)-s.max:]
+	}
+	s.persistLocked()
+}
+
+func (s *quarantineStore) list() []quarantinedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]quarantinedMessage, len(s.entries))
+	for i, e := range s.entries {
+		out[i] = *e
+	}
+	return out
+}
+
+func (s *quarantineStore) get(id string) (quarantinedMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.ID == id {
+			return *e, true
+		}
+	}
+	return quarantinedMessage{}, false
+}
+
+// resolve records the outcome of a manual retry: a success removes the
+// entry, a failure keeps it with the new error.
+func (s *quarantineStore) resolve(id string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.removeLocked(id)
+	} else {
+		for _, e := range s.entries {
+			if e.ID == id {
+				e.Attempts++
+				e.Error = err.Error()
+			}
+		}
+	}
+	s.persistLocked()
+}
+
+func (s *quarantineStore) removeLocked(id string) {
+	for i, e := range s.entries {
+		if e.ID == id {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// persistLocked writes the store through a temporary file so a crash never
+// leaves a truncated file behind.
+func (s *quarantineStore) persistLocked() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.Marshal(s.entries)
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to persist quarantine to %s: %v", s.path, err)
+	}
+}
+
+func (s *quarantineStore) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, s.list())
+}
+
+// handleRetry runs the consumer's handler on a quarantined message again.
+func (s *quarantineStore) handleRetry(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	entry, ok := s.get(id)
+	if !ok {
+		http.Error(w, "Unknown quarantined message", http.StatusNotFound)
+		return
+	}
+
+	err := handleMessage(r.Context(), entry.message())
+	s.resolve(id, err)
+	if err != nil {
+		log.Printf("[QUARANTINE] Retry of %s failed: %v", id, err)
+		writeJSON(w, r, http.StatusUnprocessableEntity, map[string]string{"status": "failed", "id": id, "error": err.Error()})
+		return
+	}
+	log.Printf("[QUARANTINE] Retry of %s succeeded", id)
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "processed", "id": id})
+}
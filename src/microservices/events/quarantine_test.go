@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestDLQReasons(t *testing.T) {
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).UnixMilli(), 10)
+	tests := []struct {
+		name        string
+		err         error
+		maxAttempts int
+		headers     []kafka.Header
+		want        dlqReason
+	}{
+		{"undecodable", deserializationError{errors.New("bad json")}, 3, nil, reasonDeserialization},
+		{"invalid", invalidEventError{[]Violation{{Field: "movie_id", Rule: "required", Message: "is required"}}}, 3, nil, reasonValidation},
+		{"single attempt", errors.New("sink down"), 1, nil, reasonHandlerError},
+		{"retried", errors.New("sink down"), 2, nil, reasonRetriesExhausted},
+		{"expired while retried", errors.New("sink down"), 2, []kafka.Header{{Key: expiresAtHeader, Value: []byte(past)}}, reasonExpired},
+		{"expired before handling", expiredError{}, 3, []kafka.Header{{Key: expiresAtHeader, Value: []byte(past)}}, reasonExpired},
+		{"reason replaced on requarantine", errors.New("sink down"), 1, []kafka.Header{{Key: dlqReasonHeader, Value: []byte(reasonValidation)}}, reasonHandlerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev, prevWriter := quarantine, deadLetterWriter
+			dlq := &recordingWriter{}
+			quarantine, _ = newQuarantineStore(10, "")
+			deadLetterWriter = dlq
+			defer func() { quarantine, deadLetterWriter = prev, prevWriter }()
+
+			topic := "dlq-" + string(tt.want)
+			counter := dlqMessages.WithLabelValues(topic, string(tt.want))
+			before := testutil.ToFloat64(counter)
+
+			r := &fakeReader{msgs: []kafka.Message{{Topic: topic, Offset: 7, Value: []byte(`{}`), Headers: tt.headers}}}
+			cfg := consumerConfig{MaxAttempts: tt.maxAttempts, ManualCommit: true}
+			runConsumer(context.Background(), r, cfg, topic, func(context.Context, kafka.Message) error { return tt.err })
+
+			entries := quarantine.list()
+			if len(entries) != 1 {
+				t.Fatalf("quarantined %d messages, want 1", len(entries))
+			}
+			e := entries[0]
+			if e.Reason != tt.want {
+				t.Fatalf("reason = %s, want %s", e.Reason, tt.want)
+			}
+			var reasons []string
+			for _, h := range e.Headers {
+				if h.Key == dlqReasonHeader {
+					reasons = append(reasons, string(h.Value))
+				}
+			}
+			if len(reasons) != 1 || reasons[0] != string(tt.want) {
+				t.Fatalf("%s headers = %v, want [%s]", dlqReasonHeader, reasons, tt.want)
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Fatalf("dlq_messages_total{reason=%q} grew by %v, want 1", tt.want, got)
+			}
+			if len(r.committed) != 1 {
+				t.Fatalf("committed %d messages, want the quarantined one", len(r.committed))
+			}
+			if len(dlq.written) != 1 {
+				t.Fatalf("produced %d dead letters, want 1", len(dlq.written))
+			}
+			d := dlq.written[0]
+			if d.Topic != topic+".dlq" || header(d, dlqReasonHeader) != string(tt.want) || header(d, dlqErrorHeader) != tt.err.Error() {
+				t.Errorf("dead letter to %s with reason %q and error %q, want %s.dlq, %s and %q",
+					d.Topic, header(d, dlqReasonHeader), header(d, dlqErrorHeader), topic, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+// A message is committed past only once the dead-letter topic or the
+// quarantine store took it; otherwise the consumer halts on it.
+func TestQuarantineDestinations(t *testing.T) {
+	tests := []struct {
+		name          string
+		store         bool
+		writer        *recordingWriter
+		wantProduced  int
+		wantStored    int
+		wantCommitted int
+		wantHalted    bool
+	}{
+		{"topic and store", true, &recordingWriter{}, 1, 1, 1, false},
+		{"topic only", false, &recordingWriter{}, 1, 0, 1, false},
+		{"store only", true, nil, 0, 1, 1, false},
+		{"neither", false, nil, 0, 0, 0, true},
+		{"topic failing without a store", false, &recordingWriter{fakeWriter{err: errors.New("broker down")}}, 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev, prevWriter := quarantine, deadLetterWriter
+			defer func() { quarantine, deadLetterWriter = prev, prevWriter }()
+			defer consumerHalted.WithLabelValues(movieTopic).Set(0)
+			resetHealthState(t)
+			quarantine, deadLetterWriter = nil, nil
+			if tt.store {
+				quarantine, _ = newQuarantineStore(10, "")
+			}
+			if tt.writer != nil {
+				deadLetterWriter = tt.writer
+			}
+
+			r := &fakeReader{msgs: []kafka.Message{{Topic: movieTopic, Offset: 4, Value: []byte(`{}`)}}}
+			runConsumer(context.Background(), r, consumerConfig{MaxAttempts: 1, ManualCommit: true}, movieTopic, func(context.Context, kafka.Message) error {
+				return errors.New("sink down")
+			})
+
+			produced := 0
+			if tt.writer != nil {
+				produced = len(tt.writer.written)
+			}
+			stored := 0
+			if quarantine != nil {
+				stored = len(quarantine.list())
+			}
+			if produced != tt.wantProduced || stored != tt.wantStored {
+				t.Errorf("produced %d and stored %d dead letters, want %d and %d", produced, stored, tt.wantProduced, tt.wantStored)
+			}
+			if len(r.committed) != tt.wantCommitted {
+				t.Errorf("committed %d messages, want %d", len(r.committed), tt.wantCommitted)
+			}
+			if _, ok := haltedTopics()[movieTopic]; ok != tt.wantHalted {
+				t.Errorf("halted = %v, want %v", ok, tt.wantHalted)
+			}
+		})
+	}
+}
+
+func TestQuarantineRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        string
+		wantStatus   int
+		wantEntries  int
+		wantAttempts int
+	}{
+		{"fixed message is processed", `{"movie_id":1,"title":"Heat","action":"viewed"}`, http.StatusOK, 0, 0},
+		{"still failing stays quarantined", `{"movie_id":0}`, http.StatusUnprocessableEntity, 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := newQuarantineStore(10, "")
+			prev := quarantine
+			quarantine = store
+			defer func() { quarantine = prev }()
+
+			// The consumer's handler fails every attempt, as a handler with a
+			// bug would, so the message is quarantined after MaxAttempts.
+			var calls atomic.Int32
+			m := kafka.Message{Topic: movieTopic, Partition: 1, Offset: 7, Value: []byte(tt.value)}
+			r := &fakeReader{msgs: []kafka.Message{m}}
+			runConsumer(context.Background(), r, consumerConfig{MaxAttempts: 2}, movieTopic, func(context.Context, kafka.Message) error {
+				calls.Add(1)
+				return errors.New("handler failed")
+			})
+			if calls.Load() != 2 {
+				t.Fatalf("handler called %d times, want 2", calls.Load())
+			}
+			entries := store.list()
+			if len(entries) != 1 || entries[0].Attempts != 2 || entries[0].Error != "handler failed" {
+				t.Fatalf("quarantine = %+v, want one entry after 2 attempts", entries)
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /api/events/quarantine", store.handleList)
+			mux.HandleFunc("POST /api/events/quarantine/{id}/retry", store.handleRetry)
+			var listed []quarantinedMessage
+			decodeJSON(t, serve(mux, httptest.NewRequest(http.MethodGet, "/api/events/quarantine", nil)), &listed)
+			if len(listed) != 1 || string(listed[0].Value) != tt.value {
+				t.Fatalf("listed %+v, want the quarantined message", listed)
+			}
+
+			id := quarantineID(m)
+			rec := serve(mux, httptest.NewRequest(http.MethodPost, "/api/events/quarantine/"+id+"/retry", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("retry status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			entries = store.list()
+			if len(entries) != tt.wantEntries {
+				t.Fatalf("%d entries after retry, want %d", len(entries), tt.wantEntries)
+			}
+			if tt.wantEntries > 0 && entries[0].Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", entries[0].Attempts, tt.wantAttempts)
+			}
+			if rec := serve(mux, httptest.NewRequest(http.MethodPost, "/api/events/quarantine/unknown/retry", nil)); rec.Code != http.StatusNotFound {
+				t.Errorf("retry of unknown id status = %d, want 404", rec.Code)
+			}
+		})
+	}
+}
+
+func TestQuarantineStore(t *testing.T) {
+	tests := []struct {
+		name        string
+		max         int
+		add         []int64
+		wantOffsets []int64
+	}{
+		{"keeps everything under the limit", 3, []int64{1, 2}, []int64{1, 2}},
+		{"drops the oldest", 2, []int64{1, 2, 3}, []int64{2, 3}},
+		{"requarantine replaces", 3, []int64{1, 2, 1}, []int64{2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "quarantine.json")
+			store, err := newQuarantineStore(tt.max, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, offset := range tt.add {
+				store.add(kafka.Message{Topic: "quarantine-store", Offset: offset, Value: []byte(`{}`)}, 1, reasonHandlerError, errors.New("failed"))
+			}
+			reloaded, err := newQuarantineStore(tt.max, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, s := range map[string]*quarantineStore{"in memory": store, "reloaded": reloaded} {
+				var offsets []int64
+				for _, e := range s.list() {
+					offsets = append(offsets, e.Offset)
+				}
+				if fmt.Sprint(offsets) != fmt.Sprint(tt.wantOffsets) {
+					t.Errorf("%s offsets = %v, want %v", name, offsets, tt.wantOffsets)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// rawTopicAllowlist is RAW_PRODUCE_TOPICS: exact topic names, or prefixes
+// when an entry ends in "*".
+type rawTopicAllowlist []string
+
+func parseRawTopics(value string) rawTopicAllowlist {
+	var list rawTopicAllowlist
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func (l rawTopicAllowlist) allows(topic string) bool {
+	for _, entry := range l {
+		if prefix, ok := strings.CutSuffix(entry, "*"); ok {
+			if strings.HasPrefix(topic, prefix) {
+				return true
+			}
+		} else if entry == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRawProduce serves POST /api/events/raw?topic=X for integration tests:
+// the body is produced verbatim to X, which is used as is, without
+// KAFKA_TOPIC_PREFIX, and bypasses decoding, validation and filters. A JSON
+// body must at least be well-formed; with ?encoding=base64 the body is
+// decoded first and may be anything. ?key= sets the message key.
+func handleRawProduce(allowed rawTopicAllowlist) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		topic := r.URL.Query().Get("topic")
+		if topic == "" || !allowed.allows(topic) {
+			writeProduceError(w, r, &ProduceError{Category: CategoryValidation, Code: "topic_not_allowed", Message: fmt.Sprintf("Topic %q is not in RAW_PRODUCE_TOPICS", topic), Status: http.StatusForbidden})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxMessageBytes)))
+		if err != nil {
+			writeProduceError(w, r, &ProduceError{Category: CategoryValidation, Code: "invalid_body", Message: err.Error()})
+			return
+		}
+		switch r.URL.Query().Get("encoding") {
+		case "", "json":
+			if !json.Valid(body) {
+				writeProduceError(w, r, &ProduceError{Category: CategoryValidation, Code: "invalid_body", Message: "Body is not valid JSON; use ?encoding=base64 for other payloads"})
+				return
+			}
+		case "base64":
+			if body, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(body))); err != nil {
+				writeProduceError(w, r, &ProduceError{Category: CategoryValidation, Code: "invalid_body", Message: "Body is not valid base64: " + err.Error()})
+				return
+			}
+		default:
+			writeProduceError(w, r, &ProduceError{Category: CategoryValidation, Code: "invalid_encoding", Message: "encoding must be json or base64"})
+			return
+		}
+
+		msg := kafka.Message{Topic: topic, Value: body}
+		if key := r.URL.Query().Get("key"); key != "" {
+			msg.Key = []byte(key)
+		}
+		produced, located, perr := produceMessage(r.Context(), topic, msg)
+		if perr != nil && produceDLQ.accept(topic, msg, perr) {
+			writeProduced(w, r, produceDeferred, map[string]interface{}{"status": "deferred", "code": perr.Code, "topic": topic})
+			return
+		}
+		if perr != nil {
+			log.Printf("[ADMIN] Raw produce to %s failed (%s): %v", topic, perr.Code, perr)
+			writeProduceError(w, r, perr)
+			return
+		}
+
+		log.Printf("[ADMIN] Raw message produced to %s from %s (%d bytes)", topic, ClientIP(r), len(body))
+		resp := map[string]interface{}{"status": "success", "topic": topic}
+		if located {
+			resp["partition"] = produced.Partition
+			resp["offset"] = produced.Offset
+		}
+		writeProduced(w, r, produceCommitted, resp)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRawTopicAllowlist(t *testing.T) {
+	allowed := parseRawTopics(" it-orders , loadtest-* ,")
+	tests := []struct {
+		topic string
+		want  bool
+	}{
+		{"it-orders", true},
+		{"it-orders-v2", false},
+		{"loadtest-", true},
+		{"loadtest-payments", true},
+		{"movie-events", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := allowed.allows(tt.topic); got != tt.want {
+			t.Errorf("allows(%q) = %v, want %v", tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestHandleRawProduce(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		token      string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantValue  string
+		wantKey    string
+	}{
+		{"allowed custom topic", "/api/events/raw?topic=it-orders", "secret", `{"order": 1, "anything": ["goes"]}`, http.StatusCreated, "", `{"order": 1, "anything": ["goes"]}`, ""},
+		{"prefix entry with key", "/api/events/raw?topic=loadtest-payments&key=42", "secret", `{"amount": 5}`, http.StatusCreated, "", `{"amount": 5}`, "42"},
+		{"base64 body", "/api/events/raw?topic=it-orders&encoding=base64", "secret", "AAECAw==", http.StatusCreated, "", "\x00\x01\x02\x03", ""},
+		{"disallowed topic", "/api/events/raw?topic=movie-events", "secret", `{}`, http.StatusForbidden, "topic_not_allowed", "", ""},
+		{"missing topic", "/api/events/raw", "secret", `{}`, http.StatusForbidden, "topic_not_allowed", "", ""},
+		{"invalid JSON", "/api/events/raw?topic=it-orders", "secret", `{"order":`, http.StatusBadRequest, "invalid_body", "", ""},
+		{"invalid base64", "/api/events/raw?topic=it-orders&encoding=base64", "secret", "not base64!", http.StatusBadRequest, "invalid_body", "", ""},
+		{"unknown encoding", "/api/events/raw?topic=it-orders&encoding=hex", "secret", "00", http.StatusBadRequest, "invalid_encoding", "", ""},
+		{"without the admin token", "/api/events/raw?topic=it-orders", "", `{}`, http.StatusUnauthorized, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, loadtest := &recordingWriter{}, &recordingWriter{}
+			prevWriters, prevToken, prevDLQ, prevAttempts := topicWriters, adminToken, produceDLQ, produceAttempts
+			topicWriters = map[string]eventWriter{"it-orders": orders, "loadtest-payments": loadtest}
+			adminToken, produceDLQ, produceAttempts = "secret", nil, 1
+			defer func() {
+				topicWriters, adminToken, produceDLQ, produceAttempts = prevWriters, prevToken, prevDLQ, prevAttempts
+			}()
+
+			r := jsonRequest(http.MethodPost, tt.target, tt.body)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := serve(requireAdmin(handleRawProduce(parseRawTopics("it-orders,loadtest-*"))), r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			written := append(orders.written, loadtest.written...)
+			if tt.wantStatus != http.StatusCreated {
+				if len(written) != 0 {
+					t.Errorf("rejected request produced %d messages", len(written))
+				}
+				if tt.wantCode != "" {
+					var resp struct{ Code string }
+					decodeJSON(t, rec, &resp)
+					if resp.Code != tt.wantCode {
+						t.Errorf("code = %q, want %q", resp.Code, tt.wantCode)
+					}
+				}
+				return
+			}
+			if len(written) != 1 {
+				t.Fatalf("produced %d messages, want 1", len(written))
+			}
+			m := written[0]
+			if string(m.Value) != tt.wantValue || string(m.Key) != tt.wantKey {
+				t.Errorf("produced key %q value %q, want key %q value %q", m.Key, m.Value, tt.wantKey, tt.wantValue)
+			}
+			var resp struct{ Status, Topic string }
+			decodeJSON(t, rec, &resp)
+			if resp.Status != "success" || resp.Topic != m.Topic {
+				t.Errorf("response = %+v, want success for %s", resp, m.Topic)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var consumerRebalances = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "kafka_consumer_rebalances_total",
+	Help: "Partition assignments received by a reader after joining a group generation, by group and topic.",
+}, []string{"group", "topic"})
+
+// subscribedFormat is the message kafka-go's Reader logs every time it joins
+// a generation and starts fetching its assigned partitions.
+const subscribedFormat = "subscribed to topics and partitions: %+v"
+
+// assignmentTracker follows a consumer's partition assignment. The group
+// consumers call observe from franz-go's assignment hook. kafka-go offers no
+// such hook, so installed as a kafka-go Reader's Logger the tracker picks the
+// assignment out of the subscription log line and ignores every other
+// message.
+type assignmentTracker struct {
+	group string
+	topic string
+
+	mu       sync.Mutex
+	assigned []int
+	joined   bool
+}
+
+func newAssignmentTracker(group, topic string) *assignmentTracker {
+	return &assignmentTracker{group: group, topic: topic}
+}
+
+func (t *assignmentTracker) Printf(format string, args ...interface{}) {
+	if format != subscribedFormat || len(args) != 1 {
+		return
+	}
+	t.observe(subscribedPartitions(args[0]))
+}
+
+// observe records a new assignment. Every generation counts as a rebalance,
+// including the first join; the log line is only written when the set of
+// partitions actually changed.
+func (t *assignmentTracker) observe(partitions []int) {
+	consumerRebalances.WithLabelValues(t.group, t.topic).Inc()
+
+	t.mu.Lock()
+	previous, joined := t.assigned, t.joined
+	t.assigned, t.joined = partitions, true
+	t.mu.Unlock()
+
+	if joined && slices.Equal(previous, partitions) {
+		return
+	}
+	log.Printf("[REBALANCE] group=%s topic=%s partitions=%s (was %s)", t.group, t.topic, formatPartitions(partitions), formatPartitions(previous))
+}
+
+// subscribedPartitions reads the partition IDs out of the offsets map the
+// Reader logs, whose keys are an unexported {topic, partition} struct.
+func subscribedPartitions(offsets interface{}) []int {
+	v := reflect.ValueOf(offsets)
+	if v.Kind() != reflect.Map {
+		return nil
+	}
+	partitions := make([]int, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		if key.Kind() != reflect.Struct {
+			continue
+		}
+		if f := key.FieldByName("partition"); f.IsValid() && f.CanInt() {
+			partitions = append(partitions, int(f.Int()))
+		}
+	}
+	sort.Ints(partitions)
+	return partitions
+}
+
+func formatPartitions(partitions []int) string {
+	if len(partitions) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(partitions))
+	for i, p := range partitions {
+		parts[i] = fmt.Sprint(p)
+	}
+	return strings.Join(parts, ",")
+}
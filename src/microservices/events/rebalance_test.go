@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// topicPartition has the shape of the key kafka-go logs its assignment with.
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+func assignment(topic string, partitions ...int32) map[topicPartition]int64 {
+	offsets := make(map[topicPartition]int64)
+	for _, p := range partitions {
+		offsets[topicPartition{topic: topic, partition: p}] = -1
+	}
+	return offsets
+}
+
+func TestAssignmentTracker(t *testing.T) {
+	out := &syncBuffer{}
+	log.SetOutput(out)
+	defer log.SetOutput(io.Discard)
+
+	tr := newAssignmentTracker("events-service", movieTopic)
+	counter := consumerRebalances.WithLabelValues("events-service", movieTopic)
+	before := testutil.ToFloat64(counter)
+
+	steps := []struct {
+		name      string
+		format    string
+		args      []interface{}
+		wantCount float64
+		wantLog   string
+	}{
+		{"first join", subscribedFormat, []interface{}{assignment(movieTopic, 2, 0)}, 1, "partitions=0,2 (was none)"},
+		{"unrelated log line", "committed offsets for group %s: %v", []interface{}{"events-service", "..."}, 1, ""},
+		{"same partitions after a rebalance", subscribedFormat, []interface{}{assignment(movieTopic, 0, 2)}, 2, ""},
+		{"partition moved away", subscribedFormat, []interface{}{assignment(movieTopic, 0)}, 3, "partitions=0 (was 0,2)"},
+		{"everything revoked", subscribedFormat, []interface{}{assignment(movieTopic)}, 4, "partitions=none (was 0)"},
+	}
+	for _, s := range steps {
+		logged := len(out.String())
+		tr.Printf(s.format, s.args...)
+		if got := testutil.ToFloat64(counter) - before; got != s.wantCount {
+			t.Fatalf("%s: rebalances = %v, want %v", s.name, got, s.wantCount)
+		}
+		line := out.String()[logged:]
+		if s.wantLog == "" {
+			if line != "" {
+				t.Fatalf("%s: logged %q, want nothing", s.name, line)
+			}
+			continue
+		}
+		if !strings.Contains(line, "[REBALANCE] group=events-service topic="+movieTopic) || !strings.Contains(line, s.wantLog) {
+			t.Fatalf("%s: logged %q, want %q", s.name, line, s.wantLog)
+		}
+	}
+}
+
+func TestSubscribedPartitions(t *testing.T) {
+	tests := []struct {
+		name    string
+		offsets interface{}
+		want    []int
+	}{
+		{"sorted", assignment(movieTopic, 5, 1, 3), []int{1, 3, 5}},
+		{"empty", assignment(movieTopic), []int{}},
+		{"not a map", "0,1", nil},
+		{"keys without a partition", map[string]int64{"movie-events": 1}, []int{}},
+	}
+	for _, tt := range tests {
+		if got := subscribedPartitions(tt.offsets); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: partitions = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReaderConfigGroupTimeouts(t *testing.T) {
+	cfg := consumerConfig{Brokers: []string{"kafka:9092"}, GroupID: "events-service", SessionTimeout: 10 * time.Second, HeartbeatInterval: time.Second}
+	rc := newReaderConfig(cfg, movieTopic)
+	if rc.SessionTimeout != 10*time.Second || rc.HeartbeatInterval != time.Second {
+		t.Errorf("session timeout %s, heartbeat %s", rc.SessionTimeout, rc.HeartbeatInterval)
+	}
+	tr, ok := rc.Logger.(*assignmentTracker)
+	if !ok || tr.group != "events-service" || tr.topic != movieTopic {
+		t.Errorf("logger = %#v, want an assignment tracker for the group and topic", rc.Logger)
+	}
+}
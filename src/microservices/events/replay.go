@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// replayReadTimeout ends a replay whose range runs past the last message in
+// the partition instead of waiting for new messages forever.
+const replayReadTimeout = 10 * time.Second
+
+// replayProgressEvery is how many delivered messages go by between progress
+// log lines.
+const replayProgressEvery = 100
+
+// replayJob is the state of a replay reported by GET /api/events/admin/replay.
+type replayJob struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+
+	State      string     `json:"state"`
+	Delivered  int64      `json:"delivered"`
+	Failed     int64      `json:"failed"`
+	LastOffset int64      `json:"last_offset"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// replayer POSTs a range of a partition to REPLAY_SINK_URL. Messages are
+// delivered by a fixed number of workers, so they may reach the sink out of
+// order; X-Replay-Offset lets the sink restore the order if it needs to.
+type replayer struct {
+	ctx         context.Context
+	sinkURL     string
+	client      *http.Client
+	concurrency int
+	maxRetries  int
+	maxFailures int
+	// open returns a reader positioned at offset on the given partition.
+	open func(topic string, partition int, offset int64) (messageReader, error)
+
+	mu  sync.Mutex
+	job *replayJob
+}
+
+func newPartitionReader(brokers []string) func(string, int, int64) (messageReader, error) {
+	return func(topic string, partition int, offset int64) (messageReader, error) {
+		r := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   brokers,
+			Topic:     topic,
+			Partition: partition,
+			MinBytes:  1,
+			MaxBytes:  10e6,
+		})
+		if err := r.SetOffset(offset); err != nil {
+			r.Close()
+			return nil, err
+		}
+		return r, nil
+	}
+}
+
+// handleReplay starts a replay on POST and reports the current or last one on
+// GET. Only one replay runs at a time.
+func (rp *replayer) handleReplay(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rp.mu.Lock()
+		job := rp.job
+		var snapshot replayJob
+		if job != nil {
+			snapshot = *job
+		}
+		rp.mu.Unlock()
+		if job == nil {
+			http.Error(w, "No replay has been started", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, snapshot)
+	case http.MethodPost:
+		var req struct {
+			Topic     string `json:"topic"`
+			Partition int    `json:"partition"`
+			From      int64  `json:"from"`
+			To        int64  `json:"to"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isServiceTopic(req.Topic) {
+			http.Error(w, "Unknown topic", http.StatusBadRequest)
+			return
+		}
+		if req.Partition < 0 || req.From < 0 || req.To < req.From {
+			http.Error(w, "partition and from must be non-negative and to must not be before from", http.StatusBadRequest)
+			return
+		}
+
+		rp.mu.Lock()
+		if rp.job != nil && rp.job.State == "running" {
+			rp.mu.Unlock()
+			http.Error(w, "A replay is already running", http.StatusConflict)
+			return
+		}
+		job := &replayJob{
+			Topic:      topicName(req.Topic),
+			Partition:  req.Partition,
+			From:       req.From,
+			To:         req.To,
+			State:      "running",
+			LastOffset: -1,
+			StartedAt:  time.Now(),
+		}
+		rp.job = job
+		snapshot := *job
+		rp.mu.Unlock()
+
+		go rp.run(job)
+		writeJSON(w, r, http.StatusAccepted, snapshot)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (rp *replayer) update(job *replayJob, fn func(*replayJob)) {
+	rp.mu.Lock()
+	fn(job)
+	rp.mu.Unlock()
+}
+
+func (rp *replayer) run(job *replayJob) {
+	log.Printf("[REPLAY] Replaying %s[%d] offsets %d-%d to %s", job.Topic, job.Partition, job.From, job.To, rp.sinkURL)
+
+	state, runErr := rp.replay(job)
+	var delivered, failed int64
+	rp.update(job, func(j *replayJob) {
+		now := time.Now()
+		j.State = state
+		j.FinishedAt = &now
+		if runErr != nil {
+			j.Error = runErr.Error()
+		}
+		delivered, failed = j.Delivered, j.Failed
+	})
+	log.Printf("[REPLAY] Replay of %s[%d] %s: %d delivered, %d failed", job.Topic, job.Partition, state, delivered, failed)
+}
+
+func (rp *replayer) replay(job *replayJob) (string, error) {
+	r, err := rp.open(job.Topic, job.Partition, job.From)
+	if err != nil {
+		return "failed", fmt.Errorf("open partition: %w", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(rp.ctx)
+	defer cancel()
+
+	var (
+		failures atomic.Int64
+		aborted  atomic.Bool
+		wg       sync.WaitGroup
+	)
+	msgs := make(chan kafka.Message)
+	for i := 0; i < rp.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range msgs {
+				if err := rp.deliver(ctx, m); err != nil {
+					log.Printf("[REPLAY] Failed to deliver %s[%d]@%d: %v", m.Topic, m.Partition, m.Offset, err)
+					rp.update(job, func(j *replayJob) { j.Failed++ })
+					if failures.Add(1) >= int64(rp.maxFailures) && aborted.CompareAndSwap(false, true) {
+						cancel()
+					}
+					continue
+				}
+				rp.update(job, func(j *replayJob) {
+					j.Delivered++
+					if m.Offset > j.LastOffset {
+						j.LastOffset = m.Offset
+					}
+					if j.Delivered%replayProgressEvery == 0 {
+						log.Printf("[REPLAY] %s[%d]: %d/%d delivered", j.Topic, j.Partition, j.Delivered, j.To-j.From+1)
+					}
+				})
+			}
+		}()
+	}
+
+	readErr := rp.feed(ctx, r, job.To, msgs)
+	close(msgs)
+	wg.Wait()
+
+	switch {
+	case aborted.Load():
+		return "aborted", fmt.Errorf("stopped after %d sink failures", failures.Load())
+	case rp.ctx.Err() != nil:
+		return "cancelled", rp.ctx.Err()
+	case readErr != nil:
+		return "failed", readErr
+	}
+	return "completed", nil
+}
+
+// feed sends every message up to and including offset to to msgs. It stops
+// early at the end of the partition.
+func (rp *replayer) feed(ctx context.Context, r messageReader, to int64, msgs chan<- kafka.Message) error {
+	for {
+		readCtx, cancel := context.WithTimeout(ctx, replayReadTimeout)
+		m, err := r.ReadMessage(readCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				log.Printf("[REPLAY] No message within %s, treating the partition as exhausted", replayReadTimeout)
+				return nil
+			}
+			return fmt.Errorf("read: %w", err)
+		}
+		if m.Offset > to {
+			return nil
+		}
+		select {
+		case msgs <- m:
+		case <-ctx.Done():
+			return nil
+		}
+		if m.Offset == to || (m.HighWaterMark > 0 && m.Offset >= m.HighWaterMark-1) {
+			return nil
+		}
+	}
+}
+
+// deliver POSTs one message, retrying transport errors, 429 and 5xx responses
+// with exponential backoff.
+func (rp *replayer) deliver(ctx context.Context, m kafka.Message) error {
+	value, err := messageValue(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err = rp.post(ctx, m, value)
+		var perm permanentError
+		if err == nil || errors.As(err, &perm) || attempt >= rp.maxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// permanentError is a sink response that retrying will not fix.
+type permanentError struct{ status int }
+
+func (e permanentError) Error() string { return fmt.Sprintf("sink returned %d", e.status) }
+
+func (rp *replayer) post(ctx context.Context, m kafka.Message, value []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rp.sinkURL, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Replay-Topic", m.Topic)
+	req.Header.Set("X-Replay-Partition", strconv.Itoa(m.Partition))
+	req.Header.Set("X-Replay-Offset", strconv.FormatInt(m.Offset, 10))
+
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("sink returned %d", resp.StatusCode)
+	}
+	return permanentError{status: resp.StatusCode}
+}
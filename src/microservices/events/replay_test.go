@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// stubSink records the offsets POSTed to it. fail decides the status of
+// each attempt, counted per offset from 1.
+type stubSink struct {
+	mu        sync.Mutex
+	attempts  map[int64]int
+	delivered []int64
+	fail      func(offset int64, attempt int) int
+}
+
+func (s *stubSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.ParseInt(r.Header.Get("X-Replay-Offset"), 10, 64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts[offset]++
+	if s.fail != nil {
+		if status := s.fail(offset, s.attempts[offset]); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	s.delivered = append(s.delivered, offset)
+}
+
+func partitionMessages(n int) []kafka.Message {
+	msgs := make([]kafka.Message, n)
+	for i := range msgs {
+		msgs[i] = kafka.Message{Topic: movieTopic, Offset: int64(i), HighWaterMark: int64(n), Value: []byte(fmt.Sprintf(`{"movie_id":%d}`, i+1))}
+	}
+	return msgs
+}
+
+func TestReplayToSink(t *testing.T) {
+	tests := []struct {
+		name          string
+		from, to      int64
+		concurrency   int
+		maxFailures   int
+		fail          func(offset int64, attempt int) int
+		wantState     string
+		wantDelivered []int64
+		wantFailed    int64
+	}{
+		{"whole range", 2, 6, 3, 10, nil, "completed", []int64{2, 3, 4, 5, 6}, 0},
+		{"range past the end", 8, 20, 3, 10, nil, "completed", []int64{8, 9}, 0},
+		{"transient failures retried", 0, 3, 3, 10, func(_ int64, attempt int) int {
+			if attempt == 1 {
+				return http.StatusServiceUnavailable
+			}
+			return 0
+		}, "completed", []int64{0, 1, 2, 3}, 0},
+		{"permanent failure not retried", 0, 3, 3, 10, func(offset int64, _ int) int {
+			if offset == 1 {
+				return http.StatusBadRequest
+			}
+			return 0
+		}, "completed", []int64{0, 2, 3}, 1},
+		{"too many failures abort", 0, 9, 1, 2, func(int64, int) int { return http.StatusInternalServerError }, "aborted", nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &stubSink{attempts: make(map[int64]int), fail: tt.fail}
+			server := httptest.NewServer(sink)
+			defer server.Close()
+
+			rp := &replayer{
+				ctx:         context.Background(),
+				sinkURL:     server.URL,
+				client:      server.Client(),
+				concurrency: tt.concurrency,
+				maxRetries:  1,
+				maxFailures: tt.maxFailures,
+				open: func(topic string, partition int, offset int64) (messageReader, error) {
+					return &fakeReader{msgs: partitionMessages(10)[offset:]}, nil
+				},
+			}
+			body := fmt.Sprintf(`{"topic":%q,"partition":0,"from":%d,"to":%d}`, movieTopic, tt.from, tt.to)
+			if rec := serve(http.HandlerFunc(rp.handleReplay), jsonRequest(http.MethodPost, "/api/events/admin/replay", body)); rec.Code != http.StatusAccepted {
+				t.Fatalf("start status = %d: %s", rec.Code, rec.Body.String())
+			}
+
+			var job replayJob
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				rec := serve(http.HandlerFunc(rp.handleReplay), httptest.NewRequest(http.MethodGet, "/api/events/admin/replay", nil))
+				decodeJSON(t, rec, &job)
+				if job.State != "running" {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("replay did not finish")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			if job.State != tt.wantState || job.Failed != tt.wantFailed || job.Delivered != int64(len(tt.wantDelivered)) {
+				t.Errorf("job = %s with %d delivered, %d failed; want %s with %d delivered, %d failed",
+					job.State, job.Delivered, job.Failed, tt.wantState, len(tt.wantDelivered), tt.wantFailed)
+			}
+			sort.Slice(sink.delivered, func(i, j int) bool { return sink.delivered[i] < sink.delivered[j] })
+			if fmt.Sprint(sink.delivered) != fmt.Sprint(tt.wantDelivered) {
+				t.Errorf("sink received offsets %v, want %v", sink.delivered, tt.wantDelivered)
+			}
+		})
+	}
+}
+
+func TestHandleReplayRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		running    bool
+		wantStatus int
+	}{
+		{"unknown topic", `{"topic":"ticket-events","from":0,"to":1}`, false, http.StatusBadRequest},
+		{"negative partition", `{"topic":"movie-events","partition":-1,"from":0,"to":1}`, false, http.StatusBadRequest},
+		{"to before from", `{"topic":"movie-events","from":5,"to":1}`, false, http.StatusBadRequest},
+		{"malformed", `{"topic":`, false, http.StatusBadRequest},
+		{"already running", `{"topic":"movie-events","from":0,"to":1}`, true, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := &replayer{}
+			if tt.running {
+				rp.job = &replayJob{State: "running"}
+			}
+			rec := serve(http.HandlerFunc(rp.handleReplay), jsonRequest(http.MethodPost, "/api/events/admin/replay", tt.body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
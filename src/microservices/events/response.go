@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Response envelope versions. v1 is the flat shape every endpoint has always
+// returned; v2 wraps it as {"data": ..., "meta": ...}.
+const (
+	envelopeV1 = "1"
+	envelopeV2 = "2"
+)
+
+// envelopeMeta is the meta object of a v2 response.
+type envelopeMeta struct {
+	Version string `json:"version"`
+	Status  int    `json:"status"`
+}
+
+// responseVersion is the envelope version a request asked for, from ?v= or
+// the Accept-Version header, the query parameter winning. Both accept "2" as
+// well as "v2". No preference means v1.
+func responseVersion(r *http.Request) (string, error) {
+	v := r.URL.Query().Get("v")
+	if v == "" {
+		v = r.Header.Get("Accept-Version")
+	}
+	switch v {
+	case "", envelopeV1, "v" + envelopeV1:
+		return envelopeV1, nil
+	case envelopeV2, "v" + envelopeV2:
+		return envelopeV2, nil
+	}
+	return "", fmt.Errorf("unsupported response version %q, use 1 or 2", v)
+}
+
+// writeJSON writes v with the given status in the envelope version the
+// request selected. Passing ?pretty=true indents the body for debugging with
+// curl.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	version, err := responseVersion(r)
+	if err != nil {
+		// The version is unusable, so answer in the one every client reads.
+		version = envelopeV1
+		status = http.StatusBadRequest
+		v = map[string]interface{}{
+			"status":    "error",
+			"code":      "unsupported_version",
+			"category":  CategoryValidation.String(),
+			"retryable": false,
+			"error":     err.Error(),
+		}
+	}
+	if version == envelopeV2 {
+		v = map[string]interface{}{"data": v, "meta": envelopeMeta{Version: version, Status: status}}
+	}
+	w.Header().Set("Content-Version", version)
+	w.Header().Add("Vary", "Accept-Version")
+
+	var body []byte
+	if r.URL.Query().Get("pretty") == "true" {
+		body, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		body, err = json.Marshal(v)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// produceOutcome is how a produce request was settled.
+type produceOutcome int
+
+const (
+	// produceCommitted: the event was written to Kafka before responding.
+	produceCommitted produceOutcome = iota
+	// produceBuffered: the event was queued for asynchronous delivery.
+	produceBuffered
+	// produceDropped: a filter rule discarded the event.
+	produceDropped
+	// produceDeferred: Kafka kept failing and the event was dead-lettered
+	// to PRODUCE_DLQ_FILE.
+	produceDeferred
+	// produceScheduled: the event is held until its deliver_at time.
+	produceScheduled
+)
+
+// produceStatus is the success status code for each produce outcome, so
+// every produce path answers the same way: 201 once Kafka has the event, 202
+// when it is only buffered, scheduled or dead-lettered, 200 when nothing was
+// produced. Rejected events get 422 from writeViolations.
+func produceStatus(o produceOutcome) int {
+	switch o {
+	case produceBuffered, produceDeferred, produceScheduled:
+		return http.StatusAccepted
+	case produceDropped:
+		return http.StatusOK
+	}
+	return http.StatusCreated
+}
+
+func writeProduced(w http.ResponseWriter, r *http.Request, o produceOutcome, body map[string]interface{}) {
+	writeJSON(w, r, produceStatus(o), body)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteJSONPretty(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantIndent bool
+	}{
+		{"", false},
+		{"?pretty=true", true},
+		{"?pretty=false", false},
+		{"?pretty=true&v=2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeJSON(rec, httptest.NewRequest(http.MethodGet, "/api/events/recent"+tt.query, nil), http.StatusOK, map[string]int{"a": 1})
+			if got := strings.Contains(rec.Body.String(), "\n  \""); got != tt.wantIndent {
+				t.Fatalf("body %q indented = %v, want %v", rec.Body.String(), got, tt.wantIndent)
+			}
+		})
+	}
+}
+
+func TestProduceStatus(t *testing.T) {
+	tests := []struct {
+		outcome produceOutcome
+		want    int
+	}{
+		{produceCommitted, http.StatusCreated},
+		{produceBuffered, http.StatusAccepted},
+		{produceScheduled, http.StatusAccepted},
+		{produceDeferred, http.StatusAccepted},
+		{produceDropped, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := produceStatus(tt.outcome); got != tt.want {
+			t.Errorf("produceStatus(%d) = %d, want %d", tt.outcome, got, tt.want)
+		}
+	}
+}
+
+func TestHandleEventStatusPerMode(t *testing.T) {
+	const valid = `{"movie_id": 1, "title": "Heat", "action": "viewed"}`
+	later := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		setup      func(t *testing.T)
+		writeErr   error
+		wantStatus int
+		wantField  string
+	}{
+		{"committed", "/api/events/movie", valid, nil, nil, http.StatusCreated, "offset"},
+		{"buffered", "/api/events/movie", valid, func(t *testing.T) { buffer = newProduceBuffer(10, t.TempDir()) }, nil, http.StatusAccepted, "status"},
+		{"scheduled", "/api/events/movie?deliver_at=" + later, valid, func(t *testing.T) {
+			var err error
+			if scheduler, err = newEventScheduler(10, ""); err != nil {
+				t.Fatal(err)
+			}
+		}, nil, http.StatusAccepted, "deliver_at"},
+		{"dead-lettered", "/api/events/movie", valid, func(t *testing.T) {
+			produceDLQ = &deadLetterFile{path: filepath.Join(t.TempDir(), "dlq.jsonl")}
+		}, context.DeadlineExceeded, http.StatusAccepted, "code"},
+		{"dropped", "/api/events/movie", valid, func(t *testing.T) {
+			filterRules = []filterRule{{Name: "heat", Field: "title", Op: "eq", Value: "Heat", Action: "drop"}}
+		}, nil, http.StatusOK, "dropped"},
+		{"invalid", "/api/events/movie", `{"title": "Heat"}`, nil, nil, http.StatusUnprocessableEntity, "violations"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prevWriters, prevBuffer, prevScheduler, prevDLQ, prevRules, prevAttempts := topicWriters, buffer, scheduler, produceDLQ, filterRules, produceAttempts
+			defer func() {
+				topicWriters, buffer, scheduler, produceDLQ, filterRules, produceAttempts = prevWriters, prevBuffer, prevScheduler, prevDLQ, prevRules, prevAttempts
+			}()
+			var w eventWriter = &completingWriter{}
+			if tt.writeErr != nil {
+				w = &stubWriter{errs: []error{tt.writeErr}}
+			}
+			topicWriters, produceAttempts = map[string]eventWriter{movieTopic: w}, 1
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+
+			rec := serve(handleEvent(movieTopic), jsonRequest(http.MethodPost, tt.target, tt.body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			var body map[string]interface{}
+			decodeJSON(t, rec, &body)
+			if _, ok := body[tt.wantField]; !ok {
+				t.Errorf("body %s lacks %q", rec.Body.String(), tt.wantField)
+			}
+		})
+	}
+}
+
+func TestProduceResponseEnvelope(t *testing.T) {
+	const valid = `{"movie_id": 1, "title": "Heat", "action": "viewed"}`
+	tests := []struct {
+		name          string
+		query         string
+		acceptVersion string
+		wantStatus    int
+		wantVersion   string
+		wantEnvelope  bool
+		wantWritten   int64
+	}{
+		{"default is v1", "", "", http.StatusCreated, "1", false, 1},
+		{"v1 by header", "", "1", http.StatusCreated, "1", false, 1},
+		{"v2 by header", "", "2", http.StatusCreated, "2", true, 1},
+		{"v2 by query", "?v=v2", "", http.StatusCreated, "2", true, 1},
+		{"query wins over the header", "?v=1", "2", http.StatusCreated, "1", false, 1},
+		{"unsupported version not produced", "", "3", http.StatusBadRequest, "1", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prevWriters, prevDLQ := topicWriters, produceDLQ
+			defer func() { topicWriters, produceDLQ = prevWriters, prevDLQ }()
+			w := &completingWriter{}
+			topicWriters, produceDLQ = map[string]eventWriter{movieTopic: w}, nil
+
+			r := jsonRequest(http.MethodPost, "/api/events/movie"+tt.query, valid)
+			if tt.acceptVersion != "" {
+				r.Header.Set("Accept-Version", tt.acceptVersion)
+			}
+			rec := serve(handleEvent(movieTopic), r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Version"); got != tt.wantVersion {
+				t.Errorf("Content-Version = %q, want %q", got, tt.wantVersion)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Version" {
+				t.Errorf("Vary = %q, want Accept-Version", got)
+			}
+			if w.next != tt.wantWritten {
+				t.Errorf("produced %d messages, want %d", w.next, tt.wantWritten)
+			}
+
+			var body map[string]interface{}
+			decodeJSON(t, rec, &body)
+			if tt.wantStatus != http.StatusCreated {
+				if body["code"] != "unsupported_version" {
+					t.Errorf("body = %s, want an unsupported_version error", rec.Body.String())
+				}
+				return
+			}
+			if !tt.wantEnvelope {
+				if _, ok := body["offset"]; !ok || body["data"] != nil {
+					t.Errorf("v1 body %s is not the flat produce result", rec.Body.String())
+				}
+				return
+			}
+			data, _ := body["data"].(map[string]interface{})
+			meta, _ := body["meta"].(map[string]interface{})
+			if _, ok := data["offset"]; !ok || body["offset"] != nil {
+				t.Errorf("v2 body %s does not wrap the produce result in data", rec.Body.String())
+			}
+			if meta["version"] != "2" || meta["status"] != float64(http.StatusCreated) {
+				t.Errorf("meta = %v, want version 2 and status 201", meta)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// scheduleRetryInterval is how long a scheduled event waits before another
+// produce attempt once produceMessage has given up on it.
+const scheduleRetryInterval = 5 * time.Second
+
+var errScheduleFull = errors.New("too many scheduled events pending")
+
+// scheduledEvent is an event accepted with ?deliver_at and held until then.
+type scheduledEvent struct {
+	ID        string    `json:"id"`
+	DeliverAt time.Time `json:"deliver_at"`
+	bufferedEvent
+}
+
+// scheduleQueue is a min-heap on DeliverAt.
+type scheduleQueue []*scheduledEvent
+
+func (q scheduleQueue) Len() int           { return len(q) }
+func (q scheduleQueue) Less(i, j int) bool { return q[i].DeliverAt.Before(q[j].DeliverAt) }
+func (q scheduleQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *scheduleQueue) Push(x any)        { *q = append(*q, x.(*scheduledEvent)) }
+func (q *scheduleQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// eventScheduler produces events at their deliver_at time. The pending set is
+// bounded by max and, with a path, rewritten to disk after every change so it
+// survives a restart. Delivery is at-least-once: a crash between the produce
+// and the rewrite produces the event again on the next start.
+type eventScheduler struct {
+	max  int
+	path string
+	// produce defaults to produceMessage.
+	produce func(ctx context.Context, base string, msg kafka.Message) error
+
+	mu      sync.Mutex
+	pending scheduleQueue
+	seq     int64
+	wake    chan struct{}
+}
+
+// scheduler is nil when SCHEDULE_MAX_PENDING is 0.
+var scheduler *eventScheduler
+
+func newEventScheduler(max int, path string) (*eventScheduler, error) {
+	s := &eventScheduler{max: max, path: path, wake: make(chan struct{}, 1)}
+	s.produce = func(ctx context.Context, base string, msg kafka.Message) error {
+		if _, _, perr := produceMessage(ctx, base, msg); perr != nil {
+			if produceDLQ.accept(base, msg, perr) {
+				return nil
+			}
+			return perr
+		}
+		return nil
+	}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.pending); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	heap.Init(&s.pending)
+	return s, nil
+}
+
+// requestDeliverAt reads ?deliver_at as RFC 3339 or unix milliseconds. A zero
+// time means produce now.
+func requestDeliverAt(r *http.Request) (time.Time, error) {
+	value := r.URL.Query().Get("deliver_at")
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil && ms > 0 {
+		return time.UnixMilli(ms), nil
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("deliver_at must be an RFC 3339 timestamp or unix milliseconds")
+	}
+	return at, nil
+}
+
+// schedule queues msg for at and returns the id of the scheduled event.
+func (s *eventScheduler) schedule(base string, msg kafka.Message, at time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) >= s.max {
+		return "", errScheduleFull
+	}
+	s.seq++
+	e := &scheduledEvent{
+		ID:            fmt.Sprintf("%d-%d", time.Now().UnixNano(), s.seq),
+		DeliverAt:     at,
+		bufferedEvent: bufferedEvent{Base: base, Topic: msg.Topic, Key: msg.Key, Value: msg.Value, Headers: msg.Headers},
+	}
+	heap.Push(&s.pending, e)
+	s.persistLocked()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return e.ID, nil
+}
+
+// next pops the earliest event if it is due, otherwise it reports how long
+// to wait for it.
+func (s *eventScheduler) next(now time.Time) (*scheduledEvent, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil, time.Hour
+	}
+	if wait := s.pending[0].DeliverAt.Sub(now); wait > 0 {
+		return nil, wait
+	}
+	return s.pending[0], 0
+}
+
+func (s *eventScheduler) done(e *scheduledEvent, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Events scheduled during the produce may have moved e off the top.
+	for i, p := range s.pending {
+		if p != e {
+			continue
+		}
+		if err != nil {
+			e.DeliverAt = time.Now().Add(scheduleRetryInterval)
+			heap.Fix(&s.pending, i)
+		} else {
+			heap.Remove(&s.pending, i)
+		}
+		break
+	}
+	s.persistLocked()
+}
+
+// run produces events as they fall due until ctx is cancelled. Pending events
+// stay queued, and on disk when a path is set.
+func (s *eventScheduler) run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+		for ctx.Err() == nil {
+			e, wait := s.next(time.Now())
+			if e == nil {
+				timer.Reset(wait)
+				break
+			}
+			err := s.produce(ctx, e.Base, e.message())
+			if err != nil {
+				log.Printf("[SCHEDULE] Failed to produce scheduled event %s to %s, retrying in %s: %v", e.ID, e.Topic, scheduleRetryInterval, err)
+			} else {
+				log.Printf("[SCHEDULE] Produced scheduled event %s to %s (%s late)", e.ID, e.Topic, time.Since(e.DeliverAt).Round(time.Millisecond))
+			}
+			s.done(e, err)
+		}
+	}
+}
+
+func (s *eventScheduler) persistLocked() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.Marshal(s.pending)
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to persist scheduled events to %s: %v", s.path, err)
+	}
+}
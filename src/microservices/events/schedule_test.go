@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestRequestDeliverAt(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"?deliver_at=2024-03-01T12:00:00Z", at, false},
+		{"?deliver_at=2024-03-01T13:00:00%2B01:00", at, false},
+		{"?deliver_at=" + strconv.FormatInt(at.UnixMilli(), 10), at, false},
+		{"?deliver_at=tomorrow", time.Time{}, true},
+		{"?deliver_at=-5", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := requestDeliverAt(httptest.NewRequest(http.MethodPost, "/api/events/movie"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("deliver_at = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// timedWriter reports when each message was written.
+type timedWriter struct {
+	at chan time.Time
+}
+
+func (w *timedWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for range msgs {
+		w.at <- time.Now()
+	}
+	return nil
+}
+
+func (*timedWriter) Close() error { return nil }
+
+func TestScheduledEventProducedOnTime(t *testing.T) {
+	w := &timedWriter{at: make(chan time.Time, 1)}
+	s, err := newEventScheduler(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevWriters, prevScheduler := topicWriters, scheduler
+	topicWriters, scheduler = map[string]eventWriter{movieTopic: w}, s
+	defer func() { topicWriters, scheduler = prevWriters, prevScheduler }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.run(ctx)
+
+	deliverAt := time.Now().Add(200 * time.Millisecond).Truncate(time.Millisecond)
+	target := "/api/events/movie?deliver_at=" + strconv.FormatInt(deliverAt.UnixMilli(), 10)
+	rec := serve(handleEvent(movieTopic), jsonRequest(http.MethodPost, target, `{"movie_id": 1, "title": "Heat", "action": "viewed"}`))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct{ Status, ID string }
+	decodeJSON(t, rec, &resp)
+	if resp.Status != "scheduled" || resp.ID == "" {
+		t.Fatalf("response = %+v, want a scheduled event", resp)
+	}
+
+	select {
+	case at := <-w.at:
+		if at.Before(deliverAt) {
+			t.Errorf("produced %s early", deliverAt.Sub(at))
+		}
+		if late := at.Sub(deliverAt); late > 150*time.Millisecond {
+			t.Errorf("produced %s late", late)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("scheduled event never produced")
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		n := len(s.pending)
+		s.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events still pending after delivery", n)
+		}
+	}
+}
+
+func TestSchedulerOrderAndRetry(t *testing.T) {
+	s, err := newEventScheduler(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	produced := make(chan string, 3)
+	failures := 1
+	s.produce = func(ctx context.Context, base string, msg kafka.Message) error {
+		if string(msg.Value) == "first" && failures > 0 {
+			failures--
+			return errors.New("kafka down")
+		}
+		produced <- string(msg.Value)
+		return nil
+	}
+	now := time.Now()
+	s.schedule(movieTopic, kafka.Message{Value: []byte("second")}, now.Add(-time.Second))
+	s.schedule(movieTopic, kafka.Message{Value: []byte("first")}, now.Add(-2*time.Second))
+	s.schedule(movieTopic, kafka.Message{Value: []byte("later")}, now.Add(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.run(ctx)
+	if got := <-produced; got != "second" {
+		t.Fatalf("produced %q first, want the due event that did not fail", got)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) != 2 || string(s.pending[0].Value) != "first" {
+		t.Fatalf("pending = %d events, want the failed one back in front of later", len(s.pending))
+	}
+	if retry := s.pending[0].DeliverAt.Sub(now); retry < scheduleRetryInterval-time.Second {
+		t.Errorf("failed event rescheduled %s from now, want about %s", retry, scheduleRetryInterval)
+	}
+}
+
+func TestSchedulerPersistsAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedule.json")
+	s, err := newEventScheduler(10, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Millisecond)
+	s.schedule(movieTopic, kafka.Message{Topic: "movie-events", Key: []byte("7"), Value: []byte("b")}, now.Add(2*time.Hour))
+	s.schedule(userTopic, kafka.Message{Topic: "user-events", Value: []byte("a")}, now.Add(time.Hour))
+
+	restarted, err := newEventScheduler(10, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, wait := restarted.next(now)
+	if e != nil || wait <= 59*time.Minute {
+		t.Fatalf("restarted scheduler due %v after %s, want nothing for an hour", e, wait)
+	}
+	first, _ := restarted.next(now.Add(time.Hour))
+	if first == nil || first.Base != userTopic || string(first.Value) != "a" || !first.DeliverAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("first restored event = %+v", first)
+	}
+	restarted.done(first, nil)
+
+	again, err := newEventScheduler(10, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.pending) != 1 || again.pending[0].Base != movieTopic || string(again.pending[0].Key) != "7" {
+		t.Fatalf("after delivery the file holds %+v, want the movie event only", again.pending)
+	}
+}
+
+func TestSchedulerCap(t *testing.T) {
+	s, err := newEventScheduler(2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := scheduler
+	scheduler = s
+	defer func() { scheduler = prev }()
+
+	deliverAt := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	for i, want := range []int{http.StatusAccepted, http.StatusAccepted, http.StatusServiceUnavailable} {
+		rec := serve(handleEvent(movieTopic), jsonRequest(http.MethodPost, "/api/events/movie?deliver_at="+deliverAt, `{"movie_id": 1, "title": "Heat", "action": "viewed"}`))
+		if rec.Code != want {
+			t.Fatalf("event %d: status = %d, want %d: %s", i, rec.Code, want, rec.Body.String())
+		}
+	}
+	if len(s.pending) != 2 {
+		t.Errorf("pending = %d, want the cap of 2", len(s.pending))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// supportedSchemaVersion is the newest event schema_version this consumer
+// knows. Messages without the field are version 1.
+const supportedSchemaVersion = 1
+
+// defaulter is implemented by events that can fill in fields older producers
+// did not send.
+type defaulter interface {
+	applyDefaults(m kafka.Message)
+}
+
+// Timestamps missing from older payloads fall back to the Kafka record time.
+func (e *UserEvent) applyDefaults(m kafka.Message) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = m.Time
+	}
+}
+
+func (e *PaymentEvent) applyDefaults(m kafka.Message) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = m.Time
+	}
+}
+
+// decodeConsumed decodes a consumed JSON payload for one of the service's
+// topics. Unlike the produce path it is always lenient: unknown fields from
+// newer producers are ignored and missing ones get defaults. It returns a nil
+// event for topics the service does not own.
+func decodeConsumed(m kafka.Message, value []byte) (Event, int, error) {
+	base := strings.TrimPrefix(m.Topic, topicPrefix)
+	if !isServiceTopic(base) {
+		return nil, 0, nil
+	}
+
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(value, &header); err != nil {
+		return nil, 0, err
+	}
+	version := header.SchemaVersion
+	if version == 0 {
+		version = 1
+	}
+	if version > supportedSchemaVersion {
+		log.Printf("WARNING: message from topic %s at offset %d has schema_version %d, newer than supported %d; unknown fields are ignored", m.Topic, m.Offset, version, supportedSchemaVersion)
+		newerSchemaVersions.WithLabelValues(m.Topic).Inc()
+	}
+
+	event, err := decodeEventMode(base, bytes.NewReader(value), false)
+	if err != nil {
+		return nil, version, err
+	}
+	if d, ok := event.(defaulter); ok {
+		d.applyDefaults(m)
+	}
+	return event, version, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestDecodeConsumedSchemaVersions(t *testing.T) {
+	recordTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		topic       string
+		value       string
+		wantVersion int
+		wantNewer   bool
+		check       func(t *testing.T, e Event)
+	}{
+		{
+			"newer version with extra fields", movieTopic,
+			`{"schema_version": 3, "movie_id": 7, "title": "Heat", "action": "viewed", "director": "Mann", "tags": ["crime"]}`,
+			3, true,
+			func(t *testing.T, e Event) {
+				if m := e.(*MovieEvent); m.MovieID != 7 || m.Title != "Heat" {
+					t.Errorf("decoded %+v", m)
+				}
+			},
+		},
+		{
+			"unversioned is version 1", movieTopic,
+			`{"movie_id": 7, "title": "Heat", "action": "viewed"}`,
+			1, false, nil,
+		},
+		{
+			"missing timestamp defaults to record time", userTopic,
+			`{"user_id": 3, "action": "login"}`,
+			1, false,
+			func(t *testing.T, e Event) {
+				if u := e.(*UserEvent); !u.Timestamp.Equal(recordTime) {
+					t.Errorf("timestamp = %s, want %s", u.Timestamp, recordTime)
+				}
+			},
+		},
+		{
+			"sent timestamp kept", paymentTopic,
+			`{"payment_id": 1, "user_id": 3, "amount": 5, "status": "completed", "timestamp": "2023-01-01T00:00:00Z"}`,
+			1, false,
+			func(t *testing.T, e Event) {
+				if p := e.(*PaymentEvent); p.Timestamp.Year() != 2023 {
+					t.Errorf("timestamp = %s, want the sent one", p.Timestamp)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withDecoding(t, true, false)
+			counter := newerSchemaVersions.WithLabelValues(tt.topic)
+			before := testutil.ToFloat64(counter)
+
+			m := kafka.Message{Topic: tt.topic, Value: []byte(tt.value), Time: recordTime}
+			event, version, err := decodeConsumed(m, m.Value)
+			if err != nil {
+				t.Fatalf("decodeConsumed: %v", err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("version = %d, want %d", version, tt.wantVersion)
+			}
+			if newer := testutil.ToFloat64(counter) > before; newer != tt.wantNewer {
+				t.Errorf("newer schema counted = %v, want %v", newer, tt.wantNewer)
+			}
+			if tt.check != nil {
+				tt.check(t, event)
+			}
+			// The whole consume path accepts it too, strict produce-side
+			// decoding notwithstanding.
+			if err := handleMessage(context.Background(), m); err != nil {
+				t.Errorf("handleMessage: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+var sinkDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_sink_deliveries_total",
+	Help: "Consumed events handed to the configured sink, by topic and result (delivered, failed, rejected).",
+}, []string{"topic", "result"})
+
+// eventSink receives every event the logging consumers decode. A returned
+// error goes through the consumer's usual retries and then quarantine.
+type eventSink interface {
+	deliver(ctx context.Context, m kafka.Message, value []byte) error
+}
+
+// sink is nil when consumed events are only logged.
+var sink eventSink
+
+// webhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+// body under WEBHOOK_SINK_SECRET.
+const webhookSignatureHeader = "X-Webhook-Signature"
+
+var errCircuitOpen = errors.New("webhook circuit breaker is open")
+
+// circuitBreaker opens after threshold consecutive failures and fails calls
+// without trying them for cooldown. After that one trial call is let through:
+// success closes the breaker, failure opens it again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trial || now.Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *circuitBreaker) record(now time.Time, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = now
+	}
+}
+
+// webhookSink POSTs each event to WEBHOOK_SINK_URL, retrying transport
+// errors, 429 and 5xx with exponential backoff.
+type webhookSink struct {
+	url        string
+	secret     []byte
+	client     *http.Client
+	maxRetries int
+	breaker    *circuitBreaker
+}
+
+func (s *webhookSink) deliver(ctx context.Context, m kafka.Message, value []byte) error {
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		if !s.breaker.allow(time.Now()) {
+			sinkDeliveries.WithLabelValues(m.Topic, "rejected").Inc()
+			return errCircuitOpen
+		}
+		err := s.post(ctx, m, value)
+		var perm permanentError
+		if errors.As(err, &perm) {
+			// The webhook is up; it just refuses this event.
+			s.breaker.record(time.Now(), nil)
+		} else {
+			s.breaker.record(time.Now(), err)
+		}
+		if err == nil {
+			sinkDeliveries.WithLabelValues(m.Topic, "delivered").Inc()
+			return nil
+		}
+		if errors.As(err, &perm) || attempt >= s.maxRetries {
+			sinkDeliveries.WithLabelValues(m.Topic, "failed").Inc()
+			return fmt.Errorf("webhook: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s *webhookSink) sign(body []byte) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *webhookSink) post(ctx context.Context, m kafka.Message, value []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Topic", m.Topic)
+	req.Header.Set("X-Event-Partition", strconv.Itoa(m.Partition))
+	req.Header.Set("X-Event-Offset", strconv.FormatInt(m.Offset, 10))
+	if len(s.secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, s.sign(value))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("sink returned %d", resp.StatusCode)
+	}
+	return permanentError{status: resp.StatusCode}
+}
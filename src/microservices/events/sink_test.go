@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// stubWebhook answers with statuses in turn, repeating the last one, and
+// records what it was sent.
+type stubWebhook struct {
+	statuses []int
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func (s *stubWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, string(body))
+	status := s.statuses[len(s.statuses)-1]
+	if n := len(s.requests); n <= len(s.statuses) {
+		status = s.statuses[n-1]
+	}
+	w.WriteHeader(status)
+}
+
+func (s *stubWebhook) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func newTestSink(url string, retries, threshold int) *webhookSink {
+	return &webhookSink{
+		url:        url,
+		secret:     []byte("hook-secret"),
+		client:     &http.Client{Timeout: time.Second},
+		maxRetries: retries,
+		breaker:    &circuitBreaker{threshold: threshold, cooldown: time.Hour},
+	}
+}
+
+func TestWebhookSinkDelivery(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantCalls int
+		wantErr   bool
+		wantPerm  bool
+	}{
+		{"delivered", []int{http.StatusOK}, 1, false, false},
+		{"retried after a 503", []int{http.StatusServiceUnavailable, http.StatusAccepted}, 2, false, false},
+		{"retried after a 429", []int{http.StatusTooManyRequests, http.StatusNoContent}, 2, false, false},
+		{"retries exhausted", []int{http.StatusInternalServerError}, 3, true, false},
+		{"rejected without retrying", []int{http.StatusBadRequest}, 1, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &stubWebhook{statuses: tt.statuses}
+			srv := httptest.NewServer(hook)
+			defer srv.Close()
+			s := newTestSink(srv.URL, 2, 10)
+
+			value := []byte(`{"movie_id":1,"title":"Heat","action":"viewed"}`)
+			err := s.deliver(context.Background(), kafka.Message{Topic: "movie-events", Partition: 2, Offset: 41}, value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			var perm permanentError
+			if got := errors.As(err, &perm); got != tt.wantPerm {
+				t.Errorf("permanent = %v, want %v", got, tt.wantPerm)
+			}
+			if hook.calls() != tt.wantCalls {
+				t.Fatalf("webhook called %d times, want %d", hook.calls(), tt.wantCalls)
+			}
+
+			mac := hmac.New(sha256.New, []byte("hook-secret"))
+			mac.Write(value)
+			wantSignature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+			for i, r := range hook.requests {
+				if hook.bodies[i] != string(value) {
+					t.Errorf("attempt %d body = %s", i, hook.bodies[i])
+				}
+				if got := r.Header.Get(webhookSignatureHeader); got != wantSignature {
+					t.Errorf("attempt %d signature = %q, want %q", i, got, wantSignature)
+				}
+				if r.Header.Get("X-Event-Topic") != "movie-events" || r.Header.Get("X-Event-Partition") != "2" || r.Header.Get("X-Event-Offset") != "41" {
+					t.Errorf("attempt %d event headers = %v", i, r.Header)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookSinkCircuitBreaker(t *testing.T) {
+	hook := &stubWebhook{statuses: []int{http.StatusBadGateway}}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+	s := newTestSink(srv.URL, 0, 2)
+	m := kafka.Message{Topic: "movie-events"}
+
+	for i := 0; i < 2; i++ {
+		if err := s.deliver(context.Background(), m, []byte(`{}`)); err == nil || errors.Is(err, errCircuitOpen) {
+			t.Fatalf("delivery %d: err = %v, want the webhook's failure", i, err)
+		}
+	}
+	if err := s.deliver(context.Background(), m, []byte(`{}`)); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("err = %v, want the breaker open", err)
+	}
+	if hook.calls() != 2 {
+		t.Errorf("webhook called %d times, want no call while the breaker is open", hook.calls())
+	}
+
+	// After the cooldown a single trial goes through and closes the breaker.
+	s.breaker.mu.Lock()
+	s.breaker.openedAt = time.Now().Add(-2 * time.Hour)
+	s.breaker.mu.Unlock()
+	hook.mu.Lock()
+	hook.statuses = []int{http.StatusOK}
+	hook.mu.Unlock()
+	if err := s.deliver(context.Background(), m, []byte(`{}`)); err != nil {
+		t.Fatalf("trial delivery: %v", err)
+	}
+	if !s.breaker.allow(time.Now()) {
+		t.Error("breaker still open after a successful trial")
+	}
+}
+
+func TestConsumerQuarantinesUndeliverable(t *testing.T) {
+	hook := &stubWebhook{statuses: []int{http.StatusInternalServerError}}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+	prevSink, prevQuarantine := sink, quarantine
+	sink = newTestSink(srv.URL, 0, 10)
+	quarantine, _ = newQuarantineStore(10, "")
+	defer func() { sink, quarantine = prevSink, prevQuarantine }()
+
+	r := &fakeReader{msgs: []kafka.Message{
+		{Topic: movieTopic, Offset: 3, Value: []byte(`{"movie_id": 1, "title": "Heat", "action": "viewed"}`)},
+	}}
+	runConsumer(context.Background(), r, consumerConfig{MaxAttempts: 2, ManualCommit: true}, movieTopic, handleMessage)
+
+	if hook.calls() != 2 {
+		t.Errorf("webhook called %d times, want once per consumer attempt", hook.calls())
+	}
+	entries := quarantine.list()
+	if len(entries) != 1 || entries[0].Offset != 3 {
+		t.Fatalf("quarantine = %+v, want the undeliverable message", entries)
+	}
+	if len(r.committed) != 1 {
+		t.Errorf("committed %d messages, want the quarantined one", len(r.committed))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// sourceHeaders are stamped on every produced message when SOURCE_HEADERS is
+// enabled, naming the instance that produced it.
+var sourceHeaders []kafka.Header
+
+func newSourceHeaders(hostname, pod string) []kafka.Header {
+	var headers []kafka.Header
+	if hostname != "" {
+		headers = append(headers, kafka.Header{Key: "source-host", Value: []byte(hostname)})
+	}
+	if pod != "" {
+		headers = append(headers, kafka.Header{Key: "source-pod", Value: []byte(pod)})
+	}
+	return headers
+}
+
+func headerValue(m kafka.Message, key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// describeSource formats the producing instance of m for consumer logs, or
+// returns "" for messages produced without source headers.
+func describeSource(m kafka.Message) string {
+	var parts []string
+	if host := headerValue(m, "source-host"); host != "" {
+		parts = append(parts, "host="+host)
+	}
+	if pod := headerValue(m, "source-pod"); pod != "" {
+		parts = append(parts, "pod="+pod)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (produced by %s)", strings.Join(parts, " "))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSourceHeaders(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		enabled  bool
+		hostname string
+		pod      string
+		wantLog  string
+	}{
+		{"disabled", false, host, "events-7f9", ""},
+		{"host and pod", true, host, "events-7f9", " (produced by host=" + host + " pod=events-7f9)"},
+		{"host only", true, host, "", " (produced by host=" + host + ")"},
+		{"pod only", true, "", "events-7f9", " (produced by pod=events-7f9)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &recordingWriter{}
+			prevWriters, prevSource := topicWriters, sourceHeaders
+			topicWriters, sourceHeaders = map[string]eventWriter{movieTopic: w}, nil
+			defer func() { topicWriters, sourceHeaders = prevWriters, prevSource }()
+			if tt.enabled {
+				sourceHeaders = newSourceHeaders(tt.hostname, tt.pod)
+			}
+
+			rec := serve(handleEvent(movieTopic), jsonRequest(http.MethodPost, "/api/events/movie", `{"movie_id": 1, "title": "Heat", "action": "viewed"}`))
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			m := w.written[0]
+			wantHost, wantPod := "", ""
+			if tt.enabled {
+				wantHost, wantPod = tt.hostname, tt.pod
+			}
+			if got := header(m, "source-host"); got != wantHost {
+				t.Errorf("source-host = %q, want %q", got, wantHost)
+			}
+			if got := header(m, "source-pod"); got != wantPod {
+				t.Errorf("source-pod = %q, want %q", got, wantPod)
+			}
+
+			if got := describeSource(m); got != tt.wantLog {
+				t.Errorf("describeSource = %q, want %q", got, tt.wantLog)
+			}
+			out := &syncBuffer{}
+			log.SetOutput(out)
+			defer log.SetOutput(io.Discard)
+			m.Topic = movieTopic
+			if err := handleMessage(context.Background(), m); err != nil {
+				t.Fatal(err)
+			}
+			if want := "at offset 0" + tt.wantLog + ":"; !strings.Contains(out.String(), want) {
+				t.Errorf("consumer logged %q, want %q", out.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// effectiveConfig records every setting read through getEnv with the value
+// in effect, default included, for the startup summary.
+var effectiveConfig = struct {
+	mu     sync.Mutex
+	values map[string]string
+}{values: make(map[string]string)}
+
+func recordSetting(key, value string) {
+	effectiveConfig.mu.Lock()
+	effectiveConfig.values[key] = value
+	effectiveConfig.mu.Unlock()
+}
+
+// redactSetting hides credentials: the value of any key naming a token,
+// secret or password, and the password of a URL with user info.
+func redactSetting(key, value string) string {
+	if value == "" {
+		return value
+	}
+	for _, word := range []string{"TOKEN", "SECRET", "PASSWORD"} {
+		if strings.Contains(key, word) {
+			return "[redacted]"
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+type startupSummary struct {
+	Service  string            `json:"service"`
+	Version  string            `json:"version"`
+	Listen   string            `json:"listen"`
+	Features []string          `json:"features"`
+	Config   map[string]string `json:"config"`
+}
+
+// logStartupSummary logs the effective configuration as one JSON line.
+func logStartupSummary(listen string, features []string) {
+	effectiveConfig.mu.Lock()
+	config := make(map[string]string, len(effectiveConfig.values))
+	for key, value := range effectiveConfig.values {
+		config[key] = redactSetting(key, value)
+	}
+	effectiveConfig.mu.Unlock()
+
+	if features == nil {
+		features = []string{}
+	}
+	var line strings.Builder
+	enc := json.NewEncoder(&line)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(startupSummary{Service: "events-service", Version: version, Listen: listen, Features: features, Config: config}); err != nil {
+		log.Printf("Failed to encode startup summary: %v", err)
+		return
+	}
+	log.Printf("[STARTUP] %s", strings.TrimSpace(line.String()))
+}
+
+// newHTTPServer builds the events HTTP server with the HTTP_IDLE_TIMEOUT and
+// HTTP_KEEPALIVE settings.
+func newHTTPServer(addr string) (*http.Server, error) {
+	idleTimeout, err := time.ParseDuration(getEnv("HTTP_IDLE_TIMEOUT", "120s"))
+	if err != nil || idleTimeout < 0 {
+		return nil, errors.New("HTTP_IDLE_TIMEOUT: must be a duration such as 90s")
+	}
+	srv := &http.Server{
+		Addr:           addr,
+		IdleTimeout:    idleTimeout,
+		MaxHeaderBytes: maxHeaderBytes,
+	}
+	srv.SetKeepAlivesEnabled(getEnv("HTTP_KEEPALIVE", "true") == "true")
+	return srv, nil
+}
+
+// listenAndServe is srv.ListenAndServe with a callback once the listener is
+// bound, so "ready" is only logged when connections can be accepted.
+func listenAndServe(srv *http.Server, ready func(net.Addr)) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	ready(ln.Addr())
+	return srv.Serve(ln)
+}
+
+// enabledFeatures names the optional behaviour switched on by the
+// configuration, in the order main sets it up.
+func enabledFeatures(cfg consumerConfig, streams, bounded bool) []string {
+	var features []string
+	add := func(name string, on bool) {
+		if on {
+			features = append(features, name)
+		}
+	}
+	add("strict-decoding", strictDecoding)
+	add("compat-field-names", compatFieldNames)
+	add("require-json-content-type", requireJSONContentType)
+	add("auto-create-topics", autoCreateTopics)
+	add("avro", codec != nil)
+	add("protobuf", valueFormat == formatProtobuf)
+	add("source-headers", sourceHeaders != nil)
+	add("admin", adminToken != "")
+	add("destructive-admin", allowDestructiveAdmin)
+	add("manual-commit", cfg.ManualCommit)
+	add("assigned-partitions", len(cfg.AssignedPartitions) > 0)
+	add("header-filter", cfg.HeaderFilter != nil)
+	add("bounded-consume", bounded)
+	add("produce-concurrency-limits", produceLimits != nil)
+	add("heartbeat", getEnv("HEARTBEAT_INTERVAL", "0") != "0")
+	add("produce-dlq", produceDLQ != nil)
+	add("scheduled-events", scheduler != nil)
+	add("quarantine", quarantine != nil)
+	add("consumer-dlq", deadLetterWriter != nil)
+	add("webhook-sink", sink != nil)
+	add("async-produce", buffer != nil)
+	add("stream-pipelines", streams)
+	add("openapi-validation", getEnv("OPENAPI_VALIDATION", "false") == "true")
+	add("raw-produce", getEnv("RAW_PRODUCE_TOPICS", "") != "")
+	add("movie-state-view", getEnv("MOVIE_STATE_VIEW", "false") == "true")
+	add("replay", getEnv("REPLAY_SINK_URL", "") != "")
+	add("grpc-health", getEnv("GRPC_HEALTH_PORT", "") != "")
+	return features
+}
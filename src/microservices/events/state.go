@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type movieStateEntry struct {
+	MovieID   int        `json:"movie_id"`
+	Event     MovieEvent `json:"event"`
+	Partition int        `json:"partition"`
+	Offset    int64      `json:"offset"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// movieStateView is the latest movie event per movie ID, built by reading
+// movie-events from the beginning as a compacted topic. Every instance reads
+// all partitions without a consumer group, so each one holds the full view
+// and rebuilds it on start. A tombstone (a keyed message with a null value)
+// deletes the movie.
+type movieStateView struct {
+	mu     sync.RWMutex
+	latest map[int]movieStateEntry
+}
+
+func newMovieStateView() *movieStateView {
+	return &movieStateView{latest: make(map[int]movieStateEntry)}
+}
+
+// movieKey is the movie ID a message is keyed by, if any.
+func movieKey(m kafka.Message) (int, bool) {
+	id, err := strconv.Atoi(string(m.Key))
+	return id, err == nil
+}
+
+func (v *movieStateView) apply(ctx context.Context, m kafka.Message) error {
+	if m.Value == nil {
+		if id, ok := movieKey(m); ok {
+			v.mu.Lock()
+			delete(v.latest, id)
+			v.mu.Unlock()
+		}
+		return nil
+	}
+
+	value, err := messageValue(ctx, m)
+	if err != nil {
+		return err
+	}
+	decoded, _, err := decodeConsumed(m, value)
+	if err != nil {
+		return err
+	}
+	event, ok := decoded.(*MovieEvent)
+	if !ok {
+		return nil
+	}
+	id := event.MovieID
+	if keyed, ok := movieKey(m); ok {
+		id = keyed
+	}
+	v.mu.Lock()
+	v.latest[id] = movieStateEntry{MovieID: id, Event: *event, Partition: m.Partition, Offset: m.Offset, UpdatedAt: m.Time}
+	v.mu.Unlock()
+	return nil
+}
+
+func (v *movieStateView) get(id int) (movieStateEntry, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	e, ok := v.latest[id]
+	return e, ok
+}
+
+// run reads every partition of topic until ctx is cancelled. A message that
+// cannot be decoded is logged and skipped; it must not stall the view.
+func (v *movieStateView) run(ctx context.Context, src kafkaOffsetSource, brokers []string, topic string, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	parts, err := src.partitions(ctx, []string{topic})
+	if err != nil {
+		log.Printf("Movie state view disabled: list partitions of %s: %v", topic, err)
+		return
+	}
+	handle := func(ctx context.Context, m kafka.Message) error {
+		if err := v.apply(ctx, m); err != nil {
+			log.Printf("Movie state view skipped %s[%d]@%d: %v", m.Topic, m.Partition, m.Offset, err)
+		}
+		return nil
+	}
+	var readers sync.WaitGroup
+	for _, partition := range parts[topic] {
+		readers.Add(1)
+		go func(partition int) {
+			defer readers.Done()
+			r := kafka.NewReader(kafka.ReaderConfig{Brokers: brokers, Topic: topic, Partition: partition, MaxBytes: 10e6})
+			defer r.Close()
+			runConsumer(ctx, r, consumerConfig{MaxAttempts: 1}, topic, handle)
+		}(partition)
+	}
+	log.Printf("Movie state view reading %d partitions of %s", len(parts[topic]), topic)
+	readers.Wait()
+}
+
+func (v *movieStateView) handleGet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Movie ID must be an integer", http.StatusBadRequest)
+		return
+	}
+	entry, ok := v.get(id)
+	if !ok {
+		http.Error(w, "No state for movie", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, entry)
+}
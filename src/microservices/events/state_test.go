@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestMovieStateView(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	movie := func(offset int64, key, value string) kafka.Message {
+		m := kafka.Message{Topic: movieTopic, Partition: 1, Offset: offset, Time: at.Add(time.Duration(offset) * time.Second)}
+		if key != "" {
+			m.Key = []byte(key)
+		}
+		if value != "" {
+			m.Value = []byte(value)
+		}
+		return m
+	}
+	msgs := []kafka.Message{
+		movie(0, "1", `{"movie_id": 1, "title": "Heat", "action": "created"}`),
+		movie(1, "2", `{"movie_id": 2, "title": "Ran", "action": "created"}`),
+		movie(2, "1", `{"movie_id": 1, "title": "Heat", "action": "rated"}`),
+		movie(3, "2", ""),
+		movie(4, "3", `{"movie_id": 3, "title": "Alien", "action": "created"}`),
+		// Unkeyed events fall back to the movie ID in the payload.
+		movie(5, "", `{"movie_id": 4, "title": "Solaris", "action": "created"}`),
+		// A tombstone for a movie that was never seen changes nothing.
+		movie(6, "9", ""),
+		movie(7, "3", `{"movie_id": 3, "title": "Aliens", "action": "renamed"}`),
+	}
+	v := newMovieStateView()
+	r := &fakeReader{msgs: msgs}
+	runConsumer(context.Background(), r, consumerConfig{MaxAttempts: 1}, movieTopic, v.apply)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/events/state/movie/{id}", v.handleGet)
+	tests := []struct {
+		id         string
+		wantStatus int
+		wantAction string
+		wantTitle  string
+		wantOffset int64
+	}{
+		{"1", http.StatusOK, "rated", "Heat", 2},
+		{"2", http.StatusNotFound, "", "", 0},
+		{"3", http.StatusOK, "renamed", "Aliens", 7},
+		{"4", http.StatusOK, "created", "Solaris", 5},
+		{"9", http.StatusNotFound, "", "", 0},
+		{"abc", http.StatusBadRequest, "", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			rec := serve(mux, httptest.NewRequest(http.MethodGet, "/api/events/state/movie/"+tt.id, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got movieStateEntry
+			decodeJSON(t, rec, &got)
+			if got.Event.Action != tt.wantAction || got.Event.Title != tt.wantTitle || got.Offset != tt.wantOffset || got.Partition != 1 {
+				t.Errorf("state = %+v", got)
+			}
+			if want := at.Add(time.Duration(tt.wantOffset) * time.Second); !got.UpdatedAt.Equal(want) {
+				t.Errorf("updated_at = %s, want %s", got.UpdatedAt, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// streamTransform turns one consumed message into the derived messages to
+// produce. Returning no messages skips the input. A derived message goes to
+// the pipeline's output topic unless the transform sets Topic to another
+// base topic name. Transform errors are logged
+// and the input is skipped, since retrying the same payload cannot succeed.
+type streamTransform func(ctx context.Context, m kafka.Message) ([]kafka.Message, error)
+
+// streamPipeline reads a source topic, applies a transform and writes the
+// results in a Kafka transaction that also commits the consumed offsets, so
+// a crash between the produce and the commit aborts both: read_committed
+// consumers never see the derived messages of a source message that will be
+// processed again. Every derived message still carries its source
+// coordinates in its headers, and as its key unless the transform set one.
+type streamPipeline struct {
+	name   string
+	source string
+	output string
+	fn     streamTransform
+}
+
+// streamPipelines are the pipelines selectable through STREAM_PIPELINES.
+var streamPipelines = map[string]streamPipeline{
+	"movie-stats": {name: "movie-stats", source: movieTopic, output: "movie-stats", fn: movieStats},
+	"revenue":     {name: "revenue", source: paymentTopic, output: "revenue-events", fn: revenueEvents},
+}
+
+// transactSession is the subset of *kgo.GroupTransactSession used by
+// pipelines. End commits the offsets of everything polled since the last End
+// together with the records produced since Begin, or aborts both and rewinds
+// to the last committed offsets.
+type transactSession interface {
+	PollFetches(ctx context.Context) kgo.Fetches
+	Begin() error
+	ProduceSync(ctx context.Context, rs ...*kgo.Record) kgo.ProduceResults
+	End(ctx context.Context, commit kgo.TransactionEndTry) (committed bool, err error)
+}
+
+// Backoff between attempts of a pipeline transaction that aborted because
+// its produce failed.
+var (
+	pipelineRetryBackoff = time.Second
+	pipelineMaxBackoff   = 30 * time.Second
+)
+
+var pipelineFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "stream_pipeline_failures_total",
+	Help: "Stream pipeline transactions aborted because producing the derived messages failed.",
+}, []string{"pipeline"})
+
+// stalled holds the pipelines whose last transaction failed, with the
+// failure. A stalled pipeline makes the service unready until it commits
+// again.
+var stalled = struct {
+	mu        sync.Mutex
+	pipelines map[string]string
+}{pipelines: make(map[string]string)}
+
+func stallPipeline(p streamPipeline, err error) {
+	stalled.mu.Lock()
+	stalled.pipelines[p.name] = err.Error()
+	stalled.mu.Unlock()
+}
+
+func resumePipeline(p streamPipeline) {
+	stalled.mu.Lock()
+	delete(stalled.pipelines, p.name)
+	stalled.mu.Unlock()
+}
+
+// stalledPipelines maps each stalled pipeline to the failure that stalled it.
+func stalledPipelines() map[string]string {
+	stalled.mu.Lock()
+	defer stalled.mu.Unlock()
+	out := make(map[string]string, len(stalled.pipelines))
+	for name, reason := range stalled.pipelines {
+		out[name] = reason
+	}
+	return out
+}
+
+func parseStreamPipelines(value string) ([]streamPipeline, error) {
+	var pipelines []streamPipeline
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		p, ok := streamPipelines[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown pipeline %q", name)
+		}
+		pipelines = append(pipelines, p)
+	}
+	return pipelines, nil
+}
+
+// sourceKey identifies the consumed message a derived message came from.
+func sourceKey(m kafka.Message) string {
+	return fmt.Sprintf("%s/%d/%d", m.Topic, m.Partition, m.Offset)
+}
+
+func runStream(ctx context.Context, cfg consumerConfig, p streamPipeline, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	// Each pipeline has its own group so it tracks offsets independently of
+	// the logging consumers.
+	cfg.GroupID += "-stream-" + p.name
+	s, err := newTransactSession(cfg, topicName(p.source))
+	if err != nil {
+		log.Printf("Stream pipeline %s not started: %v", p.name, err)
+		stallPipeline(p, err)
+		return
+	}
+	defer s.Close()
+
+	log.Printf("Stream pipeline %s started: %s -> %s", p.name, topicName(p.source), topicName(p.output))
+	runPipeline(ctx, s, p)
+}
+
+// newTransactSession joins cfg.GroupID on topic. The transactional ID only
+// has to be unique per instance: the group generation fences zombies
+// (KIP-447), which RequireStableFetchOffsets relies on.
+func newTransactSession(cfg consumerConfig, topic string) (*kgo.GroupTransactSession, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	opts := append(groupOptions(cfg, topic),
+		kgo.TransactionalID(cfg.GroupID+"-"+host),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+		kgo.RequireStableFetchOffsets(),
+	)
+	return kgo.NewGroupTransactSession(opts...)
+}
+
+// runPipeline processes one polled batch per transaction until ctx is
+// cancelled. A transaction whose produce fails is aborted, which rewinds the
+// session to the committed offsets, and is retried with backoff while the
+// pipeline is marked stalled. An error ending the transaction leaves the
+// session unusable, so the pipeline stops and stays stalled.
+func runPipeline(ctx context.Context, s transactSession, p streamPipeline) {
+	backoff := pipelineRetryBackoff
+	for {
+		fetches := s.PollFetches(ctx)
+		if ctx.Err() != nil || fetches.IsClientClosed() {
+			log.Printf("Stream pipeline %s stopped", p.name)
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			log.Printf("Error reading %s/%d for pipeline %s: %v", topic, partition, p.name, err)
+		})
+		if fetches.NumRecords() == 0 {
+			continue
+		}
+
+		if err := s.Begin(); err != nil {
+			log.Printf("Stream pipeline %s failed to begin a transaction: %v", p.name, err)
+			stallPipeline(p, err)
+			return
+		}
+		workCtx := context.WithoutCancel(ctx)
+		var produceErr error
+		fetches.EachRecord(func(r *kgo.Record) {
+			if produceErr != nil {
+				return
+			}
+			m := recordMessage(r)
+			out, err := p.fn(workCtx, m)
+			if err != nil {
+				log.Printf("Stream pipeline %s skipped %s: %v", p.name, sourceKey(m), err)
+				return
+			}
+			if err := produceDerived(workCtx, s, p, m, out); err != nil {
+				produceErr = fmt.Errorf("%s: %w", sourceKey(m), err)
+			}
+		})
+
+		committed, err := s.End(workCtx, kgo.TransactionEndTry(produceErr == nil))
+		switch {
+		case err != nil:
+			log.Printf("Stream pipeline %s stopped: ending the transaction failed: %v", p.name, err)
+			pipelineFailures.WithLabelValues(p.name).Inc()
+			stallPipeline(p, err)
+			return
+		case produceErr != nil:
+			log.Printf("Stream pipeline %s aborted its transaction, retrying in %s: %v", p.name, backoff, produceErr)
+			pipelineFailures.WithLabelValues(p.name).Inc()
+			stallPipeline(p, produceErr)
+			select {
+			case <-ctx.Done():
+				log.Printf("Stream pipeline %s stopped", p.name)
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, pipelineMaxBackoff)
+		case !committed:
+			// A rebalance aborted the transaction; the new owner of the
+			// partitions processes the batch again.
+			log.Printf("Stream pipeline %s aborted its transaction after a rebalance", p.name)
+		default:
+			resumePipeline(p)
+			backoff = pipelineRetryBackoff
+		}
+	}
+}
+
+// recordMessage converts a polled record for the transform.
+func recordMessage(r *kgo.Record) kafka.Message {
+	m := kafka.Message{
+		Topic:     r.Topic,
+		Partition: int(r.Partition),
+		Offset:    r.Offset,
+		Key:       r.Key,
+		Value:     r.Value,
+		Time:      r.Timestamp,
+	}
+	for _, h := range r.Headers {
+		m.Headers = append(m.Headers, kafka.Header{Key: h.Key, Value: h.Value})
+	}
+	return m
+}
+
+func produceDerived(ctx context.Context, s transactSession, p streamPipeline, m kafka.Message, out []kafka.Message) error {
+	if len(out) == 0 {
+		return nil
+	}
+
+	key := sourceKey(m)
+	records := make([]*kgo.Record, len(out))
+	for i, o := range out {
+		if o.Topic == "" {
+			o.Topic = p.output
+		}
+		if o.Key == nil {
+			o.Key = []byte(key)
+		}
+		r := &kgo.Record{Topic: topicName(o.Topic), Key: o.Key, Value: o.Value}
+		for _, h := range o.Headers {
+			r.Headers = append(r.Headers, kgo.RecordHeader{Key: h.Key, Value: h.Value})
+		}
+		r.Headers = append(r.Headers,
+			kgo.RecordHeader{Key: "source-topic", Value: []byte(m.Topic)},
+			kgo.RecordHeader{Key: "source-partition", Value: []byte(strconv.Itoa(m.Partition))},
+			kgo.RecordHeader{Key: "source-offset", Value: []byte(strconv.FormatInt(m.Offset, 10))},
+		)
+		records[i] = r
+	}
+	if err := s.ProduceSync(ctx, records...).FirstErr(); err != nil {
+		return fmt.Errorf("produce derived messages of %s: %w", p.name, err)
+	}
+	return nil
+}
+
+// MovieStatsEvent is the derived per-action counter increment emitted for
+// every movie event.
+type MovieStatsEvent struct {
+	MovieID    int    `json:"movie_id"`
+	Action     string `json:"action"`
+	Count      int    `json:"count"`
+	ObservedAt int64  `json:"observed_at"`
+}
+
+func movieStats(ctx context.Context, m kafka.Message) ([]kafka.Message, error) {
+	var event MovieEvent
+	if err := decodeSource(ctx, m, &event); err != nil {
+		return nil, err
+	}
+	if event.MovieID == 0 {
+		return nil, nil
+	}
+
+	stats, err := json.Marshal(MovieStatsEvent{
+		MovieID:    event.MovieID,
+		Action:     event.Action,
+		Count:      1,
+		ObservedAt: m.Time.UnixMilli(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []kafka.Message{{Value: stats}}, nil
+}
+
+// decodeSource decodes a consumed JSON or Avro payload into v.
+func decodeSource(ctx context.Context, m kafka.Message, v interface{}) error {
+	value, err := messageValue(ctx, m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(value, v)
+}
+
+// RevenueEvent is emitted once for every completed payment.
+type RevenueEvent struct {
+	PaymentID  int     `json:"payment_id"`
+	UserID     int     `json:"user_id"`
+	Amount     float64 `json:"amount"`
+	RecordedAt int64   `json:"recorded_at"`
+}
+
+func revenueEvents(ctx context.Context, m kafka.Message) ([]kafka.Message, error) {
+	var payment PaymentEvent
+	if err := decodeSource(ctx, m, &payment); err != nil {
+		return nil, err
+	}
+	if payment.Status != "completed" {
+		return nil, nil
+	}
+
+	revenue, err := json.Marshal(RevenueEvent{
+		PaymentID:  payment.PaymentID,
+		UserID:     payment.UserID,
+		Amount:     payment.Amount,
+		RecordedAt: m.Time.UnixMilli(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Keyed by payment so a redelivered payment can be recognized downstream.
+	return []kafka.Message{{Key: []byte(strconv.Itoa(payment.PaymentID)), Value: revenue}}, nil
+}
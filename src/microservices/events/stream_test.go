@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// fakeWriter records the messages written to it, or fails with err.
+type fakeWriter struct {
+	mu      sync.Mutex
+	err     error
+	written []kafka.Message
+}
+
+func (f *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.written = append(f.written, msgs...)
+	return nil
+}
+
+func header(m kafka.Message, key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func TestStreamTransforms(t *testing.T) {
+	observed := time.UnixMilli(1700000000000)
+	tests := []struct {
+		name    string
+		fn      streamTransform
+		value   string
+		wantKey string
+		want    string
+		wantErr bool
+	}{
+		{"movie stats", movieStats, `{"movie_id":7,"title":"Heat","action":"viewed"}`, "", `{"movie_id":7,"action":"viewed","count":1,"observed_at":1700000000000}`, false},
+		{"movie without id skipped", movieStats, `{"title":"Heat","action":"viewed"}`, "", "", false},
+		{"completed payment", revenueEvents, `{"payment_id":3,"user_id":9,"amount":12.5,"status":"completed"}`, "3", `{"payment_id":3,"user_id":9,"amount":12.5,"recorded_at":1700000000000}`, false},
+		{"pending payment skipped", revenueEvents, `{"payment_id":3,"user_id":9,"amount":12.5,"status":"pending"}`, "", "", false},
+		{"malformed", movieStats, `{"movie_id":`, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.fn(context.Background(), kafka.Message{Value: []byte(tt.value), Time: observed})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.want == "" {
+				if len(out) != 0 {
+					t.Fatalf("got %d messages, want none", len(out))
+				}
+				return
+			}
+			if len(out) != 1 {
+				t.Fatalf("got %d messages, want 1", len(out))
+			}
+			if string(out[0].Value) != tt.want || string(out[0].Key) != tt.wantKey {
+				t.Errorf("got key %q value %s, want key %q value %s", out[0].Key, out[0].Value, tt.wantKey, tt.want)
+			}
+		})
+	}
+}
+
+// txnBroker holds what the cluster keeps for a transactional pipeline
+// across restarts: the source partition, the group's committed position and
+// the committed output.
+type txnBroker struct {
+	source    []kafka.Message
+	committed int
+	output    []*kgo.Record
+}
+
+var errKilled = errors.New("killed")
+
+// fakeSession is one pipeline process's transact session on b. It polls one
+// message per batch and rewinds on abort like kgo. Each produce fails with
+// the next of produceErrs, and with crash set the process dies instead of
+// ending its first transaction.
+type fakeSession struct {
+	b           *txnBroker
+	pos         int
+	pending     []*kgo.Record
+	produceErrs []error
+	endErr      error
+	crash       bool
+}
+
+func (f *fakeSession) PollFetches(ctx context.Context) kgo.Fetches {
+	if f.pos >= len(f.b.source) {
+		return kgo.Fetches{{Topics: []kgo.FetchTopic{{Partitions: []kgo.FetchPartition{{Err: kgo.ErrClientClosed}}}}}}
+	}
+	m := f.b.source[f.pos]
+	f.pos++
+	r := &kgo.Record{Topic: m.Topic, Partition: int32(m.Partition), Offset: m.Offset, Value: m.Value, Timestamp: m.Time}
+	return kgo.Fetches{{Topics: []kgo.FetchTopic{{Topic: m.Topic, Partitions: []kgo.FetchPartition{{Partition: int32(m.Partition), Records: []*kgo.Record{r}}}}}}}
+}
+
+func (f *fakeSession) Begin() error { return nil }
+
+func (f *fakeSession) ProduceSync(ctx context.Context, rs ...*kgo.Record) kgo.ProduceResults {
+	var err error
+	if len(f.produceErrs) > 0 {
+		err, f.produceErrs = f.produceErrs[0], f.produceErrs[1:]
+	}
+	if err == nil {
+		f.pending = append(f.pending, rs...)
+	}
+	results := make(kgo.ProduceResults, len(rs))
+	for i, r := range rs {
+		results[i] = kgo.ProduceResult{Record: r, Err: err}
+	}
+	return results
+}
+
+func (f *fakeSession) End(ctx context.Context, commit kgo.TransactionEndTry) (bool, error) {
+	pending := f.pending
+	f.pending = nil
+	switch {
+	case f.crash:
+		return false, errKilled
+	case f.endErr != nil:
+		return false, f.endErr
+	case commit == kgo.TryAbort:
+		f.pos = f.b.committed
+		return false, nil
+	}
+	f.b.output = append(f.b.output, pending...)
+	f.b.committed = f.pos
+	return true, nil
+}
+
+func recordHeader(r *kgo.Record, key string) string {
+	for _, h := range r.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// resetPipelineState clears stalled pipelines and shortens the retry backoff
+// for the test.
+func resetPipelineState(t *testing.T) {
+	prev := pipelineRetryBackoff
+	pipelineRetryBackoff = time.Millisecond
+	t.Cleanup(func() {
+		pipelineRetryBackoff = prev
+		stalled.mu.Lock()
+		stalled.pipelines = make(map[string]string)
+		stalled.mu.Unlock()
+	})
+}
+
+func TestRunPipeline(t *testing.T) {
+	source := kafka.Message{Topic: movieTopic, Partition: 2, Offset: 41, Value: []byte(`{"movie_id":7,"action":"viewed"}`)}
+	skipped := kafka.Message{Topic: movieTopic, Partition: 2, Offset: 42, Value: []byte(`{"action":"viewed"}`)}
+	tests := []struct {
+		name          string
+		produceErrs   []error
+		endErr        error
+		wantOutput    int
+		wantCommitted int
+		wantFailures  float64
+		wantStalled   bool
+	}{
+		{"derived then committed", nil, nil, 1, 2, 0, false},
+		// An aborted transaction rewinds to the committed offset, so the
+		// message is processed again and produced once.
+		{"produce failure is retried", []error{errors.New("broker down"), errors.New("broker down")}, nil, 1, 2, 2, false},
+		// The session is unusable after End fails: nothing is committed and
+		// the pipeline stays stalled so the service reports unready.
+		{"end failure stalls", nil, errors.New("producer fenced"), 0, 0, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetPipelineState(t)
+			p := streamPipelines["movie-stats"]
+			counter := pipelineFailures.WithLabelValues(p.name)
+			before := testutil.ToFloat64(counter)
+
+			b := &txnBroker{source: []kafka.Message{source, skipped}}
+			runPipeline(context.Background(), &fakeSession{b: b, produceErrs: tt.produceErrs, endErr: tt.endErr}, p)
+			if len(b.output) != tt.wantOutput {
+				t.Fatalf("committed %d derived messages, want %d", len(b.output), tt.wantOutput)
+			}
+			if b.committed != tt.wantCommitted {
+				t.Errorf("committed %d source messages, want %d", b.committed, tt.wantCommitted)
+			}
+			if got := testutil.ToFloat64(counter) - before; got != tt.wantFailures {
+				t.Errorf("failures = %v, want %v", got, tt.wantFailures)
+			}
+			if _, ok := stalledPipelines()[p.name]; ok != tt.wantStalled {
+				t.Errorf("stalled = %v, want %v", ok, tt.wantStalled)
+			}
+		})
+	}
+}
+
+// A crash after the produce but before the commit aborts the transaction, so
+// when the restarted pipeline processes the source message again the
+// derived event is committed exactly once.
+func TestRunPipelineCrashBeforeCommit(t *testing.T) {
+	resetPipelineState(t)
+	withTopicPrefix(t, "staging.")
+	b := &txnBroker{source: []kafka.Message{{Topic: "staging." + movieTopic, Partition: 1, Offset: 10, Value: []byte(`{"movie_id":7,"action":"viewed"}`)}}}
+
+	runPipeline(context.Background(), &fakeSession{b: b, crash: true}, streamPipelines["movie-stats"])
+	if len(b.output) != 0 || b.committed != 0 {
+		t.Fatalf("crashed run committed %d derived messages at position %d, want none", len(b.output), b.committed)
+	}
+	runPipeline(context.Background(), &fakeSession{b: b}, streamPipelines["movie-stats"])
+	if len(b.output) != 1 {
+		t.Fatalf("committed %d derived messages, want 1", len(b.output))
+	}
+
+	out := b.output[0]
+	tests := []struct {
+		field string
+		got   string
+		want  string
+	}{
+		{"topic", out.Topic, "staging.movie-stats"},
+		{"key", string(out.Key), "staging.movie-events/1/10"},
+		{"source-topic", recordHeader(out, "source-topic"), "staging.movie-events"},
+		{"source-partition", recordHeader(out, "source-partition"), "1"},
+		{"source-offset", recordHeader(out, "source-offset"), "10"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.field, tt.got, tt.want)
+		}
+	}
+}
+
+func TestParseStreamPipelines(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"movie-stats", []string{"movie-stats"}, false},
+		{" movie-stats , revenue ,", []string{"movie-stats", "revenue"}, false},
+		{"movie-stats,unknown", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseStreamPipelines(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseStreamPipelines(%q) err = %v, want error %v", tt.value, err, tt.wantErr)
+		}
+		var names []string
+		for _, p := range got {
+			names = append(names, p.name)
+		}
+		if len(names) != len(tt.want) {
+			t.Fatalf("parseStreamPipelines(%q) = %v, want %v", tt.value, names, tt.want)
+		}
+		for i := range names {
+			if names[i] != tt.want[i] {
+				t.Fatalf("parseStreamPipelines(%q) = %v, want %v", tt.value, names, tt.want)
+			}
+		}
+	}
+}
+
+func TestRevenuePipeline(t *testing.T) {
+	payment := func(offset int64, id int, status string) kafka.Message {
+		return kafka.Message{
+			Topic:  paymentTopic,
+			Offset: offset,
+			Time:   time.UnixMilli(1700000000000),
+			Value:  []byte(fmt.Sprintf(`{"payment_id":%d,"user_id":9,"amount":12.5,"status":%q}`, id, status)),
+		}
+	}
+	tests := []struct {
+		name        string
+		msgs        []kafka.Message
+		wantPayment []string
+	}{
+		{"completed payment", []kafka.Message{payment(1, 3, "completed")}, []string{"3"}},
+		{"failed payment", []kafka.Message{payment(1, 3, "failed")}, nil},
+		{"mixed", []kafka.Message{payment(1, 3, "completed"), payment(2, 4, "failed"), payment(3, 5, "pending"), payment(4, 6, "completed")}, []string{"3", "6"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetPipelineState(t)
+			b := &txnBroker{source: tt.msgs}
+			runPipeline(context.Background(), &fakeSession{b: b}, streamPipelines["revenue"])
+			if b.committed != len(tt.msgs) {
+				t.Fatalf("committed %d messages, want %d", b.committed, len(tt.msgs))
+			}
+			if len(b.output) != len(tt.wantPayment) {
+				t.Fatalf("wrote %d revenue events, want %d", len(b.output), len(tt.wantPayment))
+			}
+			for i, r := range b.output {
+				var revenue RevenueEvent
+				if err := json.Unmarshal(r.Value, &revenue); err != nil {
+					t.Fatal(err)
+				}
+				if r.Topic != "revenue-events" || string(r.Key) != tt.wantPayment[i] || fmt.Sprint(revenue.PaymentID) != tt.wantPayment[i] || revenue.Amount != 12.5 {
+					t.Errorf("revenue event %d = %s key %q: %+v", i, r.Topic, r.Key, revenue)
+				}
+				if recordHeader(r, "source-topic") != paymentTopic {
+					t.Errorf("revenue event %d source-topic = %q", i, recordHeader(r, "source-topic"))
+				}
+			}
+		})
+	}
+}
+
+func TestRunPipelineFanOut(t *testing.T) {
+	// A transform may emit several messages, each to its own base topic.
+	fanOut := streamPipeline{name: "fan-out", source: movieTopic, output: "movie-stats", fn: func(ctx context.Context, m kafka.Message) ([]kafka.Message, error) {
+		return []kafka.Message{{Value: []byte("stats")}, {Topic: "movie-audit", Value: []byte("audit")}}, nil
+	}}
+	resetPipelineState(t)
+	withTopicPrefix(t, "staging.")
+	b := &txnBroker{source: []kafka.Message{{Topic: "staging." + movieTopic, Offset: 5, Value: []byte(`{}`)}}}
+	runPipeline(context.Background(), &fakeSession{b: b}, fanOut)
+
+	want := map[string]string{"stats": "staging.movie-stats", "audit": "staging.movie-audit"}
+	if len(b.output) != len(want) {
+		t.Fatalf("wrote %d messages, want %d", len(b.output), len(want))
+	}
+	for _, r := range b.output {
+		if r.Topic != want[string(r.Value)] {
+			t.Errorf("%s went to %s, want %s", r.Value, r.Topic, want[string(r.Value)])
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rateWindow counts messages per topic in one-second buckets and reports the
+// average rate over the last window seconds. Only completed seconds count,
+// so a steady stream reads the same whenever it is scraped.
+type rateWindow struct {
+	window int64
+
+	mu     sync.Mutex
+	topics map[string]*rateBuckets
+}
+
+type rateBuckets struct {
+	counts []int64
+	// seconds holds the Unix second each bucket is counting.
+	seconds []int64
+}
+
+// producedRate and consumedRate are nil until main sets them up, and add is
+// safe to call on nil.
+var producedRate, consumedRate *rateWindow
+
+func newRateWindow(window time.Duration) *rateWindow {
+	return &rateWindow{window: int64(window / time.Second), topics: make(map[string]*rateBuckets)}
+}
+
+func (w *rateWindow) add(topic string, n int, now time.Time) {
+	if w == nil {
+		return
+	}
+	sec := now.Unix()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b, ok := w.topics[topic]
+	if !ok {
+		b = &rateBuckets{counts: make([]int64, w.window+1), seconds: make([]int64, w.window+1)}
+		w.topics[topic] = b
+	}
+	i := sec % int64(len(b.counts))
+	if b.seconds[i] != sec {
+		b.seconds[i], b.counts[i] = sec, 0
+	}
+	b.counts[i] += int64(n)
+}
+
+// rates returns messages per second for every topic seen so far.
+func (w *rateWindow) rates(now time.Time) map[string]float64 {
+	current := now.Unix()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make(map[string]float64, len(w.topics))
+	for topic, b := range w.topics {
+		var total int64
+		for i, sec := range b.seconds {
+			if sec < current && sec >= current-w.window {
+				total += b.counts[i]
+			}
+		}
+		out[topic] = float64(total) / float64(w.window)
+	}
+	return out
+}
+
+// throughputCollector exposes both windows as gauges computed at scrape time.
+type throughputCollector struct {
+	produced, consumed         *rateWindow
+	producedDesc, consumedDesc *prometheus.Desc
+}
+
+func newThroughputCollector(produced, consumed *rateWindow) *throughputCollector {
+	return &throughputCollector{
+		produced:     produced,
+		consumed:     consumed,
+		producedDesc: prometheus.NewDesc("events_produce_rate_per_second", "Messages written to Kafka per second over THROUGHPUT_WINDOW, by topic.", []string{"topic"}, nil),
+		consumedDesc: prometheus.NewDesc("events_consume_rate_per_second", "Messages consumed per second over THROUGHPUT_WINDOW, by topic.", []string{"topic"}, nil),
+	}
+}
+
+func (c *throughputCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.producedDesc
+	ch <- c.consumedDesc
+}
+
+func (c *throughputCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for topic, rate := range c.produced.rates(now) {
+		ch <- prometheus.MustNewConstMetric(c.producedDesc, prometheus.GaugeValue, rate, topic)
+	}
+	for topic, rate := range c.consumed.rates(now) {
+		ch <- prometheus.MustNewConstMetric(c.consumedDesc, prometheus.GaugeValue, rate, topic)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestRateWindow(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		perSec  int
+		seconds int
+		at      time.Duration // when rates are read, after start
+		want    float64
+	}{
+		{"steady cadence", 5, 10, 10 * time.Second, 5},
+		{"steady cadence read mid-second", 5, 10, 10*time.Second + 700*time.Millisecond, 5},
+		{"half the window", 4, 5, 5 * time.Second, 2},
+		{"current second not counted", 5, 1, 500 * time.Millisecond, 0},
+		{"stream stopped long ago", 5, 10, 30 * time.Second, 0},
+		{"stream stopped partway through the window", 6, 10, 15 * time.Second, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newRateWindow(10 * time.Second)
+			// perSec messages spread evenly over each of the seconds.
+			for s := 0; s < tt.seconds; s++ {
+				for i := 0; i < tt.perSec; i++ {
+					at := start.Add(time.Duration(s)*time.Second + time.Duration(i)*time.Second/time.Duration(tt.perSec))
+					w.add(movieTopic, 1, at)
+				}
+			}
+			w.add(userTopic, 1, start)
+			got := w.rates(start.Add(tt.at))
+			if math.Abs(got[movieTopic]-tt.want) > 1e-9 {
+				t.Errorf("rate = %v, want %v", got[movieTopic], tt.want)
+			}
+			if _, ok := got[userTopic]; !ok {
+				t.Error("topic seen once missing from the rates")
+			}
+		})
+	}
+	var nilWindow *rateWindow
+	nilWindow.add(movieTopic, 1, start)
+}
+
+func TestThroughputGauges(t *testing.T) {
+	prevProduced, prevConsumed := producedRate, consumedRate
+	producedRate, consumedRate = newRateWindow(2*time.Second), newRateWindow(2*time.Second)
+	defer func() { producedRate, consumedRate = prevProduced, prevConsumed }()
+
+	// Every successful produce and every consumed message is counted.
+	writeCompleted([]kafka.Message{{Topic: paymentTopic}, {Topic: paymentTopic}}, nil)
+	writeCompleted([]kafka.Message{{Topic: paymentTopic}}, context.DeadlineExceeded)
+	if err := handleMessage(context.Background(), kafka.Message{Topic: movieTopic, Value: []byte(`{"movie_id": 7, "title": "Heat", "action": "viewed"}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only completed seconds count, so scrape once the current one is over.
+	// The window covers two seconds, enough for the writes above even if they
+	// straddled a second boundary.
+	next := time.Now().Truncate(time.Second).Add(time.Second)
+	time.Sleep(time.Until(next) + 50*time.Millisecond)
+	want := `
+# HELP events_consume_rate_per_second Messages consumed per second over THROUGHPUT_WINDOW, by topic.
+# TYPE events_consume_rate_per_second gauge
+events_consume_rate_per_second{topic="movie-events"} 0.5
+# HELP events_produce_rate_per_second Messages written to Kafka per second over THROUGHPUT_WINDOW, by topic.
+# TYPE events_produce_rate_per_second gauge
+events_produce_rate_per_second{topic="payment-events"} 1
+`
+	if err := testutil.CollectAndCompare(newThroughputCollector(producedRate, consumedRate), strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// withTopicPrefix sets KAFKA_TOPIC_PREFIX for the rest of the test.
+func withTopicPrefix(t *testing.T, prefix string) {
+	prev := topicPrefix
+	topicPrefix = prefix
+	t.Cleanup(func() { topicPrefix = prev })
+}
+
+func TestTopicPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		base   string
+		want   string
+	}{
+		{"", movieTopic, "movie-events"},
+		{"staging.", movieTopic, "staging.movie-events"},
+		{"staging.", userTopic, "staging.user-events"},
+		{"prod-", paymentTopic, "prod-payment-events"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			withTopicPrefix(t, tt.prefix)
+			if got := topicName(tt.base); got != tt.want {
+				t.Fatalf("topicName(%q) = %q, want %q", tt.base, got, tt.want)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/events/movie", nil)
+			event, err := newEvent(tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err := newEventMessage(r, tt.base, event, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Topic != tt.want {
+				t.Fatalf("produced to %q, want %q", msg.Topic, tt.want)
+			}
+
+			if got := newReaderConfig(consumerConfig{Brokers: []string{"kafka:9092"}}, topicName(tt.base)).Topic; got != tt.want {
+				t.Fatalf("consuming %q, want %q", got, tt.want)
+			}
+			if consumed, _, err := decodeConsumed(kafka.Message{Topic: tt.want}, []byte(`{}`)); err != nil || consumed == nil {
+				t.Fatalf("message from %q not decoded as a service event: %v, %v", tt.want, consumed, err)
+			}
+		})
+	}
+}
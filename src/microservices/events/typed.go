@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// errConsumerStopped is returned by a handler that could not hand a message
+// over because its consumer is shutting down. The message is neither
+// committed nor quarantined, so it is redelivered on the next start.
+var errConsumerStopped = errors.New("consumer stopped")
+
+// Consumer decodes the service's topics into their typed events and delivers
+// them on channels for in-process code to range over:
+//
+//	c := NewConsumer(cfg, 16)
+//	go c.Run(ctx)
+//	for e := range c.Movies() { ... }
+//
+// Payloads go through the same Avro, protobuf and lenient decoding as
+// the logging consumer. Sends block, so a slow reader slows consumption
+// rather than losing events; with ManualCommit a message is committed only
+// once it has been received. Channels are closed when Run returns.
+type Consumer struct {
+	cfg consumerConfig
+
+	movies   chan MovieEvent
+	users    chan UserEvent
+	payments chan PaymentEvent
+	stop     chan struct{}
+}
+
+// NewConsumer returns a consumer with channels of the given buffer size. It
+// reads with its own consumer group, derived from cfg.GroupID, so it sees
+// every message independently of the service's logging consumers.
+func NewConsumer(cfg consumerConfig, buffer int) *Consumer {
+	cfg.GroupID += "-typed"
+	return &Consumer{
+		cfg:      cfg,
+		movies:   make(chan MovieEvent, buffer),
+		users:    make(chan UserEvent, buffer),
+		payments: make(chan PaymentEvent, buffer),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (c *Consumer) Movies() <-chan MovieEvent     { return c.movies }
+func (c *Consumer) Users() <-chan UserEvent       { return c.users }
+func (c *Consumer) Payments() <-chan PaymentEvent { return c.payments }
+
+// Run consumes every service topic until ctx is cancelled.
+func (c *Consumer) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		close(c.stop)
+	}()
+
+	var wg sync.WaitGroup
+	for _, base := range []string{movieTopic, userTopic, paymentTopic} {
+		wg.Add(1)
+		go func(topic string) {
+			defer wg.Done()
+			r, err := newGroupReader(c.cfg, topic)
+			if err != nil {
+				log.Printf("Failed to start typed consumer for topic %s: %v", topic, err)
+				return
+			}
+			defer r.Close()
+			runConsumer(ctx, r, c.cfg, topic, c.handle)
+		}(topicName(base))
+	}
+	wg.Wait()
+
+	close(c.movies)
+	close(c.users)
+	close(c.payments)
+	log.Printf("Typed consumer stopped")
+}
+
+func (c *Consumer) handle(ctx context.Context, m kafka.Message) error {
+	if err := checkExpiry(m, time.Now()); err != nil {
+		return err
+	}
+	value, err := messageValue(ctx, m)
+	if err != nil {
+		return err
+	}
+	event, _, err := decodeConsumed(m, value)
+	if err != nil {
+		return deserializationError{fmt.Errorf("decode event: %w", err)}
+	}
+	return c.deliver(event)
+}
+
+func (c *Consumer) deliver(event Event) error {
+	switch e := event.(type) {
+	case *MovieEvent:
+		return send(c.movies, *e, c.stop)
+	case *UserEvent:
+		return send(c.users, *e, c.stop)
+	case *PaymentEvent:
+		return send(c.payments, *e, c.stop)
+	}
+	return nil
+}
+
+func send[T any](ch chan<- T, v T, stop <-chan struct{}) error {
+	select {
+	case ch <- v:
+		return nil
+	case <-stop:
+		return errConsumerStopped
+	}
+}
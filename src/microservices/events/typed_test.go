@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestConsumerDeliversTypedEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		topic  string
+		value  string
+		movies []MovieEvent
+		users  []UserEvent
+		pays   []PaymentEvent
+	}{
+		{"movie", movieTopic, `{"movie_id": 7, "title": "Heat", "action": "viewed", "user_id": 3}`, []MovieEvent{{MovieID: 7, Title: "Heat", Action: "viewed", UserID: 3}}, nil, nil},
+		{"user", userTopic, `{"user_id": 3, "username": "ann", "action": "login", "timestamp": "2024-01-01T00:00:00Z"}`, nil, []UserEvent{{UserID: 3, Username: "ann", Action: "login", Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}, nil},
+		{"payment", paymentTopic, `{"payment_id": 1, "user_id": 3, "amount": 9.5, "status": "completed", "timestamp": "2024-01-01T00:00:00Z"}`, nil, nil, []PaymentEvent{{PaymentID: 1, UserID: 3, Amount: 9.5, Status: "completed", Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConsumer(consumerConfig{GroupID: "events", ManualCommit: true, MaxAttempts: 1}, 4)
+			if c.cfg.GroupID != "events-typed" {
+				t.Errorf("group = %q, want events-typed", c.cfg.GroupID)
+			}
+			r := &fakeReader{msgs: []kafka.Message{{Topic: tt.topic, Offset: 5, Value: []byte(tt.value), Time: time.Now()}}}
+			runConsumer(context.Background(), r, c.cfg, tt.topic, c.handle)
+
+			if len(r.committed) != 1 {
+				t.Fatalf("committed %d messages, want 1", len(r.committed))
+			}
+			if got := drain(c.Movies()); !equalEvents(got, tt.movies) {
+				t.Errorf("movies = %+v, want %+v", got, tt.movies)
+			}
+			if got := drain(c.Users()); !equalEvents(got, tt.users) {
+				t.Errorf("users = %+v, want %+v", got, tt.users)
+			}
+			if got := drain(c.Payments()); !equalEvents(got, tt.pays) {
+				t.Errorf("payments = %+v, want %+v", got, tt.pays)
+			}
+		})
+	}
+}
+
+func TestConsumerStopsWithoutCommitting(t *testing.T) {
+	prev := quarantine
+	quarantine, _ = newQuarantineStore(10, "")
+	defer func() { quarantine = prev }()
+	c := NewConsumer(consumerConfig{ManualCommit: true, MaxAttempts: 3}, 0)
+	r := &fakeReader{msgs: []kafka.Message{
+		{Topic: movieTopic, Offset: 1, Value: []byte(`{"movie_id": 1, "title": "Heat", "action": "viewed"}`)},
+		{Topic: movieTopic, Offset: 2, Value: []byte(`{"movie_id": 2, "title": "Ran", "action": "viewed"}`)},
+	}}
+	done := make(chan struct{})
+	go func() {
+		runConsumer(context.Background(), r, c.cfg, movieTopic, c.handle)
+		close(done)
+	}()
+	// Take the first event, then stop with nobody receiving the second.
+	if e := <-c.Movies(); e.MovieID != 1 {
+		t.Fatalf("first event = %+v", e)
+	}
+	waitForCommits(t, r, 1)
+	close(c.stop)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("consumer did not stop")
+	}
+	if len(r.committed) != 1 || r.committed[0].Offset != 1 {
+		t.Fatalf("committed = %+v, want only offset 1", r.committed)
+	}
+	if len(quarantine.list()) != 0 {
+		t.Fatalf("an undelivered event was quarantined")
+	}
+}
+
+func drain[T any](ch <-chan T) []T {
+	var out []T
+	for {
+		select {
+		case v := <-ch:
+			out = append(out, v)
+		default:
+			return out
+		}
+	}
+}
+
+func equalEvents[T comparable](got, want []T) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func waitForCommits(t *testing.T, r *fakeReader, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.mu.Lock()
+		got := len(r.committed)
+		r.mu.Unlock()
+		if got >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("committed %d messages, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
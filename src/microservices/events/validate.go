@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validateConfig checks the service's environment up front and returns one
+// line per problem found. Unset variables fall back to the same defaults main
+// uses and are only reported if that default is itself rejected.
+func validateConfig() []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	atLeast := func(key, fallback string, min int) {
+		value := getEnv(key, fallback)
+		if n, err := strconv.Atoi(value); err != nil || n < min {
+			addf("%s: %q must be an integer of at least %d", key, value, min)
+		}
+	}
+	optionalURL := func(key string) {
+		value := getEnv(key, "")
+		if value == "" {
+			return
+		}
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			addf("%s: %q is not an http(s) URL", key, value)
+		}
+	}
+
+	if port, err := strconv.Atoi(getEnv("PORT", "8082")); err != nil || port < 1 || port > 65535 {
+		addf("PORT: %q is not a valid port", getEnv("PORT", "8082"))
+	}
+	if grpcPort := getEnv("GRPC_HEALTH_PORT", ""); grpcPort != "" {
+		if port, err := strconv.Atoi(grpcPort); err != nil || port < 1 || port > 65535 {
+			addf("GRPC_HEALTH_PORT: %q is not a valid port", grpcPort)
+		}
+		atLeast("GRPC_HEALTH_INTERVAL_MS", "5000", 1)
+	}
+	for _, key := range []string{"KAFKA_BROKERS", "KAFKA_PRODUCER_BROKERS", "KAFKA_CONSUMER_BROKERS"} {
+		value := getEnv(key, "localhost:9092")
+		if value == "" {
+			continue
+		}
+		for _, broker := range strings.Split(value, ",") {
+			if _, port, err := net.SplitHostPort(strings.TrimSpace(broker)); err != nil || port == "" {
+				addf("%s: %q must be host:port", key, broker)
+			}
+		}
+	}
+	if _, err := parseStartOffset(getEnv("KAFKA_START_OFFSET", "earliest")); err != nil {
+		addf("KAFKA_START_OFFSET: %v", err)
+	}
+
+	atLeast("KAFKA_MAX_MESSAGE_BYTES", strconv.Itoa(maxMessageBytes), 1)
+	atLeast("CONSUMER_MAX_ATTEMPTS", "3", 1)
+	atLeast("QUARANTINE_SIZE", "100", 0)
+	atLeast("SCHEDULE_MAX_PENDING", "1000", 0)
+	atLeast("LAG_CACHE_SECONDS", "5", 0)
+	atLeast("DRAIN_TIMEOUT_SECONDS", "15", 0)
+	atLeast("KAFKA_COMMIT_INTERVAL_MS", "0", 0)
+	atLeast("DESERIALIZATION_LOG_BURST", "10", 0)
+	if d, err := time.ParseDuration(getEnv("DESERIALIZATION_LOG_INTERVAL", "1m")); err != nil || d <= 0 {
+		addf("DESERIALIZATION_LOG_INTERVAL: %q must be a positive duration such as 1m", getEnv("DESERIALIZATION_LOG_INTERVAL", "1m"))
+	}
+	if mode := getEnv("PRODUCE_MODE", "sync"); mode != "sync" && mode != "async" {
+		addf("PRODUCE_MODE: %q must be sync or async", mode)
+	}
+	atLeast("PRODUCE_BUFFER_SIZE", "1000", 1)
+	atLeast("PRODUCE_RETRY_ATTEMPTS", "3", 1)
+	atLeast("PRODUCE_RETRY_BACKOFF_MS", "100", 0)
+	if format := getEnv("KAFKA_VALUE_FORMAT", "json"); format != "json" && format != formatProtobuf {
+		addf("KAFKA_VALUE_FORMAT: %q must be json or protobuf", format)
+	}
+	atLeast("KAFKA_AUTO_CREATE_PARTITIONS", "1", 1)
+	atLeast("KAFKA_AUTO_CREATE_REPLICATION", "1", 1)
+	if _, err := parseAssignedPartitions(getEnv("KAFKA_ASSIGNED_PARTITIONS", "")); err != nil {
+		addf("KAFKA_ASSIGNED_PARTITIONS: %v", err)
+	}
+	if _, err := parseTopicLimits(getEnv("PRODUCE_CONCURRENCY", "0"), getEnv("PRODUCE_CONCURRENCY_TOPICS", "")); err != nil {
+		addf("PRODUCE_CONCURRENCY: %v", err)
+	}
+	atLeast("PRODUCE_RETRY_AFTER_SECONDS", "1", 0)
+	if _, err := parseHeaderFilter(getEnv("CONSUMER_HEADER_FILTER", "")); err != nil {
+		addf("CONSUMER_HEADER_FILTER: %v", err)
+	}
+	if _, err := parseErrorPolicy(getEnv("CONSUMER_ERROR_POLICY", "dlq")); err != nil {
+		addf("CONSUMER_ERROR_POLICY: %v", err)
+	} else if _, err := parseErrorPolicies(getEnv("CONSUMER_ERROR_POLICY", "dlq"), getEnv("CONSUMER_ERROR_POLICIES", "")); err != nil {
+		addf("CONSUMER_ERROR_POLICIES: %v", err)
+	}
+	atLeast("CONSUMER_MAX_MESSAGES", "0", 0)
+	if d, err := time.ParseDuration(getEnv("CONSUMER_IDLE_TIMEOUT", "0")); err != nil || d < 0 {
+		addf("CONSUMER_IDLE_TIMEOUT: %q must be a non-negative duration such as 30s", getEnv("CONSUMER_IDLE_TIMEOUT", "0"))
+	}
+	if _, err := parseTrustedProxies(getEnv("TRUSTED_PROXIES", "")); err != nil {
+		addf("TRUSTED_PROXIES: %v", err)
+	}
+	optionalURL("SCHEMA_REGISTRY_URL")
+	optionalURL("REPLAY_SINK_URL")
+	if getEnv("REPLAY_SINK_URL", "") != "" {
+		atLeast("REPLAY_CONCURRENCY", "4", 1)
+		atLeast("REPLAY_MAX_RETRIES", "3", 0)
+		atLeast("REPLAY_MAX_FAILURES", "10", 1)
+	}
+	optionalURL("WEBHOOK_SINK_URL")
+	if getEnv("WEBHOOK_SINK_URL", "") != "" {
+		atLeast("WEBHOOK_SINK_RETRIES", "3", 0)
+		atLeast("WEBHOOK_BREAKER_FAILURES", "5", 1)
+		for key, fallback := range map[string]string{"WEBHOOK_SINK_TIMEOUT": "5s", "WEBHOOK_BREAKER_COOLDOWN": "30s"} {
+			if d, err := time.ParseDuration(getEnv(key, fallback)); err != nil || d <= 0 {
+				addf("%s: %q must be a positive duration such as %s", key, getEnv(key, fallback), fallback)
+			}
+		}
+	}
+
+	if d, err := time.ParseDuration(getEnv("CONSUMER_CHECKPOINT_INTERVAL", "60s")); err != nil || d < 0 {
+		addf("CONSUMER_CHECKPOINT_INTERVAL: %q must be a non-negative duration such as 30s", getEnv("CONSUMER_CHECKPOINT_INTERVAL", "60s"))
+	}
+	session, err := time.ParseDuration(getEnv("KAFKA_SESSION_TIMEOUT", "30s"))
+	if err != nil || session <= 0 {
+		addf("KAFKA_SESSION_TIMEOUT: %q must be a positive duration such as 30s", getEnv("KAFKA_SESSION_TIMEOUT", "30s"))
+	}
+	if d, err := time.ParseDuration(getEnv("KAFKA_HEARTBEAT_INTERVAL", "3s")); err != nil || d <= 0 {
+		addf("KAFKA_HEARTBEAT_INTERVAL: %q must be a positive duration such as 3s", getEnv("KAFKA_HEARTBEAT_INTERVAL", "3s"))
+	} else if session > 0 && d >= session {
+		addf("KAFKA_HEARTBEAT_INTERVAL: %s must be shorter than KAFKA_SESSION_TIMEOUT (%s)", d, session)
+	}
+	if d, err := time.ParseDuration(getEnv("HEARTBEAT_INTERVAL", "0")); err != nil || d < 0 {
+		addf("HEARTBEAT_INTERVAL: %q must be a duration such as 10s, or 0 to disable", getEnv("HEARTBEAT_INTERVAL", "0"))
+	} else if d > 0 {
+		if stale, err := time.ParseDuration(getEnv("HEARTBEAT_STALE_AFTER", (3 * d).String())); err != nil || stale <= d {
+			addf("HEARTBEAT_STALE_AFTER: %q must be a duration longer than HEARTBEAT_INTERVAL", getEnv("HEARTBEAT_STALE_AFTER", (3*d).String()))
+		}
+	}
+	if d, err := time.ParseDuration(getEnv("THROUGHPUT_WINDOW", "60s")); err != nil || d < time.Second {
+		addf("THROUGHPUT_WINDOW: %q must be a duration of at least 1s", getEnv("THROUGHPUT_WINDOW", "60s"))
+	}
+	if d, err := time.ParseDuration(getEnv("MESSAGE_FETCH_TIMEOUT", "5s")); err != nil || d <= 0 {
+		addf("MESSAGE_FETCH_TIMEOUT: %q must be a positive duration such as 5s", getEnv("MESSAGE_FETCH_TIMEOUT", "5s"))
+	}
+	if d, err := time.ParseDuration(getEnv("HTTP_IDLE_TIMEOUT", "120s")); err != nil || d < 0 {
+		addf("HTTP_IDLE_TIMEOUT: %q must be a non-negative duration such as 90s", getEnv("HTTP_IDLE_TIMEOUT", "120s"))
+	}
+	for _, key := range []string{"HTTP_KEEPALIVE", "KAFKA_MANUAL_COMMIT", "ALLOW_DESTRUCTIVE_ADMIN", "STRICT_DECODING", "COMPAT_FIELD_NAMES", "KAFKA_AUTO_CREATE_TOPICS", "MOVIE_STATE_VIEW", "SOURCE_HEADERS", "OPENAPI_VALIDATION", "REQUIRE_JSON_CONTENT_TYPE"} {
+		if v := getEnv(key, "false"); v != "true" && v != "false" {
+			addf("%s: %q must be true or false", key, v)
+		}
+	}
+	return problems
+}
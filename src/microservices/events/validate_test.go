@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{"defaults", nil, nil},
+		{"valid settings", map[string]string{"PORT": "9000", "KAFKA_BROKERS": "kafka-1:9092,kafka-2:9092", "KAFKA_START_OFFSET": "latest"}, nil},
+		{"broker without port", map[string]string{"KAFKA_BROKERS": "kafka-1:9092,kafka-2"}, []string{"KAFKA_BROKERS"}},
+		{"port out of range", map[string]string{"PORT": "0"}, []string{"PORT"}},
+		{"unknown start offset", map[string]string{"KAFKA_START_OFFSET": "middle"}, []string{"KAFKA_START_OFFSET"}},
+		{"relative url", map[string]string{"SCHEMA_REGISTRY_URL": "registry:8081"}, []string{"SCHEMA_REGISTRY_URL"}},
+		{"zero attempts", map[string]string{"CONSUMER_MAX_ATTEMPTS": "0"}, []string{"CONSUMER_MAX_ATTEMPTS"}},
+		{"negative duration", map[string]string{"CONSUMER_IDLE_TIMEOUT": "-5s"}, []string{"CONSUMER_IDLE_TIMEOUT"}},
+		{"heartbeat not below session timeout", map[string]string{"KAFKA_SESSION_TIMEOUT": "10s", "KAFKA_HEARTBEAT_INTERVAL": "10s"}, []string{"KAFKA_HEARTBEAT_INTERVAL"}},
+		{"not a boolean", map[string]string{"STRICT_DECODING": "1"}, []string{"STRICT_DECODING"}},
+		{"replay settings checked only with a sink", map[string]string{"REPLAY_CONCURRENCY": "0"}, nil},
+		{"replay settings checked with a sink", map[string]string{"REPLAY_SINK_URL": "http://sink:8080", "REPLAY_CONCURRENCY": "0"}, []string{"REPLAY_CONCURRENCY"}},
+		{
+			"every problem reported at once",
+			map[string]string{"PORT": "x", "KAFKA_BROKERS": "kafka", "PRODUCE_MODE": "later", "KAFKA_VALUE_FORMAT": "xml"},
+			[]string{"PORT", "KAFKA_BROKERS", "PRODUCE_MODE", "KAFKA_VALUE_FORMAT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var got []string
+			for _, p := range validateConfig() {
+				key, _, _ := strings.Cut(p, ":")
+				got = append(got, key)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("problems with %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// accessConfig is the "access" section of the config file. Entries are path
+// prefixes, or regular expressions when they start with "~". A path matching
+// Deny gets a 403; when Allow is set, a path matching none of its entries
+// gets a 404. Deny wins over Allow.
+type accessConfig struct {
+	Deny  []string `json:"deny,omitempty"`
+	Allow []string `json:"allow,omitempty"`
+}
+
+type pathMatcher struct {
+	prefix string
+	re     *regexp.Regexp
+}
+
+func (m pathMatcher) match(p string) bool {
+	if m.re != nil {
+		return m.re.MatchString(p)
+	}
+	return p == m.prefix || strings.HasPrefix(p, strings.TrimSuffix(m.prefix, "/")+"/")
+}
+
+func (m pathMatcher) String() string {
+	if m.re != nil {
+		return "~" + m.re.String()
+	}
+	return m.prefix
+}
+
+// accessPolicy is evaluated before routing, so blocked requests never reach
+// a backend.
+type accessPolicy struct {
+	deny  []pathMatcher
+	allow []pathMatcher
+}
+
+func compilePathMatchers(section string, entries []string) ([]pathMatcher, error) {
+	matchers := make([]pathMatcher, 0, len(entries))
+	for _, e := range entries {
+		if expr, ok := strings.CutPrefix(e, "~"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("access.%s: %w", section, err)
+			}
+			matchers = append(matchers, pathMatcher{re: re})
+			continue
+		}
+		if !strings.HasPrefix(e, "/") {
+			return nil, fmt.Errorf("access.%s: prefix %q must start with /", section, e)
+		}
+		matchers = append(matchers, pathMatcher{prefix: e})
+	}
+	return matchers, nil
+}
+
+// newAccessPolicy returns nil when cfg has no entries.
+func newAccessPolicy(cfg *accessConfig) (*accessPolicy, error) {
+	if cfg == nil || (len(cfg.Deny) == 0 && len(cfg.Allow) == 0) {
+		return nil, nil
+	}
+	deny, err := compilePathMatchers("deny", cfg.Deny)
+	if err != nil {
+		return nil, err
+	}
+	allow, err := compilePathMatchers("allow", cfg.Allow)
+	if err != nil {
+		return nil, err
+	}
+	return &accessPolicy{deny: deny, allow: allow}, nil
+}
+
+// check returns the status to answer with, or 0 when the request may be
+// forwarded. The path is cleaned first so "//api/admin" or "/api/x/../admin"
+// cannot slip past a prefix.
+func (a *accessPolicy) check(r *http.Request) int {
+	if a == nil {
+		return 0
+	}
+	p := path.Clean("/" + r.URL.Path)
+	for _, m := range a.deny {
+		if m.match(p) {
+			log.Printf("Blocked %s %s from %s: denied by %s", r.Method, r.URL.Path, ClientIP(r), m)
+			return http.StatusForbidden
+		}
+	}
+	if len(a.allow) == 0 {
+		return 0
+	}
+	for _, m := range a.allow {
+		if m.match(p) {
+			return 0
+		}
+	}
+	log.Printf("Blocked %s %s from %s: not in the allowlist", r.Method, r.URL.Path, ClientIP(r))
+	return http.StatusNotFound
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        accessConfig
+		path       string
+		wantStatus int
+	}{
+		{"denied prefix", accessConfig{Deny: []string{"/api/admin"}}, "/api/admin", http.StatusForbidden},
+		{"denied subpath", accessConfig{Deny: []string{"/api/admin"}}, "/api/admin/users", http.StatusForbidden},
+		{"prefix is a path segment", accessConfig{Deny: []string{"/api/admin"}}, "/api/administrators", http.StatusOK},
+		{"double slash cleaned", accessConfig{Deny: []string{"/api/admin"}}, "//api/admin", http.StatusForbidden},
+		{"dot segments cleaned", accessConfig{Deny: []string{"/api/admin"}}, "/api/movies/../admin", http.StatusForbidden},
+		{"denied regex", accessConfig{Deny: []string{"~^/api/users/[0-9]+/password"}}, "/api/users/42/password", http.StatusForbidden},
+		{"regex not matching", accessConfig{Deny: []string{"~^/api/users/[0-9]+/password"}}, "/api/users/42", http.StatusOK},
+		{"allowed by allowlist", accessConfig{Allow: []string{"/api/movies", "/health"}}, "/api/movies/7", http.StatusOK},
+		{"outside the allowlist", accessConfig{Allow: []string{"/api/movies", "/health"}}, "/api/users", http.StatusNotFound},
+		{"deny wins over allow", accessConfig{Deny: []string{"/api/movies/internal"}, Allow: []string{"/api/movies"}}, "/api/movies/internal", http.StatusForbidden},
+		{"no policy", accessConfig{}, "/api/admin", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newAccessPolicy(&tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var forwarded atomic.Int32
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded.Add(1)
+				w.WriteHeader(http.StatusOK)
+			})
+			s := newTestProxy(t, upstream, upstream)
+			s.access = policy
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = tt.path
+			rec := serve(s, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			wantForwarded := int32(0)
+			if tt.wantStatus == http.StatusOK {
+				wantForwarded = 1
+			}
+			if got := forwarded.Load(); got != wantForwarded {
+				t.Errorf("forwarded %d times, want %d", got, wantForwarded)
+			}
+		})
+	}
+}
+
+func TestNewAccessPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *accessConfig
+	}{
+		{"invalid regex", &accessConfig{Deny: []string{"~(["}}},
+		{"relative prefix", &accessConfig{Allow: []string{"api/movies"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newAccessPolicy(tt.cfg); err == nil {
+				t.Error("invalid access config accepted")
+			}
+		})
+	}
+	if policy, err := newAccessPolicy(nil); policy != nil || err != nil {
+		t.Errorf("nil config = %v, %v, want no policy", policy, err)
+	}
+}
+
+func TestAccessPolicyFromExampleConfig(t *testing.T) {
+	cfg, err := loadFileConfig("config.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := newAccessPolicy(cfg.Access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]int{
+		"/api/admin/reindex":    http.StatusForbidden,
+		"/api/users/7/password": http.StatusForbidden,
+		"/api/movies":           0,
+		"/api/users/7":          0,
+	} {
+		if got := policy.check(httptest.NewRequest(http.MethodGet, path, nil)); got != want {
+			t.Errorf("check(%s) = %d, want %d", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states, as reported by /proxy/upstreams.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker takes a replica out of a pool's rotation after failures
+// consecutive failed requests, ahead of the health checker noticing. Once
+// cooldown has passed it lets a single trial request through: success closes
+// the breaker, failure opens it for another cooldown.
+type circuitBreaker struct {
+	name     string
+	failures int
+	cooldown time.Duration
+
+	mu       sync.Mutex
+	failed   int
+	openedAt time.Time
+	trial    bool
+}
+
+func newCircuitBreaker(name string, failures int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{name: name, failures: failures, cooldown: cooldown}
+}
+
+// state reports the breaker state at now without changing it. It is safe to
+// call on nil, which is always closed.
+func (c *circuitBreaker) state(now time.Time) string {
+	if c == nil {
+		return breakerClosed
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stateLocked(now)
+}
+
+func (c *circuitBreaker) stateLocked(now time.Time) string {
+	switch {
+	case c.openedAt.IsZero():
+		return breakerClosed
+	case now.Sub(c.openedAt) < c.cooldown:
+		return breakerOpen
+	}
+	return breakerHalfOpen
+}
+
+// allow reports whether a request may go to the replica, claiming the trial
+// request when the breaker is half-open. A caller that was allowed must
+// report the outcome with record.
+func (c *circuitBreaker) allow(now time.Time) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.stateLocked(now) {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		if c.trial {
+			return false
+		}
+		c.trial = true
+	}
+	return true
+}
+
+// release gives back a trial request without a verdict, for a request the
+// client abandoned.
+func (c *circuitBreaker) release() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.trial = false
+	c.mu.Unlock()
+}
+
+func (c *circuitBreaker) record(now time.Time, ok bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wasOpen := !c.openedAt.IsZero()
+	c.trial = false
+	if ok {
+		if wasOpen {
+			log.Printf("Circuit breaker for %s closed", c.name)
+		}
+		c.failed, c.openedAt = 0, time.Time{}
+		return
+	}
+	c.failed++
+	if wasOpen || c.failed >= c.failures {
+		if !wasOpen {
+			log.Printf("Circuit breaker for %s opened after %d failures", c.name, c.failed)
+		}
+		c.openedAt = now
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	type step struct {
+		at        time.Duration
+		ok        bool
+		wantState string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"stays closed below the threshold", []step{
+			{0, false, breakerClosed},
+			{0, false, breakerClosed},
+		}},
+		{"success resets the count", []step{
+			{0, false, breakerClosed},
+			{0, false, breakerClosed},
+			{0, true, breakerClosed},
+			{0, false, breakerClosed},
+			{0, false, breakerClosed},
+		}},
+		{"opens at the threshold", []step{
+			{0, false, breakerClosed},
+			{0, false, breakerClosed},
+			{0, false, breakerOpen},
+		}},
+		{"half-open trial success closes", []step{
+			{0, false, breakerClosed},
+			{0, false, breakerClosed},
+			{0, false, breakerOpen},
+			{time.Minute, true, breakerClosed},
+		}},
+		{"half-open trial failure reopens", []step{
+			{0, false, breakerClosed},
+			{0, false, breakerClosed},
+			{0, false, breakerOpen},
+			{time.Minute, false, breakerOpen},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCircuitBreaker("events-service", 3, 30*time.Second)
+			for i, s := range tt.steps {
+				now := start.Add(s.at)
+				if !c.allow(now) {
+					t.Fatalf("step %d: request refused in state %s", i, c.state(now))
+				}
+				c.record(now, s.ok)
+				if got := c.state(now); got != s.wantState {
+					t.Fatalf("step %d: state = %s, want %s", i, got, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerAllow(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name      string
+		at        time.Duration
+		trialOut  bool
+		wantAllow bool
+		wantState string
+	}{
+		{"open during cooldown", 10 * time.Second, false, false, breakerOpen},
+		{"half-open after cooldown", 30 * time.Second, false, true, breakerHalfOpen},
+		{"one trial at a time", 30 * time.Second, true, false, breakerHalfOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCircuitBreaker("events-service", 1, 30*time.Second)
+			c.record(start, false)
+			now := start.Add(tt.at)
+			if tt.trialOut {
+				c.allow(now)
+			}
+			if got := c.allow(now); got != tt.wantAllow {
+				t.Fatalf("allow = %v, want %v", got, tt.wantAllow)
+			}
+			if got := c.state(now); got != tt.wantState {
+				t.Fatalf("state = %s, want %s", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerRelease(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	c := newCircuitBreaker("events-service", 1, time.Second)
+	c.record(start, false)
+	now := start.Add(time.Second)
+	if !c.allow(now) {
+		t.Fatal("trial refused after cooldown")
+	}
+	c.release()
+	if !c.allow(now) {
+		t.Fatal("released trial was not given back")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	resp    *bufferedResponse
+	etag    string
+	expires time.Time
+}
+
+// contentETag derives a strong ETag from the body so identical upstream
+// content always yields the same tag, whichever backend served it.
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheableRequest reports whether r may be answered from, and stored in,
+// the shared cache. The key is the request URI alone, so anything sent with
+// credentials is left out rather than served to other users.
+func cacheableRequest(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "" && r.Header.Get("Cookie") == ""
+}
+
+// cacheableResponse reports whether the upstream allows a shared cache to
+// keep resp.
+func cacheableResponse(resp *bufferedResponse) bool {
+	if resp.status != http.StatusOK || len(resp.header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	for _, v := range resp.header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "no-store", "private", "no-cache":
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// responseCache is a TTL cache of successful movies GET responses keyed by
+// the service that answered and the request URI, so a response from the
+// monolith is never served for a request routed to movies-service.
+type responseCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+}
+
+type cacheKey struct {
+	service    string
+	requestURI string
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, entries: make(map[cacheKey]*cacheEntry)}
+}
+
+func (c *responseCache) get(key cacheKey) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e, true
+}
+
+// uncachedHeaders are never replayed from the cache: cookies belong to the
+// client that caused them, and hop-by-hop headers to one connection.
+var uncachedHeaders = []string{
+	"Set-Cookie", "Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func (c *responseCache) set(key cacheKey, resp *bufferedResponse) *cacheEntry {
+	stored := &bufferedResponse{status: resp.status, header: resp.header.Clone()}
+	stored.body.Write(resp.body.Bytes())
+	for _, name := range uncachedHeaders {
+		stored.header.Del(name)
+	}
+	e := &cacheEntry{resp: stored, etag: contentETag(resp.body.Bytes()), expires: time.Now().Add(c.ttl)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = e
+	return e
+}
+
+// serve writes the cached response, or 304 Not Modified when the client
+// already holds the same representation.
+func (e *cacheEntry) serve(w http.ResponseWriter, r *http.Request) {
+	e.resp.copyHeader(w)
+	w.Header().Set("ETag", e.etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, e.etag) {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(e.resp.status)
+	w.Write(e.resp.body.Bytes())
+}
+
+// flush drops every entry and returns how many were evicted.
+func (c *responseCache) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	c.entries = make(map[cacheKey]*cacheEntry)
+	return n
+}
+
+// evict drops the entries for requestURI from every service. When
+// requestURI has no query string, cached variants of the same path with any
+// query are evicted too.
+func (c *responseCache) evict(requestURI string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for k := range c.entries {
+		if k.requestURI == requestURI || pathOf(k.requestURI) == requestURI {
+			delete(c.entries, k)
+			n++
+		}
+	}
+	return n
+}
+
+func pathOf(requestURI string) string {
+	u, err := url.ParseRequestURI(requestURI)
+	if err != nil {
+		return requestURI
+	}
+	return u.Path
+}
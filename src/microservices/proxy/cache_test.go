@@ -0,0 +1,238 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cachingProxy returns a proxy whose movies backend counts its calls and
+// answers with header h.
+func cachingProxy(t *testing.T, h http.Header) (*proxyServer, *atomic.Int32) {
+	var calls atomic.Int32
+	movies := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		for k, v := range h {
+			w.Header()[k] = v
+		}
+		w.Write([]byte(`[{"id":1}]`))
+	})
+	s := newTestProxy(t, named("monolith"), movies)
+	s.migrationPercent, s.gradualMigration = 100, true
+	s.cache = newResponseCache(time.Minute)
+	return s, &calls
+}
+
+func TestCacheFlush(t *testing.T) {
+	tests := []struct {
+		name        string
+		cached      []string
+		flush       string
+		wantEvicted int
+		wantMiss    []string
+		wantHit     []string
+	}{
+		{"everything", []string{"/api/movies", "/api/movies/1"}, "/proxy/cache/flush", 2, []string{"/api/movies", "/api/movies/1"}, nil},
+		{"one path", []string{"/api/movies", "/api/movies/1"}, "/proxy/cache/flush?path=/api/movies/1", 1, []string{"/api/movies/1"}, []string{"/api/movies"}},
+		{"path with every query", []string{"/api/movies?page=1", "/api/movies?page=2", "/api/movies/1"}, "/proxy/cache/flush?path=/api/movies", 2, []string{"/api/movies?page=1"}, []string{"/api/movies/1"}},
+		{"empty cache", nil, "/proxy/cache/flush", 0, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := cachingProxy(t, nil)
+			for _, uri := range tt.cached {
+				serve(s, httptest.NewRequest(http.MethodGet, uri, nil))
+			}
+			rec := serve(handleCacheFlush(s.cache), httptest.NewRequest(http.MethodPost, tt.flush, nil))
+			var body map[string]int
+			decodeJSON(t, rec, &body)
+			if body["evicted"] != tt.wantEvicted {
+				t.Fatalf("evicted = %d, want %d", body["evicted"], tt.wantEvicted)
+			}
+			for _, uri := range tt.wantMiss {
+				if got := serve(s, httptest.NewRequest(http.MethodGet, uri, nil)).Header().Get("X-Cache"); got != "MISS" {
+					t.Errorf("%s: X-Cache = %q after flush, want MISS", uri, got)
+				}
+			}
+			for _, uri := range tt.wantHit {
+				if got := serve(s, httptest.NewRequest(http.MethodGet, uri, nil)).Header().Get("X-Cache"); got != "HIT" {
+					t.Errorf("%s: X-Cache = %q after flush, want HIT", uri, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheFlushRequiresPost(t *testing.T) {
+	rec := serve(handleCacheFlush(newResponseCache(time.Minute)), httptest.NewRequest(http.MethodGet, "/proxy/cache/flush", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestCacheSkipsPrivateResponses(t *testing.T) {
+	tests := []struct {
+		name          string
+		request       http.Header
+		response      http.Header
+		wantCalls     int32
+		wantSecondHit bool
+	}{
+		{"anonymous public", nil, nil, 1, true},
+		{"authorization", http.Header{"Authorization": {"Bearer a"}}, nil, 2, false},
+		{"cookie", http.Header{"Cookie": {"session=a"}}, nil, 2, false},
+		{"no-store", nil, http.Header{"Cache-Control": {"no-store"}}, 2, false},
+		{"private", nil, http.Header{"Cache-Control": {"max-age=60, private"}}, 2, false},
+		{"no-cache", nil, http.Header{"Cache-Control": {"no-cache"}}, 2, false},
+		{"public max-age", nil, http.Header{"Cache-Control": {"public, max-age=60"}}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, calls := cachingProxy(t, tt.response)
+			var last *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+				for k, v := range tt.request {
+					r.Header[k] = v
+				}
+				last = serve(s, r)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", got, tt.wantCalls)
+			}
+			if hit := last.Header().Get("X-Cache") == "HIT"; hit != tt.wantSecondHit {
+				t.Errorf("second request hit = %v, want %v", hit, tt.wantSecondHit)
+			}
+		})
+	}
+}
+
+func TestCacheConditionalGet(t *testing.T) {
+	s, _ := cachingProxy(t, nil)
+	first := serve(s, httptest.NewRequest(http.MethodGet, "/api/movies", nil))
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("cached response has no ETag")
+	}
+	if again := serve(s, httptest.NewRequest(http.MethodGet, "/api/movies", nil)).Header().Get("ETag"); again != etag {
+		t.Fatalf("ETag changed from %s to %s for identical content", etag, again)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+		wantBody    bool
+	}{
+		{"matching", etag, http.StatusNotModified, false},
+		{"weak matching", "W/" + etag, http.StatusNotModified, false},
+		{"one of several", `"other", ` + etag, http.StatusNotModified, false},
+		{"wildcard", "*", http.StatusNotModified, false},
+		{"stale", `"stale"`, http.StatusOK, true},
+		{"absent", "", http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := serve(s, r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if hasBody := rec.Body.Len() > 0; hasBody != tt.wantBody {
+				t.Fatalf("body = %q, want body: %v", rec.Body.String(), tt.wantBody)
+			}
+			if rec.Header().Get("ETag") != etag {
+				t.Fatalf("ETag = %q, want %q", rec.Header().Get("ETag"), etag)
+			}
+		})
+	}
+}
+
+func TestContentETag(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{`[{"id":1}]`, `[{"id":1}]`, true},
+		{`[{"id":1}]`, `[{"id":2}]`, false},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		if got := contentETag([]byte(tt.a)) == contentETag([]byte(tt.b)); got != tt.same {
+			t.Errorf("contentETag(%q) == contentETag(%q) is %v, want %v", tt.a, tt.b, got, tt.same)
+		}
+	}
+}
+
+func TestCacheDoesNotReplayConnectionOrCookieHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		response   http.Header
+		wantCached bool
+		wantGone   []string
+		wantKept   []string
+	}{
+		{"set-cookie is not cached", http.Header{"Set-Cookie": {"session=abc"}}, false, nil, nil},
+		{"hop-by-hop stripped", http.Header{"Keep-Alive": {"timeout=5"}, "Trailer": {"X-Sum"}, "Content-Type": {"application/json"}}, true, []string{"Keep-Alive", "Trailer"}, []string{"Content-Type"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, calls := cachingProxy(t, tt.response)
+			serve(s, httptest.NewRequest(http.MethodGet, "/api/movies", nil))
+			second := serve(s, httptest.NewRequest(http.MethodGet, "/api/movies", nil))
+			if cached := calls.Load() == 1; cached != tt.wantCached {
+				t.Fatalf("cached = %v, want %v", cached, tt.wantCached)
+			}
+			if second.Header().Get("Set-Cookie") != "" && tt.wantCached {
+				t.Error("cached response replayed Set-Cookie")
+			}
+			for _, h := range tt.wantGone {
+				if second.Header().Get(h) != "" {
+					t.Errorf("cached response replayed %s", h)
+				}
+			}
+			for _, h := range tt.wantKept {
+				if second.Header().Get(h) == "" {
+					t.Errorf("cached response lost %s", h)
+				}
+			}
+		})
+	}
+}
+
+// The same URI is cached once per service, so moving the migration split
+// never serves one backend's response for a request routed to the other.
+func TestCacheKeyedByBackend(t *testing.T) {
+	s := newTestProxy(t, named("monolith"), named("movies-service"))
+	s.gradualMigration = true
+	s.cache = newResponseCache(time.Minute)
+
+	tests := []struct {
+		percent     int
+		wantBackend string
+		wantCache   string
+	}{
+		{0, "monolith", "MISS"},
+		{100, "movies-service", "MISS"},
+		{100, "movies-service", "HIT"},
+		{0, "monolith", "HIT"},
+	}
+	for i, tt := range tests {
+		s.migrationPercent = tt.percent
+		rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/movies?page=1", nil))
+		if got := rec.Header().Get("X-Backend"); got != tt.wantBackend || rec.Body.String() != tt.wantBackend {
+			t.Errorf("request %d at %d%%: served by %q (%s), want %s", i, tt.percent, got, rec.Body.String(), tt.wantBackend)
+		}
+		if got := rec.Header().Get("X-Cache"); got != tt.wantCache {
+			t.Errorf("request %d at %d%%: X-Cache = %q, want %s", i, tt.percent, got, tt.wantCache)
+		}
+	}
+	if n := s.cache.evict("/api/movies"); n != 2 {
+		t.Errorf("evicted %d entries for /api/movies, want one per service", n)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxConcurrentShadows bounds the shadow requests in flight; samples beyond it
+// are skipped rather than queued.
+const maxConcurrentShadows = 32
+
+type capturedResponse struct {
+	Backend    string      `json:"backend"`
+	Status     int         `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+	Truncated  bool        `json:"truncated,omitempty"`
+	DurationMS int64       `json:"duration_ms"`
+}
+
+// captureRecord is one line of the capture file: a request and the answer of
+// both backends to it. Served names the backend whose response reached the
+// client; the other one was called as a shadow.
+type captureRecord struct {
+	Time     time.Time        `json:"time"`
+	Method   string           `json:"method"`
+	URI      string           `json:"uri"`
+	Header   http.Header      `json:"header"`
+	Served   string           `json:"served"`
+	Monolith capturedResponse `json:"monolith"`
+	Movies   capturedResponse `json:"movies"`
+}
+
+// captureRecorder implements contract-test capture for /api/movies: a sampled
+// share of GET requests is also sent to the backend that did not serve it,
+// and both responses are appended to out as JSON lines. Only GETs are
+// sampled since replaying a write against the second backend would apply it
+// twice.
+type captureRecorder struct {
+	percent int
+	maxBody int
+
+	shadows chan struct{}
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// newCaptureRecorder appends to path, or writes to stdout when path is "-".
+func newCaptureRecorder(percent, maxBody int, path string) (*captureRecorder, error) {
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	return &captureRecorder{
+		percent: percent,
+		maxBody: maxBody,
+		shadows: make(chan struct{}, maxConcurrentShadows),
+		enc:     json.NewEncoder(out),
+	}, nil
+}
+
+func (c *captureRecorder) sample(r *http.Request) bool {
+	return c != nil && r.Method == http.MethodGet && rand.Intn(100) < c.percent
+}
+
+func (c *captureRecorder) response(b *backend, resp *bufferedResponse, took time.Duration) capturedResponse {
+	body := resp.body.Bytes()
+	truncated := len(body) > c.maxBody
+	if truncated {
+		body = body[:c.maxBody]
+	}
+	return capturedResponse{
+		Backend:    b.name,
+		Status:     resp.status,
+		Header:     resp.header.Clone(),
+		Body:       string(body),
+		Truncated:  truncated,
+		DurationMS: took.Milliseconds(),
+	}
+}
+
+// serve answers r from primary and then, in the background, calls shadow
+// with the same request and records both responses.
+func (c *captureRecorder) serve(w http.ResponseWriter, r *http.Request, primary, shadow *backend, primaryIsMonolith bool) {
+	start := time.Now()
+	resp := newBufferedResponse()
+	primary.proxy.ServeHTTP(resp, r)
+	took := time.Since(start)
+	resp.writeTo(w)
+
+	select {
+	case c.shadows <- struct{}{}:
+	default:
+		log.Printf("Capture skipped for %s: too many shadow requests in flight", r.URL.RequestURI())
+		return
+	}
+	shadowReq := r.Clone(context.WithoutCancel(r.Context()))
+	served := c.response(primary, resp, took)
+	go func() {
+		defer func() { <-c.shadows }()
+		start := time.Now()
+		shadowResp := newBufferedResponse()
+		shadow.proxy.ServeHTTP(shadowResp, shadowReq)
+		other := c.response(shadow, shadowResp, time.Since(start))
+
+		header := shadowReq.Header.Clone()
+		header.Del("Authorization")
+		header.Del("Cookie")
+		rec := captureRecord{
+			Time:   start,
+			Method: shadowReq.Method,
+			URI:    shadowReq.URL.RequestURI(),
+			Header: header,
+			Served: primary.name,
+		}
+		if primaryIsMonolith {
+			rec.Monolith, rec.Movies = served, other
+		} else {
+			rec.Monolith, rec.Movies = other, served
+		}
+		c.write(rec)
+	}()
+}
+
+func (c *captureRecorder) write(rec captureRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(rec); err != nil {
+		log.Printf("Failed to write capture record: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCaptureRecords(t *testing.T) {
+	tests := []struct {
+		name             string
+		capturePercent   int
+		migrationPercent int
+		method           string
+		maxBody          int
+		wantRecord       bool
+		wantServed       string
+		wantTruncated    bool
+	}{
+		{"monolith serves, movies shadowed", 100, 0, http.MethodGet, 1024, true, "monolith", false},
+		{"movies serves, monolith shadowed", 100, 100, http.MethodGet, 1024, true, "movies-service", false},
+		{"bodies truncated", 100, 0, http.MethodGet, 4, true, "monolith", true},
+		{"writes are not captured", 100, 0, http.MethodPost, 1024, false, "", false},
+		{"not sampled", 0, 0, http.MethodGet, 1024, false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "capture.jsonl")
+			c, err := newCaptureRecorder(tt.capturePercent, tt.maxBody, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.gradualMigration, s.migrationPercent = true, tt.migrationPercent
+			s.capture = c
+
+			r := httptest.NewRequest(tt.method, "/api/movies?id=1", nil)
+			r.Header.Set("X-User-ID", "u1")
+			r.Header.Set("Authorization", "Bearer secret")
+			rec := serve(s, r)
+			if tt.wantRecord && rec.Body.String() != tt.wantServed {
+				t.Fatalf("client got %q, want %q", rec.Body.String(), tt.wantServed)
+			}
+			// The shadow runs in the background and holds a slot until the
+			// record is written.
+			waitFor(t, func() bool { return len(c.shadows) == 0 })
+
+			records := readCapture(t, path)
+			if !tt.wantRecord {
+				if len(records) != 0 {
+					t.Fatalf("captured %d records, want none", len(records))
+				}
+				return
+			}
+			if len(records) != 1 {
+				t.Fatalf("captured %d records, want 1", len(records))
+			}
+			got := records[0]
+			if got.Method != http.MethodGet || got.URI != "/api/movies?id=1" || got.Served != tt.wantServed {
+				t.Errorf("record = %s %s served by %s", got.Method, got.URI, got.Served)
+			}
+			if got.Header.Get("X-User-ID") != "u1" || got.Header.Get("Authorization") != "" {
+				t.Errorf("header = %v, want X-User-ID kept and Authorization dropped", got.Header)
+			}
+			for _, resp := range []capturedResponse{got.Monolith, got.Movies} {
+				want := resp.Backend
+				if tt.wantTruncated {
+					want = want[:tt.maxBody]
+				}
+				if resp.Status != http.StatusOK || resp.Body != want || resp.Truncated != tt.wantTruncated {
+					t.Errorf("%s response = %+v", resp.Backend, resp)
+				}
+				if resp.Header.Get("X-Backend") != resp.Backend {
+					t.Errorf("%s response header = %v", resp.Backend, resp.Header)
+				}
+			}
+			if got.Monolith.Backend != "monolith" || got.Movies.Backend != "movies-service" {
+				t.Errorf("backends = %s, %s", got.Monolith.Backend, got.Movies.Backend)
+			}
+		})
+	}
+}
+
+func readCapture(t *testing.T, path string) []captureRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []captureRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec captureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("decode %q: %v", scanner.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
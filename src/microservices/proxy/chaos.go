@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// chaosConfig describes the faults injected into /api/movies traffic.
+// Percent selects which requests are affected; each affected request gets
+// LatencyMS of delay and then fails with a 500 or a connection reset with the
+// given probabilities.
+type chaosConfig struct {
+	Percent      int `json:"percent"`
+	LatencyMS    int `json:"latency_ms"`
+	ErrorPercent int `json:"error_percent"`
+	ResetPercent int `json:"reset_percent"`
+}
+
+func (c chaosConfig) validate() error {
+	for name, v := range map[string]int{"percent": c.Percent, "error_percent": c.ErrorPercent, "reset_percent": c.ResetPercent} {
+		if v < 0 || v > 100 {
+			return fmt.Errorf("%s must be between 0 and 100", name)
+		}
+	}
+	if c.LatencyMS < 0 {
+		return fmt.Errorf("latency_ms must not be negative")
+	}
+	return nil
+}
+
+// chaosInjector is only created when CHAOS_ENABLED=true.
+type chaosInjector struct {
+	mu  sync.RWMutex
+	cfg chaosConfig
+}
+
+func (c *chaosInjector) config() chaosConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cfg
+}
+
+// inject applies the configured faults and reports whether it already
+// answered the request.
+func (c *chaosInjector) inject(w http.ResponseWriter, r *http.Request) bool {
+	cfg := c.config()
+	if cfg.Percent == 0 || rand.Intn(100) >= cfg.Percent {
+		return false
+	}
+
+	if cfg.LatencyMS > 0 {
+		select {
+		case <-time.After(time.Duration(cfg.LatencyMS) * time.Millisecond):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+
+	switch {
+	case rand.Intn(100) < cfg.ErrorPercent:
+		log.Printf("[CHAOS] Injected 500 for %s %s", r.Method, r.URL.Path)
+		http.Error(w, "Chaos: injected upstream failure", http.StatusInternalServerError)
+		return true
+	case rand.Intn(100) < cfg.ResetPercent:
+		log.Printf("[CHAOS] Injected connection reset for %s %s", r.Method, r.URL.Path)
+		resetConnection(w)
+		return true
+	}
+	return false
+}
+
+// resetConnection closes the client connection with SO_LINGER=0 so the peer
+// sees a TCP RST instead of a clean close.
+func resetConnection(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Chaos: connection reset", http.StatusBadGateway)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// handleChaos reports (GET) or replaces (POST) the chaos configuration.
+func (c *chaosInjector) handleChaos(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var cfg chaosConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := cfg.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		c.cfg = cfg
+		c.mu.Unlock()
+		log.Printf("[CHAOS] Configuration updated: %+v", cfg)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, c.config())
+}
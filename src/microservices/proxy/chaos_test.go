@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChaosInjection(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         chaosConfig
+		path        string
+		wantStatus  int
+		wantBackend string
+		minLatency  time.Duration
+	}{
+		{"100% errors", chaosConfig{Percent: 100, ErrorPercent: 100}, "/api/movies", http.StatusInternalServerError, "", 0},
+		{"latency only", chaosConfig{Percent: 100, LatencyMS: 30}, "/api/movies", http.StatusOK, "movies-service", 30 * time.Millisecond},
+		{"latency then error", chaosConfig{Percent: 100, LatencyMS: 30, ErrorPercent: 100}, "/api/movies", http.StatusInternalServerError, "", 30 * time.Millisecond},
+		{"no requests selected", chaosConfig{Percent: 0, ErrorPercent: 100}, "/api/movies", http.StatusOK, "movies-service", 0},
+		{"selected without faults", chaosConfig{Percent: 100}, "/api/movies", http.StatusOK, "movies-service", 0},
+		{"other routes untouched", chaosConfig{Percent: 100, ErrorPercent: 100}, "/api/users", http.StatusOK, "monolith", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.gradualMigration, s.migrationPercent = true, 100
+			s.chaos = &chaosInjector{cfg: tt.cfg}
+			start := time.Now()
+			rec := serve(s, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("X-Backend"); got != tt.wantBackend {
+				t.Errorf("backend = %q, want %q", got, tt.wantBackend)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minLatency {
+				t.Errorf("took %s, want at least %s", elapsed, tt.minLatency)
+			}
+		})
+	}
+}
+
+func TestChaosConnectionReset(t *testing.T) {
+	var reached atomic.Bool
+	s := newTestProxy(t, named("monolith"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached.Store(true)
+	}))
+	s.gradualMigration, s.migrationPercent = true, 100
+	s.chaos = &chaosInjector{cfg: chaosConfig{Percent: 100, ResetPercent: 100}}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/api/movies")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("got %s, want a connection error", resp.Status)
+	}
+	if reached.Load() {
+		t.Error("request reached movies-service")
+	}
+}
+
+func TestHandleChaos(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		want       chaosConfig
+	}{
+		{"get", http.MethodGet, "", http.StatusOK, chaosConfig{Percent: 10}},
+		{"update", http.MethodPost, `{"percent":50,"latency_ms":100,"error_percent":20,"reset_percent":5}`, http.StatusOK, chaosConfig{Percent: 50, LatencyMS: 100, ErrorPercent: 20, ResetPercent: 5}},
+		{"percent above 100", http.MethodPost, `{"percent":101}`, http.StatusBadRequest, chaosConfig{Percent: 10}},
+		{"negative latency", http.MethodPost, `{"percent":50,"latency_ms":-1}`, http.StatusBadRequest, chaosConfig{Percent: 10}},
+		{"invalid json", http.MethodPost, `{`, http.StatusBadRequest, chaosConfig{Percent: 10}},
+		{"wrong method", http.MethodDelete, "", http.StatusMethodNotAllowed, chaosConfig{Percent: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &chaosInjector{cfg: chaosConfig{Percent: 10}}
+			rec := serve(http.HandlerFunc(c.handleChaos), httptest.NewRequest(tt.method, "/proxy/chaos", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if got := c.config(); got != tt.want {
+				t.Errorf("config = %+v, want %+v", got, tt.want)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var got chaosConfig
+				decodeJSON(t, rec, &got)
+				if got != tt.want {
+					t.Errorf("response = %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
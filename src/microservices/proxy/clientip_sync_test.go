@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestClientIPMatchesEvents keeps the client address code shared with the
+// events service identical, so a fix to one is never missing from the other.
+func TestClientIPMatchesEvents(t *testing.T) {
+	for _, name := range []string{"clientip.go", "clientip_test.go"} {
+		theirs, err := os.ReadFile(filepath.Join("..", "events", name))
+		if os.IsNotExist(err) {
+			t.Skipf("events service not checked out next to the proxy")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ours, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ours, theirs) {
+			t.Errorf("%s differs from ../events/%s; change both together", name, name)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Command replay sends requests recorded by the proxy's RECORD_PERCENT mode
+// to another deployment, typically staging:
+//
+//	replay -target http://staging-proxy:8000 -file recording.jsonl
+//
+// Redacted values are sent as recorded, so endpoints that need the original
+// credentials answer 401; -header adds or replaces headers such as
+// Authorization on every request. Entries with a truncated body are skipped.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cinemaabyss/proxy-service/pkg/recording"
+)
+
+type headerFlags []string
+
+func (h *headerFlags) String() string     { return strings.Join(*h, ", ") }
+func (h *headerFlags) Set(v string) error { *h = append(*h, v); return nil }
+
+// summary counts replayed requests by response status; 0 counts transport
+// errors.
+type summary struct {
+	statuses map[int]int
+	skipped  int
+}
+
+func replay(ctx context.Context, client *http.Client, in io.Reader, target string, headers http.Header, delay time.Duration) (summary, error) {
+	s := summary{statuses: make(map[int]int)}
+	err := recording.Read(in, func(e recording.Entry) error {
+		if e.BodyTruncated {
+			s.skipped++
+			return nil
+		}
+		req, err := e.Request(ctx, target)
+		if err != nil {
+			return err
+		}
+		for key, values := range headers {
+			req.Header[key] = values
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Printf("%s %s: %v", e.Method, e.URI, err)
+			s.statuses[0]++
+		} else {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			log.Printf("%s %s: %d", e.Method, e.URI, resp.StatusCode)
+			s.statuses[resp.StatusCode]++
+		}
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		return nil
+	})
+	return s, err
+}
+
+func main() {
+	var headers headerFlags
+	target := flag.String("target", "", "base URL to replay against, e.g. http://localhost:8000")
+	file := flag.String("file", "recording.jsonl", "recording to replay, or - for stdin")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of each request")
+	delay := flag.Duration("delay", 0, "pause between requests")
+	flag.Var(&headers, "header", "header to set on every request, as 'Name: value'; repeatable")
+	flag.Parse()
+	if *target == "" {
+		fmt.Fprintln(os.Stderr, "replay: -target is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	extra := make(http.Header)
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			log.Fatalf("Invalid -header %q: must be 'Name: value'", h)
+		}
+		extra.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("Failed to open recording: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	s, err := replay(context.Background(), &http.Client{Timeout: *timeout}, in, *target, extra, *delay)
+	codes := make([]int, 0, len(s.statuses))
+	for code := range s.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		label := fmt.Sprint(code)
+		if code == 0 {
+			label = "error"
+		}
+		log.Printf("%s: %d", label, s.statuses[code])
+	}
+	if s.skipped > 0 {
+		log.Printf("skipped (truncated body): %d", s.skipped)
+	}
+	if err != nil {
+		log.Fatalf("Replay stopped: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"cinemaabyss/proxy-service/pkg/recording"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+type received struct {
+	method, uri, body, auth, tenant string
+}
+
+func TestReplayRoundTrip(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got []received
+	)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, received{r.Method, r.URL.RequestURI(), string(body), r.Header.Get("Authorization"), r.Header.Get("X-Tenant-Id")})
+		mu.Unlock()
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer target.Close()
+
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	w, err := recording.NewWriter(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []recording.Entry{
+		{Method: http.MethodGet, URI: "/api/movies?page=2", Header: http.Header{"X-Tenant-Id": {"acme"}}},
+		{Method: http.MethodPost, URI: "/api/users", Header: http.Header{"Authorization": {recording.Redacted}, "Content-Type": {"application/json"}}, Body: []byte(`{"name":"ann","password":"[REDACTED]"}`)},
+		{Method: http.MethodPut, URI: "/api/movies/7", Header: http.Header{}, Body: []byte(`{"ti`), BodyTruncated: true},
+		{Method: http.MethodDelete, URI: "/api/movies/8", Header: http.Header{}},
+	} {
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := replay(context.Background(), target.Client(), f, target.URL, http.Header{"Authorization": {"Bearer staging"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []received{
+		{http.MethodGet, "/api/movies?page=2", "", "Bearer staging", "acme"},
+		{http.MethodPost, "/api/users", `{"name":"ann","password":"[REDACTED]"}`, "Bearer staging", ""},
+		{http.MethodDelete, "/api/movies/8", "", "Bearer staging", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("target received %+v, want %+v", got, want)
+	}
+	if s.skipped != 1 || !reflect.DeepEqual(s.statuses, map[int]int{http.StatusOK: 2, http.StatusNotFound: 1}) {
+		t.Errorf("summary = %+v, want 2 OK, 1 not found and 1 skipped", s)
+	}
+}
+
+func TestReplayTransportErrors(t *testing.T) {
+	target := httptest.NewServer(http.NotFoundHandler())
+	target.Close()
+	s, err := replay(context.Background(), http.DefaultClient, strings.NewReader(`{"method":"GET","uri":"/api/movies"}`), target.URL, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.statuses[0] != 1 {
+		t.Errorf("summary = %+v, want the transport error counted", s)
+	}
+}
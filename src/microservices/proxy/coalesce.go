@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// bufferedResponse captures a full upstream response so it can be replayed to
+// every caller that shared the same upstream request.
+type bufferedResponse struct {
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{status: http.StatusOK, header: make(http.Header)}
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponse) WriteHeader(status int) { b.status = status }
+
+func (b *bufferedResponse) copyHeader(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+}
+
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	b.copyHeader(w)
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
+
+// coalescer collapses concurrent identical GET requests into one upstream
+// call through a singleflight.Group, and cancels that call once every caller
+// waiting on it has gone.
+type coalescer struct {
+	group singleflight.Group
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall counts the callers waiting on one key and holds the cancel
+// of the upstream request in flight for it.
+type coalescedCall struct {
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// coalesceKey separates requests whose responses may differ: by backend,
+// URI, negotiated type and the caller's credentials.
+func coalesceKey(backend string, r *http.Request) string {
+	return backend + " " + r.URL.RequestURI() + " " + r.Header.Get("Accept") + " " + r.Header.Get("Authorization") + " " + r.Header.Get("Cookie")
+}
+
+// detachedContext returns a context that is not cancelled with parent but
+// keeps its deadline, which carries the route and low-priority timeouts.
+func detachedContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx := context.WithoutCancel(parent)
+	if deadline, ok := parent.Deadline(); ok {
+		return context.WithDeadline(ctx, deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// fetch forwards r through next, sharing the upstream call with any identical
+// request to the same backend that is already in flight. The shared call is
+// detached from the first caller's cancellation, so that caller leaving does
+// not fail the others, and is cancelled once every caller has gone. A caller
+// that leaves early gets 499, or 504 if its own deadline passed.
+func (c *coalescer) fetch(r *http.Request, backend string, next http.Handler) *bufferedResponse {
+	key := coalesceKey(backend, r)
+	c.mu.Lock()
+	if c.calls == nil {
+		c.calls = make(map[string]*coalescedCall)
+	}
+	call, ok := c.calls[key]
+	if !ok {
+		call = &coalescedCall{}
+		c.calls[key] = call
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := detachedContext(r.Context())
+		defer cancel()
+		c.mu.Lock()
+		call.cancel = cancel
+		if call.waiters == 0 {
+			cancel()
+		}
+		c.mu.Unlock()
+		resp := newBufferedResponse()
+		next.ServeHTTP(resp, r.WithContext(ctx))
+		return resp, nil
+	})
+
+	var resp *bufferedResponse
+	select {
+	case res := <-ch:
+		if res.Shared {
+			log.Printf("Coalesced request %s %s to %s", r.Method, r.URL.RequestURI(), backend)
+		}
+		resp = res.Val.(*bufferedResponse)
+	case <-r.Context().Done():
+		resp = newBufferedResponse()
+		resp.status = statusClientClosedRequest
+		if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+			resp.status = http.StatusGatewayTimeout
+		}
+	}
+
+	c.mu.Lock()
+	call.waiters--
+	if call.waiters == 0 {
+		// Later callers start a new upstream call rather than join one
+		// that is being cancelled.
+		if call.cancel != nil {
+			call.cancel()
+		}
+		if c.calls[key] == call {
+			delete(c.calls, key)
+			c.group.Forget(key)
+		}
+	}
+	c.mu.Unlock()
+	return resp
+}
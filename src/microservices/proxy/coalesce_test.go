@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescerSharesIdenticalRequests(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   []http.Header
+		wantCalls int32
+	}{
+		{"identical", []http.Header{{}, {}, {}, {}, {}}, 1},
+		{"different accept", []http.Header{{"Accept": {"application/json"}}, {"Accept": {"text/html"}}}, 2},
+		{"different authorization", []http.Header{{"Authorization": {"Bearer a"}}, {"Authorization": {"Bearer b"}}}, 2},
+		{"different cookie", []http.Header{{"Cookie": {"session=a"}}, {"Cookie": {"session=b"}}, {"Cookie": {"session=a"}}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			release := make(chan struct{})
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				<-release
+				w.Write([]byte("movies"))
+			})
+			c := &coalescer{}
+			var wg sync.WaitGroup
+			for _, h := range tt.headers {
+				wg.Add(1)
+				go func(h http.Header) {
+					defer wg.Done()
+					r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+					r.Header = h
+					if resp := c.fetch(r, "movies-service", upstream); resp.body.String() != "movies" {
+						t.Errorf("body = %q, want movies", resp.body.String())
+					}
+				}(h)
+			}
+			waitFor(t, func() bool { return calls.Load() == tt.wantCalls && inFlightWaiters(c) == len(tt.headers) })
+			close(release)
+			wg.Wait()
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("upstream calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCoalescerCancellation(t *testing.T) {
+	tests := []struct {
+		name       string
+		callers    int
+		leave      int
+		wantCancel bool
+	}{
+		{"leader leaves, others wait", 3, 1, false},
+		{"every caller leaves", 2, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			cancelled := make(chan struct{})
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-release:
+					w.Write([]byte("ok"))
+				case <-r.Context().Done():
+					close(cancelled)
+				}
+			})
+			c := &coalescer{}
+			results := make([]chan *bufferedResponse, tt.callers)
+			cancels := make([]context.CancelFunc, tt.callers)
+			for i := range results {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancels[i], results[i] = cancel, make(chan *bufferedResponse, 1)
+				r := httptest.NewRequest(http.MethodGet, "/api/movies", nil).WithContext(ctx)
+				go func(ch chan *bufferedResponse) { ch <- c.fetch(r, "movies-service", upstream) }(results[i])
+				waitFor(t, func() bool { return inFlightWaiters(c) == i+1 })
+			}
+			for i := 0; i < tt.leave; i++ {
+				cancels[i]()
+				if resp := <-results[i]; resp.status != statusClientClosedRequest {
+					t.Fatalf("caller %d status = %d, want %d", i, resp.status, statusClientClosedRequest)
+				}
+			}
+			if tt.wantCancel {
+				select {
+				case <-cancelled:
+				case <-time.After(time.Second):
+					t.Fatal("upstream request was not cancelled")
+				}
+				return
+			}
+			close(release)
+			for i := tt.leave; i < tt.callers; i++ {
+				if resp := <-results[i]; resp.status != http.StatusOK || resp.body.String() != "ok" {
+					t.Fatalf("caller %d got %d %q", i, resp.status, resp.body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCoalescerKeepsDeadline(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	})
+	c := &coalescer{}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	leader := httptest.NewRequest(http.MethodGet, "/api/movies", nil).WithContext(ctx)
+	go c.fetch(leader, "movies-service", upstream)
+	waitFor(t, func() bool { return inFlightWaiters(c) == 1 })
+
+	// The second caller has no deadline of its own, so only the one
+	// inherited from the first can end the shared call.
+	done := make(chan *bufferedResponse, 1)
+	go func() {
+		done <- c.fetch(httptest.NewRequest(http.MethodGet, "/api/movies", nil), "movies-service", upstream)
+	}()
+	select {
+	case resp := <-done:
+		if resp.status != http.StatusGatewayTimeout {
+			t.Fatalf("status = %d, want %d", resp.status, http.StatusGatewayTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shared upstream request ignored the deadline")
+	}
+}
+
+// Once every caller has left, the next identical request starts its own
+// upstream call instead of joining the one being cancelled.
+func TestCoalescerStartsFreshAfterEveryCallerLeft(t *testing.T) {
+	var calls atomic.Int32
+	finish := make(chan struct{})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			<-r.Context().Done()
+			<-finish
+			return
+		}
+		w.Write([]byte("fresh"))
+	})
+	defer close(finish)
+	c := &coalescer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan *bufferedResponse, 1)
+	go func() {
+		done <- c.fetch(httptest.NewRequest(http.MethodGet, "/api/movies", nil).WithContext(ctx), "movies-service", upstream)
+	}()
+	waitFor(t, func() bool { return calls.Load() == 1 })
+	cancel()
+	if resp := <-done; resp.status != statusClientClosedRequest {
+		t.Fatalf("first caller status = %d, want %d", resp.status, statusClientClosedRequest)
+	}
+
+	resp := c.fetch(httptest.NewRequest(http.MethodGet, "/api/movies", nil), "movies-service", upstream)
+	if resp.body.String() != "fresh" || calls.Load() != 2 {
+		t.Fatalf("second caller got %q after %d upstream calls, want a fresh call", resp.body.String(), calls.Load())
+	}
+}
+
+func inFlightWaiters(c *coalescer) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, call := range c.calls {
+		n += call.waiters
+	}
+	return n
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
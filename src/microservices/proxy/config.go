@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Backend names used as route targets.
+const (
+	targetMonolith = "monolith"
+	targetMovies   = "movies"
+	targetEvents   = "events"
+)
+
+// route is one entry of the proxy's routing table. Requests whose path starts
+// with Prefix are sent to Target; the movies target is subject to the gradual
+// migration split and falls back to the monolith.
+type route struct {
+	Prefix       string
+	Target       string
+	PreserveHost bool
+	// Methods pins requests with these methods to a backend, ahead of
+	// Target and of the migration split.
+	Methods map[string]string
+	// Timeout bounds the whole upstream exchange; a request still waiting
+	// when it expires gets 504. 0 means no limit.
+	Timeout time.Duration
+	// RequestHeaders limits the client headers forwarded to the backend;
+	// nil forwards them all.
+	RequestHeaders *requestHeaders
+}
+
+// targetFor returns the backend for method and whether it was pinned by a
+// per-method override.
+func (rt *route) targetFor(method string) (string, bool) {
+	if t, ok := rt.Methods[method]; ok {
+		return t, true
+	}
+	return rt.Target, false
+}
+
+type routeConfig struct {
+	Prefix       string `json:"prefix"`
+	Target       string `json:"target"`
+	PreserveHost *bool  `json:"preserve_host,omitempty"`
+	// Methods maps HTTP methods to targets, for example sending writes to
+	// the monolith while reads follow Target.
+	Methods map[string]string `json:"methods,omitempty"`
+	// TimeoutMS overrides UPSTREAM_TIMEOUT_MS for this route; 0 disables the
+	// timeout.
+	TimeoutMS *int `json:"timeout_ms,omitempty"`
+	// RequestHeaders replaces the file-wide request_headers policy for this
+	// route; an empty object forwards every header.
+	RequestHeaders *requestHeaders `json:"request_headers,omitempty"`
+}
+
+// fileConfig is the optional JSON document referenced by PROXY_CONFIG_FILE.
+type fileConfig struct {
+	PreserveHost *bool         `json:"preserve_host,omitempty"`
+	Routes       []routeConfig `json:"routes"`
+	// Tenants pins tenants, from a verified JWT or X-Tenant-ID, to "movies"
+	// or "monolith" ahead of the percentage-based migration decision.
+	Tenants map[string]string `json:"tenants,omitempty"`
+
+	RequestHeaders  *requestHeaders  `json:"request_headers,omitempty"`
+	ResponseHeaders *responseHeaders `json:"response_headers,omitempty"`
+	Access          *accessConfig    `json:"access,omitempty"`
+	URLRewrite      *urlRewrite      `json:"url_rewrite,omitempty"`
+}
+
+var defaultRoutes = []routeConfig{
+	{Prefix: "/api/movies", Target: targetMovies},
+	{Prefix: "/api/events", Target: targetEvents},
+}
+
+func validTarget(target string) bool {
+	switch target {
+	case targetMonolith, targetMovies, targetEvents:
+		return true
+	}
+	return false
+}
+
+func loadFileConfig(path string) (*fileConfig, error) {
+	cfg := &fileConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := cfg.RequestHeaders.validate("request_headers"); err != nil {
+		return nil, err
+	}
+	if err := cfg.ResponseHeaders.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.URLRewrite.validate(); err != nil {
+		return nil, err
+	}
+	for tenant, target := range cfg.Tenants {
+		if target != targetMovies && target != targetMonolith {
+			return nil, fmt.Errorf("tenant %q: target must be movies or monolith, got %q", tenant, target)
+		}
+	}
+	return cfg, nil
+}
+
+// buildRoutes resolves the routing table from the config file, falling back
+// to the built-in routes, and returns it along with the catch-all route to
+// defaultTarget for unmatched paths. preserveHost and timeout are the global
+// PRESERVE_HOST and UPSTREAM_TIMEOUT_MS defaults applied to routes that don't
+// set their own.
+func buildRoutes(cfg *fileConfig, preserveHost bool, timeout time.Duration, defaultTarget string) ([]*route, *route, error) {
+	if !validTarget(defaultTarget) {
+		return nil, nil, fmt.Errorf("default route target %q must be monolith, movies or events", defaultTarget)
+	}
+	if cfg.PreserveHost != nil {
+		preserveHost = *cfg.PreserveHost
+	}
+	configs := cfg.Routes
+	if len(configs) == 0 {
+		configs = defaultRoutes
+	}
+
+	routes := make([]*route, 0, len(configs))
+	for _, rc := range configs {
+		if !strings.HasPrefix(rc.Prefix, "/") {
+			return nil, nil, fmt.Errorf("route prefix %q must start with /", rc.Prefix)
+		}
+		if !validTarget(rc.Target) {
+			return nil, nil, fmt.Errorf("route %s: unknown target %q", rc.Prefix, rc.Target)
+		}
+		for method, target := range rc.Methods {
+			if method != strings.ToUpper(method) || method == "" {
+				return nil, nil, fmt.Errorf("route %s: method %q must be upper case", rc.Prefix, method)
+			}
+			if !validTarget(target) {
+				return nil, nil, fmt.Errorf("route %s: unknown target %q for %s", rc.Prefix, target, method)
+			}
+		}
+		rt := &route{Prefix: rc.Prefix, Target: rc.Target, PreserveHost: preserveHost, Methods: rc.Methods, Timeout: timeout, RequestHeaders: cfg.RequestHeaders}
+		if rc.RequestHeaders != nil {
+			if err := rc.RequestHeaders.validate("route " + rc.Prefix + ": request_headers"); err != nil {
+				return nil, nil, err
+			}
+			rt.RequestHeaders = rc.RequestHeaders
+		}
+		if rc.PreserveHost != nil {
+			rt.PreserveHost = *rc.PreserveHost
+		}
+		if rc.TimeoutMS != nil {
+			if *rc.TimeoutMS < 0 {
+				return nil, nil, fmt.Errorf("route %s: timeout_ms must not be negative", rc.Prefix)
+			}
+			rt.Timeout = time.Duration(*rc.TimeoutMS) * time.Millisecond
+		}
+		routes = append(routes, rt)
+	}
+	fallback := &route{Prefix: "/", Target: defaultTarget, PreserveHost: preserveHost, Timeout: timeout, RequestHeaders: cfg.RequestHeaders}
+	return routes, fallback, nil
+}
+
+// matchRoute returns the first route whose prefix matches path, or fallback.
+func matchRoute(routes []*route, path string, fallback *route) *route {
+	for _, rt := range routes {
+		if strings.HasPrefix(path, rt.Prefix) {
+			return rt
+		}
+	}
+	return fallback
+}
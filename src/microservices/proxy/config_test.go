@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPreserveHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      bool
+		config   string
+		path     string
+		wantHost bool
+	}{
+		{"disabled by default", false, `{}`, "/api/movies", false},
+		{"PRESERVE_HOST", true, `{}`, "/api/movies", true},
+		{"config file overrides env", true, `{"preserve_host": false}`, "/api/movies", false},
+		{"route enables", false, `{"routes": [{"prefix": "/api/movies", "target": "monolith", "preserve_host": true}]}`, "/api/movies", true},
+		{"route disables", true, `{"routes": [{"prefix": "/api/movies", "target": "monolith", "preserve_host": false}]}`, "/api/movies", false},
+		{"fallback follows global", true, `{"routes": [{"prefix": "/api/movies", "target": "monolith", "preserve_host": false}]}`, "/api/users", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg fileConfig
+			if err := json.Unmarshal([]byte(tt.config), &cfg); err != nil {
+				t.Fatal(err)
+			}
+			routes, fallback, err := buildRoutes(&cfg, tt.env, 0, targetMonolith)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotHost string
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { gotHost = r.Host }))
+			defer upstream.Close()
+			u, _ := url.Parse(upstream.URL)
+			proxy := newUpstreamProxy("monolith", u, http.DefaultTransport)
+
+			r := httptest.NewRequest(http.MethodGet, "http://cinema.example.com"+tt.path, nil)
+			serve(proxy, withRoute(r, matchRoute(routes, tt.path, fallback)))
+			want := u.Host
+			if tt.wantHost {
+				want = "cinema.example.com"
+			}
+			if gotHost != want {
+				t.Fatalf("upstream saw Host %q, want %q", gotHost, want)
+			}
+		})
+	}
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	ms := func(v int) *int { return &v }
+	cfg := &fileConfig{Routes: []routeConfig{
+		{Prefix: "/api/movies/search", Target: targetMonolith, TimeoutMS: ms(50)},
+		{Prefix: "/api/movies", Target: targetMonolith, TimeoutMS: ms(2000)},
+		{Prefix: "/api/reports", Target: targetMonolith, TimeoutMS: ms(0)},
+	}}
+	tests := []struct {
+		name        string
+		path        string
+		delay       time.Duration
+		wantStatus  int
+		wantTimeout time.Duration
+	}{
+		{"slow route with a short timeout", "/api/movies/search?q=heat", 500 * time.Millisecond, http.StatusGatewayTimeout, 50 * time.Millisecond},
+		{"fast route with a long timeout", "/api/movies/7", 150 * time.Millisecond, http.StatusOK, 2 * time.Second},
+		{"global default on the default route", "/api/users", 500 * time.Millisecond, http.StatusGatewayTimeout, 100 * time.Millisecond},
+		{"timeout disabled", "/api/reports", 150 * time.Millisecond, http.StatusOK, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.delay):
+					w.Write([]byte("done"))
+				case <-r.Context().Done():
+				}
+			}))
+			defer upstream.Close()
+			u, _ := url.Parse(upstream.URL)
+
+			routes, fallback, err := buildRoutes(cfg, false, 100*time.Millisecond, targetMonolith)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rt := matchRoute(routes, tt.path, fallback); rt.Timeout != tt.wantTimeout {
+				t.Fatalf("%s timeout = %s, want %s", tt.path, rt.Timeout, tt.wantTimeout)
+			}
+			s := newTestProxy(t, newUpstreamProxy("monolith", u, http.DefaultTransport), named("movies-service"))
+			s.routes, s.defaultRoute = routes, fallback
+
+			start := time.Now()
+			rec := serve(s, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusGatewayTimeout && time.Since(start) >= tt.delay {
+				t.Errorf("504 after %s, want it once the %s timeout expired", time.Since(start), tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestRouteTimeoutConfig(t *testing.T) {
+	negative := -1
+	cfg := &fileConfig{Routes: []routeConfig{{Prefix: "/api/movies", Target: targetMovies, TimeoutMS: &negative}}}
+	if _, _, err := buildRoutes(cfg, false, 0, targetMonolith); err == nil {
+		t.Error("negative timeout_ms accepted")
+	}
+
+	example, err := loadFileConfig("config.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, _, err := buildRoutes(example, false, 0, targetMonolith)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Duration{"/api/movies": 5 * time.Second, "/api/events": 2 * time.Second}
+	for _, rt := range routes {
+		if timeout, ok := want[rt.Prefix]; ok && rt.Timeout != timeout {
+			t.Errorf("%s timeout = %s, want %s", rt.Prefix, rt.Timeout, timeout)
+		}
+	}
+}
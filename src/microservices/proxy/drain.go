@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// drainPollInterval is how often the shutdown signal file is checked.
+const drainPollInterval = time.Second
+
+// drainShutdownTimeout bounds how long in-flight requests may take to finish
+// once the grace window is over.
+const drainShutdownTimeout = 30 * time.Second
+
+// draining is set once shutdown has been initiated. From then on readiness
+// fails so the deployment controller takes the proxy out of rotation, while
+// requests are still served until the grace window ends.
+var draining atomic.Bool
+
+// drainer starts draining when the signal file appears or the process gets
+// SIGTERM or SIGINT, waits out the grace window and then shuts srv down.
+type drainer struct {
+	srv   *http.Server
+	file  string
+	grace time.Duration
+	done  chan struct{}
+}
+
+func newDrainer(srv *http.Server, file string, grace time.Duration) *drainer {
+	return &drainer{srv: srv, file: file, grace: grace, done: make(chan struct{})}
+}
+
+func (d *drainer) signalled() bool {
+	if d.file == "" {
+		return false
+	}
+	_, err := os.Stat(d.file)
+	return err == nil
+}
+
+func (d *drainer) start() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(drainPollInterval)
+		defer ticker.Stop()
+		reason := ""
+		for reason == "" {
+			select {
+			case sig := <-sigs:
+				reason = sig.String()
+			case <-ticker.C:
+				if d.signalled() {
+					reason = "signal file " + d.file
+				}
+			}
+		}
+
+		draining.Store(true)
+		log.Printf("[SHUTDOWN] Shutdown initiated by %s, failing readiness and serving for %s", reason, d.grace)
+		select {
+		case <-time.After(d.grace):
+		case sig := <-sigs:
+			log.Printf("[SHUTDOWN] Grace window cut short by %s", sig)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), drainShutdownTimeout)
+		defer cancel()
+		if err := d.srv.Shutdown(ctx); err != nil {
+			log.Printf("[SHUTDOWN] Failed to finish in-flight requests: %v", err)
+			return
+		}
+		log.Printf("[SHUTDOWN] Proxy stopped")
+	}()
+}
+
+// wait blocks until a shutdown started by the drainer has completed. It is
+// called once the server has stopped serving, so a listener error that was
+// not caused by the drainer is returned immediately.
+func (d *drainer) wait(serveErr error) error {
+	if !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	<-d.done
+	return nil
+}
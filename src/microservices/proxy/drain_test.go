@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDrainerSignalled(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "shutdown")
+	if err := os.WriteFile(present, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		file string
+		want bool
+	}{
+		{"no signal file configured", "", false},
+		{"signal file absent", filepath.Join(dir, "missing"), false},
+		{"signal file present", present, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newDrainer(&http.Server{}, tt.file, time.Second).signalled(); got != tt.want {
+				t.Errorf("signalled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDrainerGraceWindow(t *testing.T) {
+	defer draining.Store(false)
+	signal := filepath.Join(t.TempDir(), "shutdown")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/proxy/health", handleProxyHealth(nil))
+	mux.HandleFunc("/api/movies", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("[]")) })
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: mux}
+	drain := newDrainer(srv, signal, 2*drainPollInterval)
+	drain.start()
+
+	addrs := make(chan net.Addr, 1)
+	served := make(chan error, 1)
+	go func() { served <- listenAndServe(srv, func(addr net.Addr) { addrs <- addr }) }()
+	base := "http://" + (<-addrs).String()
+	status := func(path string) int {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		name       string
+		wantHealth int
+		wantAPI    int
+	}{
+		{"before the signal", http.StatusOK, http.StatusOK},
+		{"during the grace window", http.StatusServiceUnavailable, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if tt.wantHealth == http.StatusServiceUnavailable {
+			if err := os.WriteFile(signal, nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			deadline := time.Now().Add(3 * drainPollInterval)
+			for !draining.Load() && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		if got := status("/proxy/health"); got != tt.wantHealth {
+			t.Errorf("%s: readiness = %d, want %d", tt.name, got, tt.wantHealth)
+		}
+		if got := status("/api/movies"); got != tt.wantAPI {
+			t.Errorf("%s: traffic = %d, want %d", tt.name, got, tt.wantAPI)
+		}
+	}
+
+	select {
+	case err := <-served:
+		if err := drain.wait(err); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	case <-time.After(4 * drainPollInterval):
+		t.Fatal("server still running after the grace window")
+	}
+	if got := status("/api/movies"); got != 0 {
+		t.Errorf("traffic after shutdown = %d, want the connection refused", got)
+	}
+}
+
+func TestDrainerWaitReturnsListenErrors(t *testing.T) {
+	// A listener that never came up must not block on a drain that will
+	// never start.
+	d := newDrainer(&http.Server{}, "", time.Second)
+	if err := d.wait(&net.OpError{Op: "listen", Net: "tcp"}); err == nil {
+		t.Fatal("wait swallowed the listener error")
+	}
+}
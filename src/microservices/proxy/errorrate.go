@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errorWindowBuckets is the resolution of the sliding window: it advances in
+// steps of window/errorWindowBuckets.
+const errorWindowBuckets = 10
+
+type errorBucket struct {
+	start    time.Time
+	requests int
+	errors   int
+}
+
+// errorWindow tracks the share of 5xx responses a backend returned over a
+// sliding window. A backend is degraded once its error rate exceeds
+// threshold, provided it served at least minRequests in the window so a
+// single failure on a quiet backend does not trip it.
+type errorWindow struct {
+	window      time.Duration
+	threshold   float64
+	minRequests int
+
+	mu      sync.Mutex
+	buckets [errorWindowBuckets]errorBucket
+}
+
+func newErrorWindow(window time.Duration, threshold float64, minRequests int) *errorWindow {
+	return &errorWindow{window: window, threshold: threshold, minRequests: minRequests}
+}
+
+func (e *errorWindow) bucketFor(now time.Time) *errorBucket {
+	size := e.window / errorWindowBuckets
+	start := now.Truncate(size)
+	b := &e.buckets[(start.UnixNano()/int64(size))%errorWindowBuckets]
+	if !b.start.Equal(start) {
+		*b = errorBucket{start: start}
+	}
+	return b
+}
+
+func (e *errorWindow) record(now time.Time, status int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	b := e.bucketFor(now)
+	b.requests++
+	if status >= 500 {
+		b.errors++
+	}
+}
+
+type errorRate struct {
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	Rate     float64 `json:"error_rate"`
+	Degraded bool    `json:"degraded"`
+}
+
+func (e *errorWindow) rate(now time.Time) errorRate {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var r errorRate
+	cutoff := now.Add(-e.window)
+	for _, b := range e.buckets {
+		if b.start.After(cutoff) {
+			r.Requests += b.requests
+			r.Errors += b.errors
+		}
+	}
+	if r.Requests > 0 {
+		r.Rate = float64(r.Errors) / float64(r.Requests)
+	}
+	r.Degraded = r.Requests >= e.minRequests && r.Rate > e.threshold
+	return r
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, which the
+// reverse proxy uses to flush streamed responses.
+func (w *statusRecorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// observe wraps a backend handler so each response is counted in the window.
+func (e *errorWindow) observe(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		e.record(time.Now(), rec.status)
+	})
+}
+
+type backendErrorRate struct {
+	Name string `json:"name"`
+	errorRate
+}
+
+type proxyHealth struct {
+	Ready    bool               `json:"ready"`
+	Draining bool               `json:"draining,omitempty"`
+	Backends []backendErrorRate `json:"backends"`
+}
+
+// healthReport is ready unless a backend is degraded or the proxy is
+// draining for shutdown.
+func healthReport(backends []*backend) proxyHealth {
+	now := time.Now()
+	report := proxyHealth{Ready: !draining.Load(), Draining: draining.Load()}
+	for _, b := range backends {
+		rate := b.errors.rate(now)
+		if rate.Degraded {
+			report.Ready = false
+		}
+		report.Backends = append(report.Backends, backendErrorRate{Name: b.name, errorRate: rate})
+	}
+	return report
+}
+
+// handleProxyHealth reports the observed error rate of every backend and
+// answers 503 while any of them is degraded or the proxy is draining.
+func handleProxyHealth(backends []*backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := healthReport(backends)
+		status := http.StatusOK
+		if !report.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, r, status, report)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestErrorWindow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		statuses     []int
+		age          time.Duration
+		wantRate     float64
+		wantDegraded bool
+	}{
+		{"healthy", repeatStatus(http.StatusOK, 20), 0, 0, false},
+		{"burst of 5xx", append(repeatStatus(http.StatusOK, 10), repeatStatus(http.StatusBadGateway, 10)...), 0, 0.5, true},
+		{"at the threshold", append(repeatStatus(http.StatusOK, 16), repeatStatus(http.StatusInternalServerError, 4)...), 0, 0.2, false},
+		{"4xx are not errors", repeatStatus(http.StatusNotFound, 20), 0, 0, false},
+		{"too few requests", repeatStatus(http.StatusServiceUnavailable, 5), 0, 1, false},
+		{"burst aged out of the window", repeatStatus(http.StatusBadGateway, 20), 2 * time.Minute, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newErrorWindow(time.Minute, 0.2, 10)
+			for _, status := range tt.statuses {
+				e.record(now, status)
+			}
+			got := e.rate(now.Add(tt.age))
+			if got.Rate != tt.wantRate || got.Degraded != tt.wantDegraded {
+				t.Errorf("rate = %+v, want rate %v degraded %v", got, tt.wantRate, tt.wantDegraded)
+			}
+		})
+	}
+}
+
+func repeatStatus(status, n int) []int {
+	statuses := make([]int, n)
+	for i := range statuses {
+		statuses[i] = status
+	}
+	return statuses
+}
+
+func TestProxyHealthDegraded(t *testing.T) {
+	status := http.StatusOK
+	movies := testBackend("movies-service", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	monolith := testBackend("monolith", named("monolith"))
+	backends := []*backend{monolith, movies}
+	for _, b := range backends {
+		b.errors = newErrorWindow(time.Minute, 0.2, 5)
+		b.proxy = b.errors.observe(b.proxy)
+	}
+
+	steps := []struct {
+		name       string
+		status     int
+		requests   int
+		wantStatus int
+		wantReady  bool
+	}{
+		{"healthy", http.StatusOK, 10, http.StatusOK, true},
+		{"burst of 5xx", http.StatusBadGateway, 10, http.StatusServiceUnavailable, false},
+		{"recovering traffic dilutes the rate", http.StatusOK, 40, http.StatusOK, true},
+	}
+	for _, s := range steps {
+		status = s.status
+		for i := 0; i < s.requests; i++ {
+			serve(movies.proxy, httptest.NewRequest(http.MethodGet, "/api/movies", nil))
+		}
+		rec := serve(handleProxyHealth(backends), httptest.NewRequest(http.MethodGet, "/proxy/health", nil))
+		if rec.Code != s.wantStatus {
+			t.Fatalf("%s: status = %d, want %d", s.name, rec.Code, s.wantStatus)
+		}
+		var report proxyHealth
+		decodeJSON(t, rec, &report)
+		if report.Ready != s.wantReady || len(report.Backends) != 2 {
+			t.Fatalf("%s: report = %+v", s.name, report)
+		}
+		if got := report.Backends[1]; got.Name != "movies-service" || got.Degraded == s.wantReady {
+			t.Fatalf("%s: movies-service = %+v, want degraded %v", s.name, got, !s.wantReady)
+		}
+		if movies.isDegraded() == s.wantReady {
+			t.Fatalf("%s: isDegraded = %v", s.name, movies.isDegraded())
+		}
+	}
+}
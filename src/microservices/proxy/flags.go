@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// flagClient asks an external feature-flag service whether a request should
+// go to movies-service. The service is called as
+//
+//	GET <FLAG_SERVICE_URL>?flag=<name>&user=<X-User-ID>
+//
+// and must answer {"enabled": true|false}; an adapter in front of
+// Unleash or LaunchDarkly is enough. Results, including failures, are cached
+// per user for ttl so a slow flag service costs at most one call per user
+// per ttl.
+type flagClient struct {
+	url     string
+	flag    string
+	ttl     time.Duration
+	timeout time.Duration
+	client  *http.Client
+
+	mu    sync.Mutex
+	cache map[string]flagResult
+}
+
+type flagResult struct {
+	enabled bool
+	ok      bool
+	expires time.Time
+}
+
+func newFlagClient(serviceURL, flag string, ttl, timeout time.Duration) *flagClient {
+	return &flagClient{
+		url:     serviceURL,
+		flag:    flag,
+		ttl:     ttl,
+		timeout: timeout,
+		client:  &http.Client{},
+		cache:   make(map[string]flagResult),
+	}
+}
+
+// evaluate returns the flag for r's user. ok is false when the flag service
+// could not be reached, in which case the caller falls back to the
+// percentage split.
+func (f *flagClient) evaluate(r *http.Request) (enabled, ok bool) {
+	user := requestUser(r)
+	now := time.Now()
+
+	f.mu.Lock()
+	res, hit := f.cache[user]
+	f.mu.Unlock()
+	if hit && now.Before(res.expires) {
+		return res.enabled, res.ok
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), f.timeout)
+	defer cancel()
+	enabled, err := f.fetch(ctx, user)
+	if err != nil {
+		log.Printf("Flag service unavailable, using migration percentage: %v", err)
+	}
+	res = flagResult{enabled: enabled, ok: err == nil, expires: now.Add(f.ttl)}
+
+	f.mu.Lock()
+	f.cache[user] = res
+	if len(f.cache) > maxFlagCacheEntries {
+		f.evictExpiredLocked(now)
+	}
+	f.mu.Unlock()
+	return res.enabled, res.ok
+}
+
+// cached is evaluate without calling the flag service: hit is false when
+// r's user has no unexpired result.
+func (f *flagClient) cached(r *http.Request) (enabled, ok, hit bool) {
+	f.mu.Lock()
+	res, hit := f.cache[requestUser(r)]
+	f.mu.Unlock()
+	if !hit || !time.Now().Before(res.expires) {
+		return false, false, false
+	}
+	return res.enabled, res.ok, true
+}
+
+// maxFlagCacheEntries triggers a sweep of expired entries so a stream of
+// distinct users cannot grow the cache without bound.
+const maxFlagCacheEntries = 10000
+
+func (f *flagClient) evictExpiredLocked(now time.Time) {
+	for user, res := range f.cache {
+		if !now.Before(res.expires) {
+			delete(f.cache, user)
+		}
+	}
+}
+
+func (f *flagClient) fetch(ctx context.Context, user string) (bool, error) {
+	u, err := url.Parse(f.url)
+	if err != nil {
+		return false, err
+	}
+	q := u.Query()
+	q.Set("flag", f.flag)
+	if user != "" {
+		q.Set("user", user)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("flag service returned %d", resp.StatusCode)
+	}
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, fmt.Errorf("decode flag response: %w", err)
+	}
+	if body.Enabled == nil {
+		return false, fmt.Errorf("flag response has no enabled field")
+	}
+	return *body.Enabled, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubFlagService answers every evaluation with status and body and counts
+// the calls it receives.
+func stubFlagService(t *testing.T, status int, body string, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Query().Get("flag") != "movies-migration" || r.URL.Query().Get("user") != "42" {
+			t.Errorf("flag service called with %s", r.URL.RawQuery)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFeatureFlagRouting(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		unreachable bool
+		percent     int
+		wantBackend string
+	}{
+		{"enabled overrides 0%", http.StatusOK, `{"enabled": true}`, false, 0, "movies-service"},
+		{"disabled overrides 100%", http.StatusOK, `{"enabled": false}`, false, 100, "monolith"},
+		{"error fails open to 100%", http.StatusInternalServerError, ``, false, 100, "movies-service"},
+		{"error fails open to 0%", http.StatusInternalServerError, ``, false, 0, "monolith"},
+		{"missing field fails open", http.StatusOK, `{}`, false, 100, "movies-service"},
+		{"malformed fails open", http.StatusOK, `{"enabled":`, false, 100, "movies-service"},
+		{"unreachable fails open", 0, ``, true, 100, "movies-service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := stubFlagService(t, tt.status, tt.body, &calls)
+			if tt.unreachable {
+				server.Close()
+			}
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.gradualMigration, s.migrationPercent = true, tt.percent
+			s.flags = newFlagClient(server.URL, "movies-migration", time.Minute, time.Second)
+
+			for i := 0; i < 3; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+				r.Header.Set("X-User-ID", "42")
+				if got := serve(s, r).Header().Get("X-Backend"); got != tt.wantBackend {
+					t.Fatalf("request %d went to %q, want %q", i, got, tt.wantBackend)
+				}
+			}
+			if !tt.unreachable && calls.Load() != 1 {
+				t.Errorf("flag service called %d times, want 1 within the cache ttl", calls.Load())
+			}
+		})
+	}
+}
+
+func TestFlagCacheExpiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		wantCalls int32
+	}{
+		{"cached", time.Minute, 1},
+		{"expired", 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := stubFlagService(t, http.StatusOK, `{"enabled": true}`, &calls)
+			f := newFlagClient(server.URL, "movies-migration", tt.ttl, time.Second)
+			for i := 0; i < 3; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+				r.Header.Set("X-User-ID", "42")
+				if enabled, ok := f.evaluate(r); !enabled || !ok {
+					t.Fatalf("evaluate = %v, %v; want enabled", enabled, ok)
+				}
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("flag service called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
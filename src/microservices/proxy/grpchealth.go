@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// grpcHealth serves the standard grpc.health.v1.Health service for mesh
+// probes. The overall ("") status follows ready, re-evaluated every interval,
+// so it agrees with /proxy/health.
+type grpcHealth struct {
+	server *grpc.Server
+	health *health.Server
+}
+
+func startGRPCHealth(ctx context.Context, addr string, interval time.Duration, ready func() bool) (*grpcHealth, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	g := &grpcHealth{server: grpc.NewServer(), health: health.NewServer()}
+	healthpb.RegisterHealthServer(g.server, g.health)
+	g.update(ready())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				g.update(ready())
+			}
+		}
+	}()
+	go func() {
+		if err := g.server.Serve(lis); err != nil {
+			log.Printf("gRPC health server stopped: %v", err)
+		}
+	}()
+	return g, nil
+}
+
+func (g *grpcHealth) update(ready bool) {
+	status := healthpb.HealthCheckResponse_SERVING
+	if !ready {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	g.health.SetServingStatus("", status)
+}
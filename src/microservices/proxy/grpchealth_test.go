@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestGRPCHealthTransitions(t *testing.T) {
+	movies := testBackend("movies-service", nil)
+	movies.errors = newErrorWindow(time.Minute, 0.2, 5)
+	monolith := testBackend("monolith", nil)
+	monolith.errors = newErrorWindow(time.Minute, 0.2, 5)
+	backends := []*backend{monolith, movies}
+	defer draining.Store(false)
+
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g, err := startGRPCHealth(ctx, addr, 10*time.Millisecond, func() bool { return healthReport(backends).Ready })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.server.Stop()
+	client := healthClient(t, addr)
+
+	steps := []struct {
+		name  string
+		apply func()
+		want  healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{"ready", func() {}, healthpb.HealthCheckResponse_SERVING},
+		{"backend degraded", func() {
+			for i := 0; i < 10; i++ {
+				movies.errors.record(time.Now(), http.StatusBadGateway)
+			}
+		}, healthpb.HealthCheckResponse_NOT_SERVING},
+		{"backend recovered", func() {
+			for i := 0; i < 100; i++ {
+				movies.errors.record(time.Now(), http.StatusOK)
+			}
+		}, healthpb.HealthCheckResponse_SERVING},
+		{"draining", func() { draining.Store(true) }, healthpb.HealthCheckResponse_NOT_SERVING},
+	}
+	for _, s := range steps {
+		s.apply()
+		waitForStatus(t, client, s.name, s.want)
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+func healthClient(t *testing.T, addr string) healthpb.HealthClient {
+	t.Helper()
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func waitForStatus(t *testing.T, client healthpb.HealthClient, step string, want healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+	var got healthpb.HealthCheckResponse_ServingStatus
+	var err error
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		var resp *healthpb.HealthCheckResponse
+		if resp, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err == nil {
+			if got = resp.Status; got == want {
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%s: status = %v (err %v), want %v", step, got, err, want)
+}
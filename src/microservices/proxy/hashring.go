@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// hashRing maps keys onto nodes with consistent hashing. Each node owns
+// several virtual points on the ring so load spreads evenly, and adding or
+// removing a node only moves the keys that land on its points.
+type hashRing struct {
+	vnodes int
+	points []uint32
+	owners map[uint32]string
+}
+
+func newHashRing(nodes []string, vnodes int) *hashRing {
+	if vnodes <= 0 {
+		vnodes = 1
+	}
+	h := &hashRing{vnodes: vnodes, owners: make(map[uint32]string)}
+	for _, n := range nodes {
+		h.add(n)
+	}
+	return h
+}
+
+// hashKey uses the first four bytes of MD5 like ketama; FNV clusters badly
+// on the short, similar strings used for virtual node names.
+func hashKey(key string) uint32 {
+	sum := md5.Sum([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func (h *hashRing) add(node string) {
+	for i := 0; i < h.vnodes; i++ {
+		p := hashKey(node + "#" + strconv.Itoa(i))
+		if _, taken := h.owners[p]; taken {
+			continue
+		}
+		h.owners[p] = node
+		h.points = append(h.points, p)
+	}
+	sort.Slice(h.points, func(i, j int) bool { return h.points[i] < h.points[j] })
+}
+
+func (h *hashRing) remove(node string) {
+	points := h.points[:0]
+	for _, p := range h.points {
+		if h.owners[p] == node {
+			delete(h.owners, p)
+			continue
+		}
+		points = append(points, p)
+	}
+	h.points = points
+}
+
+// lookup returns the node owning key, walking clockwise past nodes for which
+// usable reports false. Keys of an unusable node therefore move to the next
+// node on the ring while everybody else's keys stay put.
+func (h *hashRing) lookup(key string, usable func(string) bool) (string, bool) {
+	if len(h.points) == 0 {
+		return "", false
+	}
+	start := sort.Search(len(h.points), func(i int) bool { return h.points[i] >= hashKey(key) })
+	for i := 0; i < len(h.points); i++ {
+		node := h.owners[h.points[(start+i)%len(h.points)]]
+		if usable == nil || usable(node) {
+			return node, true
+		}
+	}
+	return "", false
+}
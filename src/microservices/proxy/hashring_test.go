@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func ringUsers(n int) []string {
+	users := make([]string, n)
+	for i := range users {
+		users[i] = fmt.Sprintf("user-%d", i)
+	}
+	return users
+}
+
+func TestHashRingStable(t *testing.T) {
+	nodes := []string{"movies-1", "movies-2", "movies-3", "movies-4"}
+	a, b := newHashRing(nodes, 100), newHashRing([]string{"movies-4", "movies-2", "movies-1", "movies-3"}, 100)
+	for _, user := range ringUsers(1000) {
+		first, _ := a.lookup(user, nil)
+		again, _ := a.lookup(user, nil)
+		other, _ := b.lookup(user, nil)
+		if first != again || first != other {
+			t.Fatalf("user %s mapped to %s, %s and %s", user, first, again, other)
+		}
+	}
+}
+
+func TestHashRingMinimalMovement(t *testing.T) {
+	nodes := []string{"movies-1", "movies-2", "movies-3", "movies-4"}
+	users := ringUsers(4000)
+	tests := []struct {
+		name   string
+		change func(h *hashRing) (usable func(string) bool)
+	}{
+		{"node removed", func(h *hashRing) func(string) bool { h.remove("movies-3"); return nil }},
+		{"node unhealthy", func(h *hashRing) func(string) bool { return func(n string) bool { return n != "movies-3" } }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHashRing(nodes, 100)
+			before := make(map[string]string, len(users))
+			counts := make(map[string]int)
+			for _, u := range users {
+				before[u], _ = h.lookup(u, nil)
+				counts[before[u]]++
+			}
+			usable := tt.change(h)
+			moved := 0
+			for _, u := range users {
+				after, _ := h.lookup(u, usable)
+				if after == "movies-3" {
+					t.Fatalf("user %s still on the removed node", u)
+				}
+				if after != before[u] {
+					if before[u] != "movies-3" {
+						t.Fatalf("user %s moved from %s to %s although its node stayed", u, before[u], after)
+					}
+					moved++
+				}
+			}
+			if moved != counts["movies-3"] {
+				t.Fatalf("moved %d users, want exactly the %d of movies-3", moved, counts["movies-3"])
+			}
+			// With 100 virtual nodes each of 4 nodes should hold roughly a quarter.
+			for n, c := range counts {
+				if c < len(users)/8 || c > len(users)/2 {
+					t.Errorf("%s holds %d of %d users", n, c, len(users))
+				}
+			}
+		})
+	}
+}
+
+func TestStickyPoolRouting(t *testing.T) {
+	p := testPool("movies-service", named("a"), named("b"), named("c"))
+	for _, user := range ringUsers(50) {
+		r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+		r.Header.Set("X-User-ID", user)
+		first := p.pick(r)
+		for i := 0; i < 5; i++ {
+			if got := p.pick(r); got != first {
+				t.Fatalf("user %s moved from %s to %s", user, first.name, got.name)
+			}
+		}
+	}
+}
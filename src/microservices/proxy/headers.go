@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// responseHeaders is the "response_headers" section of the config file. It
+// applies to every proxied response: Remove strips headers the backends set,
+// such as Server or X-Powered-By, and Set adds or overrides headers after
+// the removals.
+type responseHeaders struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+func (h *responseHeaders) empty() bool {
+	return h == nil || (len(h.Set) == 0 && len(h.Remove) == 0)
+}
+
+func (h *responseHeaders) validate() error {
+	if h == nil {
+		return nil
+	}
+	for name := range h.Set {
+		if !validHeaderName(name) {
+			return fmt.Errorf("response_headers.set: invalid header name %q", name)
+		}
+	}
+	for _, name := range h.Remove {
+		if !validHeaderName(name) {
+			return fmt.Errorf("response_headers.remove: invalid header name %q", name)
+		}
+	}
+	return nil
+}
+
+// validHeaderName accepts RFC 7230 token characters only.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func injectResponseHeaders(h *responseHeaders) responseModifier {
+	return func(resp *http.Response) error {
+		for _, name := range h.Remove {
+			resp.Header.Del(name)
+		}
+		for name, value := range h.Set {
+			resp.Header.Set(name, value)
+		}
+		return nil
+	}
+}
+
+// essentialRequestHeaders reach the upstream whatever a requestHeaders
+// policy says: without them bodies, content negotiation, client addressing
+// and X-Safe-Retry, which the transport consumes later, stop working.
+var essentialRequestHeaders = map[string]bool{
+	"Accept":            true,
+	"Accept-Encoding":   true,
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Te":                true,
+	"Transfer-Encoding": true,
+	"X-Forwarded-For":   true,
+	"X-Forwarded-Host":  true,
+	"X-Forwarded-Proto": true,
+	safeRetryHeader:     true,
+}
+
+// requestHeaders limits which client headers are forwarded upstream. With
+// Allow set, only the listed headers (and the essential ones) pass; Deny
+// strips headers after that. A name ending in "*" matches every header with
+// that prefix, such as "X-Internal-*". It is set for the whole config file as
+// "request_headers" and can be overridden per route.
+type requestHeaders struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+func (h *requestHeaders) empty() bool {
+	return h == nil || (len(h.Allow) == 0 && len(h.Deny) == 0)
+}
+
+func (h *requestHeaders) validate(section string) error {
+	if h == nil {
+		return nil
+	}
+	for _, name := range h.Allow {
+		if !validHeaderName(strings.TrimSuffix(name, "*")) {
+			return fmt.Errorf("%s.allow: invalid header name %q", section, name)
+		}
+	}
+	for _, name := range h.Deny {
+		if !validHeaderName(strings.TrimSuffix(name, "*")) {
+			return fmt.Errorf("%s.deny: invalid header name %q", section, name)
+		}
+		if essentialRequestHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("%s.deny: %s is always forwarded", section, name)
+		}
+	}
+	return nil
+}
+
+// filter removes the headers the policy does not forward. It is safe to call
+// on nil.
+func (h *requestHeaders) filter(header http.Header) {
+	if h.empty() {
+		return
+	}
+	for name := range header {
+		if essentialRequestHeaders[name] {
+			continue
+		}
+		if (len(h.Allow) > 0 && !matchHeader(h.Allow, name)) || matchHeader(h.Deny, name) {
+			header.Del(name)
+		}
+	}
+}
+
+func matchHeader(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestResponseHeaderInjection(t *testing.T) {
+	cfg, err := loadFileConfig("config.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "gunicorn")
+		w.Header().Set("X-Powered-By", "Express")
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Header().Set("X-Request-Cost", "3")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+
+	tests := []struct {
+		name    string
+		headers *responseHeaders
+		want    map[string]string
+	}{
+		{"example config", cfg.ResponseHeaders, map[string]string{
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+			"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+			"X-Served-By":               "cinemaabyss-proxy",
+			"Server":                    "",
+			"X-Powered-By":              "",
+			"X-Request-Cost":            "3",
+		}},
+		{"set after remove", &responseHeaders{Remove: []string{"x-served-by"}, Set: map[string]string{"X-Served-By": "proxy-2"}}, map[string]string{
+			"X-Served-By": "proxy-2",
+			"Server":      "gunicorn",
+		}},
+		{"none", nil, map[string]string{"Server": "gunicorn", "X-Frame-Options": "SAMEORIGIN", "X-Content-Type-Options": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var modifiers []responseModifier
+			if !tt.headers.empty() {
+				modifiers = append(modifiers, injectResponseHeaders(tt.headers))
+			}
+			p := newUpstreamProxy("monolith", u, http.DefaultTransport, modifiers...)
+			rec := serve(p, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+			if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+				t.Fatalf("response = %d %q", rec.Code, rec.Body.String())
+			}
+			for name, want := range tt.want {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseHeadersConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"valid", `{"response_headers": {"set": {"X-Served-By": "proxy"}, "remove": ["Server"]}}`, ""},
+		{"bad set name", `{"response_headers": {"set": {"X Served": "proxy"}}}`, `response_headers.set: invalid header name "X Served"`},
+		{"bad remove name", `{"response_headers": {"remove": ["Server:"]}}`, `response_headers.remove: invalid header name "Server:"`},
+		{"empty remove name", `{"response_headers": {"remove": [""]}}`, "response_headers.remove"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadFileConfig(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestHeaderFilter(t *testing.T) {
+	sent := http.Header{
+		"Authorization":     {"Bearer t"},
+		"Content-Type":      {"application/json"},
+		"X-Forwarded-For":   {"10.0.0.1"},
+		"X-Internal-Secret": {"s"},
+		"X-Internal-Trace":  {"1"},
+		"X-Debug":           {"1"},
+		"X-Request-Id":      {"r1"},
+		"Cookie":            {"session=1"},
+	}
+	tests := []struct {
+		name   string
+		policy *requestHeaders
+		want   []string
+	}{
+		{"no policy forwards everything", nil, []string{"Authorization", "Content-Type", "Cookie", "X-Debug", "X-Forwarded-For", "X-Internal-Secret", "X-Internal-Trace", "X-Request-Id"}},
+		{"empty policy forwards everything", &requestHeaders{}, []string{"Authorization", "Content-Type", "Cookie", "X-Debug", "X-Forwarded-For", "X-Internal-Secret", "X-Internal-Trace", "X-Request-Id"}},
+		{"deny strips listed headers and prefixes", &requestHeaders{Deny: []string{"x-internal-*", "X-Debug"}}, []string{"Authorization", "Content-Type", "Cookie", "X-Forwarded-For", "X-Request-Id"}},
+		{"allow keeps listed and essential headers", &requestHeaders{Allow: []string{"Authorization", "x-request-id"}}, []string{"Authorization", "Content-Type", "X-Forwarded-For", "X-Request-Id"}},
+		{"deny applies after allow", &requestHeaders{Allow: []string{"X-Internal-*", "Authorization"}, Deny: []string{"X-Internal-Secret"}}, []string{"Authorization", "Content-Type", "X-Forwarded-For", "X-Internal-Trace"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := sent.Clone()
+			tt.policy.filter(h)
+			var got []string
+			for name := range h {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("forwarded %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestHeadersPerRoute(t *testing.T) {
+	cfg := &fileConfig{
+		RequestHeaders: &requestHeaders{Deny: []string{"X-Internal-*"}},
+		Routes: []routeConfig{
+			{Prefix: "/api/events", Target: targetMonolith, RequestHeaders: &requestHeaders{Allow: []string{"X-Request-ID"}}},
+			{Prefix: "/api/movies", Target: targetMonolith, RequestHeaders: &requestHeaders{}},
+		},
+	}
+	tests := []struct {
+		name     string
+		path     string
+		wantKept []string
+		wantGone []string
+	}{
+		{"file-wide deny on the default route", "/api/users", []string{"X-Request-Id", "X-Tenant-Id", "Content-Type"}, []string{"X-Internal-Token"}},
+		{"route allowlist", "/api/events/movie", []string{"X-Request-Id", "Content-Type"}, []string{"X-Tenant-Id", "X-Internal-Token"}},
+		{"empty route policy forwards everything", "/api/movies", []string{"X-Request-Id", "X-Tenant-Id", "X-Internal-Token", "Content-Type"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+			}))
+			defer upstream.Close()
+			u, _ := url.Parse(upstream.URL)
+
+			routes, fallback, err := buildRoutes(cfg, false, 0, targetMonolith)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := newTestProxy(t, newUpstreamProxy("monolith", u, http.DefaultTransport), named("movies-service"))
+			s.routes, s.defaultRoute = routes, fallback
+
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-Request-ID", "r1")
+			r.Header.Set("X-Tenant-ID", "acme")
+			r.Header.Set("X-Internal-Token", "secret")
+			if rec := serve(s, r); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			for _, name := range tt.wantKept {
+				if got.Get(name) == "" {
+					t.Errorf("%s was stripped", name)
+				}
+			}
+			for _, name := range tt.wantGone {
+				if got.Get(name) != "" {
+					t.Errorf("%s reached the upstream", name)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestHeadersConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"valid", `{"request_headers": {"allow": ["Authorization", "X-Tenant-*"], "deny": ["X-Debug"]}}`, false},
+		{"invalid allow name", `{"request_headers": {"allow": ["Bad Header"]}}`, true},
+		{"essential header denied", `{"request_headers": {"deny": ["content-type"]}}`, true},
+		{"invalid route deny name", `{"routes": [{"prefix": "/api/events", "target": "events", "request_headers": {"deny": ["X:Bad"]}}]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := loadFileConfig(path)
+			if err == nil {
+				_, _, err = buildRoutes(cfg, false, 0, targetMonolith)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("config error = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthTarget is a backend health endpoint probed by /proxy/health/all and
+// the active health checker, which updates backend when set.
+type healthTarget struct {
+	name    string
+	url     string
+	backend *backend
+}
+
+type serviceHealth struct {
+	Name       string `json:"name"`
+	Healthy    bool   `json:"healthy"`
+	StatusCode int    `json:"status_code,omitempty"`
+	LatencyMS  int64  `json:"latency_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+type aggregateHealth struct {
+	Healthy  bool            `json:"healthy"`
+	Services []serviceHealth `json:"services"`
+}
+
+func probeHealth(ctx context.Context, client *http.Client, target healthTarget) (result serviceHealth) {
+	result.Name = target.name
+	start := time.Now()
+	defer func() { result.LatencyMS = time.Since(start).Milliseconds() }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.url, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Error = "timeout"
+		} else {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	result.Healthy = resp.StatusCode >= 200 && resp.StatusCode < 300
+	return result
+}
+
+// checkAll probes every target concurrently. A slow backend only costs its
+// own timeout; it is reported as unhealthy instead of failing the whole check.
+func checkAll(ctx context.Context, client *http.Client, targets []healthTarget, timeout time.Duration) aggregateHealth {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	report := aggregateHealth{Healthy: true, Services: make([]serviceHealth, len(targets))}
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target healthTarget) {
+			defer wg.Done()
+			report.Services[i] = probeHealth(ctx, client, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	for _, s := range report.Services {
+		if !s.Healthy {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+func handleAggregateHealth(targets []healthTarget, timeout time.Duration) http.HandlerFunc {
+	client := &http.Client{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checkAll(r.Context(), client, targets, timeout)
+
+		status := http.StatusOK
+		if !report.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, r, status, report)
+	}
+}
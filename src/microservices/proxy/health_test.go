@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAggregateHealth(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	failing := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) }
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}
+	tests := []struct {
+		name        string
+		handlers    []http.HandlerFunc
+		wantStatus  int
+		wantHealthy []bool
+		wantErrors  []string
+	}{
+		{"all healthy", []http.HandlerFunc{ok, ok, ok}, http.StatusOK, []bool{true, true, true}, []string{"", "", ""}},
+		{"one failing", []http.HandlerFunc{ok, failing, ok}, http.StatusServiceUnavailable, []bool{true, false, true}, []string{"", "", ""}},
+		{"one timing out", []http.HandlerFunc{ok, ok, slow}, http.StatusServiceUnavailable, []bool{true, true, false}, []string{"", "", "timeout"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var targets []healthTarget
+			for i, h := range tt.handlers {
+				srv := httptest.NewServer(h)
+				defer srv.Close()
+				targets = append(targets, healthTarget{name: []string{"monolith", "movies-service", "events-service"}[i], url: srv.URL})
+			}
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			handleAggregateHealth(targets, 100*time.Millisecond)(rec, httptest.NewRequest(http.MethodGet, "/proxy/health/all", nil))
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Fatalf("aggregate check took %s, probes are not bounded by the timeout", elapsed)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var report aggregateHealth
+			decodeJSON(t, rec, &report)
+			for i, s := range report.Services {
+				if s.Healthy != tt.wantHealthy[i] || s.Error != tt.wantErrors[i] {
+					t.Errorf("%s: healthy=%v error=%q, want %v %q", s.Name, s.Healthy, s.Error, tt.wantHealthy[i], tt.wantErrors[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProbeHealthReportsLatency(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+	got := probeHealth(context.Background(), srv.Client(), healthTarget{name: "monolith", url: srv.URL})
+	if got.LatencyMS < 20 {
+		t.Fatalf("latency_ms = %d, want at least 20", got.LatencyMS)
+	}
+}
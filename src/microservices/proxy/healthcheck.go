@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// healthChecker actively polls backends and marks them up or down. Healthy
+// backends are polled every base interval; while a backend is failing the
+// interval doubles per consecutive failure up to max, so a recovering service
+// isn't hammered. Jitter spreads polls from many proxy replicas apart.
+type healthChecker struct {
+	client  *http.Client
+	timeout time.Duration
+	base    time.Duration
+	max     time.Duration
+	jitter  float64
+}
+
+// nextInterval returns the delay before the next probe after the given
+// number of consecutive failures.
+func (c *healthChecker) nextInterval(failures int) time.Duration {
+	interval := c.base
+	for i := 0; i < failures && interval < c.max; i++ {
+		interval *= 2
+	}
+	if interval > c.max {
+		interval = c.max
+	}
+	if c.jitter > 0 {
+		delta := (rand.Float64()*2 - 1) * c.jitter * float64(interval)
+		interval += time.Duration(delta)
+	}
+	return interval
+}
+
+func (c *healthChecker) start(ctx context.Context, targets []healthTarget) {
+	for _, t := range targets {
+		if t.backend != nil {
+			go c.watch(ctx, t)
+		}
+	}
+}
+
+func (c *healthChecker) watch(ctx context.Context, target healthTarget) {
+	failures := 0
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		result := probeHealth(probeCtx, c.client, target)
+		cancel()
+
+		wasHealthy := target.backend.isHealthy()
+		target.backend.setHealthy(result.Healthy)
+		if result.Healthy {
+			if !wasHealthy {
+				log.Printf("Backend %s recovered", target.name)
+			}
+			failures = 0
+		} else {
+			if wasHealthy {
+				log.Printf("Backend %s marked unhealthy: status %d %s", target.name, result.StatusCode, result.Error)
+			}
+			failures++
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.nextInterval(failures)):
+		}
+	}
+}
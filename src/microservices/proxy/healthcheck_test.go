@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckInterval(t *testing.T) {
+	c := &healthChecker{base: time.Second, max: 30 * time.Second}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{4, 16 * time.Second},
+		{5, 30 * time.Second},
+		{50, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := c.nextInterval(tt.failures); got != tt.want {
+			t.Errorf("nextInterval(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestHealthCheckJitter(t *testing.T) {
+	c := &healthChecker{base: time.Second, max: time.Minute, jitter: 0.2}
+	tests := []struct {
+		failures int
+		min, max time.Duration
+	}{
+		{0, 800 * time.Millisecond, 1200 * time.Millisecond},
+		{3, 6400 * time.Millisecond, 9600 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 200; i++ {
+			got := c.nextInterval(tt.failures)
+			if got < tt.min || got > tt.max {
+				t.Fatalf("nextInterval(%d) = %s, want within [%s, %s]", tt.failures, got, tt.min, tt.max)
+			}
+			seen[got] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("nextInterval(%d) never varied", tt.failures)
+		}
+	}
+}
+
+func TestHealthCheckMarksBackend(t *testing.T) {
+	var healthy atomic.Bool
+	var probes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	b := testBackend("movies-service", nil)
+	c := &healthChecker{client: server.Client(), timeout: time.Second, base: time.Millisecond, max: 4 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.start(ctx, []healthTarget{{name: b.name, url: server.URL, backend: b}})
+
+	steps := []struct {
+		name    string
+		healthy bool
+	}{
+		{"fails", false},
+		{"recovers", true},
+		{"fails again", false},
+	}
+	for _, s := range steps {
+		healthy.Store(s.healthy)
+		seen := probes.Load()
+		deadline := time.Now().Add(2 * time.Second)
+		for b.isHealthy() != s.healthy || probes.Load() < seen+2 {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: backend healthy = %v after %d probes, want %v", s.name, b.isHealthy(), probes.Load()-seen, s.healthy)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIdempotencyEntries triggers a sweep of expired entries once exceeded.
+const maxIdempotencyEntries = 10000
+
+type idempotentEntry struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+	resp        *bufferedResponse
+	expires     time.Time
+}
+
+// idempotencyStore replays the response of a write that carried an
+// Idempotency-Key when the client retries it within ttl, instead of
+// forwarding the write again. Keys are scoped to method, path, Authorization
+// and Cookie so clients cannot see each other's responses. A retry that
+// arrives while the first request is still in flight waits for it. Failed
+// writes are not kept, so they can be retried for real. Bodies are buffered
+// to fingerprint them, so anything over maxBody is refused with 413.
+type idempotencyStore struct {
+	ttl      time.Duration
+	maxBody  int64
+	methods  map[string]bool
+	prefixes []string
+
+	mu      sync.Mutex
+	entries map[string]*idempotentEntry
+}
+
+func newIdempotencyStore(ttl time.Duration, maxBody int64, methods, prefixes string) *idempotencyStore {
+	s := &idempotencyStore{ttl: ttl, maxBody: maxBody, methods: make(map[string]bool), entries: make(map[string]*idempotentEntry)}
+	for _, m := range strings.Split(methods, ",") {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+			s.methods[m] = true
+		}
+	}
+	for _, p := range strings.Split(prefixes, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			s.prefixes = append(s.prefixes, p)
+		}
+	}
+	return s
+}
+
+func (s *idempotencyStore) applies(r *http.Request) bool {
+	if !s.methods[r.Method] || r.Header.Get("Idempotency-Key") == "" {
+		return false
+	}
+	if len(s.prefixes) == 0 {
+		return true
+	}
+	for _, p := range s.prefixes {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// begin returns the entry for key, and whether the caller created it and is
+// therefore responsible for filling it in.
+func (s *idempotencyStore) begin(key string, fingerprint [sha256.Size]byte) (*idempotentEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if e, ok := s.entries[key]; ok && (e.resp == nil || now.Before(e.expires)) {
+		return e, false
+	}
+	if len(s.entries) >= maxIdempotencyEntries {
+		for k, e := range s.entries {
+			if e.resp != nil && now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	e := &idempotentEntry{fingerprint: fingerprint, done: make(chan struct{})}
+	s.entries[key] = e
+	return e, true
+}
+
+// forgettable reports whether a response should not be replayed: upstream
+// failures and requests the client abandoned.
+func forgettable(status int) bool {
+	return status >= 500 || status == statusClientClosedRequest
+}
+
+func (s *idempotencyStore) finish(key string, e *idempotentEntry, resp *bufferedResponse) {
+	s.mu.Lock()
+	if forgettable(resp.status) {
+		delete(s.entries, key)
+	} else {
+		e.expires = time.Now().Add(s.ttl)
+	}
+	e.resp = resp
+	s.mu.Unlock()
+	close(e.done)
+}
+
+func (s *idempotencyStore) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.applies(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		idemKey := r.Header.Get("Idempotency-Key")
+		key := r.Method + " " + r.URL.Path + " " + r.Header.Get("Authorization") + " " + r.Header.Get("Cookie") + " " + idemKey
+		e, owner := s.begin(key, sha256.Sum256(body))
+		if owner {
+			resp := newBufferedResponse()
+			next.ServeHTTP(resp, r)
+			s.finish(key, e, resp)
+			resp.writeTo(w)
+			return
+		}
+
+		if e.fingerprint != sha256.Sum256(body) {
+			http.Error(w, "Idempotency-Key was already used with a different request body", http.StatusUnprocessableEntity)
+			return
+		}
+		select {
+		case <-e.done:
+		case <-r.Context().Done():
+			return
+		}
+		if forgettable(e.resp.status) {
+			// The original attempt failed and was forgotten; forward this one.
+			next.ServeHTTP(w, r)
+			return
+		}
+		log.Printf("Replaying stored response for Idempotency-Key %q on %s %s", idemKey, r.Method, r.URL.Path)
+		w.Header().Set("Idempotent-Replayed", "true")
+		e.resp.writeTo(w)
+	})
+}
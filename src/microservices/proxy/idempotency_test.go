@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type idemRequest struct {
+	method, path, key, auth, cookie, body string
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	first := idemRequest{http.MethodPost, "/api/users", "k1", "Bearer a", "session=a", `{"name":"ann"}`}
+	with := func(change func(*idemRequest)) idemRequest {
+		r := first
+		change(&r)
+		return r
+	}
+	tests := []struct {
+		name         string
+		ttl          time.Duration
+		status       int
+		second       idemRequest
+		wait         time.Duration
+		wantCalls    int32
+		wantStatus   int
+		wantReplayed bool
+	}{
+		{"repeated key is replayed", time.Minute, http.StatusCreated, first, 0, 1, http.StatusCreated, true},
+		{"4xx is replayed", time.Minute, http.StatusConflict, first, 0, 1, http.StatusConflict, true},
+		{"5xx is forwarded again", time.Minute, http.StatusBadGateway, first, 0, 2, http.StatusBadGateway, false},
+		{"different key", time.Minute, http.StatusCreated, with(func(r *idemRequest) { r.key = "k2" }), 0, 2, http.StatusCreated, false},
+		{"different caller", time.Minute, http.StatusCreated, with(func(r *idemRequest) { r.auth = "Bearer b" }), 0, 2, http.StatusCreated, false},
+		{"different session", time.Minute, http.StatusCreated, with(func(r *idemRequest) { r.cookie = "session=b" }), 0, 2, http.StatusCreated, false},
+		{"different path", time.Minute, http.StatusCreated, with(func(r *idemRequest) { r.path = "/api/users/2" }), 0, 2, http.StatusCreated, false},
+		{"different body", time.Minute, http.StatusCreated, with(func(r *idemRequest) { r.body = `{"name":"bob"}` }), 0, 1, http.StatusUnprocessableEntity, false},
+		{"no key", time.Minute, http.StatusCreated, with(func(r *idemRequest) { r.key = "" }), 0, 2, http.StatusCreated, false},
+		{"method not configured", time.Minute, http.StatusCreated, with(func(r *idemRequest) { r.method = http.MethodPut }), 0, 2, http.StatusCreated, false},
+		{"path not configured", time.Minute, http.StatusCreated, with(func(r *idemRequest) { r.path = "/api/movies" }), 0, 2, http.StatusCreated, false},
+		{"expired", 10 * time.Millisecond, http.StatusCreated, first, 20 * time.Millisecond, 2, http.StatusCreated, false},
+		{"body too large", time.Minute, http.StatusCreated, with(func(r *idemRequest) { r.key = "k2"; r.body = strings.Repeat("x", 65) }), 0, 1, http.StatusRequestEntityTooLarge, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+				w.Header().Set("X-Call", fmt.Sprint(n))
+				w.WriteHeader(tt.status)
+				fmt.Fprintf(w, "call %d", n)
+			})
+			h := newIdempotencyStore(tt.ttl, 64, "post, patch", "/api/users").middleware(upstream)
+
+			rec := serve(h, idemHTTPRequest(first))
+			if rec.Code != tt.status || rec.Body.String() != "call 1" {
+				t.Fatalf("first response = %d %q", rec.Code, rec.Body.String())
+			}
+			time.Sleep(tt.wait)
+			rec = serve(h, idemHTTPRequest(tt.second))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("second status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("upstream calls = %d, want %d", got, tt.wantCalls)
+			}
+			replayed := rec.Header().Get("Idempotent-Replayed") == "true"
+			if replayed != tt.wantReplayed {
+				t.Fatalf("replayed = %v, want %v", replayed, tt.wantReplayed)
+			}
+			if replayed && (rec.Body.String() != "call 1" || rec.Header().Get("X-Call") != "1") {
+				t.Fatalf("replay = %q (X-Call %s), want the first response", rec.Body.String(), rec.Header().Get("X-Call"))
+			}
+		})
+	}
+}
+
+func idemHTTPRequest(r idemRequest) *http.Request {
+	req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+	if r.key != "" {
+		req.Header.Set("Idempotency-Key", r.key)
+	}
+	req.Header.Set("Authorization", r.auth)
+	if r.cookie != "" {
+		req.Header.Set("Cookie", r.cookie)
+	}
+	return req
+}
+
+func TestIdempotencyRetryWhileInFlight(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+	h := newIdempotencyStore(time.Minute, 1<<20, "POST", "").middleware(upstream)
+	req := idemRequest{http.MethodPost, "/api/payments", "k1", "", "", `{"amount":5}`}
+
+	results := make(chan *httptest.ResponseRecorder, 2)
+	go func() { results <- serve(h, idemHTTPRequest(req)) }()
+	waitFor(t, func() bool { return calls.Load() == 1 })
+	go func() { results <- serve(h, idemHTTPRequest(req)) }()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	replays := 0
+	for i := 0; i < 2; i++ {
+		rec := <-results
+		if rec.Code != http.StatusCreated || rec.Body.String() != "created" {
+			t.Fatalf("response = %d %q", rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("Idempotent-Replayed") == "true" {
+			replays++
+		}
+	}
+	if calls.Load() != 1 || replays != 1 {
+		t.Fatalf("upstream calls = %d, replays = %d; want 1 and 1", calls.Load(), replays)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// routingClaims are the identity claims a verified JWT contributes to
+// routing. They take precedence over X-Tenant-ID and X-User-ID, which any
+// client can set.
+type routingClaims struct {
+	tenant string
+	user   string
+}
+
+type claimsContextKey struct{}
+
+func claimsFrom(ctx context.Context) *routingClaims {
+	c, _ := ctx.Value(claimsContextKey{}).(*routingClaims)
+	return c
+}
+
+// requestTenant is the tenant routing decisions use for r.
+func requestTenant(r *http.Request) string {
+	if c := claimsFrom(r.Context()); c != nil && c.tenant != "" {
+		return c.tenant
+	}
+	return r.Header.Get("X-Tenant-ID")
+}
+
+// requestUser is the user routing decisions use for r.
+func requestUser(r *http.Request) string {
+	if c := claimsFrom(r.Context()); c != nil && c.user != "" {
+		return c.user
+	}
+	return r.Header.Get("X-User-ID")
+}
+
+// jwtVerifier checks bearer tokens against a single public key. RSA keys
+// accept RS256, RS384 and RS512, ECDSA keys ES256, ES384 and ES512, and
+// Ed25519 keys EdDSA; the algorithm must match the key so a token cannot
+// pick a weaker one, and "none" is never accepted. ECDSA algorithms are
+// further bound to the key's curve: ES256 to P-256, ES384 to P-384 and ES512
+// to P-521. When issuer or audience is set, the iss claim must equal it or
+// the aud claim must contain it.
+type jwtVerifier struct {
+	key         crypto.PublicKey
+	tenantClaim string
+	userClaim   string
+	issuer      string
+	audience    string
+	now         func() time.Time
+}
+
+// ecdsaAlgorithms is the JWS algorithm each supported curve signs with.
+var ecdsaAlgorithms = map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}
+
+func newJWTVerifier(pemData []byte, tenantClaim, userClaim string) (*jwtVerifier, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var key crypto.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	case "RSA PUBLIC KEY":
+		k, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if _, ok := ecdsaAlgorithms[k.Curve.Params().Name]; !ok {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+	case *rsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return &jwtVerifier{key: key, tenantClaim: tenantClaim, userClaim: userClaim, now: time.Now}, nil
+}
+
+// attach returns r with the claims of its bearer token, if the token
+// verifies. Requests without a valid token are returned unchanged and are
+// routed on their headers. It is safe to call on a nil verifier.
+func (v *jwtVerifier) attach(r *http.Request) *http.Request {
+	if v == nil {
+		return r
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.Count(token, ".") != 2 {
+		return r
+	}
+	claims, err := v.verify(token)
+	if err != nil {
+		log.Printf("Ignoring JWT for routing: %v", err)
+		return r
+	}
+	c := &routingClaims{tenant: claimString(claims[v.tenantClaim]), user: claimString(claims[v.userClaim])}
+	return r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, c))
+}
+
+func (v *jwtVerifier) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	if err := v.checkSignature(header.Alg, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("payload: %w", err)
+	}
+	var claims map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("payload: %w", err)
+	}
+	now := v.now().Unix()
+	if exp, ok := claims["exp"].(json.Number); ok {
+		if n, err := exp.Float64(); err != nil || float64(now) >= n {
+			return nil, errors.New("token expired")
+		}
+	}
+	if nbf, ok := claims["nbf"].(json.Number); ok {
+		if n, err := nbf.Float64(); err != nil || float64(now) < n {
+			return nil, errors.New("token not valid yet")
+		}
+	}
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return nil, fmt.Errorf("issuer %v is not %q", claims["iss"], v.issuer)
+	}
+	if v.audience != "" && !hasAudience(claims["aud"], v.audience) {
+		return nil, fmt.Errorf("audience %v does not include %q", claims["aud"], v.audience)
+	}
+	return claims, nil
+}
+
+// hasAudience reports whether an aud claim, a string or an array of them,
+// names want.
+func hasAudience(aud interface{}, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []interface{}:
+		for _, v := range a {
+			if v == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *jwtVerifier) checkSignature(alg string, signed, sig []byte) error {
+	if alg == "EdDSA" {
+		key, ok := v.key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(key, signed, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	var h hash.Hash
+	var hashID crypto.Hash
+	switch alg[min(len(alg), 2):] {
+	case "256":
+		h, hashID = sha256.New(), crypto.SHA256
+	case "384":
+		h, hashID = sha512.New384(), crypto.SHA384
+	case "512":
+		h, hashID = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := v.key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") || rsa.VerifyPKCS1v15(key, hashID, digest, sig) != nil {
+			return errors.New("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		// JWS encodes the ECDSA signature as the fixed-size concatenation of
+		// r and s rather than ASN.1.
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg != ecdsaAlgorithms[key.Curve.Params().Name] || len(sig) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// claimString renders a string or numeric claim; other types yield "".
+func claimString(v interface{}) string {
+	switch c := v.(type) {
+	case string:
+		return c
+	case json.Number:
+		return c.String()
+	}
+	return ""
+}
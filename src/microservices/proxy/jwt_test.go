@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// signJWT builds a compact JWS over claims with the given algorithm header,
+// signed by key.
+func signJWT(t *testing.T, alg string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case *ecdsa.PrivateKey:
+		h := map[string]crypto.Hash{"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512}[alg]
+		if h == 0 {
+			h = crypto.SHA256
+		}
+		digest := h.New()
+		digest.Write([]byte(signed))
+		r, s, serr := ecdsa.Sign(rand.Reader, k, digest.Sum(nil))
+		if serr != nil {
+			t.Fatal(serr)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	default:
+		digest := sha256.Sum256([]byte(signed))
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func publicKeyPEM(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	claims := map[string]interface{}{"tenant": "acme", "user_id": 42, "exp": now.Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name     string
+		key      crypto.Signer
+		alg      string
+		signer   crypto.Signer
+		claims   map[string]interface{}
+		wantUser string
+		wantErr  bool
+	}{
+		{"RS256", rsaKey, "RS256", rsaKey, claims, "42", false},
+		{"ES256", ecKey, "ES256", ecKey, claims, "42", false},
+		{"ES384", p384Key, "ES384", p384Key, claims, "42", false},
+		{"ES512", p521Key, "ES512", p521Key, claims, "42", false},
+		{"ES256 on a P-384 key", p384Key, "ES256", p384Key, claims, "", true},
+		{"ES384 on a P-256 key", ecKey, "ES384", ecKey, claims, "", true},
+		{"ES256 on a P-521 key", p521Key, "ES256", p521Key, claims, "", true},
+		{"EdDSA", edKey, "EdDSA", edKey, claims, "42", false},
+		{"signed by another key", rsaKey, "RS256", otherKey, claims, "", true},
+		{"algorithm does not match the key", ecKey, "RS256", rsaKey, claims, "", true},
+		{"expired", rsaKey, "RS256", rsaKey, map[string]interface{}{"tenant": "acme", "exp": now.Add(-time.Minute).Unix()}, "", true},
+		{"not valid yet", rsaKey, "RS256", rsaKey, map[string]interface{}{"tenant": "acme", "nbf": now.Add(time.Minute).Unix()}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := newJWTVerifier(publicKeyPEM(t, tt.key), "tenant", "user_id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.now = func() time.Time { return now }
+			got, err := v.verify(signJWT(t, tt.alg, tt.signer, tt.claims))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verify err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (claimString(got["tenant"]) != "acme" || claimString(got["user_id"]) != tt.wantUser) {
+				t.Errorf("claims = %v", got)
+			}
+		})
+	}
+
+	t.Run("alg none", func(t *testing.T) {
+		v, _ := newJWTVerifier(publicKeyPEM(t, rsaKey), "tenant", "user_id")
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+		payload := base64.RawURLEncoding.EncodeToString([]byte(`{"tenant":"acme"}`))
+		if _, err := v.verify(header + "." + payload + "."); err == nil {
+			t.Fatal("unsigned token accepted")
+		}
+	})
+}
+
+func TestJWTVerifierIssuerAudience(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name             string
+		issuer, audience string
+		claims           map[string]interface{}
+		wantErr          bool
+	}{
+		{"not configured", "", "", map[string]interface{}{"iss": "someone", "aud": "else"}, false},
+		{"issuer matches", "https://auth.example", "", map[string]interface{}{"iss": "https://auth.example"}, false},
+		{"issuer differs", "https://auth.example", "", map[string]interface{}{"iss": "https://evil.example"}, true},
+		{"issuer missing", "https://auth.example", "", map[string]interface{}{}, true},
+		{"audience string", "", "cinemaabyss", map[string]interface{}{"aud": "cinemaabyss"}, false},
+		{"audience in array", "", "cinemaabyss", map[string]interface{}{"aud": []string{"billing", "cinemaabyss"}}, false},
+		{"audience differs", "", "cinemaabyss", map[string]interface{}{"aud": []string{"billing"}}, true},
+		{"audience missing", "", "cinemaabyss", map[string]interface{}{"iss": "https://auth.example"}, true},
+		{"both match", "https://auth.example", "cinemaabyss", map[string]interface{}{"iss": "https://auth.example", "aud": "cinemaabyss"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := newJWTVerifier(publicKeyPEM(t, key), "tenant", "user_id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.issuer, v.audience = tt.issuer, tt.audience
+			if _, err := v.verify(signJWT(t, "RS256", key, tt.claims)); (err != nil) != tt.wantErr {
+				t.Fatalf("verify err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTClaimRouting(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forger, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two users on either side of a 50% split.
+	var migrated, kept string
+	for i := 0; migrated == "" || kept == ""; i++ {
+		if u := fmt.Sprint(i); migratesUser(u, 50) {
+			migrated = u
+		} else {
+			kept = u
+		}
+	}
+	token := func(signer crypto.Signer, claims map[string]interface{}) string {
+		return "Bearer " + signJWT(t, "RS256", signer, claims)
+	}
+
+	tests := []struct {
+		name    string
+		auth    string
+		headers map[string]string
+		percent int
+		want    string
+	}{
+		{"tenant claim", token(key, map[string]interface{}{"tenant": "acme"}), nil, 0, "movies-service"},
+		{"tenant claim beats the header", token(key, map[string]interface{}{"tenant": "acme"}), map[string]string{"X-Tenant-ID": "legacy"}, 0, "movies-service"},
+		{"user claim beats the header", token(key, map[string]interface{}{"user_id": migrated}), map[string]string{"X-User-ID": kept}, 50, "movies-service"},
+		{"user claim kept on the monolith", token(key, map[string]interface{}{"user_id": kept}), map[string]string{"X-User-ID": migrated}, 50, "monolith"},
+		{"missing token falls back to the header", "", map[string]string{"X-Tenant-ID": "acme"}, 0, "movies-service"},
+		{"missing token and header", "", nil, 0, "monolith"},
+		{"forged token falls back to the header", token(forger, map[string]interface{}{"tenant": "acme"}), map[string]string{"X-Tenant-ID": "legacy"}, 100, "monolith"},
+		{"malformed token falls back to the header", "Bearer not-a-jwt", map[string]string{"X-User-ID": migrated}, 50, "movies-service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.gradualMigration, s.migrationPercent = true, tt.percent
+			s.tenants = map[string]string{"acme": targetMovies, "legacy": targetMonolith}
+			if s.jwt, err = newJWTVerifier(publicKeyPEM(t, key), "tenant", "user_id"); err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := serve(s, r).Header().Get("X-Backend"); got != tt.want {
+				t.Fatalf("routed to %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewJWTVerifierErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		pem  []byte
+	}{
+		{"not PEM", []byte("ssh-rsa AAAA")},
+		{"private key block", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1}})},
+		{"unsupported curve", publicKeyPEM(t, p224Key(t))},
+		{"garbage public key", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte{1, 2, 3}})},
+	}
+	for _, tt := range tests {
+		if _, err := newJWTVerifier(tt.pem, "tenant", "user_id"); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
+
+func p224Key(t *testing.T) crypto.Signer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
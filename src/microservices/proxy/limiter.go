@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// inflightLimiter caps concurrent requests through a handler. A request over
+// the limit waits up to wait for a slot and is then answered 503 with
+// Retry-After. The last reserve slots are kept for high-priority requests:
+// low-priority ones are refused as soon as only those are left, and never
+// wait. With max 0 nothing is limited but the count is still exported.
+type inflightLimiter struct {
+	name       string
+	slots      chan struct{}
+	lowMax     int
+	wait       time.Duration
+	retryAfter int
+
+	gauge    prometheus.Gauge
+	rejected prometheus.Counter
+}
+
+func newInflightLimiter(name string, max, reservePercent int, wait time.Duration, retryAfter int) *inflightLimiter {
+	l := &inflightLimiter{
+		name:       name,
+		wait:       wait,
+		retryAfter: retryAfter,
+		gauge:      inflightRequests.WithLabelValues(name),
+		rejected:   inflightRejected.WithLabelValues(name),
+	}
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+		l.lowMax = max - max*reservePercent/100
+	}
+	return l
+}
+
+// inflightEnvKey is the MAX_INFLIGHT_<BACKEND> variable for a backend name,
+// e.g. MAX_INFLIGHT_MOVIES_SERVICE.
+func inflightEnvKey(name string) string {
+	return "MAX_INFLIGHT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func (l *inflightLimiter) acquire(r *http.Request) bool {
+	if l.slots == nil {
+		return true
+	}
+	low := requestPriority(r) == priorityLow
+	if low && len(l.slots) >= l.lowMax {
+		return false
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.wait <= 0 || low {
+		return false
+	}
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	return false
+}
+
+func (l *inflightLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+func (l *inflightLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(r) {
+			l.rejected.Inc()
+			log.Printf("Rejected %s-priority %s %s: %s has %d requests in flight", requestPriority(r), r.Method, r.URL.Path, l.name, len(l.slots))
+			markLocal(r)
+			w.Header().Set("Retry-After", strconv.Itoa(l.retryAfter))
+			http.Error(w, "Too many requests in flight to "+l.name, http.StatusServiceUnavailable)
+			return
+		}
+		l.gauge.Inc()
+		defer func() {
+			l.gauge.Dec()
+			l.release()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInflightEnvKey(t *testing.T) {
+	tests := map[string]string{
+		"monolith":         "MAX_INFLIGHT_MONOLITH",
+		"movies-service":   "MAX_INFLIGHT_MOVIES_SERVICE",
+		"movies-service@1": "MAX_INFLIGHT_MOVIES_SERVICE@1",
+	}
+	for name, want := range tests {
+		if got := inflightEnvKey(name); got != want {
+			t.Errorf("inflightEnvKey(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestInflightLimiter(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		wait time.Duration
+		// releaseAfter frees one held request this long after the extra
+		// request arrives; 0 keeps them held until it has been answered.
+		releaseAfter time.Duration
+		wantStatus   int
+	}{
+		{"over the limit", 2, 0, 0, http.StatusServiceUnavailable},
+		{"queued until the wait runs out", 2, 20 * time.Millisecond, 0, http.StatusServiceUnavailable},
+		{"queued until a slot frees", 2, time.Second, 20 * time.Millisecond, http.StatusOK},
+		{"unlimited", 0, 0, 0, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "limiter-" + tt.name
+			release := make(chan struct{})
+			entered := make(chan struct{}, 10)
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Hold") != "" {
+					entered <- struct{}{}
+					<-release
+				}
+			})
+			l := newInflightLimiter(name, tt.max, 0, tt.wait, 7)
+			h := l.wrap(upstream)
+
+			var held sync.WaitGroup
+			for i := 0; i < 2; i++ {
+				held.Add(1)
+				go func() {
+					defer held.Done()
+					r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+					r.Header.Set("X-Hold", "1")
+					serve(h, r)
+				}()
+				<-entered
+			}
+			if got := testutil.ToFloat64(inflightRequests.WithLabelValues(name)); got != 2 {
+				t.Errorf("in-flight gauge = %v, want 2", got)
+			}
+
+			rejected := inflightRejected.WithLabelValues(name)
+			before := testutil.ToFloat64(rejected)
+			if tt.releaseAfter > 0 {
+				time.AfterFunc(tt.releaseAfter, func() { release <- struct{}{} })
+			}
+			rec := serve(h, httptest.NewRequest(http.MethodGet, "/api/movies", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable {
+				if got := rec.Header().Get("Retry-After"); got != "7" {
+					t.Errorf("Retry-After = %q, want 7", got)
+				}
+				if got := testutil.ToFloat64(rejected) - before; got != 1 {
+					t.Errorf("rejected %v requests, want 1", got)
+				}
+			}
+			close(release)
+			held.Wait()
+
+			// With the held requests done there is capacity again.
+			if rec := serve(h, httptest.NewRequest(http.MethodGet, "/api/movies", nil)); rec.Code != http.StatusOK {
+				t.Fatalf("status after release = %d, want 200", rec.Code)
+			}
+			if got := testutil.ToFloat64(inflightRequests.WithLabelValues(name)); got != 0 {
+				t.Errorf("in-flight gauge after release = %v, want 0", got)
+			}
+		})
+	}
+}
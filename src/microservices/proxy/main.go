@@ -1,33 +1,65 @@
 package main
 
 import (
+	"context"
 	"log"
-	"math/rand"
+	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"cinemaabyss/proxy-service/pkg/recording"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func getEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		value = fallback
 	}
-	return fallback
+	recordSetting(key, value)
+	return value
 }
 
 func main() {
-	rand.Seed(time.Now().UnixNano())
+	if problems := validateConfig(); len(problems) > 0 {
+		log.Fatalf("Invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
 
 	port := getEnv("PORT", "8000")
+	proxies, err := parseTrustedProxies(getEnv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	trustedProxies = proxies
 	monolithURL := getEnv("MONOLITH_URL", "http://localhost:8080")
 	moviesServiceURL := getEnv("MOVIES_SERVICE_URL", "http://localhost:8081")
+	moviesServiceURLs := getEnv("MOVIES_SERVICE_URLS", moviesServiceURL)
+	ringVnodesStr := getEnv("HASH_RING_VNODES", "100")
 	eventsServiceURL := getEnv("EVENTS_SERVICE_URL", "http://localhost:8082")
+	eventsServiceURLs := getEnv("EVENTS_SERVICE_URLS", eventsServiceURL)
 	gradualMigrationEnabled := getEnv("GRADUAL_MIGRATION", "false") == "true"
 	migrationPercentStr := getEnv("MOVIES_MIGRATION_PERCENT", "0")
+	coalesceEnabled := getEnv("COALESCE_MOVIES_REQUESTS", "true") == "true"
+	healthTimeoutStr := getEnv("HEALTH_PROBE_TIMEOUT_MS", "2000")
+	healthIntervalStr := getEnv("HEALTH_CHECK_INTERVAL_MS", "5000")
+	healthMaxIntervalStr := getEnv("HEALTH_CHECK_MAX_INTERVAL_MS", "60000")
+	healthJitterStr := getEnv("HEALTH_CHECK_JITTER", "0.2")
+	preserveHost := getEnv("PRESERVE_HOST", "false") == "true"
+	configFile := getEnv("PROXY_CONFIG_FILE", "")
+	defaultTarget := getEnv("DEFAULT_ROUTE_TARGET", targetMonolith)
+	cacheTTLStr := getEnv("MOVIES_CACHE_TTL_SECONDS", "0")
+	adminToken := getEnv("ADMIN_TOKEN", "")
+	queryRoutingEnabled := getEnv("ALLOW_QUERY_ROUTING", "false") == "true"
+	queryRoutingKey := getEnv("QUERY_ROUTING_KEY", "backend")
+	moviesTransformName := getEnv("MOVIES_RESPONSE_TRANSFORM", "")
+	chaosEnabled := getEnv("CHAOS_ENABLED", "false") == "true"
+	adaptiveMigration := getEnv("ADAPTIVE_MIGRATION", "false") == "true"
+	flagServiceURL := getEnv("FLAG_SERVICE_URL", "")
+	idleConnTimeoutStr := getEnv("UPSTREAM_IDLE_CONN_TIMEOUT_SECONDS", "90")
 
 	migrationPercent, err := strconv.Atoi(migrationPercentStr)
 	if err != nil {
@@ -35,56 +67,462 @@ func main() {
 		migrationPercent = 0
 	}
 
+	healthTimeoutMS, err := strconv.Atoi(healthTimeoutStr)
+	if err != nil || healthTimeoutMS <= 0 {
+		log.Printf("Invalid HEALTH_PROBE_TIMEOUT_MS value, defaulting to 2000. Error: %v", err)
+		healthTimeoutMS = 2000
+	}
+
+	healthIntervalMS, err := strconv.Atoi(healthIntervalStr)
+	if err != nil || healthIntervalMS < 0 {
+		log.Printf("Invalid HEALTH_CHECK_INTERVAL_MS value, defaulting to 5000. Error: %v", err)
+		healthIntervalMS = 5000
+	}
+	healthMaxIntervalMS, err := strconv.Atoi(healthMaxIntervalStr)
+	if err != nil || healthMaxIntervalMS < healthIntervalMS {
+		log.Printf("Invalid HEALTH_CHECK_MAX_INTERVAL_MS value, using HEALTH_CHECK_INTERVAL_MS. Error: %v", err)
+		healthMaxIntervalMS = healthIntervalMS
+	}
+	healthJitter, err := strconv.ParseFloat(healthJitterStr, 64)
+	if err != nil || healthJitter < 0 || healthJitter >= 1 {
+		log.Printf("Invalid HEALTH_CHECK_JITTER value, defaulting to 0.2. Error: %v", err)
+		healthJitter = 0.2
+	}
+
+	cacheTTLSeconds, err := strconv.Atoi(cacheTTLStr)
+	if err != nil || cacheTTLSeconds < 0 {
+		log.Printf("Invalid MOVIES_CACHE_TTL_SECONDS value, disabling the cache. Error: %v", err)
+		cacheTTLSeconds = 0
+	}
+
+	transportCfg := transportConfig{}
+	for _, opt := range []struct {
+		key      string
+		fallback int
+		dst      *int
+	}{
+		{"UPSTREAM_MAX_IDLE_CONNS", 100, &transportCfg.MaxIdleConns},
+		{"UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 32, &transportCfg.MaxIdleConnsPerHost},
+		{"UPSTREAM_MAX_CONNS_PER_HOST", 0, &transportCfg.MaxConnsPerHost},
+	} {
+		n, err := strconv.Atoi(getEnv(opt.key, strconv.Itoa(opt.fallback)))
+		if err != nil || n < 0 {
+			log.Printf("Invalid %s value, defaulting to %d. Error: %v", opt.key, opt.fallback, err)
+			n = opt.fallback
+		}
+		*opt.dst = n
+	}
+	idleConnTimeoutSeconds, err := strconv.Atoi(idleConnTimeoutStr)
+	if err != nil || idleConnTimeoutSeconds < 0 {
+		log.Printf("Invalid UPSTREAM_IDLE_CONN_TIMEOUT_SECONDS value, defaulting to 90. Error: %v", err)
+		idleConnTimeoutSeconds = 90
+	}
+	transportCfg.IdleConnTimeout = time.Duration(idleConnTimeoutSeconds) * time.Second
+	if transportCfg.RefusedRetries, err = strconv.Atoi(getEnv("REFUSED_RETRY_MAX", "2")); err != nil || transportCfg.RefusedRetries < 0 {
+		log.Printf("Invalid REFUSED_RETRY_MAX value, defaulting to 2. Error: %v", err)
+		transportCfg.RefusedRetries = 2
+	}
+	refusedBackoffMS, err := strconv.Atoi(getEnv("REFUSED_RETRY_BACKOFF_MS", "50"))
+	if err != nil || refusedBackoffMS < 0 {
+		log.Printf("Invalid REFUSED_RETRY_BACKOFF_MS value, defaulting to 50. Error: %v", err)
+		refusedBackoffMS = 50
+	}
+	transportCfg.RefusedBackoff = time.Duration(refusedBackoffMS) * time.Millisecond
+
 	monoURL, err := url.Parse(monolithURL)
 	if err != nil {
 		log.Fatalf("Failed to parse MONOLITH_URL: %v", err)
 	}
-	movURL, err := url.Parse(moviesServiceURL)
+	var movURLs []*url.URL
+	for _, raw := range strings.Split(moviesServiceURLs, ",") {
+		movURL, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			log.Fatalf("Failed to parse MOVIES_SERVICE_URLS entry %q: %v", raw, err)
+		}
+		movURLs = append(movURLs, movURL)
+	}
+	ringVnodes, err := strconv.Atoi(ringVnodesStr)
+	if err != nil || ringVnodes <= 0 {
+		log.Printf("Invalid HASH_RING_VNODES value, defaulting to 100. Error: %v", err)
+		ringVnodes = 100
+	}
+	var evtURLs []*url.URL
+	for _, raw := range strings.Split(eventsServiceURLs, ",") {
+		evtURL, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			log.Fatalf("Failed to parse EVENTS_SERVICE_URLS entry %q: %v", raw, err)
+		}
+		evtURLs = append(evtURLs, evtURL)
+	}
+
+	cfg, err := loadFileConfig(configFile)
+	if err != nil {
+		log.Fatalf("Failed to load PROXY_CONFIG_FILE: %v", err)
+	}
+	upstreamTimeoutMS, err := strconv.Atoi(getEnv("UPSTREAM_TIMEOUT_MS", "0"))
+	if err != nil || upstreamTimeoutMS < 0 {
+		log.Printf("Invalid UPSTREAM_TIMEOUT_MS value, defaulting to 0. Error: %v", err)
+		upstreamTimeoutMS = 0
+	}
+	routes, defaultRoute, err := buildRoutes(cfg, preserveHost, time.Duration(upstreamTimeoutMS)*time.Millisecond, defaultTarget)
 	if err != nil {
-		log.Fatalf("Failed to parse MOVIES_SERVICE_URL: %v", err)
+		log.Fatalf("Invalid route configuration: %v", err)
 	}
-	evtURL, err := url.Parse(eventsServiceURL)
+	access, err := newAccessPolicy(cfg.Access)
 	if err != nil {
-		log.Fatalf("Failed to parse EVENTS_SERVICE_URL: %v", err)
+		log.Fatalf("Invalid access configuration: %v", err)
+	}
+
+	var commonModifiers, moviesModifiers []responseModifier
+	if moviesTransformName != "" {
+		transform, ok := responseTransforms[moviesTransformName]
+		if !ok {
+			log.Fatalf("Unknown MOVIES_RESPONSE_TRANSFORM %q", moviesTransformName)
+		}
+		moviesModifiers = append(moviesModifiers, transformJSONResponse(moviesTransformName, transform))
+	}
+	if cfg.URLRewrite != nil {
+		moviesModifiers = append(moviesModifiers, rewriteResponseURLs(cfg.URLRewrite, movURLs))
+	}
+	if !cfg.ResponseHeaders.empty() {
+		commonModifiers = append(commonModifiers, injectResponseHeaders(cfg.ResponseHeaders))
+		moviesModifiers = append(moviesModifiers, commonModifiers...)
 	}
 
-	monolithProxy := httputil.NewSingleHostReverseProxy(monoURL)
-	moviesProxy := httputil.NewSingleHostReverseProxy(movURL)
-	eventsProxy := httputil.NewSingleHostReverseProxy(evtURL)
+	server := &proxyServer{
+		routes:           routes,
+		defaultRoute:     defaultRoute,
+		access:           access,
+		monolith:         &backend{name: "monolith", url: monoURL, proxy: newUpstreamProxy("monolith", monoURL, newTransport(transportCfg), commonModifiers...)},
+		movies:           newBackendPool("movies-service", movURLs, ringVnodes, transportCfg, moviesModifiers...),
+		events:           newBackendPool("events-service", evtURLs, ringVnodes, transportCfg, commonModifiers...),
+		gradualMigration: gradualMigrationEnabled,
+		migrationPercent: migrationPercent,
+		tenants:          cfg.Tenants,
+	}
+	errorWindowSecs, err := strconv.Atoi(getEnv("ERROR_RATE_WINDOW_SECONDS", "60"))
+	if err != nil || errorWindowSecs <= 0 {
+		log.Printf("Invalid ERROR_RATE_WINDOW_SECONDS value, defaulting to 60. Error: %v", err)
+		errorWindowSecs = 60
+	}
+	errorThresholdPct, err := strconv.Atoi(getEnv("ERROR_RATE_THRESHOLD_PERCENT", "20"))
+	if err != nil || errorThresholdPct < 0 || errorThresholdPct > 100 {
+		log.Printf("Invalid ERROR_RATE_THRESHOLD_PERCENT value, defaulting to 20. Error: %v", err)
+		errorThresholdPct = 20
+	}
+	errorMinRequests, err := strconv.Atoi(getEnv("ERROR_RATE_MIN_REQUESTS", "20"))
+	if err != nil || errorMinRequests < 0 {
+		log.Printf("Invalid ERROR_RATE_MIN_REQUESTS value, defaulting to 20. Error: %v", err)
+		errorMinRequests = 20
+	}
+	slowThresholdMS, err := strconv.Atoi(getEnv("SLOW_REQUEST_THRESHOLD_MS", "0"))
+	if err != nil || slowThresholdMS < 0 {
+		log.Printf("Invalid SLOW_REQUEST_THRESHOLD_MS value, defaulting to 0. Error: %v", err)
+		slowThresholdMS = 0
+	}
+	breakerFailures, err := strconv.Atoi(getEnv("EVENTS_BREAKER_FAILURES", "5"))
+	if err != nil || breakerFailures < 0 {
+		log.Printf("Invalid EVENTS_BREAKER_FAILURES value, defaulting to 5. Error: %v", err)
+		breakerFailures = 5
+	}
+	breakerCooldownMS, err := strconv.Atoi(getEnv("EVENTS_BREAKER_COOLDOWN_MS", "30000"))
+	if err != nil || breakerCooldownMS < 0 {
+		log.Printf("Invalid EVENTS_BREAKER_COOLDOWN_MS value, defaulting to 30000. Error: %v", err)
+		breakerCooldownMS = 30000
+	}
+	if breakerFailures > 0 {
+		for _, b := range server.events.members {
+			b.breaker = newCircuitBreaker(b.name, breakerFailures, time.Duration(breakerCooldownMS)*time.Millisecond)
+		}
+		log.Printf("events-service replicas open their circuit breaker after %d failures for %dms", breakerFailures, breakerCooldownMS)
+	}
+	allBackends := append([]*backend{server.monolith}, server.movies.members...)
+	allBackends = append(allBackends, server.events.members...)
+	for _, b := range allBackends {
+		b.errors = newErrorWindow(time.Duration(errorWindowSecs)*time.Second, float64(errorThresholdPct)/100, errorMinRequests)
+		b.proxy = b.errors.observe(b.proxy)
+		if slowThresholdMS > 0 {
+			b.proxy = logSlowRequests(b.name, time.Duration(slowThresholdMS)*time.Millisecond, b.proxy)
+		}
+	}
+	inflightWaitMS, err := strconv.Atoi(getEnv("INFLIGHT_QUEUE_TIMEOUT_MS", "0"))
+	if err != nil || inflightWaitMS < 0 {
+		log.Printf("Invalid INFLIGHT_QUEUE_TIMEOUT_MS value, defaulting to 0. Error: %v", err)
+		inflightWaitMS = 0
+	}
+	inflightWait := time.Duration(inflightWaitMS) * time.Millisecond
+	inflightRetryAfter, err := strconv.Atoi(getEnv("INFLIGHT_RETRY_AFTER_SECONDS", "1"))
+	if err != nil || inflightRetryAfter < 0 {
+		log.Printf("Invalid INFLIGHT_RETRY_AFTER_SECONDS value, defaulting to 1. Error: %v", err)
+		inflightRetryAfter = 1
+	}
+	inflightReserve, err := strconv.Atoi(getEnv("INFLIGHT_HIGH_PRIORITY_RESERVE_PERCENT", "20"))
+	if err != nil || inflightReserve < 0 || inflightReserve > 100 {
+		log.Printf("Invalid INFLIGHT_HIGH_PRIORITY_RESERVE_PERCENT value, defaulting to 20. Error: %v", err)
+		inflightReserve = 20
+	}
+	inflightLimit := func(key string) int {
+		n, err := strconv.Atoi(getEnv(key, "0"))
+		if err != nil || n < 0 {
+			log.Printf("Invalid %s value, not limiting. Error: %v", key, err)
+			return 0
+		}
+		if n > 0 {
+			log.Printf("In-flight limit %s=%d", key, n)
+		}
+		return n
+	}
+	// Replicas of a pool are limited individually, all under the pool's
+	// MAX_INFLIGHT_MOVIES_SERVICE or MAX_INFLIGHT_EVENTS_SERVICE.
+	for _, pool := range []*backendPool{server.movies, server.events} {
+		limit := inflightLimit(inflightEnvKey(pool.name))
+		for _, b := range pool.members {
+			b.proxy = newInflightLimiter(b.name, limit, inflightReserve, inflightWait, inflightRetryAfter).wrap(b.proxy)
+		}
+	}
+	monolithLimit := inflightLimit(inflightEnvKey(server.monolith.name))
+	server.monolith.proxy = newInflightLimiter(server.monolith.name, monolithLimit, inflightReserve, inflightWait, inflightRetryAfter).wrap(server.monolith.proxy)
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Incoming request: %s %s", r.Method, r.URL.Path)
+	if queryRoutingEnabled {
+		server.queryRoutingKey = queryRoutingKey
+	}
+	if secret := getEnv("ROUTE_TOKEN_SECRET", ""); secret != "" {
+		server.routeTokens = newRouteTokenVerifier(secret)
+		log.Printf("Signed route tokens enabled (%s)", routeTokenHeader)
+	}
+	if keyFile := getEnv("JWT_PUBLIC_KEY_FILE", ""); keyFile != "" {
+		pemData, err := os.ReadFile(keyFile)
+		if err != nil {
+			log.Fatalf("Failed to read JWT_PUBLIC_KEY_FILE: %v", err)
+		}
+		tenantClaim, userClaim := getEnv("JWT_TENANT_CLAIM", "tenant"), getEnv("JWT_USER_CLAIM", "user_id")
+		if server.jwt, err = newJWTVerifier(pemData, tenantClaim, userClaim); err != nil {
+			log.Fatalf("Invalid JWT_PUBLIC_KEY_FILE: %v", err)
+		}
+		server.jwt.issuer, server.jwt.audience = getEnv("JWT_ISSUER", ""), getEnv("JWT_AUDIENCE", "")
+		log.Printf("Routing on JWT claims %q and %q when a valid bearer token is present", tenantClaim, userClaim)
+	}
+	if adaptiveMigration {
+		opts := map[string]int{
+			"ADAPTIVE_P95_THRESHOLD_MS": 500,
+			"ADAPTIVE_MIN_PERCENT":      0,
+			"ADAPTIVE_STEP_PERCENT":     10,
+			"ADAPTIVE_WINDOW_SECONDS":   30,
+			"ADAPTIVE_INTERVAL_MS":      5000,
+		}
+		for key, fallback := range opts {
+			n, err := strconv.Atoi(getEnv(key, strconv.Itoa(fallback)))
+			if err != nil || n < 0 {
+				log.Printf("Invalid %s value, defaulting to %d. Error: %v", key, fallback, err)
+				n = fallback
+			}
+			opts[key] = n
+		}
+		server.throttle = newMigrationThrottle(
+			migrationPercent,
+			opts["ADAPTIVE_MIN_PERCENT"],
+			opts["ADAPTIVE_STEP_PERCENT"],
+			time.Duration(opts["ADAPTIVE_P95_THRESHOLD_MS"])*time.Millisecond,
+			time.Duration(opts["ADAPTIVE_WINDOW_SECONDS"])*time.Second,
+		)
+		server.throttle.degraded = func() bool {
+			for _, b := range server.movies.members {
+				if b.isDegraded() {
+					return true
+				}
+			}
+			return false
+		}
+		for _, b := range server.movies.members {
+			b.proxy = server.throttle.timed(b.proxy)
+		}
+		server.throttle.start(context.Background(), time.Duration(opts["ADAPTIVE_INTERVAL_MS"])*time.Millisecond)
+		log.Printf("Adaptive migration: p95 threshold %dms, min %d%%, step %d%%", opts["ADAPTIVE_P95_THRESHOLD_MS"], opts["ADAPTIVE_MIN_PERCENT"], opts["ADAPTIVE_STEP_PERCENT"])
+	} else {
+		migrationEffectivePercent.Set(float64(migrationPercent))
+	}
+	if flagServiceURL != "" {
+		flagTTLMS, err := strconv.Atoi(getEnv("FLAG_CACHE_TTL_MS", "5000"))
+		if err != nil || flagTTLMS < 0 {
+			log.Printf("Invalid FLAG_CACHE_TTL_MS value, defaulting to 5000. Error: %v", err)
+			flagTTLMS = 5000
+		}
+		flagTimeoutMS, err := strconv.Atoi(getEnv("FLAG_SERVICE_TIMEOUT_MS", "200"))
+		if err != nil || flagTimeoutMS <= 0 {
+			log.Printf("Invalid FLAG_SERVICE_TIMEOUT_MS value, defaulting to 200. Error: %v", err)
+			flagTimeoutMS = 200
+		}
+		flagName := getEnv("FLAG_NAME", "movies-service-migration")
+		server.flags = newFlagClient(flagServiceURL, flagName, time.Duration(flagTTLMS)*time.Millisecond, time.Duration(flagTimeoutMS)*time.Millisecond)
+		log.Printf("Feature flag %q from %s decides migration (cache %dms)", flagName, flagServiceURL, flagTTLMS)
+	}
+	capturePercent, err := strconv.Atoi(getEnv("CAPTURE_PERCENT", "0"))
+	if err != nil || capturePercent < 0 || capturePercent > 100 {
+		log.Printf("Invalid CAPTURE_PERCENT value, disabling capture. Error: %v", err)
+		capturePercent = 0
+	}
+	if capturePercent > 0 {
+		captureMaxBody, err := strconv.Atoi(getEnv("CAPTURE_MAX_BODY_BYTES", "65536"))
+		if err != nil || captureMaxBody < 0 {
+			log.Printf("Invalid CAPTURE_MAX_BODY_BYTES value, defaulting to 65536. Error: %v", err)
+			captureMaxBody = 65536
+		}
+		captureFile := getEnv("CAPTURE_FILE", "capture.jsonl")
+		if server.capture, err = newCaptureRecorder(capturePercent, captureMaxBody, captureFile); err != nil {
+			log.Fatalf("Failed to open CAPTURE_FILE: %v", err)
+		}
+		log.Printf("Capturing %d%% of movies GET requests to %s", capturePercent, captureFile)
+	}
+	if coalesceEnabled {
+		server.coalesce = &coalescer{}
+	}
+	if cacheTTLSeconds > 0 {
+		server.cache = newResponseCache(time.Duration(cacheTTLSeconds) * time.Second)
+	}
 
-		switch {
-		case strings.HasPrefix(r.URL.Path, "/api/movies"):
-			if gradualMigrationEnabled && rand.Intn(100) < migrationPercent {
-				log.Printf("Routing to movies-service (migration)")
-				moviesProxy.ServeHTTP(w, r)
-			} else {
-				log.Printf("Routing to monolith")
-				monolithProxy.ServeHTTP(w, r)
+	if chaosEnabled {
+		cfg := chaosConfig{}
+		for key, dst := range map[string]*int{
+			"CHAOS_PERCENT":       &cfg.Percent,
+			"CHAOS_LATENCY_MS":    &cfg.LatencyMS,
+			"CHAOS_ERROR_PERCENT": &cfg.ErrorPercent,
+			"CHAOS_RESET_PERCENT": &cfg.ResetPercent,
+		} {
+			if *dst, err = strconv.Atoi(getEnv(key, "0")); err != nil {
+				log.Fatalf("Invalid %s: %v", key, err)
 			}
-		case strings.HasPrefix(r.URL.Path, "/api/events"):
-			log.Printf("Routing to events-service")
-			eventsProxy.ServeHTTP(w, r)
-		default:
-			log.Printf("Routing to monolith (default)")
-			monolithProxy.ServeHTTP(w, r)
 		}
-	})
+		if err := cfg.validate(); err != nil {
+			log.Fatalf("Invalid chaos configuration: %v", err)
+		}
+		server.chaos = &chaosInjector{cfg: cfg}
+		http.HandleFunc("/proxy/chaos", requireAdmin(adminToken, server.chaos.handleChaos))
+	}
+
+	var handler http.Handler = server
+	handler = newInflightLimiter("all", inflightLimit("MAX_INFLIGHT"), inflightReserve, inflightWait, inflightRetryAfter).wrap(handler)
+	idempotencyTTL, err := strconv.Atoi(getEnv("IDEMPOTENCY_TTL_SECONDS", "0"))
+	if err != nil || idempotencyTTL < 0 {
+		log.Printf("Invalid IDEMPOTENCY_TTL_SECONDS value, disabling idempotency keys. Error: %v", err)
+		idempotencyTTL = 0
+	}
+	if idempotencyTTL > 0 {
+		methods := getEnv("IDEMPOTENCY_METHODS", "POST")
+		paths := getEnv("IDEMPOTENCY_PATHS", "")
+		maxBody, err := strconv.ParseInt(getEnv("IDEMPOTENCY_MAX_BODY_BYTES", "1048576"), 10, 64)
+		if err != nil || maxBody < 0 {
+			log.Printf("Invalid IDEMPOTENCY_MAX_BODY_BYTES value, defaulting to 1048576. Error: %v", err)
+			maxBody = 1048576
+		}
+		handler = newIdempotencyStore(time.Duration(idempotencyTTL)*time.Second, maxBody, methods, paths).middleware(handler)
+		log.Printf("Idempotency keys honoured for %s on %q for %ds", methods, paths, idempotencyTTL)
+	}
+	defaultPriority := getEnv("DEFAULT_PRIORITY", priorityHigh)
+	if defaultPriority != priorityHigh && defaultPriority != priorityLow {
+		log.Printf("Invalid DEFAULT_PRIORITY %q, defaulting to high", defaultPriority)
+		defaultPriority = priorityHigh
+	}
+	lowTimeoutMS, err := strconv.Atoi(getEnv("LOW_PRIORITY_TIMEOUT_MS", "10000"))
+	if err != nil || lowTimeoutMS < 0 {
+		log.Printf("Invalid LOW_PRIORITY_TIMEOUT_MS value, defaulting to 10000. Error: %v", err)
+		lowTimeoutMS = 10000
+	}
+	handler = withPriority(defaultPriority, time.Duration(lowTimeoutMS)*time.Millisecond, handler)
+	recordPercent, err := strconv.Atoi(getEnv("RECORD_PERCENT", "0"))
+	if err != nil || recordPercent < 0 || recordPercent > 100 {
+		log.Printf("Invalid RECORD_PERCENT value, disabling recording. Error: %v", err)
+		recordPercent = 0
+	}
+	if recordPercent > 0 {
+		recordMaxBody, err := strconv.Atoi(getEnv("RECORD_MAX_BODY_BYTES", "65536"))
+		if err != nil || recordMaxBody < 0 {
+			log.Printf("Invalid RECORD_MAX_BODY_BYTES value, defaulting to 65536. Error: %v", err)
+			recordMaxBody = 65536
+		}
+		recordMaxFile, err := strconv.ParseInt(getEnv("RECORD_MAX_FILE_BYTES", "104857600"), 10, 64)
+		if err != nil || recordMaxFile < 0 {
+			log.Printf("Invalid RECORD_MAX_FILE_BYTES value, defaulting to 104857600. Error: %v", err)
+			recordMaxFile = 104857600
+		}
+		recordFile := getEnv("RECORD_FILE", "recording.jsonl")
+		out, err := recording.NewWriter(recordFile, recordMaxFile)
+		if err != nil {
+			log.Fatalf("Failed to open RECORD_FILE: %v", err)
+		}
+		defer out.Close()
+		rec := newRequestRecorder(recordPercent, recordMaxBody, out,
+			getEnv("RECORD_REDACT_HEADERS", "Authorization,Cookie,X-Route-Token"),
+			getEnv("RECORD_REDACT_FIELDS", "password,token,email,card_number"))
+		handler = rec.middleware(handler)
+		log.Printf("Recording %d%% of requests to %s", recordPercent, recordFile)
+	}
+	http.Handle("/", handler)
+	http.HandleFunc("/proxy/cache/flush", requireAdmin(adminToken, handleCacheFlush(server.cache)))
+	http.HandleFunc("/proxy/migration", server.handleMigration)
+	http.HandleFunc("/proxy/simulate", server.handleSimulate)
+	http.HandleFunc("/proxy/routes", requireAdmin(adminToken, server.handleRoutes))
+	http.HandleFunc("/proxy/route-test", requireAdmin(adminToken, server.handleRouteTest))
+	http.HandleFunc("/proxy/upstreams", requireAdmin(adminToken, server.handleUpstreams))
+	http.HandleFunc("/proxy/backend/movies/disable", requireAdmin(adminToken, server.handleMoviesToggle(true)))
+	http.HandleFunc("/proxy/backend/movies/enable", requireAdmin(adminToken, server.handleMoviesToggle(false)))
+	http.Handle("/proxy/metrics", promhttp.Handler())
 
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Strangler Fig Proxy is healthy"))
 	})
 
-	log.Printf("Strangler Fig Proxy started on port %s", port)
-	log.Printf("Monolith URL: %s", monolithURL)
-	log.Printf("Movies Service URL: %s", moviesServiceURL)
-	log.Printf("Gradual migration enabled: %v", gradualMigrationEnabled)
-	log.Printf("Movies migration percentage: %d%%", migrationPercent)
+	healthTargets := []healthTarget{{name: "monolith", url: monoURL.JoinPath("/health").String(), backend: server.monolith}}
+	for _, b := range server.movies.members {
+		healthTargets = append(healthTargets, healthTarget{name: b.name, url: b.url.JoinPath("/api/movies/health").String(), backend: b})
+	}
+	for _, b := range server.events.members {
+		healthTargets = append(healthTargets, healthTarget{name: b.name, url: b.url.JoinPath("/api/events/health").String(), backend: b})
+	}
+	http.HandleFunc("/proxy/health", handleProxyHealth(allBackends))
+	http.HandleFunc("/proxy/health/all", handleAggregateHealth(healthTargets, time.Duration(healthTimeoutMS)*time.Millisecond))
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	if healthIntervalMS > 0 {
+		checker := &healthChecker{
+			client:  &http.Client{},
+			timeout: time.Duration(healthTimeoutMS) * time.Millisecond,
+			base:    time.Duration(healthIntervalMS) * time.Millisecond,
+			max:     time.Duration(healthMaxIntervalMS) * time.Millisecond,
+			jitter:  healthJitter,
+		}
+		checker.start(context.Background(), healthTargets)
+	}
+
+	if grpcHealthPort := getEnv("GRPC_HEALTH_PORT", ""); grpcHealthPort != "" {
+		intervalMS, err := strconv.Atoi(getEnv("GRPC_HEALTH_INTERVAL_MS", "5000"))
+		if err != nil || intervalMS <= 0 {
+			log.Printf("Invalid GRPC_HEALTH_INTERVAL_MS value, defaulting to 5000. Error: %v", err)
+			intervalMS = 5000
+		}
+		ready := func() bool { return healthReport(allBackends).Ready }
+		if _, err := startGRPCHealth(context.Background(), ":"+grpcHealthPort, time.Duration(intervalMS)*time.Millisecond, ready); err != nil {
+			log.Fatalf("Failed to start gRPC health server: %v", err)
+		}
+		log.Printf("gRPC health service listening on port %s", grpcHealthPort)
+	}
+
+	if chaosEnabled {
+		log.Printf("WARNING: chaos fault injection is enabled: %+v", server.chaos.config())
+	}
+	srv := &http.Server{Addr: ":" + port}
+	graceMS, err := strconv.Atoi(getEnv("SHUTDOWN_GRACE_MS", "15000"))
+	if err != nil || graceMS < 0 {
+		log.Printf("Invalid SHUTDOWN_GRACE_MS value, defaulting to 15000. Error: %v", err)
+		graceMS = 15000
+	}
+	drain := newDrainer(srv, getEnv("SHUTDOWN_SIGNAL_FILE", ""), time.Duration(graceMS)*time.Millisecond)
+	drain.start()
+
+	server.logStartupSummary(srv.Addr)
+	err = listenAndServe(srv, func(addr net.Addr) {
+		log.Printf("[READY] Strangler Fig Proxy listening on %s", addr)
+	})
+	if err := drain.wait(err); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
-}
\ No newline at end of file
+}
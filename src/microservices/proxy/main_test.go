@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+}
+
+// testBackend wraps h as a backend, so routing tests can see which backend
+// served a request without a real upstream.
+func testBackend(name string, h http.Handler) *backend {
+	return &backend{name: name, proxy: h}
+}
+
+func testPool(name string, handlers ...http.Handler) *backendPool {
+	p := &backendPool{name: name, byName: make(map[string]*backend)}
+	names := make([]string, 0, len(handlers))
+	for i, h := range handlers {
+		memberName := name
+		if len(handlers) > 1 {
+			memberName = fmt.Sprintf("%s@%d", name, i)
+		}
+		b := testBackend(memberName, h)
+		p.members = append(p.members, b)
+		p.byName[memberName] = b
+		names = append(names, memberName)
+	}
+	p.ring = newHashRing(names, 100)
+	return p
+}
+
+// named answers with its name in X-Backend and the body, so tests can tell
+// backends apart.
+func named(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", name)
+		w.Write([]byte(name))
+	}
+}
+
+// newTestProxy builds a proxyServer on the default routes with the given
+// monolith and movies handlers and a single events backend.
+func newTestProxy(t *testing.T, monolith, movies http.Handler) *proxyServer {
+	t.Helper()
+	routes, fallback, err := buildRoutes(&fileConfig{}, false, 0, targetMonolith)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &proxyServer{
+		routes:       routes,
+		defaultRoute: fallback,
+		monolith:     testBackend("monolith", monolith),
+		movies:       testPool("movies-service", movies),
+		events:       testPool("events-service", named("events-service")),
+		tenants:      map[string]string{},
+	}
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
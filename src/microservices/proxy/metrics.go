@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var migrationEffectivePercent = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "proxy_migration_effective_percent",
+	Help: "Share of movies traffic currently sent to movies-service after adaptive throttling.",
+})
+
+var moviesLatencyP95 = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "proxy_movies_service_latency_p95_seconds",
+	Help: "p95 latency of movies-service responses over the adaptive throttle window.",
+})
+
+var inflightRequests = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "proxy_inflight_requests",
+	Help: "Requests currently being proxied, by backend; \"all\" counts every request the proxy is serving.",
+}, []string{"backend"})
+
+var inflightRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_inflight_rejected_total",
+	Help: "Requests answered 503 because the in-flight limit was reached, by backend.",
+}, []string{"backend"})
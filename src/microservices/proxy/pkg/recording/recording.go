@@ -0,0 +1,151 @@
+// Package recording is the format of the proxy's request recordings: one JSON
+// entry per line, written by RECORD_PERCENT mode and read by cmd/replay.
+//
+//	err := recording.Read(f, func(e recording.Entry) error {
+//		req, err := e.Request(ctx, "http://staging-proxy:8000")
+//		...
+//	})
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces header values, query parameters and JSON fields removed
+// for privacy before an entry is written.
+const Redacted = "[REDACTED]"
+
+// Entry is one recorded request. Body holds at most the recorder's body
+// limit; BodyTruncated marks an entry whose body was cut and so cannot be
+// replayed faithfully.
+type Entry struct {
+	Time          time.Time   `json:"time"`
+	Method        string      `json:"method"`
+	URI           string      `json:"uri"`
+	Host          string      `json:"host,omitempty"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+// Request builds the request that replays e against target, a base URL such
+// as http://localhost:8000.
+func (e Entry) Request(ctx context.Context, target string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, e.Method, strings.TrimSuffix(target, "/")+e.URI, bytes.NewReader(e.Body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range e.Header {
+		if key == "Content-Length" {
+			continue
+		}
+		req.Header[key] = append([]string(nil), values...)
+	}
+	return req, nil
+}
+
+// Read calls fn for every entry in r, in order, stopping at the first error.
+func Read(r io.Reader, fn func(Entry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// backups is how many rotated files a Writer keeps: path.1 is the newest.
+const backups = 3
+
+// Writer appends entries to a file, rotating it once it would grow past
+// maxBytes. It is safe for concurrent use.
+type Writer struct {
+	path     string
+	maxBytes int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewWriter opens path for appending. maxBytes of 0 disables rotation.
+func NewWriter(path string, maxBytes int64) (*Writer, error) {
+	w := &Writer{path: path, maxBytes: maxBytes}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, info.Size()
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	for i := backups - 1; i >= 1; i-- {
+		os.Rename(w.path+"."+strconv.Itoa(i), w.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+// Write appends e as one line.
+func (w *Writer) Write(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.maxBytes > 0 && w.size > 0 && w.size+int64(len(line)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return fmt.Errorf("rotate %s: %w", w.path, err)
+		}
+	}
+	n, err := w.f.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
@@ -0,0 +1,120 @@
+package recording
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	w, err := NewWriter(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Time: at, Method: http.MethodGet, URI: "/api/movies?page=2", Host: "cinemaabyss.example.com", Header: http.Header{"Accept": {"application/json"}}},
+		{Time: at.Add(time.Second), Method: http.MethodPost, URI: "/api/users", Header: http.Header{"Authorization": {Redacted}}, Body: []byte(`{"name":"ann"}`)},
+		{Time: at.Add(2 * time.Second), Method: http.MethodPut, URI: "/api/movies/7", Header: http.Header{}, Body: []byte(`{"tit`), BodyTruncated: true},
+	}
+	for _, e := range entries {
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var read []Entry
+	if err := Read(f, func(e Entry) error { read = append(read, e); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Errorf("read %+v, want %+v", read, entries)
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    int
+		wantErr string
+	}{
+		{"blank lines skipped", `{"method":"GET","uri":"/a"}` + "\n\n  \n" + `{"method":"GET","uri":"/b"}` + "\n", 2, ""},
+		{"bad line reported with its number", `{"method":"GET","uri":"/a"}` + "\n" + `{"method":` + "\n", 1, "line 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := 0
+			err := Read(strings.NewReader(tt.input), func(Entry) error { n++; return nil })
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			if n != tt.want {
+				t.Errorf("read %d entries, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriterRotates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "recording.jsonl")
+	w, err := NewWriter(path, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	e := Entry{Method: http.MethodGet, URI: "/api/movies/" + strings.Repeat("x", 100), Header: http.Header{}}
+	for i := 0; i < 6; i++ {
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"recording.jsonl", "recording.jsonl.1", "recording.jsonl.2", "recording.jsonl.3"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("%s is %d bytes, over the limit", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "recording.jsonl.4")); !os.IsNotExist(err) {
+		t.Errorf("kept more than %d backups", backups)
+	}
+}
+
+func TestEntryRequest(t *testing.T) {
+	e := Entry{
+		Method: http.MethodPost,
+		URI:    "/api/users?invite=1",
+		Header: http.Header{"Content-Type": {"application/json"}, "Content-Length": {"999"}, "X-Tenant-Id": {"acme"}},
+		Body:   []byte(`{"name":"ann"}`),
+	}
+	req, err := e.Request(context.Background(), "http://staging:8000/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodPost || req.URL.String() != "http://staging:8000/api/users?invite=1" {
+		t.Errorf("request = %s %s", req.Method, req.URL)
+	}
+	if req.Header.Get("X-Tenant-Id") != "acme" || req.Header.Get("Content-Length") != "" {
+		t.Errorf("headers = %v, want the recorded ones without Content-Length", req.Header)
+	}
+	if body, _ := io.ReadAll(req.Body); string(body) != `{"name":"ann"}` || req.ContentLength != int64(len(body)) {
+		t.Errorf("body = %q with length %d", body, req.ContentLength)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// backendPool spreads traffic for one logical backend across replicas.
+// Requests with a user, from a verified JWT or X-User-ID, stick to a replica
+// via the hash ring; the rest are round-robined over healthy replicas whose
+// circuit breaker is not open.
+type backendPool struct {
+	name    string
+	members []*backend
+	byName  map[string]*backend
+	ring    *hashRing
+	next    atomic.Uint32
+	// disabled takes the whole pool out of rotation, see handleMoviesToggle.
+	disabled atomic.Bool
+}
+
+// newBackendPool gives every replica its own transport built from tc so one
+// slow replica cannot exhaust the connections of the others.
+func newBackendPool(name string, urls []*url.URL, vnodes int, tc transportConfig, modifiers ...responseModifier) *backendPool {
+	p := &backendPool{name: name, byName: make(map[string]*backend)}
+	names := make([]string, 0, len(urls))
+	for _, u := range urls {
+		memberName := name
+		if len(urls) > 1 {
+			memberName = name + "@" + u.Host
+		}
+		b := &backend{name: memberName, url: u, proxy: newUpstreamProxy(memberName, u, newTransport(tc), modifiers...)}
+		p.members = append(p.members, b)
+		p.byName[memberName] = b
+		names = append(names, memberName)
+	}
+	p.ring = newHashRing(names, vnodes)
+	return p
+}
+
+func (p *backendPool) isDisabled() bool { return p.disabled.Load() }
+
+func (p *backendPool) usable(name string) bool {
+	b := p.byName[name]
+	return b != nil && b.available(time.Now())
+}
+
+// pick selects the replica for r. When every replica is marked unhealthy it
+// still returns one so the caller gets an upstream error rather than nothing.
+func (p *backendPool) pick(r *http.Request) *backend {
+	b, healthy := p.choose(r, int(p.next.Add(1)))
+	if !healthy {
+		log.Printf("No healthy %s replicas, using %s", p.name, b.name)
+	}
+	return b
+}
+
+// peek returns the replica pick would choose for r next, without moving the
+// round-robin position.
+func (p *backendPool) peek(r *http.Request) *backend {
+	b, _ := p.choose(r, int(p.next.Load()+1))
+	return b
+}
+
+// choose is pick starting the round-robin at start; healthy is false when no
+// replica is.
+func (p *backendPool) choose(r *http.Request, start int) (b *backend, healthy bool) {
+	if user := requestUser(r); user != "" {
+		if name, ok := p.ring.lookup(user, p.usable); ok {
+			return p.byName[name], true
+		}
+	}
+	n := len(p.members)
+	for i := 0; i < n; i++ {
+		if b := p.members[(start+i)%n]; b.available(time.Now()) {
+			return b, true
+		}
+	}
+	return p.members[start%n], false
+}
+
+// serve forwards r to the replica pick chooses and fails over to the other
+// replicas in turn. Replicas whose breaker refuses the request are skipped
+// before anything is sent. A replica answering 502, 503 or 504 is counted
+// against its breaker, unless the proxy produced that answer itself (see
+// localMark), and the request is resent to the next replica only
+// when that is safe: an idempotent method or X-Safe-Retry: true, as for
+// refusedRetryTransport. The last failure is passed on to the client.
+func (p *backendPool) serve(w http.ResponseWriter, r *http.Request) {
+	resend := len(p.members) > 1 && (retryableMethod(r.Method) || r.Header.Get(safeRetryHeader) == "true")
+	var body []byte
+	if resend && r.Body != nil && r.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+	}
+
+	var failed *failoverWriter
+	for _, b := range p.failoverOrder(p.pick(r)) {
+		if failed != nil && !resend {
+			break
+		}
+		if !b.breaker.allow(time.Now()) {
+			continue
+		}
+		req := r
+		if body != nil {
+			req = r.Clone(r.Context())
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+		}
+		if failed != nil {
+			log.Printf("%s answered %d for %s %s, failing over to %s", failed.backend, failed.resp.status, r.Method, r.URL.Path, b.name)
+		} else {
+			log.Printf("Routing to %s", b.name)
+		}
+		fw := &failoverWriter{w: w, resp: bufferedResponse{header: make(http.Header)}, backend: b.name}
+		req, local := withLocalMark(req)
+		b.proxy.ServeHTTP(fw, req)
+		if fw.resp.status == 0 {
+			fw.WriteHeader(http.StatusOK)
+		}
+		if fw.resp.status == statusClientClosedRequest || local.set {
+			b.breaker.release()
+		} else {
+			b.breaker.record(time.Now(), !fw.held)
+		}
+		if !fw.held {
+			return
+		}
+		failed = fw
+		if r.Context().Err() != nil {
+			break
+		}
+	}
+	if failed != nil {
+		failed.resp.writeTo(w)
+		return
+	}
+	log.Printf("Every %s circuit breaker is open, rejecting %s %s", p.name, r.Method, r.URL.Path)
+	http.Error(w, "No "+p.name+" replica available", http.StatusServiceUnavailable)
+}
+
+// failoverOrder is first followed by the other healthy replicas, in pool
+// order after it.
+func (p *backendPool) failoverOrder(first *backend) []*backend {
+	order := []*backend{first}
+	at := 0
+	for i, b := range p.members {
+		if b == first {
+			at = i
+		}
+	}
+	for i := 1; i < len(p.members); i++ {
+		if b := p.members[(at+i)%len(p.members)]; b.isHealthy() {
+			order = append(order, b)
+		}
+	}
+	return order
+}
+
+// localMark records that the proxy answered a request itself, as the
+// in-flight limiter and the route timeout do, so the response says nothing
+// about the replica and is kept out of its breaker.
+type localMark struct{ set bool }
+
+type localMarkKey struct{}
+
+func withLocalMark(r *http.Request) (*http.Request, *localMark) {
+	m := &localMark{}
+	return r.WithContext(context.WithValue(r.Context(), localMarkKey{}, m)), m
+}
+
+// markLocal flags r's response as generated by the proxy. Requests that did
+// not come through a pool carry no mark and are left alone.
+func markLocal(r *http.Request) {
+	if m, ok := r.Context().Value(localMarkKey{}).(*localMark); ok {
+		m.set = true
+	}
+}
+
+// failoverStatus reports whether status means the replica, rather than the
+// request, failed.
+func failoverStatus(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// failoverWriter passes a response through to w unless its status is a
+// failoverStatus, in which case it holds the response back so the request
+// can go to another replica.
+type failoverWriter struct {
+	w       http.ResponseWriter
+	resp    bufferedResponse
+	backend string
+	held    bool
+}
+
+func (f *failoverWriter) Header() http.Header { return f.resp.header }
+
+func (f *failoverWriter) WriteHeader(status int) {
+	if f.resp.status != 0 {
+		return
+	}
+	f.resp.status = status
+	if failoverStatus(status) {
+		f.held = true
+		return
+	}
+	f.resp.copyHeader(f.w)
+	f.w.WriteHeader(status)
+}
+
+func (f *failoverWriter) Write(b []byte) (int, error) {
+	if f.resp.status == 0 {
+		f.WriteHeader(http.StatusOK)
+	}
+	if f.held {
+		return f.resp.Write(b)
+	}
+	return f.w.Write(b)
+}
+
+func (f *failoverWriter) Flush() {
+	if f.resp.status != 0 && !f.held {
+		http.NewResponseController(f.w).Flush()
+	}
+}
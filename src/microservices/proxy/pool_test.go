@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// status answers with code and its name, recording the bodies it received.
+func status(name string, code int, bodies *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if bodies != nil {
+			b, _ := io.ReadAll(r.Body)
+			*bodies = append(*bodies, name+":"+string(b))
+		}
+		w.Header().Set("X-Backend", name)
+		w.WriteHeader(code)
+		w.Write([]byte(name))
+	}
+}
+
+func TestEventsFailover(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		safe       bool
+		codes      []int
+		wantStatus int
+		wantFrom   string
+		wantTried  int
+	}{
+		{"idempotent request fails over", http.MethodGet, false, []int{http.StatusBadGateway, http.StatusOK}, http.StatusOK, "events-service@1", 2},
+		{"timeout fails over", http.MethodPut, false, []int{http.StatusGatewayTimeout, http.StatusOK}, http.StatusOK, "events-service@1", 2},
+		{"post is not resent", http.MethodPost, false, []int{http.StatusBadGateway, http.StatusOK}, http.StatusBadGateway, "events-service@0", 1},
+		{"post with X-Safe-Retry is resent", http.MethodPost, true, []int{http.StatusServiceUnavailable, http.StatusCreated}, http.StatusCreated, "events-service@1", 2},
+		{"application errors are not retried", http.MethodGet, false, []int{http.StatusInternalServerError, http.StatusOK}, http.StatusInternalServerError, "events-service@0", 1},
+		{"last failure is returned", http.MethodGet, false, []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}, http.StatusGatewayTimeout, "events-service@2", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			handlers := make([]http.Handler, len(tt.codes))
+			for i, code := range tt.codes {
+				handlers[i] = status(fmt.Sprintf("events-service@%d", i), code, &bodies)
+			}
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.events = testPool("events-service", handlers...)
+			// Start the round-robin at the first replica.
+			s.events.next.Store(uint32(len(handlers) - 1))
+
+			r := httptest.NewRequest(tt.method, "/api/events/movie", strings.NewReader(`{"movie_id":1}`))
+			if tt.safe {
+				r.Header.Set(safeRetryHeader, "true")
+			}
+			rec := serve(s, r)
+			if rec.Code != tt.wantStatus || rec.Header().Get("X-Backend") != tt.wantFrom {
+				t.Fatalf("got %d from %s, want %d from %s", rec.Code, rec.Header().Get("X-Backend"), tt.wantStatus, tt.wantFrom)
+			}
+			if rec.Body.String() != tt.wantFrom {
+				t.Fatalf("body = %q, want only the answer of %s", rec.Body.String(), tt.wantFrom)
+			}
+			if len(bodies) != tt.wantTried {
+				t.Fatalf("tried %v, want %d replicas", bodies, tt.wantTried)
+			}
+			for _, b := range bodies {
+				if !strings.HasSuffix(b, `{"movie_id":1}`) {
+					t.Fatalf("replica got body %q", b)
+				}
+			}
+		})
+	}
+}
+
+func TestEventsLoadBalancing(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		wantSeen int
+	}{
+		{"round-robin without a user", "", 3},
+		{"sticky with a user", "user-42", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.events = testPool("events-service", named("events-service@0"), named("events-service@1"), named("events-service@2"))
+			seen := make(map[string]int)
+			for i := 0; i < 6; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/api/events/health", nil)
+				if tt.user != "" {
+					r.Header.Set("X-User-ID", tt.user)
+				}
+				seen[serve(s, r).Header().Get("X-Backend")]++
+			}
+			if len(seen) != tt.wantSeen {
+				t.Fatalf("replicas used = %v, want %d of them", seen, tt.wantSeen)
+			}
+		})
+	}
+}
+
+func TestEventsBreakerTakesReplicaOut(t *testing.T) {
+	tests := []struct {
+		name       string
+		healthy    int
+		wantStatus int
+	}{
+		{"other replica serves", http.StatusOK, http.StatusOK},
+		{"every breaker open", http.StatusBadGateway, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.events = testPool("events-service", status("events-service@0", http.StatusBadGateway, &bodies), status("events-service@1", tt.healthy, &bodies))
+			for _, b := range s.events.members {
+				b.breaker = newCircuitBreaker(b.name, 2, time.Minute)
+			}
+			for i := 0; i < 4; i++ {
+				serve(s, httptest.NewRequest(http.MethodGet, "/api/events/health", nil))
+			}
+			bodies = nil
+			rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/events/health", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for _, b := range bodies {
+				if strings.HasPrefix(b, "events-service@0") {
+					t.Fatal("request reached a replica with an open breaker")
+				}
+			}
+			if got := s.events.members[0].breaker.state(time.Now()); got != breakerOpen {
+				t.Fatalf("failing replica breaker = %s, want open", got)
+			}
+		})
+	}
+}
+
+// Responses the proxy generates itself, the in-flight limiter's 503 and the
+// route timeout's 504, say nothing about the replica and leave its breaker
+// closed.
+func TestBreakerIgnoresLocalResponses(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+	slowURL, _ := url.Parse(slow.URL)
+
+	saturated := newInflightLimiter("breaker-saturated", 1, 0, 0, 1)
+	if !saturated.acquire(httptest.NewRequest(http.MethodGet, "/api/events/health", nil)) {
+		t.Fatal("could not take the limiter's only slot")
+	}
+	defer saturated.release()
+
+	tests := []struct {
+		name       string
+		replica    http.Handler
+		timeout    time.Duration
+		wantStatus int
+		wantState  string
+	}{
+		{"limiter saturated", saturated.wrap(named("events-service")), 0, http.StatusServiceUnavailable, breakerClosed},
+		{"route timeout", newUpstreamProxy("events-service", slowURL, http.DefaultTransport), 10 * time.Millisecond, http.StatusGatewayTimeout, breakerClosed},
+		{"replica failing", status("events-service", http.StatusBadGateway, nil), 0, http.StatusBadGateway, breakerOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPool("events-service", tt.replica)
+			p.members[0].breaker = newCircuitBreaker(p.members[0].name, 2, time.Minute)
+			for i := 0; i < 3; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/api/events/health", nil)
+				if tt.timeout > 0 {
+					ctx, cancel := context.WithTimeout(r.Context(), tt.timeout)
+					defer cancel()
+					r = r.WithContext(ctx)
+				}
+				if rec := serve(http.HandlerFunc(p.serve), r); i < 2 && rec.Code != tt.wantStatus {
+					t.Fatalf("request %d status = %d, want %d", i, rec.Code, tt.wantStatus)
+				}
+			}
+			if got := p.members[0].breaker.state(time.Now()); got != tt.wantState {
+				t.Fatalf("breaker = %s, want %s", got, tt.wantState)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	priorityHigh = "high"
+	priorityLow  = "low"
+)
+
+type priorityContextKey struct{}
+
+// requestPriority is the priority withPriority assigned to r, high if none.
+func requestPriority(r *http.Request) string {
+	if p, ok := r.Context().Value(priorityContextKey{}).(string); ok {
+		return p
+	}
+	return priorityHigh
+}
+
+// withPriority classifies requests by X-Priority: high|low, falling back to
+// defaultPriority for a missing or unknown value. Low-priority requests are
+// shed first by the in-flight limiters and, with lowTimeout set, are
+// cancelled after that long.
+func withPriority(defaultPriority string, lowTimeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Priority")))
+		if p != priorityHigh && p != priorityLow {
+			p = defaultPriority
+		}
+		ctx := context.WithValue(r.Context(), priorityContextKey{}, p)
+		if p == priorityLow && lowTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, lowTimeout)
+			defer cancel()
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestPriority(t *testing.T) {
+	tests := []struct {
+		header          string
+		defaultPriority string
+		want            string
+	}{
+		{"high", priorityLow, priorityHigh},
+		{" LOW ", priorityHigh, priorityLow},
+		{"", priorityHigh, priorityHigh},
+		{"", priorityLow, priorityLow},
+		{"urgent", priorityLow, priorityLow},
+	}
+	for _, tt := range tests {
+		var got string
+		var deadline bool
+		h := withPriority(tt.defaultPriority, time.Second, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = requestPriority(r)
+			_, deadline = r.Context().Deadline()
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+		if tt.header != "" {
+			r.Header.Set("X-Priority", tt.header)
+		}
+		serve(h, r)
+		if got != tt.want {
+			t.Errorf("X-Priority %q with default %s: priority = %s, want %s", tt.header, tt.defaultPriority, got, tt.want)
+		}
+		// Only low-priority requests get the shorter timeout.
+		if deadline != (tt.want == priorityLow) {
+			t.Errorf("X-Priority %q: deadline set = %v", tt.header, deadline)
+		}
+	}
+	if got := requestPriority(httptest.NewRequest(http.MethodGet, "/", nil)); got != priorityHigh {
+		t.Errorf("unclassified request priority = %s, want high", got)
+	}
+}
+
+func TestPrioritySheddingUnderLoad(t *testing.T) {
+	// Five slots with 40% reserved: low priority is shed once three are busy.
+	release := make(chan struct{})
+	entered := make(chan struct{}, 10)
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Hold") != "" {
+			entered <- struct{}{}
+			<-release
+		}
+	})
+	l := newInflightLimiter("priority-test", 5, 40, 50*time.Millisecond, 1)
+	h := withPriority(priorityHigh, 0, l.wrap(upstream))
+
+	var held sync.WaitGroup
+	hold := func(priority string) {
+		held.Add(1)
+		go func() {
+			defer held.Done()
+			r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+			r.Header.Set("X-Priority", priority)
+			r.Header.Set("X-Hold", "1")
+			serve(h, r)
+		}()
+		<-entered
+	}
+	request := func(priority string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+		r.Header.Set("X-Priority", priority)
+		return serve(h, r).Code
+	}
+	defer func() {
+		close(release)
+		held.Wait()
+	}()
+
+	steps := []struct {
+		name  string
+		load  []string
+		probe string
+		want  int
+	}{
+		{"low admitted while idle", nil, priorityLow, http.StatusOK},
+		{"low admitted below the reserve", []string{priorityLow, priorityLow}, priorityLow, http.StatusOK},
+		{"low shed at the reserve", []string{priorityLow}, priorityLow, http.StatusServiceUnavailable},
+		{"high admitted into the reserve", nil, priorityHigh, http.StatusOK},
+		{"high fills the reserve", []string{priorityHigh}, priorityHigh, http.StatusOK},
+		{"low still shed", []string{priorityHigh}, priorityLow, http.StatusServiceUnavailable},
+		{"high shed when full", nil, priorityHigh, http.StatusServiceUnavailable},
+	}
+	for _, s := range steps {
+		for _, p := range s.load {
+			hold(p)
+		}
+		start := time.Now()
+		if got := request(s.probe); got != s.want {
+			t.Fatalf("%s: status = %d, want %d", s.name, got, s.want)
+		}
+		// Low-priority requests are shed at once instead of queueing.
+		if s.probe == priorityLow && s.want != http.StatusOK && time.Since(start) >= 50*time.Millisecond {
+			t.Fatalf("%s: low-priority request waited for a slot", s.name)
+		}
+	}
+}
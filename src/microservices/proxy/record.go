@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cinemaabyss/proxy-service/pkg/recording"
+)
+
+// requestRecorder implements RECORD_PERCENT: a sampled share of all incoming
+// requests, of any method, is written with its body to a rotating file for
+// replay elsewhere with cmd/replay. Unlike capture, nothing is sent to a
+// second backend, so writes are safe to record.
+type requestRecorder struct {
+	percent int
+	maxBody int
+	out     *recording.Writer
+	// headers and fields are redacted, case-insensitively; fields apply to
+	// query parameters and to JSON body keys at any depth.
+	headers []string
+	fields  map[string]bool
+}
+
+func newRequestRecorder(percent, maxBody int, out *recording.Writer, headers, fields string) *requestRecorder {
+	rec := &requestRecorder{percent: percent, maxBody: maxBody, out: out, fields: make(map[string]bool)}
+	for _, h := range strings.Split(headers, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			rec.headers = append(rec.headers, http.CanonicalHeaderKey(h))
+		}
+	}
+	for _, f := range strings.Split(fields, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			rec.fields[strings.ToLower(f)] = true
+		}
+	}
+	return rec
+}
+
+func (rec *requestRecorder) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rand.Intn(100) >= rec.percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+		entry := recording.Entry{Time: time.Now(), Method: r.Method, URI: rec.redactURI(r.URL), Host: r.Host, Header: rec.redactHeader(r.Header)}
+		if r.Body != nil && r.Body != http.NoBody {
+			body, err := io.ReadAll(io.LimitReader(r.Body, int64(rec.maxBody)+1))
+			if err != nil {
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			// The upstream still gets the whole body, read or not.
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			if len(body) > rec.maxBody {
+				body, entry.BodyTruncated = body[:rec.maxBody], true
+			}
+			if entry.Body = rec.redactBody(body, entry.BodyTruncated); entry.Body == nil && len(body) > 0 {
+				entry.BodyTruncated = true
+			}
+		}
+		if err := rec.out.Write(entry); err != nil {
+			log.Printf("Failed to record %s %s: %v", r.Method, r.URL.Path, err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (rec *requestRecorder) redactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for _, key := range rec.headers {
+		if _, ok := out[key]; ok {
+			out[key] = []string{recording.Redacted}
+		}
+	}
+	return out
+}
+
+func (rec *requestRecorder) redactURI(u *url.URL) string {
+	q := u.Query()
+	changed := false
+	for key := range q {
+		if rec.fields[strings.ToLower(key)] {
+			q[key] = []string{recording.Redacted}
+			changed = true
+		}
+	}
+	if !changed {
+		return u.RequestURI()
+	}
+	redacted := *u
+	redacted.RawQuery = q.Encode()
+	return redacted.RequestURI()
+}
+
+// redactBody blanks sensitive fields of a JSON body. A body that is not JSON,
+// or was truncated and so cannot be parsed, is dropped unless no fields are
+// configured, since it cannot be checked; the entry is then marked truncated.
+func (rec *requestRecorder) redactBody(body []byte, truncated bool) []byte {
+	if len(rec.fields) == 0 || len(body) == 0 {
+		return body
+	}
+	var v interface{}
+	if truncated || json.Unmarshal(body, &v) != nil {
+		return nil
+	}
+	redacted, err := json.Marshal(rec.redactValue(v))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+func (rec *requestRecorder) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if rec.fields[strings.ToLower(key)] {
+				v[key] = recording.Redacted
+			} else {
+				v[key] = rec.redactValue(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = rec.redactValue(child)
+		}
+	}
+	return v
+}
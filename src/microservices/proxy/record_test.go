@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cinemaabyss/proxy-service/pkg/recording"
+)
+
+func TestRequestRecorder(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		header        http.Header
+		body          string
+		fields        string
+		wantURI       string
+		wantBody      string
+		wantTruncated bool
+	}{
+		{"query field redacted", http.MethodGet, "/api/movies?api_key=abc&page=2", nil, "", "api_key", "/api/movies?api_key=%5BREDACTED%5D&page=2", "", false},
+		{"nested JSON fields redacted", http.MethodPost, "/api/users", nil, `{"name":"ann","profile":{"Email":"ann@example.com"},"cards":[{"number":"4111"}]}`, "email,number", "/api/users", `{"cards":[{"number":"[REDACTED]"}],"name":"ann","profile":{"Email":"[REDACTED]"}}`, false},
+		{"non-JSON body dropped with fields set", http.MethodPost, "/api/upload", nil, "name=ann", "email", "/api/upload", "", true},
+		{"non-JSON body kept without fields", http.MethodPost, "/api/upload", nil, "name=ann", "", "/api/upload", "name=ann", false},
+		{"long body truncated", http.MethodPut, "/api/movies/7", nil, strings.Repeat("x", 200), "", "/api/movies/7", strings.Repeat("x", 128), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "recording.jsonl")
+			out, err := recording.NewWriter(path, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := newRequestRecorder(100, 128, out, "Authorization, cookie", tt.fields)
+			var upstreamBody string
+			h := rec.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				upstreamBody = string(body)
+			}))
+
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.body == "" {
+				r.Body = http.NoBody
+			}
+			r.Header.Set("Authorization", "Bearer s3cret")
+			r.Header.Set("Cookie", "session=abc")
+			r.Header.Set("X-Tenant-ID", "acme")
+			serve(h, r)
+			out.Close()
+
+			if upstreamBody != tt.body {
+				t.Errorf("upstream got body %q, want the original %q", upstreamBody, tt.body)
+			}
+			entries := readRecording(t, path)
+			if len(entries) != 1 {
+				t.Fatalf("recorded %d entries, want 1", len(entries))
+			}
+			e := entries[0]
+			if e.Method != tt.method || e.URI != tt.wantURI {
+				t.Errorf("recorded %s %s, want %s %s", e.Method, e.URI, tt.method, tt.wantURI)
+			}
+			if string(e.Body) != tt.wantBody || e.BodyTruncated != tt.wantTruncated {
+				t.Errorf("recorded body %q (truncated %v), want %q (truncated %v)", e.Body, e.BodyTruncated, tt.wantBody, tt.wantTruncated)
+			}
+			if e.Header.Get("Authorization") != recording.Redacted || e.Header.Get("Cookie") != recording.Redacted || e.Header.Get("X-Tenant-ID") != "acme" {
+				t.Errorf("recorded headers = %v", e.Header)
+			}
+			if r.Header.Get("Authorization") != "Bearer s3cret" {
+				t.Error("redaction changed the forwarded request")
+			}
+		})
+	}
+}
+
+func TestRequestRecorderSampling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	out, err := recording.NewWriter(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newRequestRecorder(0, 1024, out, "", "").middleware(named("monolith"))
+	for i := 0; i < 20; i++ {
+		if rec := serve(h, httptest.NewRequest(http.MethodGet, "/api/movies", nil)); rec.Body.String() != "monolith" {
+			t.Fatalf("request not forwarded: %q", rec.Body.String())
+		}
+	}
+	out.Close()
+	if entries := readRecording(t, path); len(entries) != 0 {
+		t.Errorf("recorded %d entries at 0%%", len(entries))
+	}
+}
+
+func readRecording(t *testing.T, path string) []recording.Entry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []recording.Entry
+	if err := recording.Read(f, func(e recording.Entry) error { entries = append(entries, e); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// writeJSON encodes v as the response body. Read endpoints honour
+// ?pretty=true to indent the output for humans; the default stays compact.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	var (
+		body []byte
+		err  error
+	)
+	if r.URL.Query().Get("pretty") == "true" {
+		body, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		body, err = json.Marshal(v)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteJSONPretty(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantIndent bool
+	}{
+		{"", false},
+		{"?pretty=true", true},
+		{"?pretty=false", false},
+		{"?pretty=1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeJSON(rec, httptest.NewRequest(http.MethodGet, "/proxy/health/all"+tt.query, nil), http.StatusOK, map[string]int{"a": 1})
+			if got := strings.Contains(rec.Body.String(), "\n  \"a\""); got != tt.wantIndent {
+				t.Fatalf("body %q indented = %v, want %v", rec.Body.String(), got, tt.wantIndent)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("Content-Type = %q", ct)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// urlRewrite is the "url_rewrite" section of the config file. Movies-service
+// responses embed absolute links to its own host; this replaces those with
+// PublicURL so the links keep working through the proxy. Routes limits the
+// rewrite to responses for these route prefixes and ContentTypes to these
+// media types; both default to everything the movies-service serves as
+// application/json.
+type urlRewrite struct {
+	PublicURL    string   `json:"public_url"`
+	Routes       []string `json:"routes,omitempty"`
+	ContentTypes []string `json:"content_types,omitempty"`
+}
+
+func (u *urlRewrite) validate() error {
+	if u == nil {
+		return nil
+	}
+	public, err := url.Parse(u.PublicURL)
+	if err != nil || (public.Scheme != "http" && public.Scheme != "https") || public.Host == "" {
+		return fmt.Errorf("url_rewrite.public_url: %q must be an absolute http or https URL", u.PublicURL)
+	}
+	for _, prefix := range u.Routes {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("url_rewrite.routes: %q must start with /", prefix)
+		}
+	}
+	for _, ct := range u.ContentTypes {
+		if _, _, err := mime.ParseMediaType(ct); err != nil {
+			return fmt.Errorf("url_rewrite.content_types: %q: %v", ct, err)
+		}
+	}
+	return nil
+}
+
+func (u *urlRewrite) appliesTo(resp *http.Response) bool {
+	if len(u.Routes) > 0 {
+		rt := routeFrom(resp.Request.Context())
+		if rt == nil || !containsString(u.Routes, rt.Prefix) {
+			return false
+		}
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	types := u.ContentTypes
+	if len(types) == 0 {
+		types = []string{"application/json"}
+	}
+	for _, ct := range types {
+		if ct, _, _ := mime.ParseMediaType(ct); ct == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteURLs replaces every occurrence of a base URL in body with public,
+// including the \/-escaped form JSON encoders may produce. An occurrence
+// only counts when the host does not continue past it, so http://movies:8081
+// is not found inside http://movies:80812 or http://movies:8081.example.com.
+func rewriteURLs(body []byte, bases []string, public string) []byte {
+	public = strings.TrimSuffix(public, "/")
+	for _, base := range bases {
+		base = strings.TrimSuffix(base, "/")
+		body = replaceAtBoundary(body, []byte(base), []byte(public))
+		escaped := strings.ReplaceAll(base, "/", `\/`)
+		body = replaceAtBoundary(body, []byte(escaped), []byte(strings.ReplaceAll(public, "/", `\/`)))
+	}
+	return body
+}
+
+func replaceAtBoundary(body, old, repl []byte) []byte {
+	var out []byte
+	rest := body
+	for {
+		i := bytes.Index(rest, old)
+		if i < 0 {
+			break
+		}
+		end := i + len(old)
+		if end < len(rest) && isHostByte(rest[end]) {
+			out = append(out, rest[:end]...)
+			rest = rest[end:]
+			continue
+		}
+		out = append(out, rest[:i]...)
+		out = append(out, repl...)
+		rest = rest[end:]
+	}
+	if out == nil {
+		return body
+	}
+	return append(out, rest...)
+}
+
+func isHostByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(".-_:@", c) >= 0
+}
+
+// rewriteResponseURLs applies u to uncompressed responses it covers, swapping
+// any of the upstream base URLs for the public one.
+func rewriteResponseURLs(u *urlRewrite, upstreams []*url.URL) responseModifier {
+	bases := make([]string, len(upstreams))
+	for i, up := range upstreams {
+		bases[i] = up.String()
+	}
+	return func(resp *http.Response) error {
+		if !u.appliesTo(resp) {
+			return nil
+		}
+		if enc := resp.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+			return nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		out := rewriteURLs(body, bases, u.PublicURL)
+		resp.Body = io.NopCloser(bytes.NewReader(out))
+		resp.ContentLength = int64(len(out))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(out)))
+		return nil
+	}
+}
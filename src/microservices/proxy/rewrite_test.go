@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRewriteURLs(t *testing.T) {
+	bases := []string{"http://movies-1:8081", "http://movies-2:8081/"}
+	const public = "https://cinemaabyss.example.com/"
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"absolute link", `{"href":"http://movies-1:8081/api/movies/7"}`, `{"href":"https://cinemaabyss.example.com/api/movies/7"}`},
+		{"every replica", `["http://movies-1:8081/a","http://movies-2:8081/b"]`, `["https://cinemaabyss.example.com/a","https://cinemaabyss.example.com/b"]`},
+		{"bare base", `{"self":"http://movies-1:8081"}`, `{"self":"https://cinemaabyss.example.com"}`},
+		{"escaped slashes", `{"href":"http:\/\/movies-1:8081\/api\/movies"}`, `{"href":"https:\/\/cinemaabyss.example.com\/api\/movies"}`},
+		{"longer port untouched", `{"href":"http://movies-1:80812/x"}`, `{"href":"http://movies-1:80812/x"}`},
+		{"longer host untouched", `{"href":"http://movies-1:8081.example.com/x"}`, `{"href":"http://movies-1:8081.example.com/x"}`},
+		{"other content untouched", `{"title":"Heat","rating":8.3,"url":"http://monolith:8080/x"}`, `{"title":"Heat","rating":8.3,"url":"http://monolith:8080/x"}`},
+		{"nothing to rewrite", `{}`, `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(rewriteURLs([]byte(tt.body), bases, public)); got != tt.want {
+				t.Errorf("rewriteURLs = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteResponseURLs(t *testing.T) {
+	upstream := []*url.URL{{Scheme: "http", Host: "movies-1:8081"}}
+	const (
+		in  = `{"href":"http://movies-1:8081/api/movies/7"}`
+		out = `{"href":"https://cinemaabyss.example.com/api/movies/7"}`
+	)
+	tests := []struct {
+		name    string
+		rewrite urlRewrite
+		route   string
+		header  http.Header
+		want    string
+	}{
+		{"json by default", urlRewrite{}, "/api/movies", http.Header{"Content-Type": {"application/json; charset=utf-8"}}, out},
+		{"configured route", urlRewrite{Routes: []string{"/api/movies"}}, "/api/movies", http.Header{"Content-Type": {"application/json"}}, out},
+		{"other route", urlRewrite{Routes: []string{"/api/movies"}}, "/api/users", http.Header{"Content-Type": {"application/json"}}, in},
+		{"no route matched", urlRewrite{Routes: []string{"/api/movies"}}, "", http.Header{"Content-Type": {"application/json"}}, in},
+		{"configured content type", urlRewrite{ContentTypes: []string{"application/hal+json"}}, "/api/movies", http.Header{"Content-Type": {"application/hal+json"}}, out},
+		{"json not configured", urlRewrite{ContentTypes: []string{"application/hal+json"}}, "/api/movies", http.Header{"Content-Type": {"application/json"}}, in},
+		{"text untouched", urlRewrite{}, "/api/movies", http.Header{"Content-Type": {"text/plain"}}, in},
+		{"compressed untouched", urlRewrite{}, "/api/movies", http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}}, in},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rewrite.PublicURL = "https://cinemaabyss.example.com"
+			req := httptest.NewRequest(http.MethodGet, "/api/movies/7", nil)
+			if tt.route != "" {
+				req = withRoute(req, &route{Prefix: tt.route, Target: targetMovies})
+			}
+			resp := &http.Response{StatusCode: http.StatusOK, Header: tt.header, Body: io.NopCloser(strings.NewReader(in)), Request: req}
+			if err := rewriteResponseURLs(&tt.rewrite, upstream)(resp); err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.want {
+				t.Errorf("body = %s, want %s", body, tt.want)
+			}
+			if tt.want == out && (resp.ContentLength != int64(len(out)) || resp.Header.Get("Content-Length") != strconv.Itoa(len(out))) {
+				t.Errorf("Content-Length = %d/%q, want %d", resp.ContentLength, resp.Header.Get("Content-Length"), len(out))
+			}
+		})
+	}
+}
+
+func TestURLRewriteConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"valid", `{"url_rewrite": {"public_url": "https://cinemaabyss.example.com", "routes": ["/api/movies"], "content_types": ["application/hal+json"]}}`, false},
+		{"relative public url", `{"url_rewrite": {"public_url": "/movies"}}`, true},
+		{"unsupported scheme", `{"url_rewrite": {"public_url": "ftp://cinemaabyss.example.com"}}`, true},
+		{"route without slash", `{"url_rewrite": {"public_url": "https://cinemaabyss.example.com", "routes": ["api/movies"]}}`, true},
+		{"bad content type", `{"url_rewrite": {"public_url": "https://cinemaabyss.example.com", "content_types": ["json;;"]}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadFileConfig(path); (err != nil) != tt.wantErr {
+				t.Fatalf("loadFileConfig error = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// backend is a named upstream the proxy can forward to.
+type backend struct {
+	name  string
+	url   *url.URL
+	proxy http.Handler
+
+	down atomic.Bool
+	// errors is the rolling 5xx rate of the responses proxied to the backend.
+	errors *errorWindow
+	// breaker, when set, takes the backend out of its pool after repeated
+	// failures, see backendPool.serve.
+	breaker *circuitBreaker
+}
+
+func (b *backend) isDegraded() bool {
+	return b.errors != nil && b.errors.rate(time.Now()).Degraded
+}
+
+func (b *backend) isHealthy() bool { return !b.down.Load() }
+
+// available reports whether b is healthy and its breaker, if any, is not
+// open at now.
+func (b *backend) available(now time.Time) bool {
+	return b.isHealthy() && b.breaker.state(now) != breakerOpen
+}
+
+func (b *backend) setHealthy(healthy bool) { b.down.Store(!healthy) }
+
+// proxyServer holds the routing table and per-backend proxies and implements
+// the catch-all handler.
+type proxyServer struct {
+	routes       []*route
+	defaultRoute *route
+	// access, when set, blocks denied paths before routing.
+	access *accessPolicy
+
+	monolith *backend
+	movies   *backendPool
+	events   *backendPool
+
+	gradualMigration bool
+	migrationPercent int
+	// throttle, when set, lowers migrationPercent while movies-service is slow.
+	throttle *migrationThrottle
+	// flags, when set, decides migration per user ahead of the percentage.
+	flags   *flagClient
+	tenants map[string]string
+
+	// queryRoutingKey, when set, lets ?<key>=new|old pick the movies backend.
+	queryRoutingKey string
+	// routeTokens, when set, pins requests with a valid X-Route-Token to
+	// movies-service.
+	routeTokens *routeTokenVerifier
+	// jwt, when set, takes the tenant and user from a verified bearer token.
+	jwt *jwtVerifier
+
+	// coalesce, cache and chaos are nil when the feature is disabled.
+	coalesce *coalescer
+	cache    *responseCache
+	chaos    *chaosInjector
+	// capture, when set, records sampled requests against both backends.
+	capture *captureRecorder
+}
+
+func (s *proxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Incoming request: %s %s from %s", r.Method, r.URL.Path, ClientIP(r))
+	if status := s.access.check(r); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	r = s.jwt.attach(r)
+	rt := matchRoute(s.routes, r.URL.Path, s.defaultRoute)
+	r = withRoute(r, rt)
+	if rt.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), rt.Timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	if rt == s.defaultRoute {
+		log.Printf("No route matched, using default target %s", rt.Target)
+	}
+
+	target, pinned := rt.targetFor(r.Method)
+	if pinned {
+		log.Printf("Method %s pinned to %s", r.Method, target)
+	}
+	switch target {
+	case targetMovies:
+		if s.chaos != nil && s.chaos.inject(w, r) {
+			return
+		}
+		s.serveMovies(w, r, pinned)
+	case targetEvents:
+		s.events.serve(w, r)
+	default:
+		log.Printf("Routing to monolith")
+		s.monolith.proxy.ServeHTTP(w, r)
+	}
+}
+
+// queryOverride extracts the routing query parameter, returning the target it
+// requests and a copy of r with the parameter removed so it never reaches the
+// upstream.
+func (s *proxyServer) queryOverride(r *http.Request) (*http.Request, string) {
+	if s.queryRoutingKey == "" {
+		return r, ""
+	}
+	q := r.URL.Query()
+	value, ok := q[s.queryRoutingKey]
+	if !ok {
+		return r, ""
+	}
+	q.Del(s.queryRoutingKey)
+	stripped := new(http.Request)
+	*stripped = *r
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	stripped.URL = &u
+
+	switch value[0] {
+	case "new", targetMovies:
+		return stripped, targetMovies
+	case "old", targetMonolith:
+		return stripped, targetMonolith
+	}
+	return stripped, ""
+}
+
+// chooseMoviesBackend applies the query or route token override, per-tenant
+// overrides, the feature flag and then the gradual migration split.
+func (s *proxyServer) chooseMoviesBackend(r *http.Request, forced string) *backend {
+	b, reason := s.moviesChoice(r, forced, false)
+	if reason != "" {
+		log.Printf("Routing to %s (%s)", b.name, reason)
+	} else {
+		log.Printf("Routing to %s", b.name)
+	}
+	return b
+}
+
+// moviesChoice is the decision behind chooseMoviesBackend, with the reason
+// for it; the reason is empty for a request that simply stays on the
+// monolith. A dry run leaves the round-robin position alone and only uses
+// cached feature flag results; without one it goes on to the percentage
+// split and says so in the reason, as it does when that split is a random
+// draw for a request without a user.
+func (s *proxyServer) moviesChoice(r *http.Request, forced string, dryRun bool) (*backend, string) {
+	pick := s.movies.pick
+	if dryRun {
+		pick = s.movies.peek
+	}
+	switch forced {
+	case targetMovies:
+		return pick(r), "override"
+	case targetMonolith:
+		return s.monolith, "query override"
+	}
+	if tenant := requestTenant(r); tenant != "" {
+		switch s.tenants[tenant] {
+		case targetMovies:
+			return pick(r), "tenant " + tenant
+		case targetMonolith:
+			return s.monolith, "tenant " + tenant
+		}
+	}
+	var note string
+	if s.flags != nil {
+		var enabled, ok bool
+		if dryRun {
+			var hit bool
+			if enabled, ok, hit = s.flags.cached(r); !hit {
+				note = " (feature flag not cached)"
+			}
+		} else {
+			enabled, ok = s.flags.evaluate(r)
+		}
+		if ok {
+			if enabled {
+				return pick(r), "feature flag"
+			}
+			return s.monolith, "feature flag"
+		}
+	}
+	percent := s.effectiveMigrationPercent()
+	if dryRun && s.gradualMigration && requestUser(r) == "" && percent > 0 && percent < 100 {
+		note += " (random for requests without a user)"
+	}
+	if s.gradualMigration && migratesUser(requestUser(r), percent) {
+		return pick(r), "migration" + note
+	}
+	if note != "" {
+		return s.monolith, "migration" + note
+	}
+	return s.monolith, ""
+}
+
+// moviesOverride strips the routing query parameter from r and returns the
+// backend the request is forced to, if any, and why. A per-method pin beats
+// the query parameter, which beats a route token. The route token header is
+// removed whatever the outcome, and whether or not tokens are configured, so
+// it never reaches an upstream.
+func (s *proxyServer) moviesOverride(r *http.Request, pinned bool) (*http.Request, string, string) {
+	r, forced := s.queryOverride(r)
+	tokenPinned := !pinned && forced == "" && s.routeTokens.verify(r)
+	r.Header.Del(routeTokenHeader)
+	if pinned {
+		return r, targetMovies, "method pinned"
+	}
+	if tokenPinned {
+		log.Printf("Valid route token for %s, pinning to movies-service", r.URL.Path)
+		return r, targetMovies, "route token"
+	}
+	if forced != "" {
+		return r, forced, "query override"
+	}
+	return r, "", ""
+}
+
+func (s *proxyServer) effectiveMigrationPercent() int {
+	if s.throttle != nil {
+		return s.throttle.percent()
+	}
+	return s.migrationPercent
+}
+
+// serviceOf names the service b belongs to: the monolith, or the movies
+// pool shared by all of its replicas.
+func (s *proxyServer) serviceOf(b *backend) string {
+	if b == s.monolith {
+		return b.name
+	}
+	return s.movies.name
+}
+
+// serveMovies sends r to movies-service or the monolith. pinned requests,
+// from a per-method route override, always go to movies-service; query
+// overrides and route tokens cannot move them.
+func (s *proxyServer) serveMovies(w http.ResponseWriter, r *http.Request, pinned bool) {
+	if s.movies.isDisabled() {
+		log.Printf("Routing to monolith (%s disabled)", s.movies.name)
+		s.monolith.proxy.ServeHTTP(w, r)
+		return
+	}
+	r, forced, _ := s.moviesOverride(r, pinned)
+	if s.capture.sample(r) {
+		b := s.chooseMoviesBackend(r, forced)
+		if b == s.monolith {
+			s.capture.serve(w, r, b, s.movies.pick(r), true)
+		} else {
+			s.capture.serve(w, r, b, s.monolith, false)
+		}
+		return
+	}
+	if r.Method != http.MethodGet || (s.coalesce == nil && s.cache == nil) {
+		s.chooseMoviesBackend(r, forced).proxy.ServeHTTP(w, r)
+		return
+	}
+
+	// Explicitly routed requests bypass the cache so QA links really reach
+	// the requested backend.
+	cache := s.cache
+	if (forced != "" && !pinned) || !cacheableRequest(r) {
+		cache = nil
+	}
+
+	// The cache is checked only once the backend is chosen: the monolith
+	// and movies-service may answer the same URI differently.
+	b := s.chooseMoviesBackend(r, forced)
+	key := cacheKey{service: s.serviceOf(b), requestURI: r.URL.RequestURI()}
+	if cache != nil {
+		if entry, ok := cache.get(key); ok {
+			w.Header().Set("X-Cache", "HIT")
+			entry.serve(w, r)
+			return
+		}
+	}
+
+	var resp *bufferedResponse
+	if s.coalesce != nil {
+		resp = s.coalesce.fetch(r, b.name, b.proxy)
+	} else {
+		resp = newBufferedResponse()
+		b.proxy.ServeHTTP(resp, r)
+	}
+
+	if cache != nil {
+		w.Header().Set("X-Cache", "MISS")
+		if cacheableResponse(resp) {
+			cache.set(key, resp).serve(w, r)
+			return
+		}
+	}
+	resp.writeTo(w)
+}
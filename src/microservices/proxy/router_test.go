@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTenantRouting(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenant  string
+		percent int
+		want    string
+	}{
+		{"migrated tenant", "acme", 0, "movies-service"},
+		{"pinned to monolith", "legacy", 100, "monolith"},
+		{"unlisted tenant at 0%", "other", 0, "monolith"},
+		{"unlisted tenant at 100%", "other", 100, "movies-service"},
+		{"no tenant at 100%", "", 100, "movies-service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.gradualMigration = true
+			s.migrationPercent = tt.percent
+			s.tenants = map[string]string{"acme": targetMovies, "legacy": targetMonolith}
+
+			r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+			r.Header.Set("X-User-ID", "42")
+			if tt.tenant != "" {
+				r.Header.Set("X-Tenant-ID", tt.tenant)
+			}
+			if got := serve(s, r).Header().Get("X-Backend"); got != tt.want {
+				t.Fatalf("routed to %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"movies and monolith", `{"tenants": {"acme": "movies", "legacy": "monolith"}}`, false},
+		{"events is not a migration target", `{"tenants": {"acme": "events"}}`, true},
+		{"unknown target", `{"tenants": {"acme": "new"}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadFileConfig(path); (err != nil) != tt.wantErr {
+				t.Fatalf("loadFileConfig error = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultRouteTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{targetMonolith, "/api/users", "monolith", false},
+		{targetMovies, "/api/users", "movies-service", false},
+		{targetEvents, "/api/users", "events-service", false},
+		{targetEvents, "/api/movies", "movies-service", false},
+		{"payments", "/api/users", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target+tt.path, func(t *testing.T) {
+			routes, fallback, err := buildRoutes(&fileConfig{}, false, 0, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildRoutes error = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.routes, s.defaultRoute = routes, fallback
+			s.gradualMigration, s.migrationPercent = true, 100
+			if got := serve(s, httptest.NewRequest(http.MethodGet, tt.path, nil)).Header().Get("X-Backend"); got != tt.want {
+				t.Fatalf("%s routed to %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryRouting(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		query     string
+		percent   int
+		want      string
+		wantQuery string
+	}{
+		{"new", "backend", "?backend=new&page=2", 0, "movies-service", "page=2"},
+		{"movies", "backend", "?backend=movies", 0, "movies-service", ""},
+		{"old", "backend", "?backend=old", 100, "monolith", ""},
+		{"unknown value is stripped", "backend", "?backend=beta&page=2", 0, "monolith", "page=2"},
+		{"custom key", "route", "?route=new", 0, "movies-service", ""},
+		{"disabled", "", "?backend=new", 0, "monolith", "backend=new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotQuery string
+			record := func(name string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					gotQuery = r.URL.RawQuery
+					named(name)(w, r)
+				}
+			}
+			s := newTestProxy(t, record("monolith"), record("movies-service"))
+			s.gradualMigration, s.migrationPercent = true, tt.percent
+			s.queryRoutingKey = tt.key
+
+			rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/movies"+tt.query, nil))
+			if got := rec.Header().Get("X-Backend"); got != tt.want {
+				t.Fatalf("routed to %q, want %q", got, tt.want)
+			}
+			if gotQuery != tt.wantQuery {
+				t.Fatalf("upstream query = %q, want %q", gotQuery, tt.wantQuery)
+			}
+		})
+	}
+}
+
+func TestMethodRouting(t *testing.T) {
+	cfg := &fileConfig{Routes: []routeConfig{{
+		Prefix: "/api/movies",
+		Target: targetMovies,
+		Methods: map[string]string{
+			http.MethodGet:    targetMovies,
+			http.MethodPost:   targetMonolith,
+			http.MethodPut:    targetMonolith,
+			http.MethodDelete: targetMonolith,
+		},
+	}}}
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		percent int
+		want    string
+	}{
+		{"read at 0%", http.MethodGet, "/api/movies", 0, "movies-service"},
+		{"read at 100%", http.MethodGet, "/api/movies/7", 100, "movies-service"},
+		{"create at 100%", http.MethodPost, "/api/movies", 100, "monolith"},
+		{"update at 100%", http.MethodPut, "/api/movies/7", 100, "monolith"},
+		{"delete at 100%", http.MethodDelete, "/api/movies/7", 100, "monolith"},
+		{"unlisted method follows the split", http.MethodPatch, "/api/movies/7", 100, "movies-service"},
+		{"unlisted method at 0%", http.MethodPatch, "/api/movies/7", 0, "monolith"},
+		{"pinned read ignores the query override", http.MethodGet, "/api/movies?backend=old", 0, "movies-service"},
+		{"pinned write ignores the query override", http.MethodPost, "/api/movies?backend=new", 100, "monolith"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, fallback, err := buildRoutes(cfg, false, 0, targetMonolith)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.routes, s.defaultRoute = routes, fallback
+			s.gradualMigration, s.migrationPercent = true, tt.percent
+			s.queryRoutingKey = "backend"
+
+			if got := serve(s, httptest.NewRequest(tt.method, tt.target, nil)).Header().Get("X-Backend"); got != tt.want {
+				t.Fatalf("%s %s routed to %q, want %q", tt.method, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMethodRoutingConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		methods map[string]string
+	}{
+		{"lower-case method", map[string]string{"post": targetMonolith}},
+		{"unknown target", map[string]string{http.MethodPost: "payments"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &fileConfig{Routes: []routeConfig{{Prefix: "/api/movies", Target: targetMovies, Methods: tt.methods}}}
+			if _, _, err := buildRoutes(cfg, false, 0, targetMonolith); err == nil {
+				t.Error("invalid methods accepted")
+			}
+		})
+	}
+}
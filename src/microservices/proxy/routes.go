@@ -0,0 +1,57 @@
+package main
+
+import "net/http"
+
+// routeInfo describes one row of GET /proxy/routes.
+type routeInfo struct {
+	Order        int    `json:"order"`
+	Match        string `json:"match"`
+	Prefix       string `json:"prefix"`
+	Target       string `json:"target"`
+	PreserveHost bool   `json:"preserve_host"`
+	Default      bool   `json:"default,omitempty"`
+	// Methods lists per-method targets that override Target.
+	Methods   map[string]string `json:"methods,omitempty"`
+	TimeoutMS int64             `json:"timeout_ms,omitempty"`
+	// MigrationPercent is the share of the route's traffic currently sent to
+	// movies-service; it is only set for the movies target.
+	MigrationPercent *int `json:"migration_percent,omitempty"`
+}
+
+type routeTable struct {
+	Routes  []routeInfo       `json:"routes"`
+	Tenants map[string]string `json:"tenants,omitempty"`
+}
+
+func (s *proxyServer) routeTable() routeTable {
+	percent := 0
+	if s.gradualMigration {
+		percent = s.effectiveMigrationPercent()
+	}
+	info := func(i int, rt *route) routeInfo {
+		ri := routeInfo{Order: i, Match: "prefix", Prefix: rt.Prefix, Target: rt.Target, PreserveHost: rt.PreserveHost, Methods: rt.Methods, TimeoutMS: rt.Timeout.Milliseconds()}
+		if rt.Target == targetMovies {
+			ri.MigrationPercent = &percent
+		}
+		return ri
+	}
+
+	table := routeTable{Tenants: s.tenants}
+	for i, rt := range s.routes {
+		table.Routes = append(table.Routes, info(i+1, rt))
+	}
+	fallback := info(len(s.routes)+1, s.defaultRoute)
+	fallback.Default = true
+	table.Routes = append(table.Routes, fallback)
+	return table
+}
+
+// handleRoutes reports the routing table in match order: the first prefix
+// that matches wins, and the default route catches everything else.
+func (s *proxyServer) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, s.routeTable())
+}
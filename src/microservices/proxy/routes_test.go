@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleRoutes(t *testing.T) {
+	eventsTimeoutMS := 10000
+	cfg := &fileConfig{
+		Routes: []routeConfig{
+			{Prefix: "/api/movies", Target: targetMovies, Methods: map[string]string{http.MethodPost: targetMonolith}},
+			{Prefix: "/api/events", Target: targetEvents, TimeoutMS: &eventsTimeoutMS},
+			{Prefix: "/api/users", Target: targetMonolith},
+		},
+		Tenants: map[string]string{"acme": targetMovies},
+	}
+	tests := []struct {
+		name        string
+		gradual     bool
+		throttle    bool
+		wantPercent int
+	}{
+		{"migration disabled", false, false, 0},
+		{"configured percent", true, false, 60},
+		{"runtime throttled percent", true, true, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, fallback, err := buildRoutes(cfg, false, 2*time.Second, targetMonolith)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &proxyServer{routes: routes, defaultRoute: fallback, tenants: cfg.Tenants, gradualMigration: tt.gradual, migrationPercent: 60}
+			if tt.throttle {
+				s.throttle = newMigrationThrottle(60, 0, 30, 10*time.Millisecond, time.Minute)
+				s.throttle.observe(time.Second)
+				s.throttle.adjust(time.Now())
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/proxy/routes", nil)
+			r.Header.Set("Authorization", "Bearer secret")
+			rec := serve(requireAdmin("secret", s.handleRoutes), r)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var table routeTable
+			decodeJSON(t, rec, &table)
+
+			want := []routeInfo{
+				{Order: 1, Match: "prefix", Prefix: "/api/movies", Target: targetMovies, Methods: map[string]string{http.MethodPost: targetMonolith}, TimeoutMS: 2000},
+				{Order: 2, Match: "prefix", Prefix: "/api/events", Target: targetEvents, TimeoutMS: 10000},
+				{Order: 3, Match: "prefix", Prefix: "/api/users", Target: targetMonolith, TimeoutMS: 2000},
+				{Order: 4, Match: "prefix", Prefix: "/", Target: targetMonolith, Default: true, TimeoutMS: 2000},
+			}
+			if len(table.Routes) != len(want) {
+				t.Fatalf("got %d routes, want %d: %+v", len(table.Routes), len(want), table.Routes)
+			}
+			for i, got := range table.Routes {
+				w := want[i]
+				if got.Order != w.Order || got.Match != w.Match || got.Prefix != w.Prefix || got.Target != w.Target || got.Default != w.Default || got.TimeoutMS != w.TimeoutMS || len(got.Methods) != len(w.Methods) {
+					t.Errorf("route %d = %+v, want %+v", i, got, w)
+				}
+				for m, target := range w.Methods {
+					if got.Methods[m] != target {
+						t.Errorf("route %d %s target = %q, want %q", i, m, got.Methods[m], target)
+					}
+				}
+				switch {
+				case got.Target != targetMovies && got.MigrationPercent != nil:
+					t.Errorf("route %d has a migration percent for target %s", i, got.Target)
+				case got.Target == targetMovies && (got.MigrationPercent == nil || *got.MigrationPercent != tt.wantPercent):
+					t.Errorf("route %d migration percent = %v, want %d", i, got.MigrationPercent, tt.wantPercent)
+				}
+			}
+			if table.Tenants["acme"] != targetMovies {
+				t.Errorf("tenants = %v, want acme pinned to movies", table.Tenants)
+			}
+		})
+	}
+}
+
+func TestHandleRoutesRequiresAdmin(t *testing.T) {
+	s := newTestProxy(t, named("monolith"), named("movies-service"))
+	tests := []struct {
+		name       string
+		token      string
+		auth       string
+		method     string
+		wantStatus int
+	}{
+		{"admin disabled", "", "Bearer secret", http.MethodGet, http.StatusForbidden},
+		{"missing token", "secret", "", http.MethodGet, http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer nope", http.MethodGet, http.StatusUnauthorized},
+		{"wrong method", "secret", "Bearer secret", http.MethodPost, http.StatusMethodNotAllowed},
+		{"ok", "secret", "Bearer secret", http.MethodGet, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/proxy/routes", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			if rec := serve(requireAdmin(tt.token, s.handleRoutes), r); rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// routeDecision is where the proxy would send a request, and why.
+type routeDecision struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Route is the prefix of the matched route, "/" for the default route.
+	Route   string `json:"route"`
+	Target  string `json:"target"`
+	Backend string `json:"backend"`
+	Reason  string `json:"reason"`
+	User    string `json:"user,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+
+	MigrationPercent int `json:"migration_percent"`
+	// MigrationBucket is set when the request has a user; the user migrates
+	// while the bucket is below MigrationPercent.
+	MigrationBucket *int `json:"migration_bucket,omitempty"`
+}
+
+// decide runs r through the same steps as ServeHTTP and reports the outcome
+// without forwarding anything or touching routing state: the round-robin
+// position is not advanced and the flag service is not called. Chaos
+// injection is left out. A request without a user is spread round-robin over
+// the replicas of a pool, so the replica reported for it is the one the next
+// live request would reach.
+func (s *proxyServer) decide(r *http.Request) routeDecision {
+	r = s.jwt.attach(r)
+	rt := matchRoute(s.routes, r.URL.Path, s.defaultRoute)
+	d := routeDecision{
+		Method:           r.Method,
+		Path:             r.URL.Path,
+		Route:            rt.Prefix,
+		User:             requestUser(r),
+		Tenant:           requestTenant(r),
+		MigrationPercent: s.effectiveMigrationPercent(),
+	}
+	if d.User != "" {
+		bucket := migrationBucket(d.User)
+		d.MigrationBucket = &bucket
+	}
+
+	target, pinned := rt.targetFor(r.Method)
+	var b *backend
+	switch target {
+	case targetMovies:
+		if s.movies.isDisabled() {
+			b, d.Reason = s.monolith, s.movies.name+" disabled"
+			break
+		}
+		r, forced, why := s.moviesOverride(r, pinned)
+		b, d.Reason = s.moviesChoice(r, forced, true)
+		switch {
+		case why != "":
+			d.Reason = why
+		case d.Reason == "" && s.gradualMigration:
+			d.Reason = "migration"
+		case d.Reason == "":
+			d.Reason = "gradual migration disabled"
+		}
+	case targetEvents:
+		b, d.Reason = s.events.peek(r), "route"
+	default:
+		b, d.Reason = s.monolith, "route"
+	}
+	if rt == s.defaultRoute && d.Reason == "route" {
+		d.Reason = "default route"
+	}
+
+	d.Backend = b.name
+	switch {
+	case b == s.monolith:
+		d.Target = targetMonolith
+	case target == targetEvents:
+		d.Target = targetEvents
+	default:
+		d.Target = targetMovies
+	}
+	return d
+}
+
+// handleRouteTest serves GET /proxy/route-test?path=/api/movies&user_id=123.
+// The optional method (GET by default), tenant, route_token and jwt
+// parameters stand in for the request method, X-Tenant-ID, X-Route-Token
+// and the bearer token. A query routing override goes inside path, as in
+// path=/api/movies%3Fbackend%3Dnew.
+func (s *proxyServer) handleRouteTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	path := q.Get("path")
+	if !strings.HasPrefix(path, "/") {
+		http.Error(w, "path must start with /", http.StatusBadRequest)
+		return
+	}
+	method := strings.ToUpper(q.Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(r.Context(), method, path, nil)
+	if err != nil {
+		http.Error(w, "invalid path or method: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for param, header := range map[string]string{"user_id": "X-User-ID", "tenant": "X-Tenant-ID", "route_token": routeTokenHeader} {
+		if v := q.Get(param); v != "" {
+			req.Header.Set(header, v)
+		}
+	}
+	if token := q.Get("jwt"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	writeJSON(w, r, http.StatusOK, s.decide(req))
+}
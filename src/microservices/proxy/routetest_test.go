@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// routeTestProxy routes half the users to a three-replica movies pool, pins
+// POST to movies, pins tenant acme to movies and honours ?backend=.
+func routeTestProxy(t *testing.T) *proxyServer {
+	s := newTestProxy(t, named("monolith"), nil)
+	s.movies = testPool("movies-service", named("movies-service@0"), named("movies-service@1"), named("movies-service@2"))
+	s.gradualMigration = true
+	s.migrationPercent = 50
+	s.queryRoutingKey = "backend"
+	s.tenants = map[string]string{"acme": targetMovies}
+	s.routes[0].Methods = map[string]string{http.MethodPost: targetMovies}
+	return s
+}
+
+// usersBy returns one user the 50% split migrates and one it keeps.
+func usersBy() (migrated, kept string) {
+	for i := 0; migrated == "" || kept == ""; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		if migratesUser(user, 50) {
+			migrated = user
+		} else {
+			kept = user
+		}
+	}
+	return migrated, kept
+}
+
+func TestRouteTestMatchesRouting(t *testing.T) {
+	migrated, kept := usersBy()
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		user   string
+		tenant string
+		// percent overrides the 50% split; -1 stands for 0%.
+		percent int
+		reason  string
+	}{
+		{"migrated user", http.MethodGet, "/api/movies", migrated, "", 0, "migration"},
+		{"kept user", http.MethodGet, "/api/movies", kept, "", 0, "migration"},
+		{"no user, all migrated", http.MethodGet, "/api/movies", "", "", 100, "migration"},
+		{"no user, none migrated", http.MethodGet, "/api/movies", "", "", -1, "migration"},
+		{"override to movies", http.MethodGet, "/api/movies?backend=new", kept, "", 0, "query override"},
+		{"override to monolith", http.MethodGet, "/api/movies?backend=old", migrated, "", 0, "query override"},
+		{"tenant", http.MethodGet, "/api/movies", kept, "acme", 0, "tenant acme"},
+		{"method pinned", http.MethodPost, "/api/movies?backend=old", kept, "", 0, "method pinned"},
+		{"events", http.MethodGet, "/api/events", migrated, "", 0, "route"},
+		{"default route", http.MethodGet, "/api/users", migrated, "", 0, "default route"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := routeTestProxy(t)
+			switch {
+			case tt.percent > 0:
+				s.migrationPercent = tt.percent
+			case tt.percent < 0:
+				s.migrationPercent = 0
+			}
+			// Twice, so a round-robin pick shows up if decide advanced it.
+			for i := 0; i < 2; i++ {
+				q := url.Values{"path": {tt.path}, "method": {tt.method}, "user_id": {tt.user}, "tenant": {tt.tenant}}
+				rec := serve(http.HandlerFunc(s.handleRouteTest), httptest.NewRequest(http.MethodGet, "/proxy/route-test?"+q.Encode(), nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("route-test status = %d: %s", rec.Code, rec.Body.String())
+				}
+				var d routeDecision
+				decodeJSON(t, rec, &d)
+
+				r := httptest.NewRequest(tt.method, tt.path, nil)
+				if tt.user != "" {
+					r.Header.Set("X-User-ID", tt.user)
+				}
+				if tt.tenant != "" {
+					r.Header.Set("X-Tenant-ID", tt.tenant)
+				}
+				if got := serve(s, r).Header().Get("X-Backend"); got != d.Backend {
+					t.Fatalf("route-test said %s (%s), request went to %s", d.Backend, d.Reason, got)
+				}
+				if tt.reason != "migration" && d.Reason != tt.reason {
+					t.Fatalf("reason = %q, want %q", d.Reason, tt.reason)
+				}
+			}
+		})
+	}
+}
+
+func TestRouteTestFlagsRandomSplit(t *testing.T) {
+	migrated, _ := usersBy()
+	tests := []struct {
+		name    string
+		user    string
+		percent int
+		random  bool
+	}{
+		{"no user, partial split", "", 50, true},
+		{"no user, full split", "", 100, false},
+		{"no user, empty split", "", 0, false},
+		{"user, partial split", migrated, 50, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := routeTestProxy(t)
+			s.migrationPercent = tt.percent
+			r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+			if tt.user != "" {
+				r.Header.Set("X-User-ID", tt.user)
+			}
+			d := s.decide(r)
+			if got := strings.Contains(d.Reason, "random"); got != tt.random {
+				t.Fatalf("reason = %q, want random: %v", d.Reason, tt.random)
+			}
+		})
+	}
+}
+
+func TestRouteTestHasNoSideEffects(t *testing.T) {
+	var flagCalls atomic.Int32
+	flagService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flagCalls.Add(1)
+		w.Write([]byte(`{"enabled": true}`))
+	}))
+	defer flagService.Close()
+
+	s := routeTestProxy(t)
+	s.flags = newFlagClient(flagService.URL, "movies", time.Minute, time.Second)
+	before := s.movies.next.Load()
+
+	tests := []struct {
+		name       string
+		user       string
+		cached     bool
+		wantReason string
+	}{
+		{"uncached flag", "alice", false, "(feature flag not cached)"},
+		{"cached flag", "bob", true, "feature flag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+			req.Header.Set("X-User-ID", tt.user)
+			if tt.cached {
+				s.flags.evaluate(req)
+			}
+			calls := flagCalls.Load()
+			d := s.decide(req)
+			if flagCalls.Load() != calls {
+				t.Fatal("decide called the flag service")
+			}
+			if got := s.movies.next.Load(); got != before {
+				t.Fatalf("decide moved the round-robin position from %d to %d", before, got)
+			}
+			if !strings.Contains(d.Reason, tt.wantReason) {
+				t.Fatalf("reason = %q, want it to mention %q", d.Reason, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// routeTokenHeader carries a signed pin to movies-service. The value is
+// "<expiry>.<signature>" where expiry is a Unix timestamp in seconds and
+// signature is the hex HMAC-SHA256 of "<path>\n<expiry>" under the shared
+// secret, see signRouteToken.
+const routeTokenHeader = "X-Route-Token"
+
+type routeTokenVerifier struct {
+	secret []byte
+	now    func() time.Time
+}
+
+func newRouteTokenVerifier(secret string) *routeTokenVerifier {
+	return &routeTokenVerifier{secret: []byte(secret), now: time.Now}
+}
+
+func signRouteToken(secret []byte, path string, expiry int64) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expiry, 10)))
+	return strconv.FormatInt(expiry, 10) + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify reports whether r carries a valid, unexpired token for its path.
+// The header is removed either way so it never reaches an upstream. It is
+// safe to call on a nil verifier.
+func (v *routeTokenVerifier) verify(r *http.Request) bool {
+	if v == nil {
+		return false
+	}
+	token := r.Header.Get(routeTokenHeader)
+	if token == "" {
+		return false
+	}
+	r.Header.Del(routeTokenHeader)
+
+	expiryStr, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expiry, err := strconv.ParseInt(expiryStr, 10, 64)
+	if err != nil || v.now().Unix() > expiry {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(signRouteToken(v.secret, r.URL.Path, expiry)))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRouteTokenRouting(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Unix(1_700_000_000, 0)
+	future, past := now.Add(time.Minute).Unix(), now.Add(-time.Minute).Unix()
+	valid := signRouteToken(secret, "/api/movies", future)
+
+	tests := []struct {
+		name     string
+		token    string
+		method   string
+		query    string
+		pinned   bool
+		verifier bool
+		want     string
+	}{
+		{"valid", valid, http.MethodGet, "", false, true, "movies-service"},
+		{"expired", signRouteToken(secret, "/api/movies", past), http.MethodGet, "", false, true, "monolith"},
+		{"tampered signature", valid[:len(valid)-1] + "0", http.MethodGet, "", false, true, "monolith"},
+		{"tampered expiry", signRouteToken(secret, "/api/movies", future)[1:], http.MethodGet, "", false, true, "monolith"},
+		{"other secret", signRouteToken([]byte("other"), "/api/movies", future), http.MethodGet, "", false, true, "monolith"},
+		{"other path", signRouteToken(secret, "/api/movies/1", future), http.MethodGet, "", false, true, "monolith"},
+		{"malformed", "garbage", http.MethodGet, "", false, true, "monolith"},
+		{"absent", "", http.MethodGet, "", false, true, "monolith"},
+		{"method pinned", valid, http.MethodPost, "", true, true, "movies-service"},
+		{"query override", valid, http.MethodGet, "?backend=monolith", false, true, "monolith"},
+		{"tokens not configured", valid, http.MethodGet, "", false, false, "monolith"},
+		{"tokens not configured, method pinned", valid, http.MethodPost, "", true, false, "movies-service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var leaked []string
+			record := func(name string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					if v := r.Header.Get(routeTokenHeader); v != "" {
+						leaked = append(leaked, name)
+					}
+					named(name)(w, r)
+				}
+			}
+			s := newTestProxy(t, record("monolith"), record("movies-service"))
+			s.queryRoutingKey = "backend"
+			if tt.verifier {
+				s.routeTokens = &routeTokenVerifier{secret: secret, now: func() time.Time { return now }}
+			}
+			if tt.pinned {
+				s.routes[0].Methods = map[string]string{http.MethodPost: targetMovies}
+			}
+
+			r := httptest.NewRequest(tt.method, "/api/movies"+tt.query, nil)
+			if tt.token != "" {
+				r.Header.Set(routeTokenHeader, tt.token)
+			}
+			rec := serve(s, r)
+			if got := rec.Header().Get("X-Backend"); got != tt.want {
+				t.Fatalf("served by %q, want %q", got, tt.want)
+			}
+			if len(leaked) > 0 {
+				t.Fatalf("%s reached %v", routeTokenHeader, leaked)
+			}
+		})
+	}
+}
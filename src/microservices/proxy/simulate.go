@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+)
+
+const maxSimulationSamples = 1000000
+
+// migrationBucket places a user in one of 100 buckets; the user goes to the
+// movies service when the bucket is below the migration percentage, so
+// raising the percentage only ever moves users from the monolith, never back.
+// Requests without a user fall back to a random bucket.
+func migrationBucket(user string) int {
+	if user == "" {
+		return rand.Intn(100)
+	}
+	return int(hashKey("migration:"+user) % 100)
+}
+
+func migratesUser(user string, percent int) bool {
+	return migrationBucket(user) < percent
+}
+
+type simulation struct {
+	Percent       int     `json:"percent"`
+	Samples       int     `json:"samples"`
+	Movies        int     `json:"movies"`
+	Monolith      int     `json:"monolith"`
+	MoviesPercent float64 `json:"movies_percent"`
+}
+
+// simulateMigration runs user IDs 1..samples through the migration split at
+// percent.
+func simulateMigration(percent, samples int) simulation {
+	sim := simulation{Percent: percent, Samples: samples}
+	for id := 1; id <= samples; id++ {
+		if migratesUser(strconv.Itoa(id), percent) {
+			sim.Movies++
+		} else {
+			sim.Monolith++
+		}
+	}
+	if samples > 0 {
+		sim.MoviesPercent = float64(sim.Movies) * 100 / float64(samples)
+	}
+	return sim
+}
+
+// handleSimulate serves GET /proxy/simulate?percent=30&samples=10000. percent
+// defaults to the current effective percentage. Tenant pins and the feature
+// flag are not taken into account.
+func (s *proxyServer) handleSimulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	percent := s.effectiveMigrationPercent()
+	if v := q.Get("percent"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 100 {
+			http.Error(w, "percent must be between 0 and 100", http.StatusBadRequest)
+			return
+		}
+		percent = n
+	}
+	samples := 10000
+	if v := q.Get("samples"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxSimulationSamples {
+			http.Error(w, "samples must be between 1 and "+strconv.Itoa(maxSimulationSamples), http.StatusBadRequest)
+			return
+		}
+		samples = n
+	}
+	writeJSON(w, r, http.StatusOK, simulateMigration(percent, samples))
+}
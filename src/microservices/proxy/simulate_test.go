@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestSimulateMatchesRouting(t *testing.T) {
+	const samples = 500
+	for _, percent := range []int{0, 10, 30, 75, 100} {
+		t.Run(strconv.Itoa(percent), func(t *testing.T) {
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.gradualMigration, s.migrationPercent = true, 50
+
+			rec := serve(http.HandlerFunc(s.handleSimulate), httptest.NewRequest(http.MethodGet, "/proxy/simulate?percent="+strconv.Itoa(percent)+"&samples="+strconv.Itoa(samples), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var sim simulation
+			decodeJSON(t, rec, &sim)
+			if sim.Percent != percent || sim.Samples != samples || sim.Movies+sim.Monolith != samples {
+				t.Fatalf("simulation = %+v", sim)
+			}
+
+			// Route the same users for real at that percentage.
+			s.migrationPercent = percent
+			movies := 0
+			for id := 1; id <= samples; id++ {
+				r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+				r.Header.Set("X-User-ID", strconv.Itoa(id))
+				if serve(s, r).Header().Get("X-Backend") == "movies-service" {
+					movies++
+				}
+			}
+			if movies != sim.Movies {
+				t.Fatalf("routed %d users to movies-service, simulation predicted %d", movies, sim.Movies)
+			}
+			if want := float64(sim.Movies) * 100 / samples; sim.MoviesPercent != want {
+				t.Errorf("movies_percent = %v, want %v", sim.MoviesPercent, want)
+			}
+		})
+	}
+}
+
+func TestMigrationIsMonotonic(t *testing.T) {
+	for id := 1; id <= 1000; id++ {
+		user := strconv.Itoa(id)
+		migrated := false
+		for percent := 0; percent <= 100; percent += 5 {
+			now := migratesUser(user, percent)
+			if migrated && !now {
+				t.Fatalf("user %s moved back to the monolith at %d%%", user, percent)
+			}
+			migrated = now
+		}
+		if !migrated {
+			t.Fatalf("user %s not migrated at 100%%", user)
+		}
+	}
+}
+
+func TestHandleSimulateParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		query       string
+		wantStatus  int
+		wantPercent int
+		wantSamples int
+	}{
+		{"defaults to the effective percentage", http.MethodGet, "", http.StatusOK, 40, 10000},
+		{"explicit", http.MethodGet, "?percent=5&samples=20", http.StatusOK, 5, 20},
+		{"percent out of range", http.MethodGet, "?percent=101", http.StatusBadRequest, 0, 0},
+		{"percent not a number", http.MethodGet, "?percent=half", http.StatusBadRequest, 0, 0},
+		{"no samples", http.MethodGet, "?samples=0", http.StatusBadRequest, 0, 0},
+		{"too many samples", http.MethodGet, "?samples=" + strconv.Itoa(maxSimulationSamples+1), http.StatusBadRequest, 0, 0},
+		{"POST", http.MethodPost, "", http.StatusMethodNotAllowed, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			s.gradualMigration, s.migrationPercent = true, 40
+			rec := serve(http.HandlerFunc(s.handleSimulate), httptest.NewRequest(tt.method, "/proxy/simulate"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var sim simulation
+			decodeJSON(t, rec, &sim)
+			if sim.Percent != tt.wantPercent || sim.Samples != tt.wantSamples {
+				t.Errorf("simulation = %+v", sim)
+			}
+		})
+	}
+}
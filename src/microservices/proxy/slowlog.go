@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// logSlowRequests wraps a backend handler and logs a warning for every
+// request that takes longer than threshold, whatever its outcome.
+func logSlowRequests(name string, threshold time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if elapsed := time.Since(start); elapsed > threshold {
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			log.Printf("WARNING: slow request to %s: %s %s took %dms (status %d, threshold %dms)",
+				name, r.Method, r.URL.Path, elapsed.Milliseconds(), rec.status, threshold.Milliseconds())
+		}
+	})
+}
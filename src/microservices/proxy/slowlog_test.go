@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogSlowRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		delay   time.Duration
+		status  int
+		wantLog string
+	}{
+		{"fast request not logged", 0, http.StatusOK, ""},
+		{"slow request", 30 * time.Millisecond, 0, "WARNING: slow request to movies-service: GET /api/movies took "},
+		{"slow failure", 30 * time.Millisecond, http.StatusBadGateway, "(status 502, threshold 20ms)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			log.SetOutput(&out)
+			defer log.SetOutput(io.Discard)
+
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				w.Write([]byte("movies"))
+			})
+			rec := serve(logSlowRequests("movies-service", 20*time.Millisecond, upstream), httptest.NewRequest(http.MethodGet, "/api/movies", nil))
+			if rec.Body.String() != "movies" {
+				t.Fatalf("body = %q, want it passed through", rec.Body.String())
+			}
+
+			if tt.wantLog == "" {
+				if out.Len() != 0 {
+					t.Fatalf("logged %q, want nothing", out.String())
+				}
+				return
+			}
+			if !strings.Contains(out.String(), tt.wantLog) {
+				t.Fatalf("logged %q, want %q", out.String(), tt.wantLog)
+			}
+			if tt.status == 0 && !strings.Contains(out.String(), "(status 200,") {
+				t.Errorf("logged %q, want an implicit 200", out.String())
+			}
+		})
+	}
+}
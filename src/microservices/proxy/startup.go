@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// effectiveConfig records every setting read through getEnv with the value
+// in effect, default included, for the startup summary.
+var effectiveConfig = struct {
+	mu     sync.Mutex
+	values map[string]string
+}{values: make(map[string]string)}
+
+func recordSetting(key, value string) {
+	effectiveConfig.mu.Lock()
+	effectiveConfig.values[key] = value
+	effectiveConfig.mu.Unlock()
+}
+
+// redactSetting hides credentials: the value of any key naming a token,
+// secret or password, and the password of a URL with user info.
+func redactSetting(key, value string) string {
+	if value == "" {
+		return value
+	}
+	for _, word := range []string{"TOKEN", "SECRET", "PASSWORD"} {
+		if strings.Contains(key, word) {
+			return "[redacted]"
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+type startupSummary struct {
+	Service  string            `json:"service"`
+	Listen   string            `json:"listen"`
+	Features []string          `json:"features"`
+	Routes   []string          `json:"routes"`
+	Config   map[string]string `json:"config"`
+}
+
+// features names the optional behaviour switched on by the configuration.
+func (s *proxyServer) features() []string {
+	features := []string{}
+	add := func(name string, on bool) {
+		if on {
+			features = append(features, name)
+		}
+	}
+	add("gradual-migration", s.gradualMigration)
+	add("adaptive-migration", s.throttle != nil)
+	add("feature-flags", s.flags != nil)
+	add("query-routing", s.queryRoutingKey != "")
+	add("route-tokens", s.routeTokens != nil)
+	add("jwt-claims", s.jwt != nil)
+	add("access-policy", s.access != nil)
+	add("request-header-policy", s.filtersRequestHeaders())
+	add("coalescing", s.coalesce != nil)
+	add("response-cache", s.cache != nil)
+	add("capture", s.capture != nil)
+	add("chaos", s.chaos != nil)
+	add("idempotency-keys", getEnv("IDEMPOTENCY_TTL_SECONDS", "0") != "0")
+	add("active-health-checks", getEnv("HEALTH_CHECK_INTERVAL_MS", "5000") != "0")
+	add("grpc-health", getEnv("GRPC_HEALTH_PORT", "") != "")
+	add("shutdown-signal-file", getEnv("SHUTDOWN_SIGNAL_FILE", "") != "")
+	return features
+}
+
+// routeLines describes the routing table in match order.
+func (s *proxyServer) routeLines() []string {
+	var lines []string
+	for _, rt := range append(append([]*route{}, s.routes...), s.defaultRoute) {
+		lines = append(lines, fmt.Sprintf("%s -> %s (preserve host: %v, timeout: %s)", rt.Prefix, rt.Target, rt.PreserveHost, rt.Timeout))
+		methods := make([]string, 0, len(rt.Methods))
+		for method := range rt.Methods {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			lines = append(lines, fmt.Sprintf("%s %s -> %s", method, rt.Prefix, rt.Methods[method]))
+		}
+	}
+	return lines
+}
+
+// logStartupSummary logs the effective configuration as one JSON line.
+func (s *proxyServer) logStartupSummary(listen string) {
+	summary := startupSummary{Service: "proxy-service", Listen: listen, Features: s.features(), Routes: s.routeLines()}
+	effectiveConfig.mu.Lock()
+	summary.Config = make(map[string]string, len(effectiveConfig.values))
+	for key, value := range effectiveConfig.values {
+		summary.Config[key] = redactSetting(key, value)
+	}
+	effectiveConfig.mu.Unlock()
+
+	var line strings.Builder
+	enc := json.NewEncoder(&line)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(summary); err != nil {
+		log.Printf("Failed to encode startup summary: %v", err)
+		return
+	}
+	log.Printf("[STARTUP] %s", strings.TrimSpace(line.String()))
+}
+
+// listenAndServe is srv.ListenAndServe with a callback once the listener is
+// bound, so "ready" is only logged when connections can be accepted.
+func listenAndServe(srv *http.Server, ready func(net.Addr)) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	ready(ln.Addr())
+	return srv.Serve(ln)
+}
+
+func (s *proxyServer) filtersRequestHeaders() bool {
+	for _, rt := range s.routes {
+		if !rt.RequestHeaders.empty() {
+			return true
+		}
+	}
+	return !s.defaultRoute.RequestHeaders.empty()
+}
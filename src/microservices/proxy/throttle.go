@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxLatencySamples bounds the memory used by the latency window under heavy
+// traffic; the oldest samples are dropped first.
+const maxLatencySamples = 2000
+
+type latencySample struct {
+	at time.Time
+	d  time.Duration
+}
+
+// migrationThrottle lowers the effective migration percentage while
+// movies-service p95 latency is above threshold and raises it back towards
+// the configured value once latency falls below recover. Samples older than
+// window are ignored, so a throttle that reached min and stopped sending
+// traffic recovers once the old slow samples age out.
+type migrationThrottle struct {
+	configured int
+	min        int
+	step       int
+	threshold  time.Duration
+	recover    time.Duration
+	window     time.Duration
+	// degraded, when set, reports a high movies-service error rate, which
+	// throttles the migration the same way high latency does.
+	degraded func() bool
+
+	mu        sync.Mutex
+	samples   []latencySample
+	effective int
+	p95       time.Duration
+}
+
+func newMigrationThrottle(configured, min, step int, threshold, window time.Duration) *migrationThrottle {
+	if min > configured {
+		min = configured
+	}
+	t := &migrationThrottle{
+		configured: configured,
+		min:        min,
+		step:       step,
+		threshold:  threshold,
+		recover:    threshold * 8 / 10,
+		window:     window,
+		effective:  configured,
+	}
+	migrationEffectivePercent.Set(float64(configured))
+	return t
+}
+
+func (t *migrationThrottle) observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples = append(t.samples, latencySample{at: time.Now(), d: d})
+	if len(t.samples) > maxLatencySamples {
+		t.samples = t.samples[len(t.samples)-maxLatencySamples:]
+	}
+}
+
+// timed wraps a movies-service handler so every response feeds the window.
+func (t *migrationThrottle) timed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		t.observe(time.Since(start))
+	})
+}
+
+func (t *migrationThrottle) percent() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.effective
+}
+
+// adjust recomputes p95 over the window and moves the effective percentage
+// by at most one step.
+func (t *migrationThrottle) adjust(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := now.Add(-t.window)
+	i := sort.Search(len(t.samples), func(i int) bool { return !t.samples[i].at.Before(cutoff) })
+	t.samples = t.samples[i:]
+	t.p95 = p95(t.samples)
+	moviesLatencyP95.Set(t.p95.Seconds())
+
+	previous := t.effective
+	switch {
+	case t.p95 > t.threshold || (t.degraded != nil && t.degraded()):
+		t.effective = max(t.min, t.effective-t.step)
+	case t.p95 < t.recover:
+		t.effective = min(t.configured, t.effective+t.step)
+	}
+	if t.effective != previous {
+		log.Printf("Movies-service p95 latency %s (threshold %s), degraded %v: migration %d%% -> %d%%", t.p95, t.threshold, t.degraded != nil && t.degraded(), previous, t.effective)
+		migrationEffectivePercent.Set(float64(t.effective))
+	}
+}
+
+func p95(samples []latencySample) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	ds := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		ds[i] = s.d
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[(len(ds)*95+99)/100-1]
+}
+
+func (t *migrationThrottle) start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				t.adjust(now)
+			}
+		}
+	}()
+}
+
+type migrationStatus struct {
+	Enabled           bool  `json:"enabled"`
+	Adaptive          bool  `json:"adaptive"`
+	ConfiguredPercent int   `json:"configured_percent"`
+	EffectivePercent  int   `json:"effective_percent"`
+	MinPercent        int   `json:"min_percent,omitempty"`
+	P95MS             int64 `json:"p95_ms,omitempty"`
+	ThresholdMS       int64 `json:"threshold_ms,omitempty"`
+	// FeatureFlag is set when a flag service overrides the percentage.
+	FeatureFlag string `json:"feature_flag,omitempty"`
+}
+
+func (s *proxyServer) handleMigration(w http.ResponseWriter, r *http.Request) {
+	status := migrationStatus{
+		Enabled:           s.gradualMigration,
+		ConfiguredPercent: s.migrationPercent,
+		EffectivePercent:  s.effectiveMigrationPercent(),
+	}
+	if s.flags != nil {
+		status.FeatureFlag = s.flags.flag
+	}
+	if t := s.throttle; t != nil {
+		t.mu.Lock()
+		status.Adaptive = true
+		status.MinPercent = t.min
+		status.P95MS = t.p95.Milliseconds()
+		status.ThresholdMS = t.threshold.Milliseconds()
+		t.mu.Unlock()
+	}
+	writeJSON(w, r, http.StatusOK, status)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMigrationThrottle(t *testing.T) {
+	const threshold = 100 * time.Millisecond
+	slow, fast, borderline := 300*time.Millisecond, 10*time.Millisecond, 90*time.Millisecond
+	tests := []struct {
+		name     string
+		min      int
+		degraded bool
+		// rounds are the latencies observed before each adjustment.
+		rounds [][]time.Duration
+		want   []int
+	}{
+		{"fast stays at configured", 0, false, [][]time.Duration{{fast, fast}, {fast}}, []int{80, 80}},
+		{"slow steps down", 0, false, [][]time.Duration{{slow}, {slow}, {slow}}, []int{60, 40, 20}},
+		{"bounded by min", 50, false, [][]time.Duration{{slow}, {slow}, {slow}}, []int{60, 50, 50}},
+		{"recovers one step at a time", 0, false, [][]time.Duration{{slow}, {slow}, {}, {}, {}}, []int{60, 40, 60, 80, 80}},
+		{"holds between recover and threshold", 0, false, [][]time.Duration{{slow}, {borderline}}, []int{60, 60}},
+		{"p95 ignores rare outliers", 0, false, [][]time.Duration{append(repeat(fast, 99), slow)}, []int{80}},
+		{"degraded throttles despite fast responses", 0, true, [][]time.Duration{{fast}, {fast}}, []int{60, 40}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newMigrationThrottle(80, tt.min, 20, threshold, time.Hour)
+			if tt.degraded {
+				th.degraded = func() bool { return true }
+			}
+			for i, round := range tt.rounds {
+				// Each round is judged on its own samples only.
+				th.mu.Lock()
+				th.samples = nil
+				th.mu.Unlock()
+				for _, d := range round {
+					th.observe(d)
+				}
+				th.adjust(time.Now())
+				if got := th.percent(); got != tt.want[i] {
+					t.Fatalf("round %d: effective = %d%%, want %d%%", i, got, tt.want[i])
+				}
+				if got := testutil.ToFloat64(migrationEffectivePercent); got != float64(tt.want[i]) {
+					t.Fatalf("round %d: metric = %v, want %d", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func repeat(d time.Duration, n int) []time.Duration {
+	ds := make([]time.Duration, n)
+	for i := range ds {
+		ds[i] = d
+	}
+	return ds
+}
+
+func TestMigrationThrottleWindow(t *testing.T) {
+	th := newMigrationThrottle(50, 0, 25, 100*time.Millisecond, time.Minute)
+	th.observe(time.Second)
+	th.adjust(time.Now())
+	if got := th.percent(); got != 25 {
+		t.Fatalf("effective = %d%%, want 25%%", got)
+	}
+	// With no traffic the slow sample ages out of the window and the
+	// percentage recovers.
+	th.adjust(time.Now().Add(2 * time.Minute))
+	if got := th.percent(); got != 50 {
+		t.Fatalf("effective after the window = %d%%, want 50%%", got)
+	}
+}
+
+func TestHandleMigrationAdaptive(t *testing.T) {
+	s := newTestProxy(t, named("monolith"), named("movies-service"))
+	s.gradualMigration, s.migrationPercent = true, 80
+	s.throttle = newMigrationThrottle(80, 10, 30, 5*time.Millisecond, time.Minute)
+	slowMovies := s.throttle.timed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	serve(slowMovies, httptest.NewRequest(http.MethodGet, "/api/movies", nil))
+	s.throttle.adjust(time.Now())
+
+	var status migrationStatus
+	decodeJSON(t, serve(http.HandlerFunc(s.handleMigration), httptest.NewRequest(http.MethodGet, "/proxy/migration", nil)), &status)
+	if status.P95MS < 20 {
+		t.Fatalf("p95 = %dms, want at least 20ms", status.P95MS)
+	}
+	status.P95MS = 0
+	want := migrationStatus{Enabled: true, Adaptive: true, ConfiguredPercent: 80, EffectivePercent: 50, MinPercent: 10, ThresholdMS: 5}
+	if status != want {
+		t.Fatalf("status = %+v, want %+v", status, want)
+	}
+}
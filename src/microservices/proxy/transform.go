@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// bodyTransform rewrites a JSON response body.
+type bodyTransform func(body []byte) ([]byte, error)
+
+// responseTransforms are the transforms selectable with
+// MOVIES_RESPONSE_TRANSFORM for movies-service responses.
+var responseTransforms = map[string]bodyTransform{
+	"monolith": normalizeMoviesToMonolith,
+}
+
+// movieFieldAliases maps alternative movies-service field names onto the
+// monolith's contract.
+var movieFieldAliases = map[string]string{
+	"movie_id": "id",
+	"name":     "title",
+}
+
+// normalizeMoviesToMonolith reshapes movies-service payloads into the
+// monolith's Movie contract: envelopes such as {"data": [...]} are unwrapped,
+// aliased fields are renamed and missing fields get the monolith's defaults.
+func normalizeMoviesToMonolith(body []byte) ([]byte, error) {
+	var payload interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	if obj, ok := payload.(map[string]interface{}); ok {
+		for _, key := range []string{"data", "movies"} {
+			if inner, ok := obj[key]; ok {
+				payload = inner
+				break
+			}
+		}
+	}
+
+	switch v := payload.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if movie, ok := item.(map[string]interface{}); ok {
+				normalizeMovie(movie)
+			}
+		}
+	case map[string]interface{}:
+		normalizeMovie(v)
+	}
+	return json.Marshal(payload)
+}
+
+func normalizeMovie(movie map[string]interface{}) {
+	for from, to := range movieFieldAliases {
+		if value, ok := movie[from]; ok {
+			if _, exists := movie[to]; !exists {
+				movie[to] = value
+			}
+			delete(movie, from)
+		}
+	}
+	switch genres := movie["genres"].(type) {
+	case nil:
+		movie["genres"] = []interface{}{}
+	case string:
+		list := []interface{}{}
+		for _, g := range strings.Split(genres, ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				list = append(list, g)
+			}
+		}
+		movie["genres"] = list
+	}
+	if _, ok := movie["description"]; !ok {
+		movie["description"] = ""
+	}
+	if _, ok := movie["rating"]; !ok {
+		movie["rating"] = 0
+	}
+}
+
+func isJSONResponse(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// transformJSONResponse applies t to successful, uncompressed JSON responses.
+func transformJSONResponse(name string, t bodyTransform) responseModifier {
+	return func(resp *http.Response) error {
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 || !isJSONResponse(resp) {
+			return nil
+		}
+		if enc := resp.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+			return nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		out, err := t(body)
+		if err != nil {
+			return fmt.Errorf("%s response transform: %w", name, err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(out))
+		resp.ContentLength = int64(len(out))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(out)))
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeMoviesToMonolith(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			"already monolith shaped",
+			`[{"id":1,"title":"Up","description":"d","genres":["animation"],"rating":8.2}]`,
+			`[{"description":"d","genres":["animation"],"id":1,"rating":8.2,"title":"Up"}]`,
+		},
+		{
+			"data envelope and aliases",
+			`{"data":[{"movie_id":7,"name":"Heat"}]}`,
+			`[{"description":"","genres":[],"id":7,"rating":0,"title":"Heat"}]`,
+		},
+		{
+			"movies envelope",
+			`{"movies":[{"id":2,"title":"Alien"}]}`,
+			`[{"description":"","genres":[],"id":2,"rating":0,"title":"Alien"}]`,
+		},
+		{
+			"single movie with genre string",
+			`{"id":3,"title":"Jaws","genres":"thriller, horror,"}`,
+			`{"description":"","genres":["thriller","horror"],"id":3,"rating":0,"title":"Jaws"}`,
+		},
+		{
+			"alias does not override canonical field",
+			`{"id":4,"movie_id":40,"title":"Fargo","name":"Other"}`,
+			`{"description":"","genres":[],"id":4,"rating":0,"title":"Fargo"}`,
+		},
+		{
+			"large ids keep precision",
+			`[{"id":9007199254740993,"title":"Big"}]`,
+			`[{"description":"","genres":[],"id":9007199254740993,"rating":0,"title":"Big"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeMoviesToMonolith([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformJSONResponse(t *testing.T) {
+	const in = `{"data":[{"movie_id":1,"name":"Up"}]}`
+	const normalized = `[{"description":"","genres":[],"id":1,"rating":0,"title":"Up"}]`
+	tests := []struct {
+		name    string
+		status  int
+		header  http.Header
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"json", http.StatusOK, http.Header{"Content-Type": {"application/json; charset=utf-8"}}, in, normalized, false},
+		{"json suffix", http.StatusOK, http.Header{"Content-Type": {"application/vnd.movies+json"}}, in, normalized, false},
+		{"identity encoding", http.StatusOK, http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"identity"}}, in, normalized, false},
+		{"error status", http.StatusNotFound, http.Header{"Content-Type": {"application/json"}}, in, in, false},
+		{"not json", http.StatusOK, http.Header{"Content-Type": {"text/plain"}}, in, in, false},
+		{"compressed", http.StatusOK, http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}}, in, in, false},
+		{"invalid json", http.StatusOK, http.Header{"Content-Type": {"application/json"}}, `{"data":`, "", true},
+	}
+	modify := transformJSONResponse("monolith", normalizeMoviesToMonolith)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: tt.header, Body: io.NopCloser(strings.NewReader(tt.body))}
+			err := modify(resp)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.want {
+				t.Errorf("body = %s, want %s", body, tt.want)
+			}
+			if tt.want != tt.body && resp.ContentLength != int64(len(tt.want)) {
+				t.Errorf("ContentLength = %d, want %d", resp.ContentLength, len(tt.want))
+			}
+		})
+	}
+}
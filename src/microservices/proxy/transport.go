@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// transportConfig sizes the connection pool of each upstream transport.
+// net/http keeps only two idle connections per host by default, which
+// serializes most of the traffic to a busy backend through fresh dials.
+type transportConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost caps dialing, active and idle connections; 0 means no limit.
+	MaxConnsPerHost int
+	IdleConnTimeout time.Duration
+	// RefusedRetries is how many times a request whose connection was
+	// refused is retried, see refusedRetryTransport; 0 disables it.
+	RefusedRetries int
+	RefusedBackoff time.Duration
+}
+
+// newTransport returns a copy of http.DefaultTransport, keeping its dial and
+// TLS timeouts, with the pool limits from cfg.
+func newTransport(cfg transportConfig) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = cfg.MaxIdleConns
+	t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	t.MaxConnsPerHost = cfg.MaxConnsPerHost
+	t.IdleConnTimeout = cfg.IdleConnTimeout
+	if cfg.RefusedRetries > 0 {
+		return &refusedRetryTransport{next: t, retries: cfg.RefusedRetries, backoff: cfg.RefusedBackoff}
+	}
+	return t
+}
+
+// safeRetryHeader lets a client declare a non-idempotent request safe to
+// retry when the upstream refused the connection.
+const safeRetryHeader = "X-Safe-Retry"
+
+// refusedRetryTransport retries requests that failed with ECONNREFUSED, as
+// happens for a moment while a backend restarts during a rolling deploy. A
+// refused connection means nothing reached the upstream, but only idempotent
+// methods are retried unless the client sent X-Safe-Retry: true. Other
+// errors are returned as is.
+type refusedRetryTransport struct {
+	next    http.RoundTripper
+	retries int
+	backoff time.Duration
+}
+
+func retryableMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func (t *refusedRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	safe := req.Header.Get(safeRetryHeader) == "true"
+	if safe {
+		req = req.Clone(req.Context())
+		req.Header.Del(safeRetryHeader)
+	}
+	if !safe && !retryableMethod(req.Method) {
+		return t.next.RoundTrip(req)
+	}
+	// The transport closes the body on failure, so keep a copy to resend.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	}
+
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil || !errors.Is(err, syscall.ECONNREFUSED) || attempt >= t.retries {
+			return resp, err
+		}
+		log.Printf("Connection refused by %s for %s %s, retrying in %s", req.URL.Host, req.Method, req.URL.Path, backoff)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
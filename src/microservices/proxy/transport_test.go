@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewTransport(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  transportConfig
+	}{
+		{"zero values", transportConfig{}},
+		{"tuned", transportConfig{MaxIdleConns: 200, MaxIdleConnsPerHost: 64, MaxConnsPerHost: 128, IdleConnTimeout: 45 * time.Second}},
+		{"tuned with refused retries", transportConfig{MaxIdleConns: 10, MaxIdleConnsPerHost: 5, MaxConnsPerHost: 20, IdleConnTimeout: time.Second, RefusedRetries: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newTransport(tt.cfg)
+			if retry, ok := rt.(*refusedRetryTransport); ok {
+				if tt.cfg.RefusedRetries == 0 {
+					t.Fatal("refused retries wrapped with RefusedRetries 0")
+				}
+				rt = retry.next
+			}
+			tr, ok := rt.(*http.Transport)
+			if !ok {
+				t.Fatalf("transport is %T, want *http.Transport", rt)
+			}
+			got := transportConfig{
+				MaxIdleConns:        tr.MaxIdleConns,
+				MaxIdleConnsPerHost: tr.MaxIdleConnsPerHost,
+				MaxConnsPerHost:     tr.MaxConnsPerHost,
+				IdleConnTimeout:     tr.IdleConnTimeout,
+				RefusedRetries:      tt.cfg.RefusedRetries,
+			}
+			if got != tt.cfg {
+				t.Errorf("transport built with %+v, want %+v", got, tt.cfg)
+			}
+			if tr == http.DefaultTransport {
+				t.Error("http.DefaultTransport was modified instead of cloned")
+			}
+			if tr.TLSHandshakeTimeout != http.DefaultTransport.(*http.Transport).TLSHandshakeTimeout {
+				t.Error("default TLS handshake timeout not kept")
+			}
+		})
+	}
+}
+
+func TestBackendPoolTransports(t *testing.T) {
+	cfg := transportConfig{MaxIdleConns: 50, MaxIdleConnsPerHost: 25, MaxConnsPerHost: 60, IdleConnTimeout: 30 * time.Second}
+	urls := []*url.URL{{Scheme: "http", Host: "movies-1:8081"}, {Scheme: "http", Host: "movies-2:8081"}}
+	p := newBackendPool("movies-service", urls, 10, cfg)
+	seen := make(map[*http.Transport]bool)
+	for _, b := range p.members {
+		tr, ok := b.proxy.(*httputil.ReverseProxy).Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("%s transport is not an *http.Transport", b.name)
+		}
+		if tr.MaxIdleConnsPerHost != cfg.MaxIdleConnsPerHost || tr.MaxConnsPerHost != cfg.MaxConnsPerHost {
+			t.Errorf("%s pool limits = %d/%d, want %d/%d", b.name, tr.MaxIdleConnsPerHost, tr.MaxConnsPerHost, cfg.MaxIdleConnsPerHost, cfg.MaxConnsPerHost)
+		}
+		seen[tr] = true
+	}
+	if len(seen) != len(urls) {
+		t.Errorf("%d replicas share %d transports, want one each", len(urls), len(seen))
+	}
+}
+
+// refusingTransport fails the first refusals round trips with err and then
+// answers 200, recording the body and safe-retry header of every attempt.
+type refusingTransport struct {
+	refusals int
+	err      error
+	bodies   []string
+	safe     []string
+}
+
+func (f *refusingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+		req.Body.Close()
+	}
+	f.bodies = append(f.bodies, string(body))
+	f.safe = append(f.safe, req.Header.Get(safeRetryHeader))
+	if len(f.bodies) <= f.refusals {
+		return nil, f.err
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestRefusedRetryTransport(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	tests := []struct {
+		name      string
+		method    string
+		safe      bool
+		refusals  int
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"GET retried after a refusal", http.MethodGet, false, 1, refused, 2, false},
+		{"PUT retried with its body", http.MethodPut, false, 2, refused, 3, false},
+		{"POST not retried", http.MethodPost, false, 1, refused, 1, true},
+		{"POST with safe-retry header retried", http.MethodPost, true, 1, refused, 2, false},
+		{"retries capped", http.MethodGet, false, 5, refused, 3, true},
+		{"other errors not retried", http.MethodGet, false, 1, errors.New("connection reset"), 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &refusingTransport{refusals: tt.refusals, err: tt.err}
+			rt := &refusedRetryTransport{next: next, retries: 2, backoff: time.Millisecond}
+			req := httptest.NewRequest(tt.method, "http://monolith:8080/api/users", strings.NewReader(`{"name":"ann"}`))
+			req.RequestURI = ""
+			if tt.safe {
+				req.Header.Set(safeRetryHeader, "true")
+			}
+
+			resp, err := rt.RoundTrip(req)
+			if len(next.bodies) != tt.wantCalls {
+				t.Fatalf("attempts = %d, want %d", len(next.bodies), tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d", resp.StatusCode)
+			}
+			for i, body := range next.bodies {
+				if body != `{"name":"ann"}` {
+					t.Errorf("attempt %d sent body %q", i, body)
+				}
+				if next.safe[i] != "" {
+					t.Errorf("attempt %d forwarded %s", i, safeRetryHeader)
+				}
+			}
+		})
+	}
+}
+
+func TestRefusedRetryDuringRestart(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	// The backend comes back on the same address while the proxy retries.
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("back")) })}
+	defer srv.Close()
+	time.AfterFunc(30*time.Millisecond, func() {
+		if lis, err := net.Listen("tcp", addr); err == nil {
+			go srv.Serve(lis)
+		}
+	})
+
+	rt := newTransport(transportConfig{RefusedRetries: 4, RefusedBackoff: 20 * time.Millisecond})
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/api/users", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request failed across the restart: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "back" {
+		t.Fatalf("body = %q, want back", body)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// statusClientClosedRequest mirrors nginx's non-standard 499 code. The client
+// is already gone, so it only shows up in logs and metrics.
+const statusClientClosedRequest = 499
+
+type routeContextKey struct{}
+
+// withRoute attaches the matched route so the upstream Director can apply
+// per-route options.
+func withRoute(r *http.Request, rt *route) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), routeContextKey{}, rt))
+}
+
+func routeFrom(ctx context.Context) *route {
+	rt, _ := ctx.Value(routeContextKey{}).(*route)
+	return rt
+}
+
+// responseModifier adjusts an upstream response before it is copied to the
+// client. Modifiers run in order from the proxy's ModifyResponse hook.
+type responseModifier func(*http.Response) error
+
+// newUpstreamProxy builds a reverse proxy for a single backend. The outgoing
+// request inherits the incoming r.Context(), so a client disconnect cancels
+// the in-flight upstream call instead of letting it run to completion.
+func newUpstreamProxy(name string, target *url.URL, transport http.RoundTripper, modifiers ...responseModifier) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		rt := routeFrom(req.Context())
+		if rt == nil || !rt.PreserveHost {
+			req.Host = target.Host
+		}
+		if rt != nil {
+			rt.RequestHeaders.filter(req.Header)
+		}
+	}
+	if len(modifiers) > 0 {
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			for _, m := range modifiers {
+				if err := m(resp); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, context.Canceled) || errors.Is(r.Context().Err(), context.Canceled) {
+			log.Printf("Client cancelled request to %s: %s %s", name, r.Method, r.URL.Path)
+			w.WriteHeader(statusClientClosedRequest)
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			// The proxy's own route or priority timeout ran out, rather
+			// than the replica failing.
+			if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+				markLocal(r)
+			}
+			log.Printf("Upstream %s timed out for %s %s", name, r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		log.Printf("Upstream %s error for %s %s: %v", name, r.Method, r.URL.Path, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	return proxy
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestUpstreamCancellation(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		wantStatus int
+	}{
+		{"client disconnects", 0, statusClientClosedRequest},
+		{"deadline passes", 50 * time.Millisecond, http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started, cancelled := make(chan struct{}), make(chan struct{})
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-r.Context().Done():
+					close(cancelled)
+				case <-time.After(5 * time.Second):
+				}
+			}))
+			defer upstream.Close()
+			u, _ := url.Parse(upstream.URL)
+			proxy := newUpstreamProxy("movies-service", u, http.DefaultTransport)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.timeout > 0 {
+				ctx, cancel = context.WithTimeout(context.Background(), tt.timeout)
+			}
+			defer cancel()
+			rec := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/movies", nil).WithContext(ctx))
+				close(done)
+			}()
+			<-started
+			if tt.timeout == 0 {
+				cancel()
+			}
+			select {
+			case <-cancelled:
+			case <-time.After(2 * time.Second):
+				t.Fatal("upstream request was not cancelled")
+			}
+			<-done
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestUpstreamErrorStatus(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	u, _ := url.Parse(upstream.URL)
+	upstream.Close()
+
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want int
+	}{
+		{"unreachable", func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }, http.StatusBadGateway},
+		{"already expired", func() (context.Context, context.CancelFunc) {
+			return context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		}, http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			rec := serve(newUpstreamProxy("movies-service", u, http.DefaultTransport), httptest.NewRequest(http.MethodGet, "/api/movies", nil).WithContext(ctx))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
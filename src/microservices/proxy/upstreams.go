@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// upstreamInfo describes one row of GET /proxy/upstreams.
+type upstreamInfo struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Healthy  bool   `json:"healthy"`
+	Degraded bool   `json:"degraded"`
+	// Disabled is set while an operator has taken the backend out of
+	// rotation with /proxy/backend/<name>/disable.
+	Disabled bool `json:"disabled"`
+	// Breaker is the circuit breaker state of a replica that has one.
+	Breaker string `json:"breaker,omitempty"`
+}
+
+func (s *proxyServer) upstreams() []upstreamInfo {
+	now := time.Now()
+	info := func(b *backend, disabled bool) upstreamInfo {
+		u := upstreamInfo{Name: b.name, URL: b.url.String(), Healthy: b.isHealthy(), Degraded: b.isDegraded(), Disabled: disabled}
+		if b.breaker != nil {
+			u.Breaker = b.breaker.state(now)
+		}
+		return u
+	}
+	out := []upstreamInfo{info(s.monolith, false)}
+	for _, b := range s.movies.members {
+		out = append(out, info(b, s.movies.isDisabled()))
+	}
+	for _, b := range s.events.members {
+		out = append(out, info(b, false))
+	}
+	return out
+}
+
+func (s *proxyServer) handleUpstreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, s.upstreams())
+}
+
+// handleMoviesToggle serves POST /proxy/backend/movies/disable and /enable.
+// While disabled, every request that would reach movies-service goes to the
+// monolith instead, whatever the health checks, overrides or migration
+// percentage say.
+func (s *proxyServer) handleMoviesToggle(disable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.movies.disabled.Swap(disable) != disable {
+			state := "enabled"
+			if disable {
+				state = "disabled"
+			}
+			log.Printf("[ADMIN] Backend %s %s from %s", s.movies.name, state, ClientIP(r))
+		}
+		writeJSON(w, r, http.StatusOK, s.upstreams())
+	}
+}
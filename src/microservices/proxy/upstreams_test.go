@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// withURLs gives the test backends of s the URLs /proxy/upstreams reports.
+func withURLs(s *proxyServer) {
+	for _, b := range append([]*backend{s.monolith}, append(s.movies.members, s.events.members...)...) {
+		b.url = &url.URL{Scheme: "http", Host: b.name + ":8080"}
+	}
+}
+
+func TestMoviesToggle(t *testing.T) {
+	s := newTestProxy(t, named("monolith"), named("movies-service"))
+	s.gradualMigration, s.migrationPercent = true, 100
+	s.queryRoutingKey = "backend"
+	withURLs(s)
+	admin := http.NewServeMux()
+	admin.HandleFunc("/proxy/upstreams", requireAdmin("secret", s.handleUpstreams))
+	admin.HandleFunc("/proxy/backend/movies/disable", requireAdmin("secret", s.handleMoviesToggle(true)))
+	admin.HandleFunc("/proxy/backend/movies/enable", requireAdmin("secret", s.handleMoviesToggle(false)))
+	adminRequest := func(method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		return serve(admin, r)
+	}
+
+	steps := []struct {
+		name         string
+		toggle       string
+		wantBackend  string
+		wantOverride string
+		wantDisabled bool
+	}{
+		{"enabled", "", "movies-service", "movies-service", false},
+		{"disabled", "/proxy/backend/movies/disable", "monolith", "monolith", true},
+		{"disabled twice", "/proxy/backend/movies/disable", "monolith", "monolith", true},
+		{"enabled again", "/proxy/backend/movies/enable", "movies-service", "movies-service", false},
+	}
+	for _, step := range steps {
+		if step.toggle != "" {
+			rec := adminRequest(http.MethodPost, step.toggle)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: POST %s = %d", step.name, step.toggle, rec.Code)
+			}
+		}
+		if got := serve(s, httptest.NewRequest(http.MethodGet, "/api/movies", nil)).Header().Get("X-Backend"); got != step.wantBackend {
+			t.Errorf("%s: routed to %q, want %q", step.name, got, step.wantBackend)
+		}
+		if got := serve(s, httptest.NewRequest(http.MethodGet, "/api/movies?backend=new", nil)).Header().Get("X-Backend"); got != step.wantOverride {
+			t.Errorf("%s: query override routed to %q, want %q", step.name, got, step.wantOverride)
+		}
+
+		var upstreams []upstreamInfo
+		decodeJSON(t, adminRequest(http.MethodGet, "/proxy/upstreams"), &upstreams)
+		if len(upstreams) != 3 {
+			t.Fatalf("%s: upstreams = %+v", step.name, upstreams)
+		}
+		for _, u := range upstreams {
+			want := step.wantDisabled && u.Name == "movies-service"
+			if u.Disabled != want {
+				t.Errorf("%s: %s disabled = %v, want %v", step.name, u.Name, u.Disabled, want)
+			}
+		}
+	}
+}
+
+func TestMoviesToggleRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		wantStatus int
+	}{
+		{"admin POST", http.MethodPost, "secret", http.StatusOK},
+		{"GET", http.MethodGet, "secret", http.StatusMethodNotAllowed},
+		{"wrong token", http.MethodPost, "guess", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestProxy(t, named("monolith"), named("movies-service"))
+			withURLs(s)
+			r := httptest.NewRequest(tt.method, "/proxy/backend/movies/disable", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := serve(requireAdmin("secret", s.handleMoviesToggle(true)), r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got, want := s.movies.isDisabled(), tt.wantStatus == http.StatusOK; got != want {
+				t.Errorf("disabled = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// configProblems collects every invalid setting so a misconfigured
+// deployment is reported in one go rather than one restart per mistake.
+type configProblems []string
+
+func (p *configProblems) addf(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p *configProblems) url(key, value string) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		p.addf("%s: %q is not an absolute URL", key, value)
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		p.addf("%s: scheme must be http or https, got %q", key, u.Scheme)
+	}
+}
+
+func (p *configProblems) intRange(key, value string, min, max int) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		p.addf("%s: %q must be an integer between %d and %d", key, value, min, max)
+	}
+}
+
+func (p *configProblems) atLeast(key, value string, min int) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min {
+		p.addf("%s: %q must be an integer of at least %d", key, value, min)
+	}
+}
+
+func (p *configProblems) boolean(key string) {
+	if v := getEnv(key, "false"); v != "true" && v != "false" {
+		p.addf("%s: %q must be true or false", key, v)
+	}
+}
+
+// validateConfig checks the environment before anything is built. Values are
+// validated with the same defaults main applies, so an unset variable is
+// never a problem.
+func validateConfig() []string {
+	var p configProblems
+
+	p.intRange("PORT", getEnv("PORT", "8000"), 1, 65535)
+	p.url("MONOLITH_URL", getEnv("MONOLITH_URL", "http://localhost:8080"))
+	eventsURLs := getEnv("EVENTS_SERVICE_URLS", getEnv("EVENTS_SERVICE_URL", "http://localhost:8082"))
+	for _, raw := range strings.Split(eventsURLs, ",") {
+		p.url("EVENTS_SERVICE_URLS", strings.TrimSpace(raw))
+	}
+	moviesURLs := getEnv("MOVIES_SERVICE_URLS", getEnv("MOVIES_SERVICE_URL", "http://localhost:8081"))
+	for _, raw := range strings.Split(moviesURLs, ",") {
+		p.url("MOVIES_SERVICE_URLS", strings.TrimSpace(raw))
+	}
+
+	if grpcPort := getEnv("GRPC_HEALTH_PORT", ""); grpcPort != "" {
+		p.intRange("GRPC_HEALTH_PORT", grpcPort, 1, 65535)
+		p.atLeast("GRPC_HEALTH_INTERVAL_MS", getEnv("GRPC_HEALTH_INTERVAL_MS", "5000"), 1)
+	}
+
+	p.intRange("MOVIES_MIGRATION_PERCENT", getEnv("MOVIES_MIGRATION_PERCENT", "0"), 0, 100)
+	p.atLeast("HEALTH_PROBE_TIMEOUT_MS", getEnv("HEALTH_PROBE_TIMEOUT_MS", "2000"), 1)
+	p.atLeast("HEALTH_CHECK_INTERVAL_MS", getEnv("HEALTH_CHECK_INTERVAL_MS", "5000"), 0)
+	p.atLeast("HEALTH_CHECK_MAX_INTERVAL_MS", getEnv("HEALTH_CHECK_MAX_INTERVAL_MS", "60000"), 1)
+	p.atLeast("MOVIES_CACHE_TTL_SECONDS", getEnv("MOVIES_CACHE_TTL_SECONDS", "0"), 0)
+	p.atLeast("HASH_RING_VNODES", getEnv("HASH_RING_VNODES", "100"), 1)
+	for _, key := range []string{"UPSTREAM_MAX_IDLE_CONNS", "UPSTREAM_MAX_IDLE_CONNS_PER_HOST", "UPSTREAM_MAX_CONNS_PER_HOST"} {
+		p.atLeast(key, getEnv(key, "0"), 0)
+	}
+	p.atLeast("REFUSED_RETRY_MAX", getEnv("REFUSED_RETRY_MAX", "2"), 0)
+	p.atLeast("REFUSED_RETRY_BACKOFF_MS", getEnv("REFUSED_RETRY_BACKOFF_MS", "50"), 0)
+	p.atLeast("UPSTREAM_IDLE_CONN_TIMEOUT_SECONDS", getEnv("UPSTREAM_IDLE_CONN_TIMEOUT_SECONDS", "90"), 0)
+	p.atLeast("UPSTREAM_TIMEOUT_MS", getEnv("UPSTREAM_TIMEOUT_MS", "0"), 0)
+	p.atLeast("SHUTDOWN_GRACE_MS", getEnv("SHUTDOWN_GRACE_MS", "15000"), 0)
+	if jitter, err := strconv.ParseFloat(getEnv("HEALTH_CHECK_JITTER", "0.2"), 64); err != nil || jitter < 0 || jitter >= 1 {
+		p.addf("HEALTH_CHECK_JITTER: must be a number in [0, 1)")
+	}
+
+	if _, err := parseTrustedProxies(getEnv("TRUSTED_PROXIES", "")); err != nil {
+		p.addf("TRUSTED_PROXIES: %v", err)
+	}
+
+	for _, key := range []string{"GRADUAL_MIGRATION", "COALESCE_MOVIES_REQUESTS", "PRESERVE_HOST", "ALLOW_QUERY_ROUTING", "CHAOS_ENABLED", "ADAPTIVE_MIGRATION"} {
+		p.boolean(key)
+	}
+	if !validTarget(getEnv("DEFAULT_ROUTE_TARGET", targetMonolith)) {
+		p.addf("DEFAULT_ROUTE_TARGET: %q is not a known target", getEnv("DEFAULT_ROUTE_TARGET", targetMonolith))
+	}
+	if flagURL := getEnv("FLAG_SERVICE_URL", ""); flagURL != "" {
+		p.url("FLAG_SERVICE_URL", flagURL)
+		p.atLeast("FLAG_CACHE_TTL_MS", getEnv("FLAG_CACHE_TTL_MS", "5000"), 0)
+		p.atLeast("FLAG_SERVICE_TIMEOUT_MS", getEnv("FLAG_SERVICE_TIMEOUT_MS", "200"), 1)
+	}
+	for _, key := range []string{"MAX_INFLIGHT", "MAX_INFLIGHT_MONOLITH", "MAX_INFLIGHT_MOVIES_SERVICE", "MAX_INFLIGHT_EVENTS_SERVICE", "INFLIGHT_QUEUE_TIMEOUT_MS"} {
+		p.atLeast(key, getEnv(key, "0"), 0)
+	}
+	p.intRange("INFLIGHT_HIGH_PRIORITY_RESERVE_PERCENT", getEnv("INFLIGHT_HIGH_PRIORITY_RESERVE_PERCENT", "20"), 0, 100)
+	if prio := getEnv("DEFAULT_PRIORITY", "high"); prio != "high" && prio != "low" {
+		p.addf("DEFAULT_PRIORITY: %q must be high or low", prio)
+	}
+	p.atLeast("LOW_PRIORITY_TIMEOUT_MS", getEnv("LOW_PRIORITY_TIMEOUT_MS", "10000"), 0)
+	p.atLeast("INFLIGHT_RETRY_AFTER_SECONDS", getEnv("INFLIGHT_RETRY_AFTER_SECONDS", "1"), 0)
+	p.atLeast("IDEMPOTENCY_TTL_SECONDS", getEnv("IDEMPOTENCY_TTL_SECONDS", "0"), 0)
+	p.atLeast("IDEMPOTENCY_MAX_BODY_BYTES", getEnv("IDEMPOTENCY_MAX_BODY_BYTES", "1048576"), 0)
+	p.intRange("CAPTURE_PERCENT", getEnv("CAPTURE_PERCENT", "0"), 0, 100)
+	p.atLeast("CAPTURE_MAX_BODY_BYTES", getEnv("CAPTURE_MAX_BODY_BYTES", "65536"), 0)
+	p.intRange("RECORD_PERCENT", getEnv("RECORD_PERCENT", "0"), 0, 100)
+	p.atLeast("RECORD_MAX_BODY_BYTES", getEnv("RECORD_MAX_BODY_BYTES", "65536"), 0)
+	p.atLeast("RECORD_MAX_FILE_BYTES", getEnv("RECORD_MAX_FILE_BYTES", "104857600"), 0)
+	p.atLeast("ERROR_RATE_WINDOW_SECONDS", getEnv("ERROR_RATE_WINDOW_SECONDS", "60"), 1)
+	p.intRange("ERROR_RATE_THRESHOLD_PERCENT", getEnv("ERROR_RATE_THRESHOLD_PERCENT", "20"), 0, 100)
+	p.atLeast("ERROR_RATE_MIN_REQUESTS", getEnv("ERROR_RATE_MIN_REQUESTS", "20"), 0)
+	p.atLeast("EVENTS_BREAKER_FAILURES", getEnv("EVENTS_BREAKER_FAILURES", "5"), 0)
+	p.atLeast("EVENTS_BREAKER_COOLDOWN_MS", getEnv("EVENTS_BREAKER_COOLDOWN_MS", "30000"), 0)
+	p.atLeast("SLOW_REQUEST_THRESHOLD_MS", getEnv("SLOW_REQUEST_THRESHOLD_MS", "0"), 0)
+	if getEnv("ADAPTIVE_MIGRATION", "false") == "true" {
+		p.atLeast("ADAPTIVE_P95_THRESHOLD_MS", getEnv("ADAPTIVE_P95_THRESHOLD_MS", "500"), 1)
+		p.intRange("ADAPTIVE_MIN_PERCENT", getEnv("ADAPTIVE_MIN_PERCENT", "0"), 0, 100)
+		p.intRange("ADAPTIVE_STEP_PERCENT", getEnv("ADAPTIVE_STEP_PERCENT", "10"), 1, 100)
+		p.atLeast("ADAPTIVE_WINDOW_SECONDS", getEnv("ADAPTIVE_WINDOW_SECONDS", "30"), 1)
+		p.atLeast("ADAPTIVE_INTERVAL_MS", getEnv("ADAPTIVE_INTERVAL_MS", "5000"), 1)
+	}
+	if getEnv("CHAOS_ENABLED", "false") == "true" {
+		for _, key := range []string{"CHAOS_PERCENT", "CHAOS_ERROR_PERCENT", "CHAOS_RESET_PERCENT"} {
+			p.intRange(key, getEnv(key, "0"), 0, 100)
+		}
+		p.atLeast("CHAOS_LATENCY_MS", getEnv("CHAOS_LATENCY_MS", "0"), 0)
+	}
+	return p
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// problemKeys returns the variable each problem is about.
+func problemKeys(problems []string) []string {
+	keys := make([]string, len(problems))
+	for i, p := range problems {
+		keys[i], _, _ = strings.Cut(p, ":")
+	}
+	return keys
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{"defaults", nil, nil},
+		{"valid settings", map[string]string{"PORT": "9000", "MONOLITH_URL": "https://monolith:8080", "MOVIES_MIGRATION_PERCENT": "100", "GRADUAL_MIGRATION": "true"}, nil},
+		{"relative url", map[string]string{"MONOLITH_URL": "monolith:8080"}, []string{"MONOLITH_URL"}},
+		{"bad scheme", map[string]string{"MONOLITH_URL": "ftp://monolith"}, []string{"MONOLITH_URL"}},
+		{"one bad replica", map[string]string{"MOVIES_SERVICE_URLS": "http://movies-1:8081, movies-2"}, []string{"MOVIES_SERVICE_URLS"}},
+		{"percent above 100", map[string]string{"MOVIES_MIGRATION_PERCENT": "150"}, []string{"MOVIES_MIGRATION_PERCENT"}},
+		{"percent not a number", map[string]string{"MOVIES_MIGRATION_PERCENT": "half"}, []string{"MOVIES_MIGRATION_PERCENT"}},
+		{"zero timeout", map[string]string{"HEALTH_PROBE_TIMEOUT_MS": "0"}, []string{"HEALTH_PROBE_TIMEOUT_MS"}},
+		{"port out of range", map[string]string{"PORT": "70000"}, []string{"PORT"}},
+		{"not a boolean", map[string]string{"CHAOS_ENABLED": "yes"}, []string{"CHAOS_ENABLED"}},
+		{"unknown default target", map[string]string{"DEFAULT_ROUTE_TARGET": "nowhere"}, []string{"DEFAULT_ROUTE_TARGET"}},
+		{"flag service checked only when set", map[string]string{"FLAG_SERVICE_TIMEOUT_MS": "0"}, nil},
+		{"chaos settings checked when enabled", map[string]string{"CHAOS_ENABLED": "true", "CHAOS_ERROR_PERCENT": "101"}, []string{"CHAOS_ERROR_PERCENT"}},
+		{
+			"every problem reported at once",
+			map[string]string{"PORT": "x", "MONOLITH_URL": "::", "MOVIES_MIGRATION_PERCENT": "-1", "HEALTH_CHECK_JITTER": "1"},
+			[]string{"PORT", "MONOLITH_URL", "MOVIES_MIGRATION_PERCENT", "HEALTH_CHECK_JITTER"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			got := problemKeys(validateConfig())
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("problems with %v, want %v", got, tt.want)
+			}
+		})
+	}
+}